@@ -0,0 +1,361 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/session"
+	"vibe-common/storage"
+	mAPI "vibe/model/api"
+	"vibe/transcode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A draft holds a vibe while its parts upload: it names the vibe's folder and the roles it is
+// made of, each upload carrying its draft_id is staged below drafts/<draft_id>/ and recorded as
+// a part, and committing copies the parts into the vibe's folder and records the vibe once every
+// part is stored. Drafts left uncommitted past DRAFT_EXPIRY are swept with their files.
+
+// draftPrefix is the top folder parts are staged in, core-streaming does not serve it
+const draftPrefix = "drafts"
+
+// how long a draft waits for its commit, set by Setup
+var DRAFT_EXPIRY time.Duration
+
+// DraftResponse is where a draft stands
+type DraftResponse struct {
+	DraftId    string    `json:"draft_id"`
+	LocationId string    `json:"location_id"`
+	VibeId     string    `json:"vibe_id" doc:"folder the vibe is stored in once committed"`
+	Parts      []string  `json:"parts" doc:"roles the vibe is made of"`
+	Stored     []string  `json:"stored" doc:"roles uploaded so far"`
+	Missing    []string  `json:"missing" doc:"roles still to upload before the commit"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func newDraftResponse(d repository.Draft) DraftResponse {
+	res := DraftResponse{DraftId: d.ID, LocationId: d.Location.Hash, VibeId: d.Folder, Parts: d.Parts,
+		Stored: []string{}, Missing: missingParts(d), ExpiresAt: d.ExpiresAt}
+	for _, p := range d.Stored {
+		res.Stored = append(res.Stored, p.Role)
+	}
+	return res
+}
+
+// storedPart is the part stored for the role, false when there is none
+func storedPart(d repository.Draft, role string) (repository.DraftPart, bool) {
+	for _, p := range d.Stored {
+		if p.Role == role {
+			return p, true
+		}
+	}
+	return repository.DraftPart{}, false
+}
+
+// missingParts are the roles of the draft's manifest with no stored part
+func missingParts(d repository.Draft) []string {
+	missing := []string{}
+	for _, role := range d.Parts {
+		if _, ok := storedPart(d, role); !ok {
+			missing = append(missing, role)
+		}
+	}
+	return missing
+}
+
+// hasPart says whether role is in the draft's manifest
+func hasPart(d repository.Draft, role string) bool {
+	for _, part := range d.Parts {
+		if part == role {
+			return true
+		}
+	}
+	return false
+}
+
+// checkParts validates a manifest: known roles, each once, the video among them
+func checkParts(parts []string) []api.FieldError {
+	seen := map[string]bool{}
+	for _, role := range parts {
+		if roleFileName(role) == "" {
+			return []api.FieldError{{Field: "parts", Message: "must only hold " + strings.Join(roles, ", ")}}
+		}
+		if seen[role] {
+			return []api.FieldError{{Field: "parts", Message: role + " is listed twice"}}
+		}
+		seen[role] = true
+	}
+	if !seen["video"] {
+		return []api.FieldError{{Field: "parts", Message: "must include video"}}
+	}
+	return nil
+}
+
+// ownDraft reads a draft of the session's user, answering 404 when it is gone, expired or someone else's
+func ownDraft(w http.ResponseWriter, r *http.Request, id string) (repository.Draft, bool) {
+	d, err := repos.Drafts.ByID(r.Context(), id)
+	if err == nil && d.UserID == session.UserID(r) && time.Now().Before(d.ExpiresAt) {
+		return d, true
+	}
+	if err != nil && err != repository.ErrNotFound {
+		logging.FromContext(r.Context()).Error("reading draft failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to read the draft")
+		return d, false
+	}
+	api.RespondError(w, http.StatusNotFound, api.CodeDraftNotFound, "no draft "+id)
+	return d, false
+}
+
+// draftFolder is the folder a draft's parts are staged in, with a trailing slash
+func draftFolder(d repository.Draft) (string, error) {
+	folder, err := storageKey(draftPrefix, d.ID)
+	return folder + "/", err
+}
+
+// draftKey is the key a part of the role is staged under, named as in the vibe's folder so
+// its renditions come along
+func draftKey(d repository.Draft, role string) (string, error) {
+	name := roleFileName(role)
+	if name == "" {
+		return "", fmt.Errorf("unknown vibe file role %q", role)
+	}
+	return storageKey(draftPrefix, d.ID, name)
+}
+
+// removeDraftFiles deletes the parts staged for a draft
+func removeDraftFiles(ctx context.Context, d repository.Draft) error {
+	folder, err := draftFolder(d)
+	if err != nil {
+		return nil // nothing was ever staged under a name like that
+	}
+	return storage.DeletePrefix(ctx, objects, folder)
+}
+
+// removeCopiedFiles deletes the files a commit failing with cause copied into the vibe's folder.
+// A draft gone in the meantime may have been committed concurrently, its vibe then holds the
+// same files and they stay.
+func removeCopiedFiles(ctx context.Context, d repository.Draft, copied []string, cause error) error {
+	if cause == repository.ErrNotFound {
+		exists, err := repos.Videos.Exists(ctx, d.Folder, d.Location.Hash)
+		if err != nil || exists {
+			return err
+		}
+	}
+	for _, key := range copied {
+		if err := objects.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardDraft deletes a draft and the files uploaded into it
+func discardDraft(ctx context.Context, d repository.Draft) error {
+	if err := repos.Drafts.Delete(ctx, d.ID); err != nil {
+		return err
+	}
+	return removeDraftFiles(ctx, d)
+}
+
+// recordPart records a part stored under its key with its draft. When the draft went in the
+// meantime the file is removed and 404 answered, false means the request was answered.
+func recordPart(w http.ResponseWriter, r *http.Request, d repository.Draft, p repository.DraftPart) bool {
+	reqLog := logging.FromContext(r.Context())
+	err := repos.Drafts.SetPart(r.Context(), d.ID, p)
+	if err == nil {
+		return true
+	}
+	if err != repository.ErrNotFound {
+		reqLog.Error("recording draft part failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the part")
+		return false
+	}
+	if err := removeDraftFiles(r.Context(), d); err != nil {
+		reqLog.Error("Error removing the files of a gone draft: ", err)
+	}
+	api.RespondError(w, http.StatusNotFound, api.CodeDraftNotFound, "no draft "+d.ID)
+	return false
+}
+
+// CreateDraft starts a vibe whose parts upload into it, uploads name it with their draft_id metadata
+func CreateDraft(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
+	req := openapi.Request(r).(*mAPI.DraftRequest)
+	if invalid := checkParts(req.Parts); invalid != nil {
+		api.RespondInvalid(w, invalid...)
+		return
+	}
+
+	file := vibeFile{LocationName: req.LocationName, Lat: req.Lat, Lon: req.Lon, TimeStamp: req.TimeStamp}
+	location := file.location()
+	vibe := file.vibe(session.UserID(r))
+	if _, err := storageKey("videos", location.Hash, vibe.Folder); err != nil {
+		reqLog.Error("draft has no safe storage key: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to name the vibe's folder")
+		return
+	}
+	id, err := newUploadID()
+	if err != nil {
+		reqLog.Error("generating draft ID failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to start the draft")
+		return
+	}
+
+	now := time.Now()
+	d := repository.Draft{ID: id, UserID: vibe.UserID, Folder: vibe.Folder, Location: location, TimeStamp: vibe.TimeStamp,
+		Parts: req.Parts, CreatedAt: now, ExpiresAt: now.Add(DRAFT_EXPIRY)}
+	if err := repos.Drafts.Create(r.Context(), d); err == repository.ErrDuplicate {
+		api.RespondError(w, http.StatusConflict, api.CodeVibeExists, "a vibe or draft of "+vibe.Folder+" is already at this location")
+		return
+	} else if err != nil {
+		reqLog.Error("add draft failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the draft")
+		return
+	}
+
+	reqLog.WithField("draft_id", id).Info("draft created")
+	api.Respond(w, newDraftResponse(d), http.StatusCreated)
+}
+
+// GetDraft reads which parts of a draft are stored and which are missing
+func GetDraft(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.DraftParam)
+	d, ok := ownDraft(w, r, req.DraftId)
+	if !ok {
+		return
+	}
+	api.Respond(w, newDraftResponse(d), http.StatusOK)
+}
+
+// CommitDraft records a draft's vibe once every part of its manifest is stored. The parts are
+// copied into the vibe's folder first, then the location, the vibe and its transcode job are
+// written in one transaction and the draft is gone after.
+func CommitDraft(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
+	req := openapi.Request(r).(*mAPI.DraftParam)
+	d, ok := ownDraft(w, r, req.DraftId)
+	if !ok {
+		return
+	}
+
+	// a part counts once its file is still in storage, not only recorded
+	var missing []api.FieldError
+	for _, role := range d.Parts {
+		p, ok := storedPart(d, role)
+		if !ok {
+			missing = append(missing, api.FieldError{Field: role, Message: "is not uploaded"})
+			continue
+		}
+		if _, err := objects.Stat(r.Context(), p.Key); err == storage.ErrNotExist {
+			missing = append(missing, api.FieldError{Field: role, Message: "is no longer stored, upload it again"})
+		} else if err != nil {
+			reqLog.Error("Error reading stored draft part: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to check the draft's parts")
+			return
+		}
+	}
+	if missing != nil {
+		api.RespondError(w, http.StatusConflict, api.CodeDraftIncomplete, "every part of the draft must be uploaded before the commit", missing...)
+		return
+	}
+
+	// the parts must not overwrite the files of a vibe recorded in the folder, deleted ones
+	// included since the commit refuses those too
+	if exists, err := repos.Videos.Exists(r.Context(), d.Folder, d.Location.Hash); err != nil {
+		reqLog.Error("checking the vibe's folder failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to check the vibe's folder")
+		return
+	} else if exists {
+		api.RespondError(w, http.StatusConflict, api.CodeVibeExists, "a vibe of "+d.Folder+" is already at this location")
+		return
+	}
+	var copied []string
+	staged, err := draftFolder(d)
+	if err == nil {
+		var folder string
+		if folder, err = storageKey("videos", d.Location.Hash, d.Folder); err == nil {
+			copied, err = storage.CopyPrefix(r.Context(), objects, staged, folder+"/")
+		}
+	}
+	if err != nil {
+		reqLog.Error("copying draft parts failed: ", err)
+		if err := removeCopiedFiles(r.Context(), d, copied, err); err != nil {
+			reqLog.Error("Error removing the copied parts of a draft: ", err)
+		}
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "unable to store the vibe's files")
+		return
+	}
+
+	video, _ := storedPart(d, "video")
+	videoKey, _ := vibeKey(d.Location.Hash, d.Folder, "video")
+	vibe := repository.Video{Folder: d.Folder, LocationHash: d.Location.Hash, UserID: d.UserID, TimeStamp: d.TimeStamp,
+		SHA256: video.SHA256, Meta: video.Meta}
+	err = repos.Drafts.Commit(r.Context(), d.ID, vibe, videoKey, TRANSCODE_ENABLED, time.Now())
+	if err != nil {
+		if err := removeCopiedFiles(r.Context(), d, copied, err); err != nil {
+			reqLog.Error("Error removing the copied parts of a draft: ", err)
+		}
+	}
+	switch err {
+	case nil:
+	case repository.ErrNotFound:
+		api.RespondError(w, http.StatusNotFound, api.CodeDraftNotFound, "no draft "+d.ID)
+		return
+	case repository.ErrDuplicate:
+		api.RespondError(w, http.StatusConflict, api.CodeVibeExists, "a vibe of "+d.Folder+" is already at this location")
+		return
+	default:
+		reqLog.Error("committing draft failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the vibe")
+		return
+	}
+	if TRANSCODE_ENABLED {
+		transcode.Notify()
+	}
+	if err := removeDraftFiles(r.Context(), d); err != nil {
+		reqLog.Error("Error removing the staged parts of a committed draft: ", err)
+	}
+
+	reqLog.WithField("draft_id", d.ID).Info("draft committed as ", d.Folder)
+	api.Respond(w, VibeResponse{LocationId: d.Location.Hash, VibeId: d.Folder, SHA256: video.SHA256}, http.StatusCreated)
+}
+
+// DeleteDraft abandons a draft and the parts uploaded into it
+func DeleteDraft(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.DraftParam)
+	d, ok := ownDraft(w, r, req.DraftId)
+	if !ok {
+		return
+	}
+	if err := discardDraft(r.Context(), d); err != nil {
+		logging.FromContext(r.Context()).Error("delete draft failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to delete the draft")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sweepDrafts discards the drafts that expired before now, with their files
+func sweepDrafts(now time.Time) {
+	expired, err := repos.Drafts.Expired(context.Background(), now)
+	if err != nil {
+		log.Error("reading expired drafts failed: ", err)
+		return
+	}
+	for _, d := range expired {
+		if err := discardDraft(context.Background(), d); err != nil {
+			log.Error("removing expired draft ", d.ID, " failed: ", err)
+		}
+	}
+	if len(expired) > 0 {
+		log.Info("Swept ", len(expired), " expired drafts")
+	}
+}
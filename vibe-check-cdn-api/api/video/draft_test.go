@@ -0,0 +1,185 @@
+package video
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"vibe-common/api"
+	"vibe-common/repository"
+)
+
+// createDraft starts a draft of user's vibe recorded in Berlin with the parts
+func (s *testServer) createDraft(user string, parts ...string) DraftResponse {
+	s.t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"location_name": "Berlin", "lat": 52.52, "lon": 13.405,
+		"time_stamp": "2021-06-01T12:00:00Z", "parts": parts})
+	w := s.do("POST", "/v1/drafts", user, map[string]string{"Content-Type": "application/json"}, body)
+	if w.Code != http.StatusCreated {
+		s.t.Fatalf("creating draft: %d %s", w.Code, w.Body)
+	}
+	var d DraftResponse
+	decode(s.t, w, &d)
+	return d
+}
+
+// uploadPart uploads file as the role's part of a draft
+func (s *testServer) uploadPart(user string, draftID string, role string, file []byte) {
+	s.t.Helper()
+	if w := s.upload(user, map[string]string{"role": role, "draft_id": draftID}, file); w.Code != http.StatusCreated {
+		s.t.Fatalf("uploading %s: %d %s", role, w.Code, w.Body)
+	}
+}
+
+func TestCommitDraft(t *testing.T) {
+	s := newTestServer(t)
+	d := s.createDraft("alice", "video", "thumbnail")
+	if strings.Join(d.Missing, ",") != "video,thumbnail" || d.VibeId != "alice-2021-06-01-12-00-00" {
+		t.Fatalf("created %+v", d)
+	}
+	commit := "/v1/drafts/" + d.DraftId + "/commit"
+	if w := s.do("POST", commit, "alice", nil, nil); w.Code != http.StatusConflict || errorCode(t, w) != api.CodeDraftIncomplete {
+		t.Errorf("committing an empty draft = %d, want 409 %s", w.Code, api.CodeDraftIncomplete)
+	}
+
+	s.uploadPart("alice", d.DraftId, "video", testMovie())
+	s.uploadPart("alice", d.DraftId, "thumbnail", testPicture(t, 64, 48))
+	staged := s.keys("drafts/" + d.DraftId + "/")
+	if len(staged) < 2 || len(s.keys("videos/")) != 0 {
+		t.Fatalf("staged %q, stored %q", staged, s.keys("videos/"))
+	}
+
+	w := s.do("POST", commit, "alice", nil, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("commit = %d %s", w.Code, w.Body)
+	}
+	folder := "videos/" + d.LocationId + "/" + d.VibeId + "/"
+	stored := s.keys(folder)
+	if len(stored) != len(staged) {
+		t.Errorf("stored %q from the staged %q", stored, staged)
+	}
+	for _, key := range stored {
+		if !strings.HasPrefix(key, folder) {
+			t.Errorf("stored %s outside the vibe's folder", key)
+		}
+	}
+	if keys := s.keys("drafts/"); len(keys) != 0 {
+		t.Errorf("left %q staged", keys)
+	}
+	if vibes, _ := s.repos.Videos.ByUser(context.Background(), "alice", 0); len(vibes) != 1 || vibes[0].Folder != d.VibeId {
+		t.Errorf("recorded %+v", vibes)
+	}
+	if w := s.do("GET", "/v1/drafts/"+d.DraftId, "alice", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("reading a committed draft = %d, want 404", w.Code)
+	}
+	if w := s.do("POST", commit, "alice", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("committing twice = %d, want 404", w.Code)
+	}
+}
+
+func TestCommitDraftRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		before func(s *testServer, d DraftResponse)
+		status int
+		code   string
+	}{
+		{"someone else's draft", "bob", func(*testServer, DraftResponse) {}, http.StatusNotFound, api.CodeDraftNotFound},
+		{"part no longer stored", "alice", func(s *testServer, d DraftResponse) {
+			if err := s.objects.Delete(context.Background(), "drafts/"+d.DraftId+"/video.mp4"); err != nil {
+				s.t.Fatal(err)
+			}
+		}, http.StatusConflict, api.CodeDraftIncomplete},
+		{"vibe recorded in the folder meanwhile", "alice", func(s *testServer, d DraftResponse) {
+			v := repository.Video{Folder: d.VibeId, LocationHash: d.LocationId, UserID: "alice"}
+			if err := s.repos.Videos.Create(context.Background(), v); err != nil {
+				s.t.Fatal(err)
+			}
+			key := "videos/" + d.LocationId + "/" + d.VibeId + "/video.mp4"
+			if err := s.objects.Put(context.Background(), key, strings.NewReader("recorded"), 8, "video/mp4"); err != nil {
+				s.t.Fatal(err)
+			}
+		}, http.StatusConflict, api.CodeVibeExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			d := s.createDraft("alice", "video")
+			s.uploadPart("alice", d.DraftId, "video", testMovie())
+			tt.before(s, d)
+
+			w := s.do("POST", "/v1/drafts/"+d.DraftId+"/commit", tt.user, nil, nil)
+			if w.Code != tt.status || errorCode(t, w) != tt.code {
+				t.Errorf("commit = %d, want %d %s", w.Code, tt.status, tt.code)
+			}
+			if w := s.do("GET", "/v1/drafts/"+d.DraftId, "alice", nil, nil); w.Code != http.StatusOK {
+				t.Errorf("a rejected commit dropped the draft, reading it = %d", w.Code)
+			}
+			// nothing was copied over, the files of a vibe recorded meanwhile are kept
+			for _, key := range s.keys("videos/") {
+				body, err := s.objects.Get(context.Background(), key, 0, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := ioutil.ReadAll(body)
+				body.Close()
+				if string(b) != "recorded" {
+					t.Errorf("a rejected commit stored %s", key)
+				}
+			}
+		})
+	}
+}
+
+// racingDrafts commits like the repository, then fails with err as a commit losing a race would
+type racingDrafts struct {
+	repository.Drafts
+	record bool // the vibe is recorded before err is returned
+	err    error
+}
+
+func (d racingDrafts) Commit(ctx context.Context, id string, v repository.Video, sourceKey string, queue bool, now time.Time) error {
+	if d.record {
+		if err := d.Drafts.Commit(ctx, id, v, sourceKey, queue, now); err != nil {
+			return err
+		}
+	}
+	return d.err
+}
+
+func TestCommitDraftRemovesCopies(t *testing.T) {
+	tests := []struct {
+		name   string
+		drafts racingDrafts
+		status int
+		kept   bool
+	}{
+		{"vibe recorded after the check", racingDrafts{err: repository.ErrDuplicate}, http.StatusConflict, false},
+		{"recording failed", racingDrafts{err: errors.New("connection reset")}, http.StatusInternalServerError, false},
+		{"draft deleted meanwhile", racingDrafts{err: repository.ErrNotFound}, http.StatusNotFound, false},
+		{"draft committed concurrently", racingDrafts{record: true, err: repository.ErrNotFound}, http.StatusNotFound, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			d := s.createDraft("alice", "video")
+			s.uploadPart("alice", d.DraftId, "video", testMovie())
+			tt.drafts.Drafts = s.repos.Drafts
+			s.repos.Drafts = tt.drafts
+
+			if w := s.do("POST", "/v1/drafts/"+d.DraftId+"/commit", "alice", nil, nil); w.Code != tt.status {
+				t.Errorf("commit = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			// the concurrent commit's vibe keeps the files it was recorded with
+			if kept := len(s.keys("videos/")) > 0; kept != tt.kept {
+				t.Errorf("copies kept %t, want %t", kept, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package video
+
+import (
+	"fmt"
+	"net/http"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/repository"
+	"vibe/config"
+	"vibe/media"
+)
+
+// mediaLimits are what a file of each role may be, the vibe roles and "user_picture", set by Setup
+var mediaLimits map[string]media.Limits
+
+// Runs on startup from Setup, reads the limits of each role from the configuration
+func setupMediaLimits() {
+	c := config.CONFIGURATION
+	photos := []media.Type{media.JPEG, media.PNG, media.HEIC}
+	// thumbnails are shown by browsers too, which do not read HEIC
+	pictures := []media.Type{media.JPEG, media.PNG}
+	mediaLimits = map[string]media.Limits{
+		"video": {Types: []media.Type{media.MP4, media.MOV}, MaxSize: int64(c.VIDEO_MAX_SIZE),
+			MaxDuration: c.VIDEO_MAX_DURATION, MaxDimension: c.VIDEO_MAX_DIMENSION},
+		"thumbnail":    {Types: pictures, MaxSize: int64(c.THUMBNAIL_MAX_SIZE), MaxDimension: c.THUMBNAIL_MAX_DIMENSION},
+		"selfie":       {Types: photos, MaxSize: int64(c.SELFIE_MAX_SIZE), MaxDimension: c.SELFIE_MAX_DIMENSION},
+		"user_picture": {Types: photos, MaxSize: int64(c.USER_PICTURE_MAX_SIZE), MaxDimension: c.USER_PICTURE_MAX_DIMENSION},
+	}
+}
+
+// videoMeta is what the all_videos row of a vibe records of its probed video
+func videoMeta(info media.Info) repository.VideoMeta {
+	width, height := info.Display()
+	return repository.VideoMeta{Duration: info.Duration, Width: width, Height: height, Codec: info.Codec,
+		Rotation: info.Rotation, Bitrate: info.Bitrate, Size: info.Size}
+}
+
+// respondTooLarge answers a file announced with more bytes than its role allows
+func respondTooLarge(w http.ResponseWriter, role string) {
+	api.RespondError(w, http.StatusRequestEntityTooLarge, api.CodeUploadTooLarge, fmt.Sprintf("a %s may have at most %d bytes", role, mediaLimits[role].MaxSize))
+}
+
+// keepMedia checks the uploaded file at path against the limits of its role and says what it
+// is. A file breaking them is removed with discard and the client told why; false means the
+// request was answered.
+func keepMedia(w http.ResponseWriter, r *http.Request, path string, role string, discard func() error) (media.Info, bool) {
+	reqLog := logging.FromContext(r.Context())
+	info, err := media.Validate(path, mediaLimits[role])
+	if err == nil {
+		return info, true
+	}
+	rejection, ok := err.(*media.Rejection)
+	if !ok {
+		reqLog.Error("Error probing uploaded file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error reading the file")
+		return info, false
+	}
+
+	if err := discard(); err != nil {
+		reqLog.Error("discarding rejected file failed: ", err)
+	}
+	reqLog.WithField("role", role).Info("rejected upload: ", rejection.Message)
+	respondRejection(w, role, rejection)
+	return info, false
+}
+
+// keepHead checks the first bytes of a file being uploaded, so a file of the wrong type is
+// turned away before the rest of it is sent. Answers and returns false like keepMedia.
+func keepHead(w http.ResponseWriter, r *http.Request, head []byte, role string, discard func() error) bool {
+	t := media.Sniff(head)
+	if mediaLimits[role].Allows(t) {
+		return true
+	}
+	if err := discard(); err != nil {
+		logging.FromContext(r.Context()).Error("discarding rejected file failed: ", err)
+	}
+	found := string(t)
+	if t == "" {
+		found = "an unrecognized format"
+	}
+	respondRejection(w, role, &media.Rejection{Reason: media.BadType, Message: fmt.Sprintf("file starts like %s, not a type a %s may be", found, role)})
+	return false
+}
+
+func respondRejection(w http.ResponseWriter, role string, rejection *media.Rejection) {
+	switch rejection.Reason {
+	case media.TooLarge:
+		uploadsRejected.WithLabelValues(role, "size").Inc()
+		api.RespondError(w, http.StatusRequestEntityTooLarge, api.CodeUploadTooLarge, rejection.Message)
+	case media.OverLimit:
+		uploadsRejected.WithLabelValues(role, "limit").Inc()
+		api.RespondError(w, http.StatusUnprocessableEntity, api.CodeMediaLimitExceeded, rejection.Message)
+	default:
+		uploadsRejected.WithLabelValues(role, "type").Inc()
+		api.RespondError(w, http.StatusUnsupportedMediaType, api.CodeMediaTypeNotAllowed, rejection.Message)
+	}
+}
@@ -0,0 +1,34 @@
+package video
+
+import (
+	"vibe-common/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// upload metrics, kind is "vibe" or "user_picture"
+var chunksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "upload_chunks_received_total",
+	Help:      "Upload chunks written to storage.",
+}, []string{"kind"})
+
+var bytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "upload_bytes_received_total",
+	Help:      "Upload bytes written to storage.",
+}, []string{"kind"})
+
+var uploadsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "uploads_completed_total",
+	Help:      "Uploads whose final chunk was received.",
+}, []string{"kind"})
+
+// role is a vibe file role or "user_picture", reason is "type", "size" or "limit"
+var uploadsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "uploads_rejected_total",
+	Help:      "Uploaded files discarded for their media type, size, duration or dimensions.",
+}, []string{"role", "reason"})
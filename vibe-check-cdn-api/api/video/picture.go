@@ -0,0 +1,367 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/session"
+	"vibe-common/storage"
+	"vibe/imaging"
+	"vibe/media"
+	"vibe/transcode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pictureSizes are the renditions every profile picture and thumbnail is stored in next to
+// its original, in pixels of the longer side, largest first
+var pictureSizes = []int{1024, 256, 64}
+
+// Rendition of the profile picture each link uses: chat avatars, the avatar on a vibe and the
+// picture of a profile
+const (
+	chatPictureSize    = 64
+	vibePictureSize    = 256
+	profilePictureSize = 1024
+)
+
+// Rendition of the thumbnail the feeds link
+const thumbnailSize = 1024
+
+// renditionName is the file a rendition of the picture stored as name is stored as,
+// user.png at 64 pixels is user_64.jpg
+func renditionName(name string, size int) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + "_" + strconv.Itoa(size) + ".jpg"
+}
+
+// userPicLink links the rendition of a user's profile picture of the given size, or the picture
+// itself while it has no renditions
+func userPicLink(ctx context.Context, userID string, size int) string {
+	link := USER_CONTENT_STREAM + "/" + userID + "/"
+	if key, err := userPictureKey(userID); err == nil && hasRenditions(ctx, key) {
+		return link + renditionName(USER_PICTURE, size)
+	}
+	return link + USER_PICTURE
+}
+
+// thumbnailLink links the rendition of a vibe's thumbnail the feeds show, or the thumbnail
+// itself while it has no renditions
+func thumbnailLink(ctx context.Context, locationHash string, folder string) string {
+	link := VIBE_CONTENT_STREAM + "/" + locationHash + "/" + folder + "/"
+	if key, err := storageKey("videos", locationHash, folder, VIBE_THUMBNAIL); err == nil && hasRenditions(ctx, key) {
+		return link + renditionName(VIBE_THUMBNAIL, thumbnailSize)
+	}
+	return link + VIBE_THUMBNAIL
+}
+
+// how long a picture found without renditions is linked as it is before it is looked up again
+const renditionRecheck = time.Minute
+
+// rendered remembers which stored pictures have their renditions, by the key of the picture.
+// A picture keeps them once it has them, so only the ones without are looked up again.
+var rendered = struct {
+	sync.Mutex
+	has     map[string]bool
+	checked map[string]time.Time
+}{has: map[string]bool{}, checked: map[string]time.Time{}}
+
+// hasRenditions says whether the picture stored under key has its renditions, pictures stored
+// before there were any lack them until the backfill stored them. A failed lookup says no.
+func hasRenditions(ctx context.Context, key string) bool {
+	rendered.Lock()
+	has, checked := rendered.has[key], rendered.checked[key]
+	rendered.Unlock()
+	if has || time.Since(checked) < renditionRecheck {
+		return has
+	}
+
+	// the smallest rendition is stored last, a picture that has it has them all
+	dir, name := path.Split(key)
+	_, err := objects.Stat(ctx, dir+renditionName(name, pictureSizes[len(pictureSizes)-1]))
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		logging.FromContext(ctx).Error("Error looking up the renditions of ", key, ": ", err)
+		return false
+	}
+	markRendered(key, err == nil)
+	return err == nil
+}
+
+// markRendered records whether the picture stored under key has its renditions
+func markRendered(key string, has bool) {
+	rendered.Lock()
+	defer rendered.Unlock()
+	rendered.has[key] = has
+	rendered.checked[key] = time.Now()
+}
+
+// forgetRendered drops what hasRenditions learnt, for storage the pictures were not looked up in
+func forgetRendered() {
+	rendered.Lock()
+	defer rendered.Unlock()
+	rendered.has = map[string]bool{}
+	rendered.checked = map[string]time.Time{}
+}
+
+// decodePicture decodes the picture in file, a HEIC one through the PNG ffmpeg converts it to.
+// A longer side than maxSide pixels fails with imaging.ErrTooLarge before it is decoded.
+func decodePicture(ctx context.Context, file string, maxSide int) (imaging.Picture, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return imaging.Picture{}, err
+	}
+	head := make([]byte, media.SniffLen)
+	n, _ := io.ReadFull(f, head)
+	f.Close()
+	if media.Sniff(head[:n]) != media.HEIC {
+		return imaging.Decode(file, maxSide)
+	}
+
+	converted, err := ioutil.TempFile(UPLOAD_STORAGE, "picture-*.png")
+	if err != nil {
+		return imaging.Picture{}, err
+	}
+	converted.Close()
+	defer os.Remove(converted.Name())
+	var exit *exec.ExitError
+	if err := transcode.ToPNG(ctx, file, converted.Name()); errors.As(err, &exit) {
+		return imaging.Picture{}, fmt.Errorf("%w: %v", imaging.ErrUnreadable, err)
+	} else if err != nil {
+		return imaging.Picture{}, err
+	}
+	return imaging.Decode(converted.Name(), maxSide)
+}
+
+// storePicture decodes the picture in file, turns it upright and stores it under key without
+// the metadata it came with, then stores a JPEG rendition of every size next to it. A HEIC
+// original is stored as the PNG it decodes to. One bigger than the MaxDimension of role is
+// refused before it is decoded.
+func storePicture(ctx context.Context, file string, key string, role string) error {
+	picture, err := decodePicture(ctx, file, mediaLimits[role].MaxDimension)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := picture.Encode(&buf); err != nil {
+		return err
+	}
+	contentType := string(media.JPEG)
+	if picture.Format == "png" {
+		contentType = string(media.PNG)
+	}
+	if err := objects.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), contentType); err != nil {
+		return err
+	}
+
+	// each rendition is scaled from the one before, the larger ones already averaged most pixels
+	img := picture.Image
+	dir, name := path.Split(key)
+	for _, size := range pictureSizes {
+		img = imaging.Fit(img, size)
+		buf.Reset()
+		if err := imaging.EncodeJPEG(&buf, img); err != nil {
+			return err
+		}
+		if err := objects.Put(ctx, dir+renditionName(name, size), bytes.NewReader(buf.Bytes()), int64(buf.Len()), string(media.JPEG)); err != nil {
+			return err
+		}
+	}
+	markRendered(key, true)
+	return nil
+}
+
+// keepPicture stores an uploaded profile picture or thumbnail with storePicture. One that passed
+// probing but does not decode is removed with discard and rejected like keepMedia rejects files;
+// false means the request was answered.
+func keepPicture(w http.ResponseWriter, r *http.Request, file string, key string, role string, discard func() error) bool {
+	reqLog := logging.FromContext(r.Context())
+	err := storePicture(r.Context(), file, key, role)
+	if err == nil {
+		return true
+	}
+	tooLarge := errors.Is(err, imaging.ErrTooLarge)
+	if !tooLarge && !errors.Is(err, imaging.ErrUnreadable) {
+		reqLog.Error("Error storing picture: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error storing the file")
+		return false
+	}
+
+	if err := discard(); err != nil {
+		reqLog.Error("discarding rejected file failed: ", err)
+	}
+	reqLog.WithField("role", role).Info("rejected upload: ", err)
+	if tooLarge {
+		respondRejection(w, role, &media.Rejection{Reason: media.OverLimit, Message: fmt.Sprintf("picture is larger than %d pixels on its longest side", mediaLimits[role].MaxDimension)})
+		return false
+	}
+	respondRejection(w, role, &media.Rejection{Reason: media.BadType, Message: "file is not a picture that can be read"})
+	return false
+}
+
+// PictureResponse links the profile picture stored for the session's user
+type PictureResponse struct {
+	PictureLink string `json:"picture_link" doc:"1024 pixel rendition, the _256 and _64 files next to it are the smaller ones"`
+}
+
+// PutPicture stores the request body as the profile picture of the session's user, the current
+// picture stays linked until the new one checks out
+func PutPicture(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
+	userID := session.UserID(r)
+	limit := mediaLimits["user_picture"].MaxSize
+	if r.ContentLength > limit {
+		respondTooLarge(w, "user_picture")
+		return
+	}
+	key, err := userPictureKey(userID)
+	if err != nil {
+		reqLog.Error("user has no safe storage key: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to name the stored file")
+		return
+	}
+
+	f, staged, err := openStaging(key)
+	if err != nil {
+		reqLog.Error("Error creating user pic file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error creating user pic file")
+		return
+	}
+	defer f.Close()
+	discard := func() error { return os.Remove(staged) }
+	written, err := io.Copy(f, io.LimitReader(r.Body, limit+1))
+	if err == nil {
+		err = f.Truncate(written)
+	}
+	if err != nil {
+		discard()
+		reqLog.Error("Error writing user pic: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error writing the picture")
+		return
+	}
+	if written > limit {
+		discard()
+		respondTooLarge(w, "user_picture")
+		return
+	}
+	chunksReceived.WithLabelValues("user_picture").Inc()
+	bytesReceived.WithLabelValues("user_picture").Add(float64(written))
+
+	if _, ok := keepMedia(w, r, staged, "user_picture", discard); !ok {
+		return
+	}
+	if !keepPicture(w, r, staged, key, "user_picture", discard) {
+		return
+	}
+	if err := discard(); err != nil {
+		reqLog.Error("Error removing staged user pic: ", err)
+	}
+	uploadsCompleted.WithLabelValues("user_picture").Inc()
+
+	if err := repos.Users.SetPhoto(r.Context(), userID, true); err != nil {
+		reqLog.Error("upload user pic failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the user pic")
+		return
+	}
+	api.RespondOK(w, PictureResponse{PictureLink: userPicLink(r.Context(), userID, profilePictureSize)})
+}
+
+// BackfillRenditions stores the renditions of the profile pictures and thumbnails stored before
+// pictures had any, cleaning their originals on the way. A picture that fails is logged and
+// left as it is.
+func BackfillRenditions(ctx context.Context) error {
+	smallest := pictureSizes[len(pictureSizes)-1]
+	done, failed := 0, 0
+	for _, prefix := range []string{"users/", "videos/"} {
+		stored, err := objects.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		keys := map[string]bool{}
+		for _, o := range stored {
+			keys[o.Key] = true
+		}
+		for _, o := range stored {
+			dir, name := path.Split(o.Key)
+			parts := strings.Split(o.Key, "/")
+			picture := prefix == "users/" && len(parts) == 3 && name == USER_PICTURE
+			thumbnail := prefix == "videos/" && len(parts) == 4 && name == VIBE_THUMBNAIL
+			// the smallest rendition is stored last, a picture that has it has them all
+			if !picture && !thumbnail || keys[dir+renditionName(name, smallest)] {
+				continue
+			}
+
+			role := "user_picture"
+			if thumbnail {
+				role = "thumbnail"
+			}
+			err := backfillPicture(ctx, o.Key, role)
+			if err == nil {
+				done++
+				continue
+			}
+			if ctx.Err() != nil {
+				log.Info("Stopped storing renditions after ", done, " pictures")
+				return ctx.Err()
+			}
+			failed++
+			log.Error("Error storing the renditions of ", o.Key, ": ", err)
+		}
+	}
+	log.Info("Stored the renditions of ", done, " pictures, ", failed, " failed")
+	return nil
+}
+
+// stops the rendition backfill started with the server, set by StartBackfill
+var stopBackfill context.CancelFunc
+var backfillDone chan struct{}
+
+// StartBackfill runs BackfillRenditions in the background, so pictures stored before renditions
+// existed get theirs after a deploy without anyone running it. Cleanup stops it.
+func StartBackfill() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopBackfill = cancel
+	backfillDone = make(chan struct{})
+	go func() {
+		defer close(backfillDone)
+		if err := BackfillRenditions(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Error storing the renditions of stored pictures: ", err)
+		}
+	}()
+}
+
+// backfillPicture fetches the picture stored under key and stores it again with storePicture
+func backfillPicture(ctx context.Context, key string, role string) error {
+	if err := os.MkdirAll(UPLOAD_STORAGE, uploadDirMode); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(UPLOAD_STORAGE, "rendition-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	body, err := objects.Get(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	return storePicture(ctx, f.Name(), key, role)
+}
@@ -0,0 +1,52 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPictureLinks(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	// alice's picture is from before renditions, bob's larger than a picture may be decoded
+	for user, picture := range map[string][]byte{"alice": testPicture(t, 300, 200), "bob": testPicture(t, 4100, 1)} {
+		if err := s.objects.Put(ctx, "users/"+user+"/"+USER_PICTURE, bytes.NewReader(picture), int64(len(picture)), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, user := range []string{"alice", "bob"} {
+		if link := userPicLink(ctx, user, chatPictureSize); link != USER_CONTENT_STREAM+"/"+user+"/user.png" {
+			t.Errorf("%s's link before the backfill = %s, want the picture itself", user, link)
+		}
+	}
+
+	if err := BackfillRenditions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if link := userPicLink(ctx, "alice", chatPictureSize); link != USER_CONTENT_STREAM+"/alice/user_64.jpg" {
+		t.Errorf("alice's link after the backfill = %s, want the 64 rendition", link)
+	}
+	if link := userPicLink(ctx, "bob", chatPictureSize); link != USER_CONTENT_STREAM+"/bob/user.png" {
+		t.Errorf("bob's link after the backfill failed = %s, want the picture itself", link)
+	}
+	if keys := s.keys("users/bob/"); len(keys) != 1 {
+		t.Errorf("bob's files after the backfill failed = %v, want only the picture", keys)
+	}
+
+	w := s.do("PUT", "/v1/me/picture", "bob", nil, testPicture(t, 40, 30))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT picture = %d %s", w.Code, w.Body)
+	}
+	var resp PictureResponse
+	decode(t, w, &resp)
+	if !strings.HasSuffix(resp.PictureLink, "/bob/user_1024.jpg") {
+		t.Errorf("picture_link = %s, want the 1024 rendition", resp.PictureLink)
+	}
+	if link := thumbnailLink(ctx, "somewhere", "nothing"); link != VIBE_CONTENT_STREAM+"/somewhere/nothing/thumbnail.jpg" {
+		t.Errorf("thumbnail link without renditions = %s, want the thumbnail itself", link)
+	}
+}
@@ -0,0 +1,53 @@
+package video
+
+import (
+	"net/http"
+	"time"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/session"
+	mAPI "vibe/model/api"
+	"vibe/transcode"
+)
+
+// VibeStatusResponse is how far a vibe's video got through processing
+type VibeStatusResponse struct {
+	LocationId string    `json:"location_id"`
+	VibeId     string    `json:"vibe_id"`
+	Status     string    `json:"status" doc:"processing, ready or failed, the feeds only show ready vibes"`
+	Attempts   int       `json:"attempts" doc:"transcoding attempts so far"`
+	Error      string    `json:"error,omitempty" doc:"why the last attempt failed"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	StreamLink string    `json:"streamLink,omitempty" doc:"HLS master playlist, once transcoded"`
+	PosterLink string    `json:"posterLink,omitempty"`
+}
+
+// hlsLinks are the stream links of a transcoded vibe's master playlist and poster
+func hlsLinks(locationHash string, folder string) (string, string) {
+	hls := VIBE_CONTENT_STREAM + "/" + locationHash + "/" + folder + "/" + transcode.HLSFolder + "/"
+	return hls + transcode.MasterPlaylist, hls + transcode.Poster
+}
+
+// GetVibeStatus reads the processing status of a vibe of the session's user, for polling after upload
+func GetVibeStatus(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.VibeParam)
+	status, err := repos.Transcodes.Status(r.Context(), req.LocationId, req.VibeId)
+	if err == repository.ErrNotFound || (err == nil && status.UserID != session.UserID(r)) {
+		api.RespondError(w, http.StatusNotFound, api.CodeNotFound, "no such vibe of the session's user")
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("reading vibe status failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to read the vibe status")
+		return
+	}
+
+	res := VibeStatusResponse{LocationId: req.LocationId, VibeId: req.VibeId, Status: status.Status,
+		Attempts: status.Attempts, Error: status.Error, UpdatedAt: status.UpdatedAt}
+	if status.HLS {
+		res.StreamLink, res.PosterLink = hlsLinks(req.LocationId, req.VibeId)
+	}
+	api.Respond(w, res, http.StatusOK)
+}
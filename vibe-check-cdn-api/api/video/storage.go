@@ -0,0 +1,107 @@
+package video
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"vibe-common/logging"
+	"vibe-common/storage"
+)
+
+// Files being uploaded are the service's alone until they are stored
+const (
+	uploadDirMode  os.FileMode = 0700
+	uploadFileMode os.FileMode = 0600
+)
+
+// stagingPrefix names the files legacy chunk uploads are assembled in, next to the tus uploads
+const stagingPrefix = "legacy-"
+
+// media storage, set by Setup
+var objects storage.Storage
+var STORAGE_PART_SIZE int64
+
+// roles are the files a vibe folder holds
+var roles = []string{"video", "thumbnail", "selfie"}
+
+// safeComponent is what one component of a storage key may look like: no separators, no
+// leading dot, nothing a shell or a URL needs escaped
+var safeComponent = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// storageKey joins components into a storage key, rejecting any component that could name
+// something outside the folder it belongs in
+func storageKey(components ...string) (string, error) {
+	for _, c := range components {
+		if !safeComponent.MatchString(c) || strings.Contains(c, "..") {
+			return "", fmt.Errorf("%q is not a safe storage path component", c)
+		}
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// vibeKey is the key a file of the role is stored under in its vibe's folder
+func vibeKey(locationHash string, vibeID string, role string) (string, error) {
+	name := roleFileName(role)
+	if name == "" {
+		return "", fmt.Errorf("unknown vibe file role %q", role)
+	}
+	return storageKey("videos", locationHash, vibeID, name)
+}
+
+// userPictureKey is the key a user's profile picture is stored under
+func userPictureKey(userID string) (string, error) {
+	return storageKey("users", userID, USER_PICTURE)
+}
+
+// legacyRole maps the x-file-name of a legacy chunk upload to a role, accepting the role
+// itself or the name its files are stored under, "" when it names neither
+func legacyRole(fileName string) string {
+	for _, role := range roles {
+		if fileName == role || fileName == roleFileName(role) {
+			return role
+		}
+	}
+	return ""
+}
+
+// openStaging opens the file the chunks of a legacy upload of key are written to, the
+// finished file is stored under key and the staging file removed
+func openStaging(key string) (*os.File, string, error) {
+	sum := sha256.Sum256([]byte(key))
+	path := UPLOAD_STORAGE + "/" + stagingPrefix + hex.EncodeToString(sum[:16])
+	if err := os.MkdirAll(UPLOAD_STORAGE, uploadDirMode); err != nil {
+		return nil, "", err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, uploadFileMode)
+	return f, path, err
+}
+
+// storeFile stores the finished file at path under key, in parts when it is large
+func storeFile(ctx context.Context, path string, key string, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return storage.Upload(ctx, objects, key, f, info.Size(), contentType, STORAGE_PART_SIZE)
+}
+
+// removeVibeFiles deletes the stored files of a vibe whose rows are gone, failures leave orphans behind and are only logged
+func removeVibeFiles(ctx context.Context, locationHash string, vibeID string) {
+	folder, err := storageKey("videos", locationHash, vibeID)
+	if err != nil {
+		return // no file was ever stored under a name like that
+	}
+	if err := storage.DeletePrefix(ctx, objects, folder+"/"); err != nil {
+		logging.FromContext(ctx).Error("Error deleting the files of vibe ", folder, ": ", err)
+	}
+}
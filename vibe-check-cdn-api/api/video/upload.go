@@ -0,0 +1,710 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/session"
+	"vibe/config"
+	"vibe/media"
+	mAPI "vibe/model/api"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Resumable uploads speak tus 1.0.0 (https://tus.io/protocols/resumable-upload) so stock
+// mobile clients can send vibes: POST creates an upload, HEAD reads its offset, PATCH
+// appends a chunk at that offset and DELETE abandons it. Finalizing moves the finished
+// file into the vibe's folder, which tus leaves to the server.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,checksum,termination,expiration"
+	tusChecksums  = "sha1,sha256"
+
+	// PATCH bodies must say they are raw bytes at an offset
+	offsetContentType = "application/offset+octet-stream"
+
+	// tus answers a chunk that does not match its Upload-Checksum with this status
+	statusChecksumMismatch = 460
+)
+
+// upload storage and limits
+var UPLOAD_STORAGE string
+var UPLOAD_MAX_SIZE int64
+var UPLOAD_EXPIRY time.Duration
+
+// uploadsInFlight holds the uploads a request is writing, a second PATCH to one of them is turned away
+var uploadsInFlight = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: map[string]bool{}}
+
+// stops the expired upload sweep, set by Setup
+var stopSweep chan struct{}
+var sweepDone chan struct{}
+
+// Runs on startup from Setup, derives the upload settings and starts sweeping expired uploads
+func setupUploads() {
+	UPLOAD_STORAGE = config.CONFIGURATION.UPLOAD_STORAGE
+	if UPLOAD_STORAGE == "" {
+		UPLOAD_STORAGE = DESTINATION + "/.uploads" // hidden, so never a storage key
+	}
+	UPLOAD_MAX_SIZE = int64(config.CONFIGURATION.UPLOAD_MAX_SIZE)
+	UPLOAD_EXPIRY = config.CONFIGURATION.UPLOAD_EXPIRY
+	DRAFT_EXPIRY = config.CONFIGURATION.DRAFT_EXPIRY
+	if err := os.MkdirAll(UPLOAD_STORAGE, uploadDirMode); err != nil {
+		log.Error("Error creating upload directory "+UPLOAD_STORAGE+": ", err)
+	}
+
+	stopSweep = make(chan struct{})
+	sweepDone = make(chan struct{})
+	go sweepUploads(config.CONFIGURATION.UPLOAD_SWEEP_INTERVAL)
+}
+
+// Cleanup stops the expired upload sweep and the rendition backfill, run it before the DB is closed
+func Cleanup() {
+	close(stopSweep)
+	<-sweepDone
+	log.Info("Upload sweep stopped")
+	if stopBackfill != nil {
+		stopBackfill()
+		<-backfillDone
+	}
+}
+
+// VibeResponse names the vibe a finalized upload belongs to
+type VibeResponse struct {
+	LocationId string `json:"location_id"`
+	VibeId     string `json:"vibe_id"`
+	SHA256     string `json:"sha256"` // hex digest of the stored file
+}
+
+// vibeFile is what an upload's metadata says about the file and the vibe it belongs to
+type vibeFile struct {
+	Role         string
+	LocationName string
+	Lat          float64
+	Lon          float64
+	TimeStamp    time.Time
+	SHA256       string // hex digest the whole file must have, "" skips the check
+	DraftID      string // draft the file is a part of, "" records the vibe with its video
+}
+
+// parseVibeFile reads the upload metadata a client must send, reporting each bad key as a field error
+func parseVibeFile(metadata map[string]string) (vibeFile, []api.FieldError) {
+	file := vibeFile{Role: metadata["role"], LocationName: metadata["location_name"], DraftID: metadata["draft_id"]}
+	var invalid []api.FieldError
+	if roleFileName(file.Role) == "" {
+		invalid = append(invalid, api.FieldError{Field: "role", Message: "must be one of " + strings.Join(roles, ", ")})
+	}
+	lat, lon, bad := parseLatLon(metadata["lat"], metadata["lon"])
+	invalid = append(invalid, bad...)
+	if bad == nil && (lat < -90 || lat > 90) {
+		invalid = append(invalid, api.FieldError{Field: "lat", Message: "must be between -90 and 90"})
+	}
+	if bad == nil && (lon < -180 || lon > 180) {
+		invalid = append(invalid, api.FieldError{Field: "lon", Message: "must be between -180 and 180"})
+	}
+	file.Lat, file.Lon = lat, lon
+	timeStamp, err := time.Parse(time.RFC3339, metadata["time_stamp"])
+	if err != nil {
+		invalid = append(invalid, api.FieldError{Field: "time_stamp", Message: "must be an RFC 3339 time"})
+	}
+	file.TimeStamp = timeStamp
+	file.SHA256 = strings.ToLower(metadata["sha256"])
+	if _, err := hex.DecodeString(file.SHA256); err != nil || (file.SHA256 != "" && len(file.SHA256) != sha256.Size*2) {
+		invalid = append(invalid, api.FieldError{Field: "sha256", Message: "must be a hex SHA-256 digest"})
+	}
+	return file, invalid
+}
+
+// roleFileName is the name a file of the role is stored under in its vibe's folder, "" for unknown roles
+func roleFileName(role string) string {
+	switch role {
+	case "video":
+		return VIBE_VIDEO
+	case "thumbnail":
+		return VIBE_THUMBNAIL
+	case "selfie":
+		return VIBE_SELFIE
+	}
+	return ""
+}
+
+// location is the row the vibe's location is recorded with, hashed the way the legacy uploads hash it
+func (f vibeFile) location() repository.Location {
+	hash := GenerateLocationHashString(f.LocationName, fmt.Sprintf("%.9f", f.Lat), fmt.Sprintf("%.9f", f.Lon))
+	return repository.Location{Hash: hash, Name: f.LocationName, Lat: f.Lat, Lon: f.Lon}
+}
+
+// vibe is the all_videos row of the vibe, one folder per user and recording time
+func (f vibeFile) vibe(userID string) repository.Video {
+	folder := userID + "-" + f.TimeStamp.Format("2006-01-02-15-04-05")
+	return repository.Video{Folder: folder, LocationHash: f.location().Hash, UserID: userID, TimeStamp: f.TimeStamp}
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header, "key base64value,key2 base64value2"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("%q is not a key and a base64 value", strings.TrimSpace(pair))
+		}
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("value of %s is not base64", parts[0])
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
+
+// encodeUploadMetadata is the inverse of parseUploadMetadata, keys sorted
+func encodeUploadMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + " " + base64.StdEncoding.EncodeToString([]byte(metadata[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// parseChecksum reads a tus Upload-Checksum header, "sha256 base64digest"
+func parseChecksum(header string) (hash.Hash, []byte, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("Upload-Checksum should look like sha256 <base64 digest>")
+	}
+	digest, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("Upload-Checksum digest is not base64")
+	}
+	switch parts[0] {
+	case "sha1":
+		return sha1.New(), digest, nil
+	case "sha256":
+		return sha256.New(), digest, nil
+	}
+	return nil, nil, fmt.Errorf("Upload-Checksum algorithm must be one of %s", tusChecksums)
+}
+
+func newUploadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// uploadPath is where the bytes of an upload are kept until it is finalized
+func uploadPath(id string) string {
+	return UPLOAD_STORAGE + "/" + id
+}
+
+// lockUpload claims an upload for one request, false when another request holds it
+func lockUpload(id string) bool {
+	uploadsInFlight.Lock()
+	defer uploadsInFlight.Unlock()
+	if uploadsInFlight.ids[id] {
+		return false
+	}
+	uploadsInFlight.ids[id] = true
+	return true
+}
+
+func unlockUpload(id string) {
+	uploadsInFlight.Lock()
+	defer uploadsInFlight.Unlock()
+	delete(uploadsInFlight.ids, id)
+}
+
+// ownUpload reads an upload of the session's user, answering 404 when it is gone, expired or someone else's
+func ownUpload(w http.ResponseWriter, r *http.Request, id string) (repository.Upload, bool) {
+	u, err := repos.Uploads.ByID(r.Context(), id)
+	if err == nil && u.UserID == session.UserID(r) && time.Now().Before(u.ExpiresAt) {
+		return u, true
+	}
+	if err != nil && err != repository.ErrNotFound {
+		logging.FromContext(r.Context()).Error("reading upload failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to read the upload")
+		return u, false
+	}
+	api.RespondError(w, http.StatusNotFound, api.CodeUploadNotFound, "no upload "+id)
+	return u, false
+}
+
+// lockOwnUpload is ownUpload for requests that change the upload, answering 423 while another one does
+func lockOwnUpload(w http.ResponseWriter, r *http.Request, id string) (repository.Upload, bool) {
+	if !lockUpload(id) {
+		api.RespondError(w, http.StatusLocked, api.CodeUploadLocked, "another request is writing upload "+id)
+		return repository.Upload{}, false
+	}
+	u, ok := ownUpload(w, r, id)
+	if !ok {
+		unlockUpload(id)
+	}
+	return u, ok
+}
+
+// setUploadHeaders describes where an upload stands
+func setUploadHeaders(w http.ResponseWriter, u repository.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// Tus marks every answer with the tus version and turns away clients speaking another one
+func Tus(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			api.RespondError(w, http.StatusPreconditionFailed, api.CodeTusVersionUnsupported, "Tus-Resumable must be "+tusVersion)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UploadOptions tells tus clients what the server supports
+func UploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(UPLOAD_MAX_SIZE, 10))
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksums)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload of one file of a vibe, its URL is in the Location header
+func CreateUpload(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
+	req := openapi.Request(r).(*mAPI.UploadCreateRequest)
+	if req.Length > UPLOAD_MAX_SIZE {
+		api.RespondError(w, http.StatusRequestEntityTooLarge, api.CodeUploadTooLarge, fmt.Sprintf("Upload-Length must be at most %d bytes", UPLOAD_MAX_SIZE))
+		return
+	}
+	metadata, err := parseUploadMetadata(req.Metadata)
+	if err != nil {
+		api.RespondInvalid(w, api.FieldError{Field: "Upload-Metadata", Message: err.Error()})
+		return
+	}
+	// a part of a draft belongs to the draft's vibe, whatever else the metadata says
+	if id := metadata["draft_id"]; id != "" {
+		d, ok := ownDraft(w, r, id)
+		if !ok {
+			return
+		}
+		if !hasPart(d, metadata["role"]) {
+			api.RespondInvalid(w, api.FieldError{Field: "role", Message: "must be one of the draft's parts, " + strings.Join(d.Parts, ", ")})
+			return
+		}
+		metadata["lat"] = strconv.FormatFloat(d.Location.Lat, 'f', -1, 64)
+		metadata["lon"] = strconv.FormatFloat(d.Location.Lon, 'f', -1, 64)
+		metadata["location_name"] = d.Location.Name
+		metadata["time_stamp"] = d.TimeStamp.Format(time.RFC3339Nano)
+	}
+	file, invalid := parseVibeFile(metadata)
+	if invalid != nil {
+		api.RespondInvalid(w, invalid...)
+		return
+	}
+	if req.Length > mediaLimits[file.Role].MaxSize {
+		respondTooLarge(w, file.Role)
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		reqLog.Error("generating upload ID failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to start the upload")
+		return
+	}
+	if err := os.MkdirAll(UPLOAD_STORAGE, uploadDirMode); err != nil {
+		reqLog.Error("Error creating upload directory "+UPLOAD_STORAGE+": ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error creating upload directory")
+		return
+	}
+	f, err := os.OpenFile(uploadPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, uploadFileMode)
+	if err != nil {
+		reqLog.Error("Error creating upload file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error creating upload file")
+		return
+	}
+	f.Close()
+
+	now := time.Now()
+	u := repository.Upload{ID: id, UserID: session.UserID(r), Length: req.Length, Metadata: metadata, CreatedAt: now, ExpiresAt: now.Add(UPLOAD_EXPIRY)}
+	if err := repos.Uploads.Create(r.Context(), u); err != nil {
+		reqLog.Error("add upload failed: ", err)
+		RemoveFile(uploadPath(id))
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the upload")
+		return
+	}
+
+	reqLog.WithField("upload_id", id).Info("upload created")
+	w.Header().Set("Location", "/v1/uploads/"+id)
+	setUploadHeaders(w, u)
+	api.RespondSuccess(w, http.StatusCreated, id, "upload created")
+}
+
+// HeadUpload answers where an upload stands, clients resume a PATCH from its Upload-Offset
+func HeadUpload(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.UploadParam)
+	u, ok := ownUpload(w, r, req.UploadId)
+	if !ok {
+		return
+	}
+	setUploadHeaders(w, u)
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Upload-Metadata", encodeUploadMetadata(u.Metadata))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload writes a chunk at the upload's offset. A retried chunk lands on the same
+// bytes instead of being appended twice, and a chunk failing its checksum is discarded.
+func PatchUpload(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
+	req := openapi.Request(r).(*mAPI.UploadPatchRequest)
+	if r.Header.Get("Content-Type") != offsetContentType {
+		api.RespondError(w, http.StatusUnsupportedMediaType, api.CodeUnsupportedMediaType, "Content-Type must be "+offsetContentType)
+		return
+	}
+	var sum hash.Hash
+	var want []byte
+	if req.Checksum != "" {
+		var err error
+		if sum, want, err = parseChecksum(req.Checksum); err != nil {
+			api.RespondError(w, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+			return
+		}
+	}
+
+	u, ok := lockOwnUpload(w, r, req.UploadId)
+	if !ok {
+		return
+	}
+	defer unlockUpload(u.ID)
+	if req.Offset != u.Offset {
+		setUploadHeaders(w, u)
+		api.RespondError(w, http.StatusConflict, api.CodeUploadOffsetMismatch, fmt.Sprintf("upload is at offset %d", u.Offset))
+		return
+	}
+
+	f, err := os.OpenFile(uploadPath(u.ID), os.O_CREATE|os.O_RDWR, uploadFileMode)
+	if err != nil {
+		reqLog.Error("Error opening upload file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error opening upload file")
+		return
+	}
+	defer f.Close()
+
+	// drop bytes an interrupted request wrote past the recorded offset, then write at it
+	if err = f.Truncate(u.Offset); err == nil {
+		_, err = f.Seek(u.Offset, io.SeekStart)
+	}
+	if err != nil {
+		reqLog.Error("Error seeking upload file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error writing to upload file")
+		return
+	}
+	// every chunk is hashed so finalize can tell which ranges to have sent again
+	chunkSum := sha256.New()
+	dst := io.MultiWriter(f, chunkSum)
+	if sum != nil {
+		dst = io.MultiWriter(f, chunkSum, sum)
+	}
+	remaining := u.Length - u.Offset
+	written, err := io.Copy(dst, io.LimitReader(r.Body, remaining+1))
+	switch {
+	case written > remaining:
+		f.Truncate(u.Offset)
+		api.RespondError(w, http.StatusRequestEntityTooLarge, api.CodeUploadTooLarge, "chunk runs past Upload-Length")
+		return
+	case err != nil && sum != nil:
+		f.Truncate(u.Offset)
+		reqLog.Info("upload chunk interrupted, discarding it: ", err)
+		api.RespondError(w, http.StatusBadRequest, api.CodeUploadFailed, "chunk was interrupted")
+		return
+	case err != nil:
+		// without a checksum the bytes that arrived are kept and the client resumes after them
+		reqLog.Info("upload chunk interrupted after ", written, " bytes: ", err)
+	case sum != nil && !bytes.Equal(sum.Sum(nil), want):
+		f.Truncate(u.Offset)
+		api.RespondError(w, statusChecksumMismatch, api.CodeUploadChecksumMismatch, "chunk does not match its Upload-Checksum")
+		return
+	}
+
+	if written > 0 {
+		chunk := repository.UploadChunk{Offset: u.Offset, Length: written, SHA256: hex.EncodeToString(chunkSum.Sum(nil)), Verified: sum != nil}
+		if err := repos.Uploads.AddChunk(r.Context(), u.ID, chunk); err != nil {
+			reqLog.Error("update upload offset failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the upload offset")
+			return
+		}
+		u.Offset += written
+	}
+	chunksReceived.WithLabelValues("vibe").Inc()
+	bytesReceived.WithLabelValues("vibe").Add(float64(written))
+
+	// once the first bytes are in, a file of the wrong type is dropped instead of sent in full
+	if start := u.Offset - written; start < media.SniffLen && (u.Offset >= media.SniffLen || u.Offset == u.Length) {
+		head := make([]byte, u.Offset)
+		if u.Offset > media.SniffLen {
+			head = head[:media.SniffLen]
+		}
+		if _, err := f.ReadAt(head, 0); err != nil {
+			reqLog.Error("Error reading upload file: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error reading the upload")
+			return
+		}
+		if !keepHead(w, r, head, u.Metadata["role"], func() error { return removeUpload(r.Context(), u) }) {
+			return
+		}
+	}
+
+	setUploadHeaders(w, u)
+	if err != nil {
+		api.RespondError(w, http.StatusBadRequest, api.CodeUploadFailed, fmt.Sprintf("chunk was interrupted, upload is at offset %d", u.Offset))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUpload abandons an upload and its bytes
+func DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.UploadParam)
+	u, ok := lockOwnUpload(w, r, req.UploadId)
+	if !ok {
+		return
+	}
+	defer unlockUpload(u.ID)
+	if err := removeUpload(r.Context(), u); err != nil {
+		logging.FromContext(r.Context()).Error("delete upload failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to delete the upload")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FinalizeUpload moves a complete upload into its vibe's folder. Finalizing the video
+// records the vibe, thumbnails and selfies are only stored next to it. A part of a draft
+// is only recorded with the draft, its commit records the vibe.
+func FinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
+	req := openapi.Request(r).(*mAPI.UploadParam)
+	u, ok := lockOwnUpload(w, r, req.UploadId)
+	if !ok {
+		return
+	}
+	defer unlockUpload(u.ID)
+	if u.Offset < u.Length {
+		setUploadHeaders(w, u)
+		api.RespondError(w, http.StatusConflict, api.CodeUploadIncomplete, fmt.Sprintf("%d of %d bytes received", u.Offset, u.Length))
+		return
+	}
+	file, invalid := parseVibeFile(u.Metadata)
+	if invalid != nil {
+		reqLog.WithField("invalid", invalid).Error("upload metadata no longer parses")
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to read the upload metadata")
+		return
+	}
+	var draft repository.Draft
+	if file.DraftID != "" {
+		if draft, ok = ownDraft(w, r, file.DraftID); !ok {
+			return
+		}
+	}
+
+	// The assembled file must hash to what the client announced. When it does not the
+	// upload rewinds to the first chunk nothing vouches for and the client resumes there.
+	digest, err := fileSHA256(uploadPath(u.ID))
+	if err != nil {
+		reqLog.Error("Error hashing upload file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error reading the upload")
+		return
+	}
+	if file.SHA256 != "" && digest != file.SHA256 {
+		offset := suspectOffset(u)
+		if err := os.Truncate(uploadPath(u.ID), offset); err == nil {
+			err = repos.Uploads.Rewind(r.Context(), u.ID, offset)
+		}
+		if err != nil {
+			reqLog.Error("rewinding upload failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to rewind the upload")
+			return
+		}
+		reqLog.WithField("upload_id", u.ID).Info("upload failed its sha256, rewound to ", offset)
+		u.Offset = offset
+		setUploadHeaders(w, u)
+		api.RespondError(w, statusChecksumMismatch, api.CodeUploadChecksumMismatch, fmt.Sprintf("file does not match its sha256, resume from offset %d", offset),
+			api.FieldError{Field: "sha256", Message: "file hashes to " + digest})
+		return
+	}
+
+	info, ok := keepMedia(w, r, uploadPath(u.ID), file.Role, func() error { return removeUpload(r.Context(), u) })
+	if !ok {
+		return
+	}
+
+	location := file.location()
+	vibe := file.vibe(u.UserID)
+	vibe.SHA256 = digest
+	vibe.Meta = videoMeta(info)
+
+	// a draft's parts are staged until its commit copies them into the vibe's folder
+	key, err := vibeKey(location.Hash, vibe.Folder, file.Role)
+	if file.DraftID != "" {
+		key, err = draftKey(draft, file.Role)
+	}
+	if err != nil {
+		reqLog.Error("upload has no safe storage key: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to name the stored file")
+		return
+	}
+	if file.Role == "thumbnail" {
+		if !keepPicture(w, r, uploadPath(u.ID), key, file.Role, func() error { return removeUpload(r.Context(), u) }) {
+			return
+		}
+	} else if err := storeFile(r.Context(), uploadPath(u.ID), key, string(info.Type)); err != nil {
+		reqLog.Error("Error storing upload: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error storing the file")
+		return
+	}
+	if file.DraftID != "" {
+		if !recordPart(w, r, draft, repository.DraftPart{Role: file.Role, Key: key, SHA256: digest, Meta: vibe.Meta}) {
+			return
+		}
+	} else if file.Role == "video" && !recordVibe(w, r, location, vibe, key) {
+		// the bytes stay with the upload so finalizing can be retried
+		if err := objects.Delete(r.Context(), key); err != nil {
+			reqLog.Error("Error deleting unrecorded vibe file: ", err)
+		}
+		return
+	}
+
+	if err := removeUpload(r.Context(), u); err != nil {
+		reqLog.Error("removing finalized upload failed, the sweep removes it: ", err)
+	}
+	uploadsCompleted.WithLabelValues("vibe").Inc()
+	reqLog.WithField("upload_id", u.ID).Info("upload finalized into ", key)
+	api.Respond(w, VibeResponse{LocationId: location.Hash, VibeId: vibe.Folder, SHA256: digest}, http.StatusCreated)
+}
+
+// suspectOffset is where a complete upload that failed its sha256 resumes: the first chunk
+// sent without a checksum, whose bytes changed on disk since, or that is missing. When every
+// chunk checks out the announced digest is wrong and the whole file is sent again.
+func suspectOffset(u repository.Upload) int64 {
+	f, err := os.Open(uploadPath(u.ID))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	next := int64(0)
+	for _, c := range u.Chunks {
+		if c.Offset != next || !c.Verified {
+			return next
+		}
+		sum := sha256.New()
+		if _, err := io.Copy(sum, io.NewSectionReader(f, c.Offset, c.Length)); err != nil || hex.EncodeToString(sum.Sum(nil)) != c.SHA256 {
+			return c.Offset
+		}
+		next += c.Length
+	}
+	if next < u.Length {
+		return next
+	}
+	return 0
+}
+
+// removeUpload deletes an upload's bytes and its row
+func removeUpload(ctx context.Context, u repository.Upload) error {
+	if err := os.Remove(uploadPath(u.ID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return repos.Uploads.Delete(ctx, u.ID)
+}
+
+// sweepUploads removes expired uploads and drafts every interval until Cleanup, a zero interval never sweeps
+func sweepUploads(interval time.Duration) {
+	defer close(sweepDone)
+	if interval <= 0 {
+		<-stopSweep
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopSweep:
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := repos.Uploads.Expired(context.Background(), time.Now())
+		if err != nil {
+			log.Error("reading expired uploads failed: ", err)
+			continue
+		}
+		for _, u := range expired {
+			if !lockUpload(u.ID) {
+				continue // being written, the next sweep gets it
+			}
+			if err := removeUpload(context.Background(), u); err != nil {
+				log.Error("removing expired upload ", u.ID, " failed: ", err)
+			}
+			unlockUpload(u.ID)
+		}
+		if len(expired) > 0 {
+			log.Info("Swept ", len(expired), " expired uploads")
+		}
+		sweepStaging(time.Now().Add(-UPLOAD_EXPIRY))
+		sweepDrafts(time.Now())
+	}
+}
+
+// sweepStaging removes the staging files of legacy uploads last written before cutoff
+func sweepStaging(cutoff time.Time) {
+	staged, err := filepath.Glob(UPLOAD_STORAGE + "/" + stagingPrefix + "*")
+	if err != nil {
+		return
+	}
+	for _, path := range staged {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Error("removing expired staging file ", path, " failed: ", err)
+		}
+	}
+}
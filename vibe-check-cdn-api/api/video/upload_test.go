@@ -0,0 +1,202 @@
+package video
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"vibe-common/api"
+	"vibe-common/repository"
+)
+
+// vibeMetadata is the upload metadata of a file of the role recorded in Berlin
+func vibeMetadata(role string) map[string]string {
+	return map[string]string{"role": role, "lat": "52.52", "lon": "13.405", "location_name": "Berlin",
+		"time_stamp": "2021-06-01T12:00:00Z"}
+}
+
+func sha256Checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestPatchUpload(t *testing.T) {
+	file := testMovie()
+	chunk := file[:100]
+	sha1Sum := sha1.Sum(chunk)
+	tests := []struct {
+		name     string
+		user     string
+		headers  map[string]string
+		offset   int
+		checksum string
+		chunk    []byte
+		status   int
+		code     string
+		after    int // Upload-Offset HEAD answers after, -1 when the upload is gone
+	}{
+		{"first chunk", "alice", nil, 0, "", chunk, http.StatusNoContent, "", 100},
+		{"sha256 checksum", "alice", nil, 0, sha256Checksum(chunk), chunk, http.StatusNoContent, "", 100},
+		{"sha1 checksum", "alice", nil, 0, "sha1 " + base64.StdEncoding.EncodeToString(sha1Sum[:]), chunk, http.StatusNoContent, "", 100},
+		{"checksum of other bytes", "alice", nil, 0, sha256Checksum(file[1:101]), chunk, statusChecksumMismatch, api.CodeUploadChecksumMismatch, 0},
+		{"unknown checksum algorithm", "alice", nil, 0, "md5 AAAA", chunk, http.StatusBadRequest, api.CodeBadRequest, 0},
+		{"wrong offset", "alice", nil, 10, "", chunk, http.StatusConflict, api.CodeUploadOffsetMismatch, 0},
+		{"past Upload-Length", "alice", nil, 0, "", append(append([]byte{}, file...), 0), http.StatusRequestEntityTooLarge, api.CodeUploadTooLarge, 0},
+		{"someone else's upload", "bob", nil, 0, "", chunk, http.StatusNotFound, api.CodeUploadNotFound, 0},
+		{"not offset bytes", "alice", map[string]string{"Content-Type": "application/json"}, 0, "", chunk, http.StatusUnsupportedMediaType, api.CodeUnsupportedMediaType, 0},
+		{"other tus version", "alice", map[string]string{"Tus-Resumable": "0.2.2"}, 0, "", chunk, http.StatusPreconditionFailed, api.CodeTusVersionUnsupported, 0},
+		{"not a video", "alice", nil, 0, "", testPicture(t, 8, 8), http.StatusUnsupportedMediaType, api.CodeMediaTypeNotAllowed, -1},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := s.create("alice", vibeMetadata("video"), len(file))
+			headers := map[string]string{"Content-Type": offsetContentType, "Upload-Offset": strconv.Itoa(tt.offset), "Upload-Checksum": tt.checksum}
+			for key, value := range tt.headers {
+				headers[key] = value
+			}
+			w := s.do("PATCH", "/v1/uploads/"+id, tt.user, headers, tt.chunk)
+			if w.Code != tt.status {
+				t.Fatalf("PATCH = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.code != "" {
+				if code := errorCode(t, w); code != tt.code {
+					t.Errorf("error code %s, want %s", code, tt.code)
+				}
+			}
+
+			head := s.do("HEAD", "/v1/uploads/"+id, "alice", nil, nil)
+			if tt.after < 0 {
+				if head.Code != http.StatusNotFound {
+					t.Errorf("HEAD = %d, want the upload gone", head.Code)
+				}
+				return
+			}
+			if got := head.Header().Get("Upload-Offset"); head.Code != http.StatusOK || got != strconv.Itoa(tt.after) {
+				t.Errorf("HEAD = %d at offset %s, want offset %d", head.Code, got, tt.after)
+			}
+			if info, err := os.Stat(uploadPath(id)); err != nil || info.Size() != int64(tt.after) {
+				t.Errorf("upload file holds %v bytes, want %d", info.Size(), tt.after)
+			}
+		})
+	}
+}
+
+func TestFinalizeUpload(t *testing.T) {
+	s := newTestServer(t)
+	file := testMovie()
+
+	id := s.create("alice", vibeMetadata("video"), len(file))
+	s.patch("alice", id, 0, "", file[:100])
+	if w := s.do("POST", "/v1/uploads/"+id+"/finalize", "alice", nil, nil); w.Code != http.StatusConflict || errorCode(t, w) != api.CodeUploadIncomplete {
+		t.Errorf("finalizing an incomplete upload = %d, want 409 %s", w.Code, api.CodeUploadIncomplete)
+	}
+
+	id = s.create("alice", vibeMetadata("video"), len(file))
+	s.patch("alice", id, 0, "", file)
+	w := s.do("POST", "/v1/uploads/"+id+"/finalize", "alice", nil, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("finalize = %d %s", w.Code, w.Body)
+	}
+	var res VibeResponse
+	decode(t, w, &res)
+	sum := sha256.Sum256(file)
+	if res.VibeId != "alice-2021-06-01-12-00-00" || res.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("finalize = %+v", res)
+	}
+	if keys := s.keys("videos/"); len(keys) != 1 || keys[0] != "videos/"+res.LocationId+"/"+res.VibeId+"/video.mp4" {
+		t.Errorf("stored %q", keys)
+	}
+	if _, err := os.Stat(uploadPath(id)); !os.IsNotExist(err) {
+		t.Errorf("the upload file is left behind: %v", err)
+	}
+	vibes, _ := s.repos.Videos.ByUser(context.Background(), "alice", 0)
+	if len(vibes) != 1 || vibes[0].Meta.Width != 640 || vibes[0].Meta.Duration.Seconds() != 2 {
+		t.Errorf("recorded %+v", vibes)
+	}
+}
+
+func TestFinalizeRewindsToSuspectChunk(t *testing.T) {
+	file := testMovie()
+	half := len(file) / 2
+	tests := []struct {
+		name     string
+		verified [2]bool
+		resume   int
+	}{
+		{"second chunk unverified", [2]bool{true, false}, half},
+		{"first chunk unverified", [2]bool{false, true}, 0},
+		{"every chunk verified, the announced digest is wrong", [2]bool{true, true}, 0},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := vibeMetadata("video")
+			metadata["sha256"] = strings.Repeat("ab", sha256.Size)
+			id := s.create("alice", metadata, len(file))
+			for i, chunk := range [][]byte{file[:half], file[half:]} {
+				checksum := ""
+				if tt.verified[i] {
+					checksum = sha256Checksum(chunk)
+				}
+				if w := s.patch("alice", id, i*half, checksum, chunk); w.Code != http.StatusNoContent {
+					t.Fatalf("PATCH = %d %s", w.Code, w.Body)
+				}
+			}
+
+			w := s.do("POST", "/v1/uploads/"+id+"/finalize", "alice", nil, nil)
+			if w.Code != statusChecksumMismatch || w.Header().Get("Upload-Offset") != strconv.Itoa(tt.resume) {
+				t.Errorf("finalize = %d at offset %s, want %d at %d", w.Code, w.Header().Get("Upload-Offset"), statusChecksumMismatch, tt.resume)
+			}
+			if head := s.do("HEAD", "/v1/uploads/"+id, "alice", nil, nil); head.Header().Get("Upload-Offset") != strconv.Itoa(tt.resume) {
+				t.Errorf("HEAD at offset %s, want %d", head.Header().Get("Upload-Offset"), tt.resume)
+			}
+			if b, _ := ioutil.ReadFile(uploadPath(id)); len(b) != tt.resume {
+				t.Errorf("upload file holds %d bytes, want %d", len(b), tt.resume)
+			}
+		})
+	}
+}
+
+func TestSuspectOffset(t *testing.T) {
+	UPLOAD_STORAGE = t.TempDir()
+	data := []byte("0123456789")
+	if err := ioutil.WriteFile(uploadPath("upload"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(offset int, length int, verified bool) repository.UploadChunk {
+		sum := sha256.Sum256(data[offset : offset+length])
+		return repository.UploadChunk{Offset: int64(offset), Length: int64(length), SHA256: hex.EncodeToString(sum[:]), Verified: verified}
+	}
+	changed := chunk(4, 6, true)
+	changed.SHA256 = strings.Repeat("0", 64)
+
+	tests := []struct {
+		name   string
+		id     string
+		chunks []repository.UploadChunk
+		want   int64
+	}{
+		{"every chunk checks out", "upload", []repository.UploadChunk{chunk(0, 4, true), chunk(4, 6, true)}, 0},
+		{"second chunk unverified", "upload", []repository.UploadChunk{chunk(0, 4, true), chunk(4, 6, false)}, 4},
+		{"first chunk unverified", "upload", []repository.UploadChunk{chunk(0, 4, false), chunk(4, 6, true)}, 0},
+		{"bytes changed on disk", "upload", []repository.UploadChunk{chunk(0, 4, true), changed}, 4},
+		{"gap between chunks", "upload", []repository.UploadChunk{chunk(0, 4, true), chunk(6, 4, true)}, 4},
+		{"tail not recorded", "upload", []repository.UploadChunk{chunk(0, 4, true)}, 4},
+		{"file gone", "missing", []repository.UploadChunk{chunk(0, 4, true)}, 0},
+	}
+	for _, tt := range tests {
+		u := repository.Upload{ID: tt.id, Length: int64(len(data)), Chunks: tt.chunks}
+		if got := suspectOffset(u); got != tt.want {
+			t.Errorf("suspectOffset(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
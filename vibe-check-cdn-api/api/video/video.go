@@ -1,23 +1,31 @@
 package video
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 
 	"os"
 	"strconv"
 	"strings"
-	"vibe/api"
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/session"
+	"vibe-common/storage"
 
 	"fmt"
 	"hash/fnv"
 	"time"
-	"vibe/store"
-
-	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	"vibe/config"
+	mAPI "vibe/model/api"
+	"vibe/transcode"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -47,20 +55,25 @@ var ASSETS_CONTENT_STREAM string
 var ASSETS_IN_APP_ICONS string
 var FALLBACK_CONTENT string
 
-func init() {
+// whether uploaded videos wait for transcoding before they are served
+var TRANSCODE_ENABLED bool
 
-	// load .env
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("err loading: %v", err)
-	}
+// vibe_db access, set by Setup
+var repos *repository.Repos
+
+// Runs on startup, derives the storage and stream locations from the configuration
+func Setup(r *repository.Repos, s storage.Storage) {
+	repos = r
+	objects = s
+	forgetRendered()
+	STORAGE_PART_SIZE = int64(config.CONFIGURATION.STORAGE_PART_SIZE)
 
-	DESTINATION = os.Getenv("DESTINATION")
+	DESTINATION = config.CONFIGURATION.DESTINATION
 	VIBE_CONTENT_STORAGE = DESTINATION + "/videos"
 	ASSETS_CONTENT_STORAGE = DESTINATION + "/assets"
 	USER_CONTENT_STORAGE = DESTINATION + "/users"
 
-	STREAM_HOST = os.Getenv("STREAM_HOST")
+	STREAM_HOST = config.CONFIGURATION.STREAM_HOST
 	VIBE_CONTENT_STREAM = STREAM_HOST + "/videos"
 	ASSETS_CONTENT_STREAM = STREAM_HOST + "/assets"
 	USER_CONTENT_STREAM = STREAM_HOST + "/users"
@@ -69,463 +82,363 @@ func init() {
 
 	USER_PICTURE = "user.png"
 
-	VIBE_THUMBNAIL = os.Getenv("VIBE_THUMBNAIL")
-	VIBE_VIDEO = os.Getenv("VIBE_VIDEO")
-	VIBE_SELFIE = os.Getenv("VIBE_SELFIE")
+	VIBE_THUMBNAIL = config.CONFIGURATION.VIBE_THUMBNAIL
+	VIBE_VIDEO = config.CONFIGURATION.VIBE_VIDEO
+	VIBE_SELFIE = config.CONFIGURATION.VIBE_SELFIE
+
+	// file names end storage keys, one that could leave its folder is a configuration error
+	names := []string{VIBE_VIDEO, VIBE_THUMBNAIL, VIBE_SELFIE, USER_PICTURE}
+	for _, size := range pictureSizes {
+		names = append(names, renditionName(VIBE_THUMBNAIL, size), renditionName(USER_PICTURE, size))
+	}
+	for _, name := range names {
+		if _, err := storageKey(name); err != nil {
+			log.Fatal("Unusable stored file name: ", err)
+		}
+	}
+
+	TRANSCODE_ENABLED = config.CONFIGURATION.TRANSCODE_ENABLED
+
+	setupMediaLimits()
+	setupUploads()
+}
 
+// parseLatLon reads the coordinates of a request, reporting each bad one as a field error
+func parseLatLon(lat string, lon string) (float64, float64, []api.FieldError) {
+	var invalid []api.FieldError
+	lat_float, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		invalid = append(invalid, api.FieldError{Field: "lat", Message: "must be a number"})
+	}
+	lon_float, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		invalid = append(invalid, api.FieldError{Field: "lon", Message: "must be a number"})
+	}
+	return lat_float, lon_float, invalid
 }
 
-// Response -> response for the util scope
-type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Name    string `json:"name"`
+// parseContentRange reads the first and last byte of the chunk and the total file
+// size from a "bytes start-end/size" Content-Range header
+func parseContentRange(header string) (int, int, int, error) {
+	rangeAndSize := strings.Split(strings.TrimPrefix(strings.TrimSpace(header), "bytes "), "/")
+	rangeParts := strings.Split(rangeAndSize[0], "-")
+	if len(rangeAndSize) != 2 || len(rangeParts) != 2 {
+		return 0, 0, 0, errors.New("Content-Range header should look like bytes start-end/size")
+	}
+	rangeMin, err := strconv.Atoi(rangeParts[0])
+	if err != nil {
+		return 0, 0, 0, errors.New("Missing range in Content-Range header")
+	}
+	rangeMax, err := strconv.Atoi(rangeParts[1])
+	if err != nil || rangeMax < rangeMin {
+		return 0, 0, 0, errors.New("Missing range in Content-Range header")
+	}
+	fileSize, err := strconv.Atoi(rangeAndSize[1])
+	if err != nil {
+		return 0, 0, 0, errors.New("Missing file size in Content-Range header")
+	}
+	return rangeMin, rangeMax, fileSize, nil
 }
 
 // helper function to generate the location hash based off the name, lat an long
 func GenerateLocationHashString(name string, lat string, long string) string {
-
-	log.WithFields(log.Fields{
-		"name": name,
-		"lat":  lat,
-		"long": long,
-	}).Trace("generating location hash")
-
 	hash32 := fnv.New32a()
 	hash32.Write([]byte(name + lat + long))
-	hashString := strconv.Itoa(int(hash32.Sum32()))
-
-	log.WithFields(log.Fields{
-		"hashString": hashString,
-	}).Trace("location hash generated")
-
-	return hashString
+	return strconv.Itoa(int(hash32.Sum32()))
 }
 
 func ChunkUploadHandler(w http.ResponseWriter, r *http.Request) {
-
-	log.Trace("entered video upload handler")
-
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-	var f *os.File
-
-	file, uploadFile, err := r.FormFile("file")
-
+	reqLog := logging.FromContext(r.Context())
+	req := openapi.Request(r).(*mAPI.ChunkUploadRequest)
+	uploadFile := req.File
+	file, err := uploadFile.Open()
 	if err != nil {
-		response.Message = "error occured while reading in file -> "
-		log.Info(response.Message, err.Error())
-		api.Respond(w, response, http.StatusBadRequest)
+		reqLog.Error("error occured while reading in file -> ", err.Error())
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error reading the chunk")
 		return
 	}
+	defer file.Close()
 
 	// Content-Range needed in header to determine overall size and
 	// what chunk we are currently working with
-	contentRangeHeader := r.Header.Get("Content-Range")
-	rangeAndSize := strings.Split(contentRangeHeader, "/")
-	rangeParts := strings.Split(rangeAndSize[0], "-")
-
-	log.Trace("current content range: ", contentRangeHeader)
-
-	// get max range
-	rangeMax, err := strconv.Atoi(rangeParts[1])
+	rangeMin, rangeMax, fileSize, err := parseContentRange(req.ContentRange)
 	if err != nil {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": "Missing range in Content-Range header"})
-		response.Message = "Missing range in Content-Range header"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+		api.RespondError(w, http.StatusBadRequest, api.CodeUploadRangeInvalid, err.Error())
 		return
 	}
 
-	// get files size
-	fileSize, err := strconv.Atoi(rangeAndSize[1])
-	if err != nil {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file size in Content-Range header"})
-		response.Message = "Missing file size in Content-Range header"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+	// x-file-name only picks the role, the file is stored under the name the server gives it
+	role := legacyRole(req.FileName)
+	if role == "" {
+		api.RespondInvalid(w, api.FieldError{Field: "x-file-name", Message: "must be one of " + strings.Join(roles, ", ")})
 		return
 	}
 
-	// validate file size is within max bounds (100MB)
-	if fileSize > 100*1024*1024 {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": "File size should be less than 100MB"})
-		response.Message = "File size should be less than 100MB"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+	// validate file size is within the bounds of its role
+	if int64(fileSize) > mediaLimits[role].MaxSize {
+		respondTooLarge(w, role)
 		return
 	}
 
 	// extract locationName, lat, long; concatenate string, and use as input for generating locationHash
-	log.Trace("locationName processing...")
-	locationName := r.FormValue("locationName")
-	lat := r.FormValue("lat")
-	lon := r.FormValue("lon")
-
-	// take string lat and lon and make into float
-	lat_float, err := strconv.ParseFloat(lat, 64)
-	lon_float, err := strconv.ParseFloat(lon, 64)
-	if err != nil {
-		response.Message = "there was an error parsing the lat and long"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
+	locationName := req.LocationName
+	lat_float, lon_float := req.Lat, req.Lon
 
 	// expand float to have 9 decimal points as is the the db table criteria
 	formattedLat := fmt.Sprintf("%.9f", lat_float)
 	formattedLon := fmt.Sprintf("%.9f", lon_float)
 	locationHash := GenerateLocationHashString(locationName, formattedLat, formattedLon)
 
-	// extract time_stamp and parse to match golang time.Time format, to have correct formatting when SQL inserting
-	log.Info("time_stamp processing...")
-	time_stamp_unparsed := r.FormValue("time_stamp")
-	log.Info(time_stamp_unparsed)
-	layout_react_native_time_stamp := "2006-01-02T15:04:05.000Z" // Must specify the layout of the input string
-	time_stamp, err := time.Parse(layout_react_native_time_stamp, time_stamp_unparsed)
-	if err != nil {
-		log.Info("time_stamp parsing issue")
-		log.Info(err)
-		return
-	}
-	log.Info(time_stamp) // Output: 2022-12-29 23:46:02
+	// time_stamp arrives parsed into time.Time from e.g. 2022-12-29T23:46:02.000Z, to have correct formatting when SQL inserting
+	time_stamp := req.TimeStamp
 	layout_folder_time_stamp := "2006-01-02-15-04-05"
 	time_stamp_folder := time_stamp.Format(layout_folder_time_stamp)
 
 	// extract user_id to provide linking of who posted this video
-	log.Info("user_id processing...")
-	user_id := r.FormValue("user_id")
-	log.Info(user_id)
-
-	filepath := VIBE_CONTENT_STORAGE + "/" + string(locationHash) + "/" + string(user_id) + "-" + string(time_stamp_folder)
-	log.Info("File location-----> " + filepath)
-	filename := r.Header.Get("x-file-name") //uploadFile.Filename
-	log.Info("Filename-----> " + (filename))
+	user_id := req.UserId
 
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		err := os.MkdirAll(filepath, 0777)
-		if err != nil {
-			response.Message = "Error creating temporary directory: " + filepath
-			log.Info(response.Message)
-			api.Respond(w, response, http.StatusBadRequest)
-			return
-		}
+	video_folder := string(user_id) + "-" + string(time_stamp_folder)
+	key, err := vibeKey(locationHash, video_folder, role)
+	if err != nil {
+		api.RespondInvalid(w, api.FieldError{Field: "user_id", Message: "must only hold letters, digits, '.', '_' and '-'"})
+		return
 	}
 
-	full_filepath := (filepath) + "/" + filename
-	// create/append to current file being copied
-	if f == nil {
-		f, err = os.OpenFile(full_filepath, os.O_APPEND|os.O_CREATE|os.O_RDWR, os.ModeAppend)
-		if err != nil {
-			response.Message = "Error creating file"
-			log.Info(response.Message)
-			api.Respond(w, response, http.StatusBadRequest)
-			return
-		}
+	// chunks are assembled in a staging file, the finished file is stored under the key
+	f, full_filepath, err := openStaging(key)
+	if err != nil {
+		reqLog.Error("Error creating file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error creating file")
+		return
 	}
+	defer f.Close()
 
-	// copies bytes from file chunk to the file
-	if _, err := io.Copy(f, file); err != nil {
-		response.Message = "Error writing to a file"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+	written, ok := writeChunk(w, r, f, file, rangeMin, rangeMax)
+	if !ok {
 		return
 	}
+	chunksReceived.WithLabelValues("vibe").Inc()
+	bytesReceived.WithLabelValues("vibe").Add(float64(written))
 
-	log.Trace("rangeMax: ", rangeMax, " fileSize: ", fileSize)
-
-	// close file and report status
-	defer f.Close()
+	// report status
 	if rangeMax >= fileSize-1 {
-		combinedFile := (VIBE_CONTENT_STORAGE + "/" + string(locationHash) + "/" + string(user_id) + "-" + string(time_stamp_folder) + "/" + filename)
-
-		uploadingFile, err := os.Open(combinedFile)
-		if err != nil {
-			response.Message = "Failed to upload file "
-			log.Info(response.Message)
-			api.Respond(w, response, http.StatusBadRequest)
+		mediaInfo, ok := keepMedia(w, r, full_filepath, role, func() error { return os.Remove(full_filepath) })
+		if !ok {
 			return
 		}
-		uploadingFile.Close()
 
-		log.Info("video file sucessfully hit the server")
-
-		// INSERT into locations table if first-ever video upload to location
-		query := "INSERT INTO locations (location_hash, location_name, lat, lon) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT * FROM locations WHERE location_hash = ?)"
-		video_folder := string(user_id) + "-" + string(time_stamp_folder)
-
-		result, err := store.DB.Exec(query, locationHash, locationName, formattedLat, formattedLon, locationHash)
+		digest, err := fileSHA256(full_filepath)
 		if err != nil {
-			log.Info("INSERT INTO locations failed: ")
-			log.Info(err.Error())
+			reqLog.Error("Failed to upload file: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Failed to upload file")
 			return
-		} else {
-			rows, err := result.RowsAffected()
-			if err != nil {
-				log.Error("error retrieving rows affected, database most likely not supported")
-			} else {
-				if rows != 0 {
-					log.Info("added to locations , rows affected: ", rows)
-				}
-			}
 		}
 
-		// INSERT into general videos store
-		query = "INSERT INTO `vibe_db`.`all_videos` (`video_folder`, `location_hash`, `user_id`, `time_stamp`, `like_count`) VALUES (?, ?, ?, ?, ?)"
-		video_folder = string(user_id) + "-" + string(time_stamp_folder)
-
-		result, err = store.DB.Exec(query, video_folder, locationHash, user_id, time_stamp, 0)
-		// result := store.DB.QueryRow(query, locationHash, uID_timeStamp)
-		if err != nil {
-			log.Error("add vibe to all_videos table failed: ", err.Error())
-			return
-		} else {
-			rows, err := result.RowsAffected()
-			if err != nil {
-				log.Error("error retrieving rows affected, database most likely not supported")
-			} else {
-				if rows != 0 {
-					log.Info("added vibe to all_videos, rows affected: ", rows)
-				}
+		if role == "thumbnail" {
+			if !keepPicture(w, r, full_filepath, key, role, func() error { return os.Remove(full_filepath) }) {
+				return
 			}
+		} else if err := storeFile(r.Context(), full_filepath, key, string(mediaInfo.Type)); err != nil {
+			reqLog.Error("Error storing file: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error storing the file")
+			return
 		}
 
-		// INSERT into `latest.video` not `all_videos` store {locationHash: latestVideo}
-		// query = "INSERT INTO `vibe_db`.`latest_videos` (`location_hash`, `video_folder`) VALUES (?, ?) ON DUPLICATE KEY UPDATE video_folder = VALUES(video_folder)"
-		query = "INSERT INTO `vibe_db`.`latest_videos` (`video_folder`, `location_hash`, `user_id`, `time_stamp`, `like_count`) VALUES (?, ?, ?, ?, ?)"
-
-		result, err = store.DB.Exec(query, locationHash, video_folder)
-		if err != nil {
-			log.Error("add vibe to latest_videos failed, ", err.Error())
-		} else {
-			rows, err := result.RowsAffected()
-			if err != nil {
-				log.Error("error retrieving rows affected, database most likely not supported")
-			} else {
-				if rows != 0 {
-					log.Info("added vibe to latest_videos , rows affected: ", rows)
-				}
+		// the thumbnail and selfie join the vibe the video records
+		if role == "video" {
+			location := repository.Location{Hash: locationHash, Name: locationName, Lat: lat_float, Lon: lon_float}
+			vibe := repository.Video{Folder: video_folder, LocationHash: locationHash, UserID: user_id, TimeStamp: time_stamp, SHA256: digest, Meta: videoMeta(mediaInfo)}
+			if !recordVibe(w, r, location, vibe, key) {
+				// the staging file stays, so resending the last chunk retries
+				objects.Delete(r.Context(), key)
+				return
 			}
 		}
+		RemoveFile(full_filepath)
+		uploadsCompleted.WithLabelValues("vibe").Inc()
 
-		log.Info("sucessfully created database items for current video")
-
-		// if strings.HasSuffix(filename, ".mp4") {
-
-		// 	// perform chunking of .mp4 file to allow for increased ability to stream content
-		// 	input_url := VIBE_CONTENT_STORAGE + "/" + string(locationHash) + "/" + string(user_id) + "-" + string(time_stamp_folder) + "/" + string(filename)
-		// 	storage_location := VIBE_CONTENT_STORAGE + "/" + string(locationHash) + "/" + string(user_id) + "-" + string(time_stamp_folder) + "/"
-		// 	output_file := storage_location + "outputlist.m3u8"
-
-		// 	log.Info("running ffmpeg")
-
-		// 	err = ffmpeg_go.Input(input_url).
-		// 		Output(output_file, ffmpeg_go.KwArgs{
-		// 			// "b:v":               "5000k",
-		// 			"s":        "720:1280",
-		// 			"level":    "3.0",
-		// 			"hls_time": "1",
-		// 			// "hls_playlist_type": "vod",
-		// 			// "hls_flags":         "independent_segments",
-		// 			// "hls_segment_type":  "mpegts",
-		// 			"hls_list_size": "0",
-		// 			"f":             "hls"}).
-		// 		OverWriteOutput().ErrorToStdOut().Run()
-
-		// 	log.Info("output file path: " + output_file)
-
-		// 	if err != nil {
-		// 		// error occured, removing file from the directory
-		// 		log.Error("error occured running ffmpeg" + err.Error())
-		// 		RemoveFile(storage_location + "video.mp4")
-		// 		return
-		// 	} else {
-		// 		log.Info("ffmpeg completed")
-		// 	}
-
-		// 	log.Info("generating thumbnail")
-
-		// 	// generate thumbnail image of .mp4 file to use when looking at pins/ReelsView
-		// 	input_url = VIBE_CONTENT_STORAGE + "/" + string(locationHash) + "/" + string(user_id) + "-" + string(time_stamp_folder) + "/" + string(filename)
-		// 	output_location_thumbnail := VIBE_CONTENT_STORAGE + "/" + string(locationHash) + "/" + string(user_id) + "-" + string(time_stamp_folder) + "/" + "thumbnail.jpg"
-
-		// 	err = ffmpeg_go.Input(input_url).Output(output_location_thumbnail, ffmpeg_go.KwArgs{
-		// 		"ss":      "00:00:01",
-		// 		"vframes": "1",
-		// 		"s":       "320x640"}).
-		// 		OverWriteOutput().ErrorToStdOut().Run()
-
-		// 	if err != nil {
-		// 		// error occured, removing file from the directory
-		// 		log.Error("error occured running ffmpeg" + err.Error())
-		// 		RemoveFile(storage_location + "video.mp4")
-		// 		return
-		// 	} else {
-		// 		log.Info("ffmpeg thumbnail completed")
-		// 	}
-
-		// }
-
-		response.Message = "file successfully uploaded"
-		response.Success = true
-		response.Name = uploadFile.Filename
-		log.Info(response.Message)
-		api.RespondOK(w, response)
+		reqLog.WithField("role", role).Info("legacy upload stored into ", key)
+		api.RespondSuccess(w, http.StatusOK, uploadFile.Filename, "file successfully uploaded")
 	} else {
-		response.Message = "file chunk hit the server, moving on to the next chunk"
-		response.Success = true
-		response.Name = uploadFile.Filename
-		log.Info(response.Message)
-		api.Respond(w, response, 201) // alows the next() function to be called on client api
+		api.RespondSuccess(w, http.StatusCreated, uploadFile.Filename, "file chunk hit the server, moving on to the next chunk") // alows the next() function to be called on client api
 	}
 
 }
 
-// helper function to remove a file when given a path
-func RemoveFile(file string) {
-	log.Trace("attemtping to remove file: ", file)
-	e := os.Remove(file)
-	if e != nil {
-		log.Fatal(e)
-	} else {
-		log.Info("sucessfully removed file: ", file)
+// writeChunk writes the chunk of a legacy upload where its Content-Range starts, so a retried
+// chunk replaces itself instead of being appended twice. A chunk past the end of the staging
+// file would leave a gap and answers 409, one shorter or longer than its range 400 and is
+// dropped; false means the request was answered.
+func writeChunk(w http.ResponseWriter, r *http.Request, f *os.File, chunk io.Reader, rangeMin int, rangeMax int) (int64, bool) {
+	reqLog := logging.FromContext(r.Context())
+	info, err := f.Stat()
+	if err != nil {
+		reqLog.Error("Error reading file size: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error writing to a file")
+		return 0, false
+	}
+	if int64(rangeMin) > info.Size() {
+		api.RespondError(w, http.StatusConflict, api.CodeUploadOffsetMismatch, fmt.Sprintf("next chunk must start at byte %d", info.Size()))
+		return 0, false
+	}
+	if err = f.Truncate(int64(rangeMin)); err == nil {
+		_, err = f.Seek(int64(rangeMin), io.SeekStart)
+	}
+	if err != nil {
+		reqLog.Error("Error seeking in file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error writing to a file")
+		return 0, false
+	}
+
+	written, err := io.Copy(f, chunk)
+	if err != nil {
+		f.Truncate(int64(rangeMin))
+		reqLog.Error("Error writing to a file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error writing to a file")
+		return 0, false
 	}
+	if written != int64(rangeMax-rangeMin+1) {
+		f.Truncate(int64(rangeMin))
+		api.RespondError(w, http.StatusBadRequest, api.CodeUploadRangeInvalid, fmt.Sprintf("chunk has %d bytes, Content-Range says %d", written, rangeMax-rangeMin+1))
+		return 0, false
+	}
+	return written, true
 }
 
-func UserPicUploadHandler(w http.ResponseWriter, r *http.Request) {
+// recordVibe adds the rows of an uploaded vibe whose video is stored under sourceKey, queueing
+// it for transcoding when that is enabled. Answers 500 and returns false when that fails.
+func recordVibe(w http.ResponseWriter, r *http.Request, location repository.Location, vibe repository.Video, sourceKey string) bool {
+	reqLog := logging.FromContext(r.Context())
+	// INSERT into locations table if first-ever video upload to location
+	if err := repos.Locations.Ensure(r.Context(), location); err != nil {
+		reqLog.Error("add location failed: ", err.Error())
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the location")
+		return false
+	}
+
+	// the video is served once transcoded, the worker then makes it the location's latest
+	if TRANSCODE_ENABLED {
+		if err := repos.Transcodes.Enqueue(r.Context(), vibe, sourceKey, time.Now()); err != nil {
+			reqLog.Error("queueing vibe for transcoding failed: ", err.Error())
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the video")
+			return false
+		}
+		transcode.Notify()
+		return true
+	}
+
+	// INSERT into general videos store
+	if err := repos.Videos.Create(r.Context(), vibe); err != nil {
+		reqLog.Error("add vibe to all_videos table failed: ", err.Error())
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the video")
+		return false
+	}
 
-	log.Info("in user pic upload handler-------------------------")
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
+	// latest_videos keeps one video per location {locationHash: latestVideo}
+	if err := repos.Videos.SetLatest(r.Context(), vibe); err != nil {
+		reqLog.Error("add vibe to latest_videos failed, ", err.Error())
 	}
-	log.Info(response.Message)
-	var f *os.File
 
-	file, uploadFile, err := r.FormFile("file")
+	return true
+}
 
+// fileSHA256 returns the hex SHA-256 digest of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": "content-type should be multipart/formdata"})
-		log.Info(response.Message)
+		return "", err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// helper function to remove a file when given a path, the caller decides what a failure means
+func RemoveFile(file string) error {
+	if err := os.Remove(file); err != nil {
+		log.Error("unable to remove file: ", err)
+		return err
+	}
+	return nil
+}
+
+func UserPicUploadHandler(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
+	req := openapi.Request(r).(*mAPI.UserPicUploadRequest)
+	uploadFile := req.File
+	file, err := uploadFile.Open()
+	if err != nil {
+		reqLog.Error("error occured while reading in user pic file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error reading the chunk")
 		return
 	}
+	defer file.Close()
 
-	// user_id
 	// extract user_id to provide linking of whose photo this is
-	log.Info("user_id processing: ")
-	user_id := r.FormValue("user_id")
-	log.Info(user_id)
+	user_id := req.UserId
 
 	// Content-Range needed in header to determine overall size and
 	// what chunk we are currently working with
-	contentRangeHeader := r.Header.Get("Content-Range")
-	rangeAndSize := strings.Split(contentRangeHeader, "/")
-	rangeParts := strings.Split(rangeAndSize[0], "-")
-
-	// get max range
-	rangeMax, err := strconv.Atoi(rangeParts[1])
+	rangeMin, rangeMax, fileSize, err := parseContentRange(req.ContentRange)
 	if err != nil {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": "Missing range in Content-Range header"})
-		response.Message = "Missing range in Content-Range header"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+		api.RespondError(w, http.StatusBadRequest, api.CodeUploadRangeInvalid, err.Error())
 		return
 	}
 
-	// get files size
-	fileSize, err := strconv.Atoi(rangeAndSize[1])
-	if err != nil {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file size in Content-Range header"})
-		response.Message = "Missing file size in Content-Range header"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+	// validate file size is within the bounds of a user picture
+	if int64(fileSize) > mediaLimits["user_picture"].MaxSize {
+		respondTooLarge(w, "user_picture")
 		return
 	}
 
-	// validate file size is within max bounds (100MB)
-	if fileSize > 100*1024*1024 {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": "File size should be less than 100MB"})
-		response.Message = "File size should be less than 100MB"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+	// the picture is stored as USER_PICTURE whatever the client named it, with its renditions next to it
+	key, err := userPictureKey(user_id)
+	if err != nil {
+		api.RespondInvalid(w, api.FieldError{Field: "user_id", Message: "must only hold letters, digits, '.', '_' and '-'"})
 		return
 	}
 
-	// create temp directory
-	log.Info("File location----->" + (USER_CONTENT_STORAGE + "/" + string(user_id)))
-	filename := uploadFile.Filename
-	log.Info("Filename----->" + (filename))
-
-	if _, err := os.Stat(USER_CONTENT_STORAGE + "/" + string(user_id)); os.IsNotExist(err) {
-		err := os.MkdirAll(USER_CONTENT_STORAGE+"/"+string(user_id), 0777)
-		if err != nil {
-			// c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating temporary directory"})
-			response.Message = "Error creating temporary directory"
-			log.Info(response.Message)
-			api.Respond(w, response, http.StatusBadRequest)
-			return
-		}
-	}
-
-	// create/append to current file being copied
-	if f == nil {
-		f, err = os.OpenFile((USER_CONTENT_STORAGE+"/"+string(user_id))+"/"+filename, os.O_TRUNC|os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			// c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating file"})
-			response.Message = "Error creating user pic file"
-			log.Info(response.Message)
-			api.Respond(w, response, http.StatusBadRequest)
-			return
-		}
+	// chunks are assembled in a staging file, the current picture stays until the new one checks out
+	f, combinedFile, err := openStaging(key)
+	if err != nil {
+		reqLog.Error("Error creating user pic file: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeUploadFailed, "Error creating user pic file")
+		return
 	}
+	defer f.Close()
 
-	// copies bytes from file chunk to the file
-	if _, err := io.Copy(f, file); err != nil {
-		// c.JSON(http.StatusInternalServerError, gin.H{"error": "Error writing to a file"})
-		response.Message = "Error writing to user pic file"
-		log.Info(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+	// like vibe chunks, each chunk is written where its range starts
+	written, ok := writeChunk(w, r, f, file, rangeMin, rangeMax)
+	if !ok {
 		return
 	}
+	chunksReceived.WithLabelValues("user_picture").Inc()
+	bytesReceived.WithLabelValues("user_picture").Add(float64(written))
 
-	// close file and report status
-	defer f.Close()
+	// the picture is linked once all of it arrived and it checks out
 	if rangeMax >= fileSize-1 {
-		combinedFile := (USER_CONTENT_STORAGE + "/" + string(user_id) + "/" + filename)
-
-		uploadingFile, err := os.Open(combinedFile)
-		if err != nil {
-			response.Message = "Failed to upload user pic file "
-			log.Info(response.Message)
-			api.Respond(w, response, http.StatusBadRequest)
+		discard := func() error { return os.Remove(combinedFile) }
+		if _, ok := keepMedia(w, r, combinedFile, "user_picture", discard); !ok {
 			return
 		}
-		uploadingFile.Close()
-
-		// return
-	}
-
-	query := "UPDATE users SET photo = ? WHERE user_id = ?"
+		if !keepPicture(w, r, combinedFile, key, "user_picture", discard) {
+			return
+		}
+		RemoveFile(combinedFile)
+		uploadsCompleted.WithLabelValues("user_picture").Inc()
 
-	result, err := store.DB.Exec(query, true, user_id)
-	// result := store.DB.QueryRow(query, locationHash, uID_timeStamp)
-	if err != nil {
-		log.Info("upload user pic failed:")
-		log.Info(err)
-	} else {
-		log.Info("upload user pic worked:")
-		log.Info(result)
+		if err := repos.Users.SetPhoto(r.Context(), user_id, true); err != nil {
+			reqLog.Error("upload user pic failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the user pic")
+			return
+		}
+		reqLog.Info("legacy upload stored into ", key)
 	}
 
-	response.Message = "Uploaded User Pic File Successfully"
-	response.Success = true
-	response.Name = uploadFile.Filename
-	log.Info(response.Message)
-	api.Respond(w, response, http.StatusOK)
+	api.RespondSuccess(w, http.StatusOK, USER_PICTURE, "Uploaded User Pic File Successfully")
 
 }
 
@@ -545,29 +458,10 @@ type GiftedChatStruct struct {
 }
 
 func ChatMessageUpload(w http.ResponseWriter, r *http.Request) {
-	log.Info("in chat msg upload handler-------------------------")
-	log.Info(r)
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	log.Info("location_name processing: ")
-	location_name := r.FormValue("location_name")
-	log.Info(location_name)
-	lat := r.FormValue("lat")
-	lon := r.FormValue("lon")
-	thread_name := r.FormValue("thread_name")
-
-	// latString := "39.950"
-
-	// take string lat and lon and make into float
-	lat_float, err := strconv.ParseFloat(lat, 64)
-	lon_float, err := strconv.ParseFloat(lon, 64)
-	if err != nil {
-		// handle error
-	}
+	req := openapi.Request(r).(*mAPI.ChatMessageRequest)
+	location_name := req.LocationName
+	lat_float, lon_float := req.Lat, req.Lon
+	thread_name := req.ThreadName
 
 	// expand float to have 9 decimal points as is the the db table criteria
 	formattedLat := fmt.Sprintf("%.9f", lat_float)
@@ -576,61 +470,43 @@ func ChatMessageUpload(w http.ResponseWriter, r *http.Request) {
 	locationHash := GenerateLocationHashString(location_name, formattedLat, formattedLon)
 
 	// var msg []GiftedChatStruct
-	text := r.FormValue("text")
-	user_id := r.FormValue("user_id")
-	createdAtRaw := r.FormValue("createdAt")
-	_id := r.FormValue("_id")
+	text := req.Text
+	user_id := req.UserId
+	createdAt := req.CreatedAt
+	_id := req.Id
 
 	// json.Unmarshal([]byte(message), &msg)
 	// if err != nil {
 	// 	fmt.Println("error:", err)
 	// }5
 	// fmt.Printf("%+v", msg)
-	log.Info("text is: ")
-	log.Info(text)
-	log.Info("user_id is: ")
-	log.Info(user_id)
-	log.Info("createdAt is: ")
-	log.Info(createdAtRaw)
-	log.Info("_id is: ") // message _id
-	log.Info(_id)
-
-	// extract time_stamp and parse to match golang time.Time format, to have correct formatting when SQL inserting
-	log.Info("time_stamp processing: ")
-	layout_react_native_time_stamp := "2006-01-02T15:04:05.000Z" // Must specify the layout of the input string
-	createdAt, err := time.Parse(layout_react_native_time_stamp, createdAtRaw)
-	if err != nil {
-		// Handle error
-		log.Info("time_stamp parsing issue")
-		log.Info(err)
-		return
-	}
-	log.Info(createdAt) // Output: 2022-12-29 23:46:02
 
 	// insert into table all_chats, most likely will need to use a noSQL db but for now using mysql since we have boilerplate code/know-how
 
 	// insert into all_chats
-	query := "INSERT INTO all_chats (location_hash, thread_name, _id, msg_text, createdAt, user_id) SELECT ?, ?, ?, ?, ?, ?"
-	log.Info(query)
-	result, err := store.DB.Exec(query, locationHash, thread_name, _id, text, createdAt, user_id)
-	// result := store.DB.QueryRow(query, locationHash, uID_timeStamp)
-	if err != nil {
-		log.Info("INSERT INTO all_chats failed:")
-		log.Info(err)
+	chat := repository.Chat{ID: _id, LocationHash: locationHash, Thread: thread_name, UserID: user_id, CreatedAt: createdAt, Text: text}
+	if err := repos.Chats.Create(r.Context(), chat); err != nil {
+		logging.FromContext(r.Context()).Error("INSERT INTO all_chats failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to store the chat message")
 		return
-	} else {
-		log.Info("no isses with all_chats, insert worked:")
-		log.Info(result)
 	}
 
-	response.Message = "Uploaded Chat Message Successfully"
-	response.Success = true
-	// response.Name = user_id + createdAt
-	log.Info(response.Message)
-	api.Respond(w, response, http.StatusOK)
+	api.RespondSuccess(w, http.StatusOK, "", "Uploaded Chat Message Successfully")
 
 }
 
+// PostLocationMessage posts a message of the user of the session to a location thread
+func PostLocationMessage(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.LocationMessageRequest)
+	chat := repository.Chat{ID: req.Id, LocationHash: req.LocationId, Thread: req.Thread, UserID: session.UserID(r), CreatedAt: req.CreatedAt, Text: req.Text}
+	if err := repos.Chats.Create(r.Context(), chat); err != nil {
+		logging.FromContext(r.Context()).Error("INSERT INTO all_chats failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to store the chat message")
+		return
+	}
+	api.RespondSuccess(w, http.StatusCreated, req.Id, "Uploaded Chat Message Successfully")
+}
+
 /*
 Get the most recent location data via the location name, latitude, longitude, and requesting user from the database
 */
@@ -639,12 +515,6 @@ func GetLocationChat(w http.ResponseWriter, r *http.Request) {
 	//temp path location
 	//UPLOADS_DIR := "/root/UPLOADS/"
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
 	// params := mux.Vars(r)
 
 	// locationName_raw := params["locationName"]
@@ -654,168 +524,68 @@ func GetLocationChat(w http.ResponseWriter, r *http.Request) {
 	// lon_raw := params["lon"]
 	// // latString := "39.950"
 
-	log.Info("location_name processing: ")
-	locationName_raw := r.FormValue("location_name")
-	log.Info(locationName_raw)
-	lat_raw := r.FormValue("lat")
-	lon_raw := r.FormValue("lon")
-	threadName := r.FormValue("thread_name")
-
-	// take string lat and lon and make into float
-	lat_float, err := strconv.ParseFloat(lat_raw, 64)
-	lon_float, err := strconv.ParseFloat(lon_raw, 64)
-	if err != nil {
-		// handle error
-		log.Info("issue with lat/lon float gen in GetLocationChat")
-	}
+	req := openapi.Request(r).(*mAPI.LocationChatRequest)
+	locationName_raw := req.LocationName
+	lat_float, lon_float := req.Lat, req.Lon
+	threadName := req.ThreadName
 
 	// expand float to have 9 decimal points as is the the db table criteria
 	formattedLat := fmt.Sprintf("%.9f", lat_float)
 	formattedLon := fmt.Sprintf("%.9f", lon_float)
 	locationHash := GenerateLocationHashString(locationName_raw, formattedLat, formattedLon)
+	respondChat(w, r, locationHash, threadName)
+}
 
-	// Query db for all videos based on user_id
-	// ending result set, a list of videos, should contain:
-	// locationHash + video_folder (these values are used to generate the hyperlink that the streaming service will respond to)
-	// time_stamp (to show on ReelsView)
-	// location_name (to show on ReelsView)
-	// lat, lon (to navigate to given location in MapView when user taps on title in ReelsView)
-	// username (to display on ReelsView)
-	// profile_picture (to display on ReelsViews)
+// GetLocationMessages reads the messages of a location thread
+func GetLocationMessages(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.LocationMessagesQuery)
+	respondChat(w, r, req.LocationId, req.Thread)
+}
 
-	var payload = []byte(`{"messages": [`)
-	var entry interface{}
-	// log.Info(entry)
-	//
-	rows, err := store.DB.Query("SELECT * FROM all_chats WHERE location_hash = ? AND thread_name = ?  ORDER BY createdAt DESC", locationHash, threadName)
+// respondChat answers with the messages of a location thread, oldest first
+func respondChat(w http.ResponseWriter, r *http.Request, locationHash string, threadName string) {
+	reqLog := logging.FromContext(r.Context())
+	chats, err := repos.Chats.Thread(r.Context(), locationHash, threadName)
 	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Info("error in location-indexed chat SQL query")
-			log.Info(err)
-			panic(err)
-		} else {
-			// NO VIDEO RESULT IN DB - send empty array
-			// log.Info("result set is empty in chat SQL query")
-
-			payload = append(payload, []byte(`]}`)...)
-			api.RespondRaw(w, payload, http.StatusOK)
-		}
-
+		reqLog.Error("error in location-indexed chat SQL query: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to connect to database server")
+		return
 	}
 
-	// log.Info("query is not null")
-
-	// if we get here that means we have videos to send to client
-	defer rows.Close()
-	// buf := bytes.NewBuffer(newJSON)
-	// encoder := json.NewEncoder(buf)
-
-	var id = 0
-	for rows.Next() {
-		var _id string
-		var location_hash string
-		var thread_name string
-		var user_id string //as an int yes, no?
-		var createdAt time.Time
-		var text string
+	var payload = []byte(`{"messages": [`)
+	for i, chat := range chats {
+		//create user_pic_link to use as avatar
+		user_pic_link := userPicLink(r.Context(), chat.UserID, chatPictureSize)
 
-		// log.Info("in rows.next")
-		err := rows.Scan(&_id, &location_hash, &thread_name, &user_id, &createdAt, &text)
+		userStruct := GiftedUserStruct{Id: chat.UserID, UserID: chat.UserID, Avatar: user_pic_link}
+		chatStruct := GiftedChatStruct{Id: chat.ID, Text: chat.Text, CreatedAt: chat.CreatedAt, User: userStruct}
 
+		// Encode the data to JSON
+		jsonData, err := json.Marshal(chatStruct)
 		if err != nil {
-			panic(err)
-		} else {
-			// log.Info("found video result in db preparing chat struct")
-			// FOUND VIDEO RESULT IN DB - send VideoStruct
-
-			//create user_pic_link to use as avatar
-			user_pic_link := USER_CONTENT_STREAM + "/" + user_id + "/" + USER_PICTURE
-
-			// type GiftedUserStruct struct {
-			// 	// _id    int    `json:"_id"`
-			// 	Id    string `json:"_id"` // will using string be an issue? // setting _id to be same value as name for now
-			// 	Name   string `json:"name"`
-			// 	Avatar string `json:"avatar"`
-			// }
-
-			// type GiftedChatStruct struct {
-			// 	// _id       int        `json:"_id"`
-			// 	Id       string           `json:"_id"` // will using string be an issue?
-			// 	Text      string           `json:"text"`
-			// 	CreatedAt time.Time        `json:"createdAt"`
-			// 	User      GiftedUserStruct `json:"user"`
-			// }
-
-			userStruct := GiftedUserStruct{Id: user_id, UserID: user_id, Avatar: user_pic_link}
-
-			chatStruct := GiftedChatStruct{Id: _id, Text: text, CreatedAt: createdAt, User: userStruct}
-
-			id = id + 1
-			// Encode the data to JSON
-			entry = chatStruct
-			jsonData, err := json.Marshal(entry)
-			if err != nil {
-				log.Info(err)
-				return
-			}
+			reqLog.Error(err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to encode the chat")
+			return
+		}
 
-			payload = append(payload, jsonData...)
+		if i > 0 {
 			payload = append(payload, []byte(`,`)...)
-			// log.Info("adding result row to response")
 		}
-		// log.Info(_id, location_hash, name, createdAt, text)
-	}
-
-	if id > 0 {
-		payload = payload[:len(payload)-1]
+		payload = append(payload, jsonData...)
 	}
 	payload = append(payload, []byte(`]}`)...)
 
-	// log.Info(string(payload))
-	// Output: {"baz": [{"foo":"hello","bar":42}]}
-
-	if err := rows.Err(); err != nil {
-		panic(err)
-	}
-
-	if err != nil {
-		log.Info("Unable to connect to DB")
-		response.Message = "unable to connect to database server"
-		api.Respond(w, nil, http.StatusInternalServerError)
-		return
-	}
-
 	//api.RespondOK(w, msg)
 	api.RespondRaw(w, payload, http.StatusOK)
 
 }
 
-type LocationDataQuery struct {
-	Location string `json:"location"`
-	Lat      string `json:"lat"`
-	Lon      string `json:"lon"`
-	UserId   string `json:"userID"`
-}
-
-var locationData LocationDataQuery
-
 /*
 Get the most recent location data via the location name, latitude, longitude, and requesting user from the database
 */
 func GetLocationLatestData(w http.ResponseWriter, r *http.Request) {
-
-	log.Trace("entered GetLocationLatestData")
-
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    ""}
-
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&locationData)
-	if err != nil {
-		log.Panic(err)
-	}
+	reqLog := logging.FromContext(r.Context())
+	locationData := openapi.Request(r).(*mAPI.LocationDataQuery)
 
 	var loc string
 	var user_id string
@@ -827,22 +597,11 @@ func GetLocationLatestData(w http.ResponseWriter, r *http.Request) {
 	_lat = locationData.Lat
 	_lon = locationData.Lon
 
-	log.WithFields(log.Fields{
-		"Location": locationData.Location,
-		"Lat":      locationData.Lat,
-		"Lon":      locationData.Lon,
-		"UserID":   locationData.UserId,
-	}).Info("location data")
-
 	// take lat and lon strings and convert to float
-	lon_float, err := strconv.ParseFloat(_lon, 64)
-	if err != nil {
-		log.Error("could not parse incomming lon to float")
-	}
-
-	lat_float, err := strconv.ParseFloat(_lat, 64)
-	if err != nil {
-		log.Error("could not parse incomming lat to float")
+	lat_float, lon_float, invalid := parseLatLon(_lat, _lon)
+	if invalid != nil {
+		api.RespondInvalid(w, invalid...)
+		return
 	}
 
 	// expand float to have 9 decimal points as is the the db table criteria
@@ -851,249 +610,138 @@ func GetLocationLatestData(w http.ResponseWriter, r *http.Request) {
 	locationHash := GenerateLocationHashString(loc, formattedLat, formattedLon)
 
 	// INSERT into locations table if first-ever video upload to location
-	query := "INSERT INTO locations (location_hash, location_name, lat, lon) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT * FROM locations WHERE location_hash = ?)"
-	result, err := store.DB.Exec(query, locationHash, loc, formattedLat, formattedLon, locationHash)
-	if err != nil {
-		response.Message = "INSERT INTO locations failed " + err.Error()
-		api.Respond(w, nil, http.StatusInternalServerError)
+	location := repository.Location{Hash: locationHash, Name: loc, Lat: lat_float, Lon: lon_float}
+	if err := repos.Locations.Ensure(r.Context(), location); err != nil {
+		reqLog.Error("add location failed: ", err.Error())
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the location")
 		return
-	} else {
-		rows, err := result.RowsAffected()
-		if err != nil {
-			log.Error("error retrieving rows affected, database most likely not supported")
-		} else {
-			if rows != 0 {
-				log.Info("attempted to insert into locations table, rows affected: ", rows)
-			}
-		}
 	}
 
 	// get status on whether the user has liked this location or not
-	var isLiked bool
-	query = "SELECT * FROM favorites WHERE user_id = ? AND favorites.location_hash = ?"
-
-	var favoritesUserID string
-	var favoritesLocation string
-	err = store.DB.QueryRow(query, user_id, locationHash).Scan(&favoritesUserID, &favoritesLocation)
-
-	// might need to do special mysql error handling, outside of `sql: no rows in result set` error which will be handled below
+	isLiked, err := repos.Favorites.Exists(r.Context(), user_id, locationHash)
 	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Error("issue with accessing favorites table in db")
-		} else {
-			isLiked = false
-		}
-	} else {
-		if favoritesUserID != "" && favoritesLocation != "" {
-			// FOUND FAVORITE RESULT MATCH IN DB - set isLiked to true
-			isLiked = true
-		}
+		reqLog.Error("issue with accessing favorites table in db: ", err)
 	}
 
 	// Query db for video based on location
-	var id = 0
-	var video_folder string
-	var location_hash string
-	var video_like_count float64
-	var video_is_liked_by_user bool
-	var time_stamp time.Time
-	var result_user_id string
-	var user_name string
-	var photo bool
-	var location_name string
-	var lat float64
-	var lon float64
-
 	var payload interface{}
-	var videoPayload interface{}
-
-	err = store.DB.QueryRow("SELECT all_videos.video_folder, all_videos.location_hash, all_videos.like_count, IF(ISNULL(videos_liked.user_id), false, true) AS 'is_liked', time_stamp, users.user_id, users.user_name, location_name, lat, lon FROM all_videos JOIN locations ON all_videos.location_hash = locations.location_hash JOIN users ON all_videos.user_id = users.user_id LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash AND videos_liked.user_id = ? WHERE all_videos.location_hash = ? AND all_videos.is_deleted = 0 ORDER BY all_videos.time_stamp DESC LIMIT 1", user_id, locationHash).
-		Scan(&video_folder, &location_hash, &video_like_count, &video_is_liked_by_user, &time_stamp, &result_user_id, &user_name, &location_name, &lat, &lon)
-	// +----------------------------------+---------------+------------+----------+---------------------+--------------+---------------+--------------+---------------+
-	// | video_folder                     | location_hash | like_count | is_liked | time_stamp          | user_name    | location_name | lat          | lon           |
-	// +----------------------------------+---------------+------------+----------+---------------------+--------------+---------------+--------------+---------------+
-	// | frankmantest-2023-02-24-20-38-10 | 1220036614    |       NULL |        0 | 2023-02-24 20:38:10 | frankmantest | Galway's Pub  | 39.950474500 | -75.262460000 |
-	// +----------------------------------+---------------+------------+----------+---------------------+--------------+---------------+--------------+---------------+
-	// orig when using user_id to query err = store.DB.QueryRow("SELECT video_folder, all_videos.location_hash, time_stamp, all_videos.user_id, user_name, location_name, lat, lon FROM all_videos JOIN locations ON all_videos.location_hash = locations.location_hash JOIN users ON all_videos.user_name = users.user_name WHERE all_videos.location_hash = ? ORDER BY all_videos.time_stamp DESC LIMIT 1;", locationHash).Scan(&video_folder, &location_hash, &time_stamp, &user_name, &username, &location_name, &lat, &lon)
-
-	log.WithFields(log.Fields{
-		"video_folder":           video_folder,
-		"location_hash":          location_hash,
-		"video_like_count":       video_like_count,
-		"video_is_liked_by_user": video_is_liked_by_user,
-		"time_stamp":             time_stamp,
-		"user_id":                result_user_id,
-		"user_name":              user_name,
-		"photo":                  photo,
-		"location_name":          location_name,
-		"lat":                    lat,
-		"lon":                    lon,
-	}).Trace("location data")
-
-	// might need to do special mysql error handling, outside of `sql: no rows in result set` error which will be handled below
+
+	vibe, err := repos.Videos.LatestAtLocation(r.Context(), user_id, locationHash)
 	if err != nil {
-		log.Error(err.Error())
+		if err != repository.ErrNotFound {
+			reqLog.Error("reading the latest video failed: ", err)
+		}
 		payload = VibecheckLocationData{
 			Video:   VideoStruct{},
 			IsLiked: isLiked,
 		}
 	} else { // found video result in db
-		thumbnail_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_THUMBNAIL
-		video_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_VIDEO
-		selfie_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_SELFIE
-		var user_pic_link string
-		if photo == true {
-			user_pic_link = USER_CONTENT_STREAM + "/" + result_user_id + "/" + USER_PICTURE
-		} else {
-			user_pic_link = FALLBACK_CONTENT
-		}
+		videoStruct := newVideoStruct(r.Context(), 0, vibe)
 
-		log.WithFields(log.Fields{
-			"thumbnail_link": thumbnail_link,
-			"video_link":     video_link,
-			"selfie_link":    selfie_link,
-			"user_pic_link":  user_pic_link,
-		}).Info("video result data")
-
-		videoPayload = VideoStruct{
-			Id:                 id,
-			ThumbnailLink:      thumbnail_link,
-			VideoLink:          video_link,
-			SelfieLink:         selfie_link,
-			UserPicLink:        user_pic_link,
-			VideoFolder:        video_folder,
-			LocationHash:       location_hash,
-			VideoLikeCount:     video_like_count,
-			VideoIsLikedByUser: video_is_liked_by_user,
-			TimeStamp:          time_stamp,
-			UserId:             result_user_id,
-			Username:           user_name,
-			LocationName:       location_name,
-			Lat:                lat,
-			Lon:                lon}
-
-		log.Trace(videoPayload)
-
-		// assert that videoPayload is of type VideoStruct
-		videoStruct, ok := videoPayload.(VideoStruct)
-		if !ok {
-			// handle the case where videoPayload is not a VideoStruct
-			response.Message = "issue parsing video payload to VideoStruct"
-			api.Respond(w, nil, http.StatusInternalServerError)
-			return
-		} else {
-			payload = VibecheckLocationData{
-				Video:   videoStruct,
-				IsLiked: isLiked,
-			}
+		payload = VibecheckLocationData{
+			Video:   videoStruct,
+			IsLiked: isLiked,
 		}
 	}
 	api.RespondOK(w, payload)
 
 }
 
-/* Set the given video to increment or decrement by one, then if incremented, add the user-video like mapping
- */
-func SetVideoLikedStatus(w http.ResponseWriter, r *http.Request) {
+// CreateLocation records a location unless it is known, answering with its ID and
+// whether the user of the session has it among their favorites
+func CreateLocation(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.LocationRequest)
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
+	// expand float to have 9 decimal points as is the the db table criteria
+	formattedLat := fmt.Sprintf("%.9f", req.Lat)
+	formattedLon := fmt.Sprintf("%.9f", req.Lon)
+	location := repository.Location{Hash: GenerateLocationHashString(req.Name, formattedLat, formattedLon), Name: req.Name, Lat: req.Lat, Lon: req.Lon}
+	if err := repos.Locations.Ensure(r.Context(), location); err != nil {
+		logging.FromContext(r.Context()).Error("add location failed: ", err.Error())
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to record the location")
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	var q VideoLikedSetter
-	err := decoder.Decode(&q)
-	if err != nil {
-		panic(err)
+	isFavorite := false
+	if userID := session.UserID(r); userID != "" {
+		var err error
+		if isFavorite, err = repos.Favorites.Exists(r.Context(), userID, location.Hash); err != nil {
+			logging.FromContext(r.Context()).Error("issue with accessing favorites table in db: ", err)
+		}
 	}
-	log.Info(q.VideoFolder)
+	api.RespondOK(w, LocationResponse{Id: location.Hash, Name: location.Name, Lat: location.Lat, Lon: location.Lon, IsFavorite: isFavorite})
+}
 
-	video_folder := q.VideoFolder
-	location_hash := q.LocationHash
+/* Set the given video to increment or decrement by one, then if incremented, add the user-video like mapping
+ */
+func SetVideoLikedStatus(w http.ResponseWriter, r *http.Request) {
 
-	log.Info("video_folder in setVideoLikedStatus is: " + string(video_folder))
+	q := openapi.Request(r).(*mAPI.VideoLikeRequest)
+	setVideoLiked(w, r, q.UserId, q.LocationHash, q.VideoFolder, q.LikedStatus)
+}
 
-	user_id := q.UserId
-	liked_status := q.LikedStatus
+// LikeVibe adds the like of the user of the session to a vibe
+func LikeVibe(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.VibeParam)
+	setVideoLiked(w, r, session.UserID(r), req.LocationId, req.VibeId, true)
+}
 
-	if err != nil {
-		// handle error
-		log.Info("issue with data retrieval from API call in setVideoLikedStatus")
-	}
-	like_count := 0
-	if liked_status == false {
-		like_count = -1
-	} else if liked_status == true {
-		like_count = 1
-	}
+// UnlikeVibe removes the like of the user of the session from a vibe
+func UnlikeVibe(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.VibeParam)
+	setVideoLiked(w, r, session.UserID(r), req.LocationId, req.VibeId, false)
+}
 
-	// UPDATE all_videos
-	query := "UPDATE all_videos SET like_count = IFNULL(like_count, 0) + ? WHERE video_folder = ? and location_hash = ?"
-	result, err := store.DB.Exec(query, like_count, video_folder, location_hash)
-	// result := store.DB.QueryRow(query, locationHash, uID_timeStamp)
+// setVideoLiked likes or unlikes a vibe, answering 200 also when the like already was as asked
+// so a retried PUT or DELETE neither fails nor counts twice
+func setVideoLiked(w http.ResponseWriter, r *http.Request, user_id string, location_hash string, video_folder string, liked_status bool) {
+	reqLog := logging.FromContext(r.Context())
+	var changed bool
+	var err error
+	if liked_status == true {
+		changed, err = repos.Likes.Like(r.Context(), video_folder, location_hash, user_id)
+	} else { // false, remove
+		changed, err = repos.Likes.Unlike(r.Context(), video_folder, location_hash, user_id)
+	}
 	if err != nil {
-		log.Info("UPDATE all_videos failed:")
-		log.Info(err)
+		reqLog.Error("update videos_liked failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to update the like")
 		return
-	} else {
-		log.Info("no isses with UPDATE all_videos:")
-		log.Info(result)
 	}
 
-	// UPDATE latest_videos
-	query = "UPDATE latest_videos SET like_count = IFNULL(like_count, 0) + ? WHERE video_folder = ? and location_hash = ?"
-	result, err = store.DB.Exec(query, like_count, video_folder, location_hash)
-	// result := store.DB.QueryRow(query, locationHash, uID_timeStamp)
-	if err != nil {
-		log.Info("UPDATE latest_videos failed:")
-		log.Info(err)
+	if !changed {
+		api.RespondSuccess(w, http.StatusOK, "video_like_change", "Video Like Already Up To Date")
 		return
-	} else {
-		log.Info("no isses with UPDATE latest_videos:")
-		log.Info(result)
 	}
+	api.RespondSuccess(w, http.StatusOK, "video_like_change", "Video Like Change Updated Successfully")
+}
 
-	query = ""
-
-	if liked_status == true {
-		query = "INSERT INTO videos_liked (`video_folder`, `location_hash`, `user_id`) VALUES (?, ?, ?)"
-	} else { // false, remove
-		query = "DELETE FROM videos_liked WHERE video_folder = ? AND location_hash = ? AND user_id = ?"
-	}
-	// query = "INSERT INTO favorites (`location_hash`, `user_name`) VALUES (?, ?) ON DUPLICATE KEY UPDATE video_folder = VALUES(video_folder)"
+// so far, this struct will handle latest video of location and status of whether user has liked location or not
+type VibecheckLocationData struct {
+	Video   VideoStruct `json:"video"`
+	IsLiked bool        `json:"isLiked"`
+}
 
-	result, err = store.DB.Exec(query, video_folder, location_hash, user_id)
-	// result := store.DB.QueryRow(query, locationHash, uID_timeStamp)
-	if err != nil {
-		log.Info("INSERT INTO videos_liked failed:")
-		log.Info(err)
-		return
-	} else {
-		// log.Info("INSERT INTO favorites worked:")
-		log.Info(result)
-	}
+// Feed bodies, the handlers assemble them as raw JSON
 
-	response.Message = "Video Like Change Updated Successfully"
-	response.Success = true
-	response.Name = "video_like_change"
-	// log.Info(response.Message)
-	api.Respond(w, response, http.StatusOK)
+type VideosResponse struct {
+	Videos []VibecheckLocationData `json:"videos"`
+}
 
+type LocationResponse struct {
+	Id         string  `json:"id" doc:"location ID of the v1 routes"`
+	Name       string  `json:"name"`
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	IsFavorite bool    `json:"isFavorite" doc:"whether the user of the session has it among their favorites"`
 }
 
-type VideoLikedSetter struct {
-	VideoFolder  string `json:"videoFolder"`
-	LocationHash string `json:"locationHash"`
-	UserId       string `json:"userID"`
-	LikedStatus  bool   `json:"likedStatus"`
+type UserDataResponse struct {
+	Data []interface{} `json:"data" doc:"the VibecheckUserData of the user, then {\"videos\": [VibecheckLocationData]}"`
 }
 
-// so far, this struct will handle latest video of location and status of whether user has liked location or not
-type VibecheckLocationData struct {
-	Video   VideoStruct `json:"video"`
-	IsLiked bool        `json:"isLiked"`
+type ChatResponse struct {
+	Messages []GiftedChatStruct `json:"messages"`
 }
 
 type VibecheckUserData struct {
@@ -1107,6 +755,7 @@ type VideoStruct struct {
 	Id                 int       `json:"id"`
 	ThumbnailLink      string    `json:"thumbnailLink"`
 	VideoLink          string    `json:"videoLink"`
+	StreamLink         string    `json:"streamLink,omitempty" doc:"HLS master playlist, once transcoded"`
 	PosterLink         string    `json:"posterLink"`
 	SelfieLink         string    `json:"selfieLink"`
 	UserPicLink        string    `json:"userPicLink"`
@@ -1120,6 +769,15 @@ type VideoStruct struct {
 	LocationName       string    `json:"locationName"`
 	Lat                float64   `json:"lat"`
 	Lon                float64   `json:"lon"`
+	VideoSHA256        string    `json:"videoSha256,omitempty"` // lets clients check the video they download
+	// probed from the uploaded video, left out for videos uploaded before it was recorded
+	Duration float64 `json:"duration,omitempty" doc:"seconds"`
+	Width    int     `json:"width,omitempty" doc:"pixels, as displayed"`
+	Height   int     `json:"height,omitempty" doc:"pixels, as displayed"`
+	Codec    string  `json:"codec,omitempty" doc:"of the uploaded video, e.g. h264 or hevc"`
+	Rotation int     `json:"rotation,omitempty" doc:"degrees clockwise players turn the uploaded video's frames"`
+	Bitrate  int64   `json:"bitrate,omitempty" doc:"bits per second of the uploaded video"`
+	FileSize int64   `json:"fileSize,omitempty" doc:"bytes of the uploaded video"`
 }
 
 type TestVideoStruct struct {
@@ -1144,29 +802,12 @@ type EmptyVideoStruct struct {
 	VideoLink     string `json:"videoLink"`
 }
 
-type UserFollower struct {
-	UserId          string `json:"user_id" db:"user_id"`
-	UserIdFollowing string `json:"user_id_following" db:"user_id_following"`
-}
-
 /*
 Get the set of videos associated with a given user_id from the database
 */
 func GetDataByUser(w http.ResponseWriter, r *http.Request) {
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// decode creds
-	userFollower := &UserFollower{}
-	err := json.NewDecoder(r.Body).Decode(userFollower)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	userFollower := openapi.Request(r).(*mAPI.UserDataRequest)
 
 	user_id := userFollower.UserId
 	user_id_following := userFollower.UserIdFollowing
@@ -1177,67 +818,33 @@ func GetDataByUser(w http.ResponseWriter, r *http.Request) {
 		user_id_following = user_id
 	}
 
-	log.Trace("user_id is: ", user_id)
-	log.Trace("in GetDataByUser")
-
-	var payload = []byte(`{"data": [`)
-	var user_name string
-	var hasPhoto bool
-	var isFollowing bool
-	// SELECT users.user_name, users.photo, IF(ISNULL(user_follower.user_id), false, true) AS 'is_following' FROM users LEFT JOIN user_follower on user_follower.user_id_following = users.user_id and user_follower.user_id = '91752724-8c77-473d-85c0-0d22ace7d769' where users.user_id = '18fea441-e325-4893-9cc7-76b8ab2b7cad';
-	err = store.DB.QueryRow("SELECT users.user_name, users.photo, IF(ISNULL(user_follower.user_id), false, true) AS 'is_following' FROM users LEFT JOIN user_follower on user_follower.user_id_following = users.user_id and user_follower.user_id = ? where users.user_id = ?;", user_id, user_id_following).Scan(&user_name, &hasPhoto, &isFollowing) //true or false
-	// err = store.DB.QueryRow("SELECT user_name, photo from users where user_id = ?", user_id).Scan(&user_name, &hasPhoto) //true or false
-
-	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Info("error in user-indexed video query SQL")
-			log.Info(err)
-			panic(err)
-		} else {
-
-			// this shouldn't be hit
-			entry := VibecheckUserData{}
-			// Encode the data to JSON
-			jsonData, err := json.Marshal(entry)
-			if err != nil {
-				log.Info(err)
-				return
-			}
-			payload = append(payload, jsonData...)
-			// api.RespondRaw(w, payload, http.StatusOK)
-		}
+	respondUserData(w, r, user_id, user_id_following, user_id_following, 0)
+}
 
+// respondUserData writes the profile of profileID as seen by viewerID followed
+// by the videos of videosID, newest first, limit 0 sends them all
+func respondUserData(w http.ResponseWriter, r *http.Request, viewerID string, profileID string, videosID string, limit int) {
+	reqLog := logging.FromContext(r.Context())
+	profile, err := repos.Users.Profile(r.Context(), viewerID, profileID)
+	if err != nil && err != repository.ErrNotFound {
+		reqLog.Error("error in user-indexed video query SQL: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to connect to database server")
+		return
 	}
 
-	var query_user_pic_link string
-	var jsonData []byte
-	if hasPhoto == true {
+	query_user_pic_link := FALLBACK_CONTENT
+	if profile.Photo == true {
 		// return default link to streaming content
-		query_user_pic_link = USER_CONTENT_STREAM + "/" + user_id_following + "/" + USER_PICTURE
-		entry := VibecheckUserData{UserId: user_id_following, Username: user_name, Following: isFollowing, UserPicLink: query_user_pic_link}
-		// Encode the data to JSON
-		jsonData, err = json.Marshal(entry)
-		if err != nil {
-			log.Info(err)
-			return
-		}
-		payload = append(payload, jsonData...)
-
-	} else {
-		// return empty string for user_pic_link
-		query_user_pic_link = FALLBACK_CONTENT
-		entry := VibecheckUserData{UserId: user_id_following, Username: user_name, Following: isFollowing, UserPicLink: query_user_pic_link}
-		// Encode the data to JSON
-		jsonData, err = json.Marshal(entry)
-		if err != nil {
-			log.Info(err)
-			return
-		}
-		payload = append(payload, jsonData...)
-
+		query_user_pic_link = userPicLink(r.Context(), videosID, profilePictureSize)
+	}
+	entry := VibecheckUserData{UserId: videosID, Username: profile.UserName, Following: profile.IsFollowing, UserPicLink: query_user_pic_link}
+	jsonData, err := json.Marshal(entry)
+	if err != nil {
+		reqLog.Error(err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to encode the profile")
+		return
 	}
 
-	payload = append(payload, []byte(`, `)...)
 	// Query db for all videos based on user_id
 	// ending result set, a list of videos, should contain:
 	// locationHash + video_folder (these values are used to generate the hyperlink that the streaming service will respond to)
@@ -1246,159 +853,84 @@ func GetDataByUser(w http.ResponseWriter, r *http.Request) {
 	// lat, lon (to navigate to given location in MapView when user taps on title in ReelsView)
 	// username (to display on ReelsView)
 	// profile_picture (to display on ReelsViews)
-
-	payload = append(payload, []byte(`{"videos": [`)...)
-	// var payload = []byte(`"{videos": [`)
-	var entry interface{}
-
-	rows, err := store.DB.Query("SELECT all_videos.video_folder, all_videos.location_hash, all_videos.like_count, IF(ISNULL(videos_liked.user_id), false, true) AS 'is_liked', all_videos.time_stamp, all_videos.user_id, users.user_name, users.photo, locations.location_name, locations.lat, locations.lon FROM all_videos JOIN users ON all_videos.user_id = users.user_id JOIN locations ON all_videos.location_hash = locations.location_hash LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash and videos_liked.user_id = all_videos.user_id WHERE all_videos.user_id = ? AND all_videos.is_deleted = 0 ORDER BY all_videos.time_stamp DESC", user_id_following)
-	// new query
-	// SELECT all_videos.video_folder, all_videos.location_hash, all_videos.like_count, all_videos.time_stamp, all_videos.user_name, users.user_name, videos_liked.user_name AS 'liked', users.photo, locations.location_name, locations.lat, locations.lon FROM all_videos JOIN users ON all_videos.user_name = users.user_name JOIN locations ON all_videos.location_hash = locations.location_hash LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash and videos_liked.user_name = all_videos.user_name WHERE all_videos.user_name = 'vcruky' AND all_videos.is_deleted = 0 ORDER BY all_videos.time_stamp DESC;
+	vibes, err := repos.Videos.ByUser(r.Context(), videosID, limit)
 	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Info("error in user-indexed video query SQL")
-			log.Info(err)
-			panic(err)
-		} else {
-			// NO VIDEO RESULT IN DB - send EmptyVideoStruct with default thumbnail content
-			//create links
-			// thumbnail_link := FALLBACK_CONTENT
-			// video_link := FALLBACK_CONTENT
-
-			// entry = EmptyVideoStruct{ThumbnailLink: thumbnail_link, VideoLink: video_link}
-			// // Encode the data to JSON
-			// jsonData, err := json.Marshal(entry)
-			// if err != nil {
-			// 	log.Info(err)
-			// 	return
-			// }
-
-			// payload = append(payload, jsonData...)
-			payload = append(payload, []byte(`]}`)...)
-			api.RespondRaw(w, payload, http.StatusOK)
-		}
-
+		reqLog.Error("Unable to connect to DB: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to connect to database server")
+		return
 	}
 
-	defer rows.Close()
-	// buf := bytes.NewBuffer(newJSON)
-	// encoder := json.NewEncoder(buf)
-	var id = 0
-	for rows.Next() {
-		var video_folder string
-		var location_hash string
-		var time_stamp time.Time
-		var result_user_id string
-		var user_name string //as an int yes, no?
-		// var username string
-		var like_count float64
-		var video_is_liked_by_user bool
-		var photo bool
-		var location_name string
-		var lat float64
-		var lon float64
-
-		// all_videos.video_folder,
-		// all_videos.location_hash,
-		// all_videos.like_count,
-		// IF(ISNULL(videos_liked.user_name), false, true) AS 'is_liked',
-		// all_videos.time_stamp,
-		// all_videos.user_name,
-		// users.user_name,
-		// users.photo,
-		// locations.location_name,
-		// locations.lat,
-		// locations.lon
-		err := rows.Scan(&video_folder, &location_hash, &like_count, &video_is_liked_by_user, &time_stamp, &result_user_id, &user_name, &photo, &location_name, &lat, &lon)
-
+	var payload = []byte(`{"data": [`)
+	payload = append(payload, jsonData...)
+	payload = append(payload, []byte(`, {"videos": [`)...)
+	for id, vibe := range vibes {
+		entry := VibecheckLocationData{Video: newVideoStruct(r.Context(), id, vibe), IsLiked: false}
+		// Encode the data to JSON
+		jsonData, err := json.Marshal(entry)
 		if err != nil {
-			panic(err)
-		} else {
-			// FOUND VIDEO RESULT IN DB - send VideoStruct
-			//create links
-			thumbnail_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_THUMBNAIL
-			video_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_VIDEO
-			selfie_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_SELFIE
-			var user_pic_link string
-			if photo == true {
-				user_pic_link = USER_CONTENT_STREAM + "/" + result_user_id + "/" + USER_PICTURE
-			} else {
-				user_pic_link = FALLBACK_CONTENT
-			}
-
-			videoStruct := VideoStruct{
-				Id:                 id,
-				ThumbnailLink:      thumbnail_link,
-				VideoLink:          video_link,
-				SelfieLink:         selfie_link,
-				UserPicLink:        user_pic_link,
-				VideoFolder:        video_folder,
-				LocationHash:       location_hash,
-				VideoLikeCount:     like_count,
-				VideoIsLikedByUser: video_is_liked_by_user,
-				TimeStamp:          time_stamp,
-				UserId:             result_user_id,
-				Username:           user_name,
-				LocationName:       location_name,
-				Lat:                lat,
-				Lon:                lon}
-			id = id + 1
-			entry = VibecheckLocationData{Video: videoStruct, IsLiked: false}
-			// Encode the data to JSON
-			jsonData, err := json.Marshal(entry)
-			if err != nil {
-				log.Info(err)
-				return
-			}
+			reqLog.Error(err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to encode the videos")
+			return
+		}
 
-			payload = append(payload, jsonData...)
+		if id > 0 {
 			payload = append(payload, []byte(`,`)...)
-
 		}
-		log.Trace(video_folder, location_hash, like_count, video_is_liked_by_user, time_stamp, result_user_id, user_name, location_name, lat, lon)
-	}
-
-	if id > 0 {
-		payload = payload[:len(payload)-1]
+		payload = append(payload, jsonData...)
 	}
 	payload = append(payload, []byte(`]}]}`)...)
 
-	// log.Info(string(payload))
-	// Output: {"baz": [{"foo":"hello","bar":42}]}
-
-	if err := rows.Err(); err != nil {
-		panic(err)
-	}
-
-	if err != nil {
-		log.Info("Unable to connect to DB")
-		response.Message = "unable to connect to database server"
-		api.Respond(w, nil, http.StatusInternalServerError)
-		return
-	}
-
-	//api.RespondOK(w, msg)
 	api.RespondRaw(w, payload, http.StatusOK)
 }
 
+// newVideoStruct builds the stream links of a video for the client
+func newVideoStruct(ctx context.Context, id int, vibe repository.VideoView) VideoStruct {
+	thumbnail_link := thumbnailLink(ctx, vibe.LocationHash, vibe.Folder)
+	video_link := VIBE_CONTENT_STREAM + "/" + vibe.LocationHash + "/" + vibe.Folder + "/" + VIBE_VIDEO
+	selfie_link := VIBE_CONTENT_STREAM + "/" + vibe.LocationHash + "/" + vibe.Folder + "/" + VIBE_SELFIE
+	user_pic_link := FALLBACK_CONTENT
+	if vibe.Photo == true {
+		user_pic_link = userPicLink(ctx, vibe.UserID, vibePictureSize)
+	}
+	stream_link, poster_link := "", ""
+	if vibe.HLS {
+		stream_link, poster_link = hlsLinks(vibe.LocationHash, vibe.Folder)
+	}
+
+	return VideoStruct{
+		Id:                 id,
+		ThumbnailLink:      thumbnail_link,
+		VideoLink:          video_link,
+		StreamLink:         stream_link,
+		PosterLink:         poster_link,
+		SelfieLink:         selfie_link,
+		UserPicLink:        user_pic_link,
+		VideoFolder:        vibe.Folder,
+		LocationHash:       vibe.LocationHash,
+		VideoLikeCount:     vibe.LikeCount,
+		VideoIsLikedByUser: vibe.IsLiked,
+		TimeStamp:          vibe.TimeStamp,
+		UserId:             vibe.UserID,
+		Username:           vibe.UserName,
+		LocationName:       vibe.Location.Name,
+		Lat:                vibe.Location.Lat,
+		Lon:                vibe.Location.Lon,
+		VideoSHA256:        vibe.SHA256,
+		Duration:           vibe.Meta.Duration.Seconds(),
+		Width:              vibe.Meta.Width,
+		Height:             vibe.Meta.Height,
+		Codec:              vibe.Meta.Codec,
+		Rotation:           vibe.Meta.Rotation,
+		Bitrate:            vibe.Meta.Bitrate,
+		FileSize:           vibe.Meta.Size}
+}
+
 /*
 Get the latest video associated with a given user_id from the database
 */
 func GetUserLatestData(w http.ResponseWriter, r *http.Request) {
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// decode creds
-	userFollower := &UserFollower{}
-	err := json.NewDecoder(r.Body).Decode(userFollower)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	userFollower := openapi.Request(r).(*mAPI.UserDataRequest)
 
 	user_id := userFollower.UserId
 	user_id_following := userFollower.UserIdFollowing
@@ -1409,236 +941,24 @@ func GetUserLatestData(w http.ResponseWriter, r *http.Request) {
 		user_id_following = user_id
 	}
 
-	log.Trace("user_id is: ", user_id)
-	log.Trace("in GetUserLatestData")
-
-	var payload = []byte(`{"data": [`)
-	var user_name string
-	var hasPhoto bool
-	var isFollowing bool
-	// SELECT users.user_name, users.photo, IF(ISNULL(user_follower.user_id), false, true) AS 'is_following' FROM users LEFT JOIN user_follower on user_follower.user_id_following = users.user_id and user_follower.user_id = '91752724-8c77-473d-85c0-0d22ace7d769' where users.user_id = '18fea441-e325-4893-9cc7-76b8ab2b7cad';
-	err = store.DB.QueryRow("SELECT users.user_name, users.photo, IF(ISNULL(user_follower.user_id), false, true) AS 'is_following' FROM users LEFT JOIN user_follower on user_follower.user_id_following = users.user_id and user_follower.user_id = ? where users.user_id = ?;", user_id, user_id_following).Scan(&user_name, &hasPhoto, &isFollowing) //true or false
-	// err = store.DB.QueryRow("SELECT user_name, photo from users where user_id = ?", user_id).Scan(&user_name, &hasPhoto) //true or false
-
-	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Info("error in user-indexed video query SQL")
-			log.Info(err)
-			panic(err)
-		} else {
-
-			// this shouldn't be hit
-			entry := VibecheckUserData{}
-			// Encode the data to JSON
-			jsonData, err := json.Marshal(entry)
-			if err != nil {
-				log.Info(err)
-				return
-			}
-			payload = append(payload, jsonData...)
-			// api.RespondRaw(w, payload, http.StatusOK)
-		}
-
-	}
-
-	var query_user_pic_link string
-	var jsonData []byte
-	if hasPhoto == true {
-		// return default link to streaming content
-		query_user_pic_link = USER_CONTENT_STREAM + "/" + user_id + "/" + USER_PICTURE
-		entry := VibecheckUserData{UserId: user_id, Username: user_name, Following: isFollowing, UserPicLink: query_user_pic_link}
-		// Encode the data to JSON
-		jsonData, err = json.Marshal(entry)
-		if err != nil {
-			log.Info(err)
-			return
-		}
-		payload = append(payload, jsonData...)
-
-	} else {
-		// return empty string for user_pic_link
-		query_user_pic_link = FALLBACK_CONTENT
-		entry := VibecheckUserData{UserId: user_id, Username: user_name, Following: isFollowing, UserPicLink: query_user_pic_link}
-		// Encode the data to JSON
-		jsonData, err = json.Marshal(entry)
-		if err != nil {
-			log.Info(err)
-			return
-		}
-		payload = append(payload, jsonData...)
-
-	}
-
-	payload = append(payload, []byte(`, `)...)
-	// Query db for all videos based on user_id
-	// ending result set, a list of videos, should contain:
-	// locationHash + video_folder (these values are used to generate the hyperlink that the streaming service will respond to)
-	// time_stamp (to show on ReelsView)
-	// location_name (to show on ReelsView)
-	// lat, lon (to navigate to given location in MapView when user taps on title in ReelsView)
-	// username (to display on ReelsView)
-	// profile_picture (to display on ReelsViews)
-
-	payload = append(payload, []byte(`{"videos": [`)...)
-	// var payload = []byte(`"{videos": [`)
-	var entry interface{}
-
-	rows, err := store.DB.Query("SELECT all_videos.video_folder, all_videos.location_hash, all_videos.like_count, IF(ISNULL(videos_liked.user_id), false, true) AS 'is_liked', all_videos.time_stamp, users.user_name, users.photo, locations.location_name, locations.lat, locations.lon FROM all_videos JOIN users ON all_videos.user_id = users.user_id JOIN locations ON all_videos.location_hash = locations.location_hash LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash and videos_liked.user_id = all_videos.user_id WHERE all_videos.user_id = ? AND all_videos.is_deleted = 0 ORDER BY all_videos.time_stamp DESC LIMIT 1", user_id)
-	// new query
-	// SELECT all_videos.video_folder, all_videos.location_hash, all_videos.like_count, all_videos.time_stamp, all_videos.user_name, users.user_name, videos_liked.user_name AS 'liked', users.photo, locations.location_name, locations.lat, locations.lon FROM all_videos JOIN users ON all_videos.user_name = users.user_name JOIN locations ON all_videos.location_hash = locations.location_hash LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash and videos_liked.user_name = all_videos.user_name WHERE all_videos.user_name = 'vcruky' AND all_videos.is_deleted = 0 ORDER BY all_videos.time_stamp DESC;
-	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Info("error in user-indexed video query SQL")
-			log.Info(err)
-			panic(err)
-		} else {
-			// NO VIDEO RESULT IN DB - send EmptyVideoStruct with default thumbnail content
-			//create links
-			// thumbnail_link := FALLBACK_CONTENT
-			// video_link := FALLBACK_CONTENT
-
-			// entry = EmptyVideoStruct{ThumbnailLink: thumbnail_link, VideoLink: video_link}
-			// // Encode the data to JSON
-			// jsonData, err := json.Marshal(entry)
-			// if err != nil {
-			// 	log.Info(err)
-			// 	return
-			// }
-
-			// payload = append(payload, jsonData...)
-			payload = append(payload, []byte(`]}`)...)
-			api.RespondRaw(w, payload, http.StatusOK)
-		}
-
-	}
-
-	defer rows.Close()
-	// buf := bytes.NewBuffer(newJSON)
-	// encoder := json.NewEncoder(buf)
-	var id = 0
-	for rows.Next() {
-		var video_folder string
-		var location_hash string
-		var time_stamp time.Time
-		var user_name string //as an int yes, no?
-		// var username string
-		var like_count float64
-		var video_is_liked_by_user bool
-		var photo bool
-		var location_name string
-		var lat float64
-		var lon float64
-
-		// all_videos.video_folder,
-		// all_videos.location_hash,
-		// all_videos.like_count,
-		// IF(ISNULL(videos_liked.user_name), false, true) AS 'is_liked',
-		// all_videos.time_stamp,
-		// all_videos.user_name,
-		// users.user_name,
-		// users.photo,
-		// locations.location_name,
-		// locations.lat,
-		// locations.lon
-		err := rows.Scan(&video_folder, &location_hash, &like_count, &video_is_liked_by_user, &time_stamp, &user_name, &photo, &location_name, &lat, &lon)
-
-		if err != nil {
-			panic(err)
-		} else {
-			// FOUND VIDEO RESULT IN DB - send VideoStruct
-			//create links
-			thumbnail_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_THUMBNAIL
-			video_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_VIDEO
-			selfie_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_SELFIE
-			var user_pic_link string
-			if photo == true {
-				user_pic_link = USER_CONTENT_STREAM + "/" + user_id + "/" + USER_PICTURE
-			} else {
-				user_pic_link = FALLBACK_CONTENT
-			}
-
-			videoStruct := VideoStruct{
-				Id:                 id,
-				ThumbnailLink:      thumbnail_link,
-				VideoLink:          video_link,
-				SelfieLink:         selfie_link,
-				UserPicLink:        user_pic_link,
-				VideoFolder:        video_folder,
-				LocationHash:       location_hash,
-				VideoLikeCount:     like_count,
-				VideoIsLikedByUser: video_is_liked_by_user,
-				TimeStamp:          time_stamp,
-				UserId:             user_id,
-				Username:           user_name,
-				LocationName:       location_name,
-				Lat:                lat,
-				Lon:                lon}
-			id = id + 1
-			entry = VibecheckLocationData{Video: videoStruct, IsLiked: false}
-			// Encode the data to JSON
-			jsonData, err := json.Marshal(entry)
-			if err != nil {
-				log.Info(err)
-				return
-			}
-
-			payload = append(payload, jsonData...)
-			payload = append(payload, []byte(`,`)...)
-
-		}
-		log.Trace(video_folder, location_hash, like_count, video_is_liked_by_user, time_stamp, user_id, user_name, location_name, lat, lon)
-	}
-
-	if id > 0 {
-		payload = payload[:len(payload)-1]
-	}
-	payload = append(payload, []byte(`]}]}`)...)
-
-	// log.Info(string(payload))
-	// Output: {"baz": [{"foo":"hello","bar":42}]}
-
-	if err := rows.Err(); err != nil {
-		panic(err)
-	}
-
-	if err != nil {
-		log.Info("Unable to connect to DB")
-		response.Message = "unable to connect to database server"
-		api.Respond(w, nil, http.StatusInternalServerError)
-		return
-	}
-
-	//api.RespondOK(w, msg)
-	api.RespondRaw(w, payload, http.StatusOK)
+	respondUserData(w, r, user_id, user_id_following, user_id, 1)
+}
 
+// GetUserVibes reads a user's profile as the user of the session sees it, and their vibes
+func GetUserVibes(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.UserVibesQuery)
+	respondUserData(w, r, session.UserID(r), req.UserId, req.UserId, req.Limit)
 }
 
 /*
 Get the set of videos associated with a given location+lat+lon from the database
 */
 func GetVideosByLocation(w http.ResponseWriter, r *http.Request) {
-	log.Trace("in GetVideosByLocation")
-
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// params := mux.Vars(r)
-	decoder := json.NewDecoder(r.Body)
-	var q LocationDataQuery
-	err := decoder.Decode(&q)
-	if err != nil {
-		log.Panic(err)
-	}
-	log.Info(q.Location)
+	q := openapi.Request(r).(*mAPI.LocationDataQuery)
 
 	// locationName_raw := params["locationName"]
 	locationName_raw := q.Location
 
-	log.Info("locationName_raw in GetVideosByLocation is: " + string(locationName_raw))
-
 	// lat_raw := params["lat"]
 	// lon_raw := params["lon"]
 	lat_raw := q.Lat
@@ -1650,10 +970,10 @@ func GetVideosByLocation(w http.ResponseWriter, r *http.Request) {
 	// latString := "39.950"
 
 	// take string lat and lon and make into float
-	lat_float, err := strconv.ParseFloat(lat_raw, 64)
-	lon_float, err := strconv.ParseFloat(lon_raw, 64)
-	if err != nil {
-		// handle error
+	lat_float, lon_float, invalid := parseLatLon(lat_raw, lon_raw)
+	if invalid != nil {
+		api.RespondInvalid(w, invalid...)
+		return
 	}
 
 	// expand float to have 9 decimal points as is the the db table criteria
@@ -1670,121 +990,55 @@ func GetVideosByLocation(w http.ResponseWriter, r *http.Request) {
 	// username (to display on ReelsView)
 	// profile_picture (to display on ReelsViews)
 
-	var payload = []byte(`{"videos": [`)
-	var entry interface{}
-
-	// leaving off here
-	// rows, err := store.DB.Query("SELECT all_videos.video_folder, all_videos.location_hash, all_videos.like_count, IF(ISNULL(videos_liked.user_id), false, true) AS 'is_liked', all_videos.time_stamp, users.user_name, users.photo, locations.location_name, locations.lat, locations.lon FROM all_videos JOIN users ON all_videos.user_id = users.user_id JOIN locations ON all_videos.location_hash = locations.location_hash LEFT JOIN videos_liked on all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash AND all_videos.user_id = ? WHERE all_videos.location_hash = ? ORDER BY all_videos.time_stamp DESC;", user_id, locationHash)
-
-	rows, err := store.DB.Query("SELECT all_videos.video_folder, all_videos.location_hash, all_videos.like_count, IF(ISNULL(videos_liked.user_id), false, true) AS 'is_liked', time_stamp, users.user_id, users.user_name, location_name, lat, lon FROM all_videos JOIN locations ON all_videos.location_hash = locations.location_hash JOIN users ON all_videos.user_id = users.user_id LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash AND videos_liked.user_id = ? WHERE all_videos.location_hash = ? AND all_videos.is_deleted = 0 ORDER BY all_videos.time_stamp DESC;", user_id, locationHash)
+	vibes, err := repos.Videos.AtLocation(r.Context(), user_id, locationHash)
 	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Info("error in user-indexed video SQL query")
-			log.Info(err)
-			panic(err)
-		} else {
-			// NO VIDEO RESULT IN DB - send empty array
-			log.Info("result set is empty in video SQL query")
-
-			payload = append(payload, []byte(`]}`)...)
-			api.RespondRaw(w, payload, http.StatusOK)
-		}
-
+		logging.FromContext(r.Context()).Error("error in user-indexed video SQL query: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to connect to database server")
+		return
 	}
 
-	// if we get here that means we have videos to send to client
-	defer rows.Close()
-	// buf := bytes.NewBuffer(newJSON)
-	// encoder := json.NewEncoder(buf)
-
-	// Skip the first row, because getLocationLatestVideo already did that for us
-	rows.Next()
-	var id = 0
-	for rows.Next() {
-
-		// Query db for video based on location
-		var video_folder string
-		var location_hash string
-		var video_like_count float64
-		var video_is_liked_by_user bool
-		var time_stamp time.Time
-		var result_user_id string
-		var user_name string
-		var photo bool
-		var location_name string
-		var lat float64
-		var lon float64
+	// Skip the first video, because getLocationLatestVideo already did that for us
+	if len(vibes) > 0 {
+		vibes = vibes[1:]
+	}
+	respondVibes(w, r, vibes)
+}
 
-		// log.Info("in rows.next")
-		err := rows.Scan(&video_folder, &location_hash, &video_like_count, &video_is_liked_by_user, &time_stamp, &result_user_id, &user_name, &location_name, &lat, &lon)
+// GetLocationVibes reads the vibes at a location, newest first, marking the ones the user of the session liked
+func GetLocationVibes(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.LocationVibesQuery)
+	vibes, err := repos.Videos.AtLocation(r.Context(), session.UserID(r), req.LocationId)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error in location-indexed video SQL query: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to connect to database server")
+		return
+	}
+	if req.Limit > 0 && len(vibes) > req.Limit {
+		vibes = vibes[:req.Limit]
+	}
+	respondVibes(w, r, vibes)
+}
 
+// respondVibes answers with the feed entries of vibes
+func respondVibes(w http.ResponseWriter, r *http.Request, vibes []repository.VideoView) {
+	var payload = []byte(`{"videos": [`)
+	for id, vibe := range vibes {
+		// Encode the data to JSON
+		entry := VibecheckLocationData{Video: newVideoStruct(r.Context(), id, vibe), IsLiked: false}
+		jsonData, err := json.Marshal(entry)
 		if err != nil {
-			panic(err)
-		} else {
-			// FOUND VIDEO RESULT IN DB - send VideoStruct
-			//create links
-			thumbnail_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_THUMBNAIL
-			video_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_VIDEO
-			selfie_link := VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_SELFIE
-			var user_pic_link string
-			if photo == true {
-				user_pic_link = USER_CONTENT_STREAM + "/" + result_user_id + "/" + USER_PICTURE
-			} else {
-				user_pic_link = FALLBACK_CONTENT
-			}
-
-			videoPayload := VideoStruct{
-				Id:                 id,
-				ThumbnailLink:      thumbnail_link,
-				VideoLink:          video_link,
-				SelfieLink:         selfie_link,
-				UserPicLink:        user_pic_link,
-				VideoFolder:        video_folder,
-				LocationHash:       location_hash,
-				VideoLikeCount:     video_like_count,
-				VideoIsLikedByUser: video_is_liked_by_user,
-				TimeStamp:          time_stamp,
-				UserId:             result_user_id,
-				Username:           user_name,
-				LocationName:       location_name,
-				Lat:                lat,
-				Lon:                lon}
-
-			id = id + 1
-			// Encode the data to JSON
-			entry = VibecheckLocationData{Video: videoPayload, IsLiked: false}
-			jsonData, err := json.Marshal(entry)
-			if err != nil {
-				log.Info(err)
-				return
-			}
+			logging.FromContext(r.Context()).Error(err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to encode the videos")
+			return
+		}
 
-			payload = append(payload, jsonData...)
+		if id > 0 {
 			payload = append(payload, []byte(`,`)...)
-			// log.Info("adding result row to response")
 		}
-		// log.Info(video_folder, location_hash, time_stamp, user_name, username, location_name, lat, lon)
-	}
-
-	if id > 0 {
-		payload = payload[:len(payload)-1]
+		payload = append(payload, jsonData...)
 	}
 	payload = append(payload, []byte(`]}`)...)
 
-	// log.Info(string(payload))
-	// Output: {"baz": [{"foo":"hello","bar":42}]}
-
-	if err := rows.Err(); err != nil {
-		panic(err)
-	}
-
-	if err != nil {
-		log.Info("Unable to connect to DB")
-		response.Message = "unable to connect to database server"
-		api.Respond(w, nil, http.StatusInternalServerError)
-		return
-	}
-
 	//api.RespondOK(w, msg)
 	api.RespondRaw(w, payload, http.StatusOK)
 
@@ -1799,84 +1053,58 @@ func SetFavoriteStatus(w http.ResponseWriter, r *http.Request) {
 	//temp path location
 	//UPLOADS_DIR := "/root/UPLOADS/"
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	params := mux.Vars(r)
-	// latitude := params["latitude"]
-	// longitude := params["longitude"]
+	req := openapi.Request(r).(*mAPI.FavoriteStatusRequest)
 
-	locationName_raw := params["locationName"]
+	locationName_raw := req.LocationName
 	// log.Info("locationLatLon in setFavoriteStatus is: ")
 	// log.Info(locationName_raw)
 
-	lat_raw := params["lat"]
-	lon_raw := params["lon"]
-
-	user_id := params["user_id"]
+	lat_float, lon_float := req.Lat, req.Lon
 
-	liked_status := params["liked_status"]
-
-	// latString := "39.950"
-
-	// take string lat and lon and make into float
-	lat_float, err := strconv.ParseFloat(lat_raw, 64)
-	lon_float, err := strconv.ParseFloat(lon_raw, 64)
-	if err != nil {
-		// handle error
-	}
+	user_id := req.UserId
 
 	// expand float to have 9 decimal points as is the the db table criteria
 	formattedLat := fmt.Sprintf("%.9f", lat_float)
 	formattedLon := fmt.Sprintf("%.9f", lon_float)
 	locationHash := GenerateLocationHashString(locationName_raw, formattedLat, formattedLon)
+	setFavorite(w, r, user_id, locationHash, req.LikedStatus)
+}
+
+// AddFavorite adds a location to the favorites of the user of the session
+func AddFavorite(w http.ResponseWriter, r *http.Request) {
+	setFavorite(w, r, session.UserID(r), openapi.Request(r).(*mAPI.LocationParam).LocationId, true)
+}
 
-	query := ""
+// RemoveFavorite removes a location from the favorites of the user of the session
+func RemoveFavorite(w http.ResponseWriter, r *http.Request) {
+	setFavorite(w, r, session.UserID(r), openapi.Request(r).(*mAPI.LocationParam).LocationId, false)
+}
 
-	if liked_status == "true" {
-		query = "INSERT INTO favorites (`user_id`, `location_hash`) VALUES (?, ?)"
+func setFavorite(w http.ResponseWriter, r *http.Request, user_id string, locationHash string, liked bool) {
+	var err error
+	if liked {
+		err = repos.Favorites.Add(r.Context(), user_id, locationHash)
 	} else { // false, remove
-		query = "DELETE FROM favorites WHERE user_id = ? AND location_hash = ?"
+		err = repos.Favorites.Remove(r.Context(), user_id, locationHash)
 	}
-	// query = "INSERT INTO favorites (`location_hash`, `user_name`) VALUES (?, ?) ON DUPLICATE KEY UPDATE video_folder = VALUES(video_folder)"
-
-	result, err := store.DB.Exec(query, user_id, locationHash)
-	// result := store.DB.QueryRow(query, locationHash, uID_timeStamp)
 	if err != nil {
-		log.Info("INSERT INTO favorites failed:")
-		log.Info(err)
+		logging.FromContext(r.Context()).Error("INSERT INTO favorites failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to update the favorite")
 		return
-	} else {
-		// log.Info("INSERT INTO favorites worked:")
-		log.Info(result)
 	}
 
-	response.Message = "Favorite Updated Successfully"
-	response.Success = true
-	response.Name = "favorites_update"
-	// log.Info(response.Message)
-	api.Respond(w, response, http.StatusOK)
+	api.RespondSuccess(w, http.StatusOK, "favorites_update", "Favorite Updated Successfully")
 }
 
 func SetIsVideoDeletedStatus(w http.ResponseWriter, r *http.Request) {
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
 	// just need time_stamp, user_id, and deleted_status from the client
 	// query statement for sql:
 	// UPDATE all_videos SET is_deleted = 1 WHERE user_id = '18fea441-e325-4893-9cc7-76b8ab2b7cad' AND time_stamp = '2023-03-10 22:22:37';
 
-	user_id := r.FormValue("user_id")
-	log.Info("time_stamp processing...")
-	time_stamp_unparsed := r.FormValue("time_stamp")
-	log.Info(time_stamp_unparsed)
+	req := openapi.Request(r).(*mAPI.VideoDeletedRequest)
+	user_id := req.UserId
+	time_stamp_unparsed := req.TimeStamp
 	// layout_react_native_time_stamp := "2006-01-02 15:04:05" // Must specify the layout of the input string
 	// time_stamp, err := time.Parse(layout_react_native_time_stamp, time_stamp_unparsed)
 	// if err != nil {
@@ -1886,49 +1114,39 @@ func SetIsVideoDeletedStatus(w http.ResponseWriter, r *http.Request) {
 	// }
 	time_stamp := strings.Replace(time_stamp_unparsed, "T", " ", 1)
 	time_stamp = strings.Replace(time_stamp, "Z", "", 1)
-	log.Info("After processing, time_stamp = " + time_stamp)
-	deleted_status := r.FormValue("deleted_status")
-	log.Info(user_id)
-	log.Info(deleted_status)
 
-	query1 := ""
-	query2 := ""
-
-	if deleted_status == "true" {
+	if req.DeletedStatus {
 		// keep below line, will implement fully later
 		// query = "UPDATE all_videos SET is_deleted = 1 WHERE user_id = ? AND time_stamp = ?;"
-
-		query1 = "DELETE from latest_videos WHERE user_id = ? AND time_stamp = ?;"
-		query2 = "DELETE from all_videos WHERE user_id = ? AND time_stamp = ?;"
-
-	}
-
-	result, err := store.DB.Exec(query1, user_id, time_stamp)
-
-	if err != nil {
-		log.Info(query1)
-		log.Info("UPDATE latest_videos failed:")
-		log.Info(err)
-		return
-	} else {
-		log.Info(result)
+		// the rows deleted name the folders whose files go with them
+		vibes, err := repos.Videos.DeleteByTimeStamp(r.Context(), user_id, time_stamp)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("delete video failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to delete the video")
+			return
+		}
+		for _, vibe := range vibes {
+			removeVibeFiles(r.Context(), vibe.LocationHash, vibe.Folder)
+		}
 	}
 
-	result, err = store.DB.Exec(query2, user_id, time_stamp)
+	api.RespondSuccess(w, http.StatusOK, "is_deleted_set", "is_deleted set successfully")
+}
 
-	if err != nil {
-		log.Info(query1)
-		log.Info("UPDATE all_videos failed:")
-		log.Info(err)
+// DeleteVibe deletes a vibe the user of the session posted
+func DeleteVibe(w http.ResponseWriter, r *http.Request) {
+	req := openapi.Request(r).(*mAPI.VibeParam)
+	if err := repos.Videos.Delete(r.Context(), session.UserID(r), req.LocationId, req.VibeId); err != nil {
+		if err == repository.ErrNotFound {
+			api.RespondError(w, http.StatusNotFound, api.CodeNotFound, "no such vibe of the session's user")
+			return
+		}
+		logging.FromContext(r.Context()).Error("delete video failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to delete the video")
 		return
-	} else {
-		log.Info(result)
 	}
-
-	response.Message = "is_deleted set successfully"
-	response.Success = true
-	response.Name = "is_deleted_set"
-	api.Respond(w, response, http.StatusOK)
+	removeVibeFiles(r.Context(), req.LocationId, req.VibeId)
+	api.RespondSuccess(w, http.StatusOK, "vibe_deleted", "Vibe deleted successfully")
 }
 
 func GetUserFavoriteLocationData(w http.ResponseWriter, r *http.Request) {
@@ -1936,131 +1154,60 @@ func GetUserFavoriteLocationData(w http.ResponseWriter, r *http.Request) {
 	//temp path location
 	//UPLOADS_DIR := "/root/UPLOADS/"
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	params := mux.Vars(r)
-	// latitude := params["latitude"]
-	// longitude := params["longitude"]
+	respondFavorites(w, r, openapi.Request(r).(*mAPI.FavoriteLocationsRequest).UserId)
+}
 
-	user_id := params["user_id"]
-	// log.Info("user_name in GetUserFavoriteLocations is: ")
-	// log.Info(request_user_name)
+// GetFavorites reads the latest video of each favorite location of the user of the session
+func GetFavorites(w http.ResponseWriter, r *http.Request) {
+	respondFavorites(w, r, session.UserID(r))
+}
 
-	// Query db for all favorites based on user_name
+func respondFavorites(w http.ResponseWriter, r *http.Request, user_id string) {
+	// Query db for all favorites based on user_id
 	// get latest video for each location
-	var payload = []byte(`{"videos": [`)
-	var entry interface{}
-
-	//
-
-	rows, err := store.DB.Query(
-		`select latest_videos.video_folder, favorites.location_hash, all_videos.time_stamp, users.user_id, locations.location_name, locations.lat, locations.lon 
-			from favorites 
-		left join latest_videos on favorites.location_hash = latest_videos.location_hash 
-		left join locations on favorites.location_hash = locations.location_hash 
-		left join all_videos on locations.location_hash = all_videos.location_hash and latest_videos.video_folder = all_videos.video_folder
-		left join users on users.user_name = favorites.user_id
-		where favorites.user_id = ?;`, user_id)
+	reqLog := logging.FromContext(r.Context())
+	favorites, err := repos.Favorites.ForUser(r.Context(), user_id)
 	if err != nil {
-		if err.Error() != "sql: no rows in result set" {
-			log.Info("error in user-indexed video SQL query")
-			log.Info(err)
-			panic(err)
-		} else {
-			// NO VIDEO RESULT IN DB - send empty array
-			// log.Info("result set is empty in video SQL query")
-
-			payload = append(payload, []byte(`]}`)...)
-			api.RespondRaw(w, payload, http.StatusOK)
-		}
-
+		reqLog.Error("error in user-indexed video SQL query: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to connect to database server")
+		return
 	}
 
-	var id = 0
-	// if we get here that means we have videos to send to client
-	defer rows.Close()
-
-	for rows.Next() {
-		var null_video_folder sql.NullString
-		var null_location_hash sql.NullString
-		var null_time_stamp sql.NullTime
-		var null_user_name sql.NullString //as an int yes, no?
-		// var null_username sql.NullString
-		var null_location_name sql.NullString
-		var null_lat sql.NullFloat64
-		var null_lon sql.NullFloat64
+	var payload = []byte(`{"videos": [`)
+	for id, favorite := range favorites {
+		video_folder := favorite.VideoFolder
+		location_hash := favorite.Location.Hash
+
+		//create links
+		thumbnail_link := ""
+		video_link := ""
+		selfie_link := ""
+		user_pic_link := ""
+		if video_folder != "" { // handling for favorite location doesn't have a latest video
+			thumbnail_link = thumbnailLink(r.Context(), location_hash, video_folder)
+			video_link = VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_VIDEO
+			selfie_link = VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_SELFIE
+			user_pic_link = userPicLink(r.Context(), user_id, vibePictureSize)
+		}
 
-		// log.Info("in rows.next")
-		err := rows.Scan(&null_video_folder, &null_location_hash, &null_time_stamp, &null_user_name, &null_location_name, &null_lat, &null_lon)
+		videoStruct := VideoStruct{Id: id, ThumbnailLink: thumbnail_link, VideoLink: video_link, SelfieLink: selfie_link, UserPicLink: user_pic_link, VideoFolder: video_folder, LocationHash: location_hash, TimeStamp: favorite.TimeStamp, UserId: user_id, LocationName: favorite.Location.Name, Lat: favorite.Location.Lat, Lon: favorite.Location.Lon}
+		entry := VibecheckLocationData{Video: videoStruct, IsLiked: true}
 
+		// Encode the data to JSON
+		jsonData, err := json.Marshal(entry)
 		if err != nil {
-			panic(err)
-		} else {
-
-			var video_folder = null_video_folder.String
-			var location_hash = null_location_hash.String
-			var time_stamp = null_time_stamp.Time
-			var user_name = null_user_name.String
-			// var username = null_username.String
-			var location_name = null_location_name.String
-			var lat = null_lat.Float64
-			var lon = null_lon.Float64
-
-			// FOUND VIDEO RESULT IN DB - send VideoStruct
-			//create links
-			thumbnail_link := ""
-			video_link := ""
-			selfie_link := ""
-			user_pic_link := ""
-			if video_folder != "" { // handling for favorite location doesn't have a latest video
-				thumbnail_link = VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_THUMBNAIL
-				video_link = VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_VIDEO
-				selfie_link = VIBE_CONTENT_STREAM + "/" + location_hash + "/" + video_folder + "/" + VIBE_SELFIE
-				user_pic_link = USER_CONTENT_STREAM + "/" + user_id + "/" + USER_PICTURE
-			}
-
-			videoStruct := VideoStruct{Id: id, ThumbnailLink: thumbnail_link, VideoLink: video_link, SelfieLink: selfie_link, UserPicLink: user_pic_link, VideoFolder: video_folder, LocationHash: location_hash, TimeStamp: time_stamp, UserId: user_id, Username: user_name, LocationName: location_name, Lat: lat, Lon: lon}
-			id = id + 1
-
-			entry = VibecheckLocationData{Video: videoStruct, IsLiked: true}
-
-			// Encode the data to JSON
-			jsonData, err := json.Marshal(entry)
-			if err != nil {
-				log.Info(err)
-				return
-			}
+			reqLog.Error(err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to encode the videos")
+			return
+		}
 
-			payload = append(payload, jsonData...)
+		if id > 0 {
 			payload = append(payload, []byte(`,`)...)
-			// log.Info("adding result row to response")
-			// log.Info(video_folder, location_hash, time_stamp, user_id, user_name, location_name, lat, lon)
 		}
-
-	}
-	if id > 0 {
-		payload = payload[:len(payload)-1]
+		payload = append(payload, jsonData...)
 	}
 	payload = append(payload, []byte(`]}`)...)
 
-	// log.Info(string(payload))
-	// Output: {"baz": [{"foo":"hello","bar":42}]}
-
-	if err := rows.Err(); err != nil {
-		panic(err)
-	}
-
-	if err != nil {
-		log.Info("Unable to connect to DB")
-		response.Message = "unable to connect to database server"
-		api.Respond(w, nil, http.StatusInternalServerError)
-		return
-	}
-
 	//api.RespondOK(w, msg)
 	api.RespondRaw(w, payload, http.StatusOK)
 
@@ -0,0 +1,233 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/session"
+	"vibe-common/storage"
+	"vibe/config"
+	mAPI "vibe/model/api"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/mux"
+)
+
+// sessions is a Redis connection whose session tokens are the IDs of their users
+type sessions struct{}
+
+func (sessions) Close() error { return nil }
+func (sessions) Err() error   { return nil }
+func (sessions) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return []byte(args[0].(string)), nil
+}
+func (sessions) Send(cmd string, args ...interface{}) error { return nil }
+func (sessions) Flush() error                               { return nil }
+func (sessions) Receive() (interface{}, error)              { return nil, nil }
+
+// testServer is the package set up on the memory repos and local storage in a temporary
+// DESTINATION, routed like main routes it
+type testServer struct {
+	t       *testing.T
+	handler http.Handler
+	repos   *repository.Repos
+	objects storage.Storage
+}
+
+func newTestServer(t *testing.T) *testServer {
+	dir := t.TempDir()
+	config.CONFIGURATION = config.Configuration{
+		DESTINATION: dir, STREAM_HOST: "https://stream.test",
+		VIBE_VIDEO: "video.mp4", VIBE_THUMBNAIL: "thumbnail.jpg", VIBE_SELFIE: "selfie.jpg",
+		UPLOAD_MAX_SIZE: 1 << 20, UPLOAD_EXPIRY: time.Hour, DRAFT_EXPIRY: time.Hour,
+		VIDEO_MAX_SIZE: 1 << 20, VIDEO_MAX_DURATION: time.Minute, VIDEO_MAX_DIMENSION: 3840,
+		THUMBNAIL_MAX_SIZE: 1 << 20, THUMBNAIL_MAX_DIMENSION: 4096,
+		SELFIE_MAX_SIZE: 1 << 20, SELFIE_MAX_DIMENSION: 8192,
+		USER_PICTURE_MAX_SIZE: 1 << 20, USER_PICTURE_MAX_DIMENSION: 4096,
+	}
+	s := &testServer{t: t, repos: repository.NewMemory(), objects: storage.NewLocal(dir)}
+	Setup(s.repos, s.objects)
+	t.Cleanup(Cleanup)
+
+	r := mux.NewRouter()
+	spec := openapi.New("test", "1")
+	me := session.Require(func(ctx context.Context) redis.Conn { return sessions{} })
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/uploads", Request: mAPI.UploadCreateRequest{}, Status: http.StatusCreated}, me(Tus(http.HandlerFunc(CreateUpload))))
+	spec.Handle(r, openapi.Route{Method: "HEAD", Path: "/v1/uploads/{upload_id:[0-9a-f]+}", Request: mAPI.UploadParam{}}, me(Tus(http.HandlerFunc(HeadUpload))))
+	spec.Handle(r, openapi.Route{Method: "PATCH", Path: "/v1/uploads/{upload_id:[0-9a-f]+}", Request: mAPI.UploadPatchRequest{}, Status: http.StatusNoContent}, me(Tus(http.HandlerFunc(PatchUpload))))
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/uploads/{upload_id:[0-9a-f]+}/finalize", Request: mAPI.UploadParam{}, Response: VibeResponse{}, Status: http.StatusCreated}, me(http.HandlerFunc(FinalizeUpload)))
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/drafts", Request: mAPI.DraftRequest{}, Response: DraftResponse{}, Status: http.StatusCreated}, me(http.HandlerFunc(CreateDraft)))
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/drafts/{draft_id:[0-9a-f]+}", Request: mAPI.DraftParam{}, Response: DraftResponse{}}, me(http.HandlerFunc(GetDraft)))
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/drafts/{draft_id:[0-9a-f]+}/commit", Request: mAPI.DraftParam{}, Response: VibeResponse{}, Status: http.StatusCreated}, me(http.HandlerFunc(CommitDraft)))
+	spec.Handle(r, openapi.Route{Method: "PUT", Path: "/v1/me/picture", Response: PictureResponse{}}, me(http.HandlerFunc(PutPicture)))
+	spec.Handle(r, openapi.Route{Method: "PUT", Path: "/v1/locations/{location_id}/vibes/{vibe_id}/like", Request: mAPI.VibeParam{}}, me(http.HandlerFunc(LikeVibe)))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/locations/{location_id}/vibes/{vibe_id}/like", Request: mAPI.VibeParam{}}, me(http.HandlerFunc(UnlikeVibe)))
+	s.handler = r
+
+	for _, id := range []string{"alice", "bob"} {
+		if err := s.repos.Users.Create(context.Background(), repository.User{UserID: id, UserName: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// do sends a request as user with the headers, a tus request unless they say otherwise
+func (s *testServer) do(method string, path string, user string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+user)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range headers {
+		if value == "" {
+			r.Header.Del(key)
+		} else {
+			r.Header.Set(key, value)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// create starts an upload of length bytes with the metadata and returns its ID
+func (s *testServer) create(user string, metadata map[string]string, length int) string {
+	s.t.Helper()
+	w := s.do("POST", "/v1/uploads", user, map[string]string{
+		"Upload-Length": strconv.Itoa(length), "Upload-Metadata": encodeUploadMetadata(metadata)}, nil)
+	if w.Code != http.StatusCreated {
+		s.t.Fatalf("creating upload: %d %s", w.Code, w.Body)
+	}
+	return w.Header().Get("Location")[len("/v1/uploads/"):]
+}
+
+// patch writes a chunk at offset, with a checksum header unless it is ""
+func (s *testServer) patch(user string, id string, offset int, checksum string, chunk []byte) *httptest.ResponseRecorder {
+	return s.do("PATCH", "/v1/uploads/"+id, user, map[string]string{"Content-Type": offsetContentType,
+		"Upload-Offset": strconv.Itoa(offset), "Upload-Checksum": checksum}, chunk)
+}
+
+// upload sends file in one chunk and finalizes it
+func (s *testServer) upload(user string, metadata map[string]string, file []byte) *httptest.ResponseRecorder {
+	s.t.Helper()
+	id := s.create(user, metadata, len(file))
+	if w := s.patch(user, id, 0, "", file); w.Code != http.StatusNoContent {
+		s.t.Fatalf("sending upload: %d %s", w.Code, w.Body)
+	}
+	return s.do("POST", "/v1/uploads/"+id+"/finalize", user, nil, nil)
+}
+
+// keys lists what is stored below prefix
+func (s *testServer) keys(prefix string) []string {
+	s.t.Helper()
+	objects, err := s.objects.List(context.Background(), prefix)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	var keys []string
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	return keys
+}
+
+// decode reads a JSON response into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+}
+
+// errorCode is the code of an error envelope
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var envelope struct {
+		Error struct{ Code string }
+	}
+	decode(t, w, &envelope)
+	return envelope.Error.Code
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func mkbox(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	return append(append(u32(uint32(8+len(payload))), typ...), payload...)
+}
+
+// testMovie is a two second 640x360 H.264 MP4 as far as probing reads it
+func testMovie() []byte {
+	tkhd := make([]byte, 84)
+	copy(tkhd[40:], u32(0x00010000))
+	copy(tkhd[76:], u32(640<<16))
+	copy(tkhd[80:], u32(360<<16))
+	trak := mkbox("trak", mkbox("tkhd", tkhd), mkbox("mdia",
+		mkbox("hdlr", make([]byte, 8), []byte("vide"), make([]byte, 12)),
+		mkbox("minf", mkbox("stbl", mkbox("stsd", make([]byte, 4), u32(1), mkbox("avc1", make([]byte, 78)))))))
+	mvhd := mkbox("mvhd", make([]byte, 12), u32(1000), u32(2000), make([]byte, 80))
+	return bytes.Join([][]byte{
+		mkbox("ftyp", []byte("isom"), u32(0x200), []byte("isomavc1")),
+		mkbox("moov", mvhd, trak),
+		mkbox("mdat", make([]byte, 512)),
+	}, nil)
+}
+
+// testPicture is a PNG of the given size
+func testPicture(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLikeVibe(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	v := repository.Video{Folder: "alice-2021-06-01-12-00-00", LocationHash: "abc", UserID: "alice", TimeStamp: time.Now()}
+	if err := s.repos.Locations.Ensure(ctx, repository.Location{Hash: "abc", Name: "Berlin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repos.Videos.Create(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+	like := "/v1/locations/abc/vibes/" + v.Folder + "/like"
+
+	// each request is repeated the way a client retrying it would
+	for _, step := range []struct {
+		method string
+		count  float64
+	}{{"PUT", 1}, {"PUT", 1}, {"DELETE", 0}, {"DELETE", 0}, {"PUT", 1}} {
+		if w := s.do(step.method, like, "bob", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("%s like = %d %s", step.method, w.Code, w.Body)
+		}
+		vibes, err := s.repos.Videos.AtLocation(ctx, "bob", "abc")
+		if err != nil || len(vibes) != 1 {
+			t.Fatalf("AtLocation = %+v, %v", vibes, err)
+		}
+		if vibes[0].LikeCount != step.count || vibes[0].IsLiked != (step.count == 1) {
+			t.Errorf("after %s, %v likes, liked %t, want %v", step.method, vibes[0].LikeCount, vibes[0].IsLiked, step.count)
+		}
+	}
+}
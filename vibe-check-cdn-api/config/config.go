@@ -0,0 +1,78 @@
+package config
+
+import (
+	"time"
+
+	vcconfig "vibe-common/config"
+)
+
+type Configuration struct {
+	vcconfig.Base
+	vcconfig.Server
+	vcconfig.Health
+	vcconfig.Tracing
+	vcconfig.MariaDB
+	vcconfig.Redis
+	vcconfig.Storage
+	APP_PORT string `required:"true"`
+
+	// central services info, DESTINATION is the root of local storage
+	DESTINATION string `required:"true"`
+	STREAM_HOST string `required:"true"`
+
+	// vibe file names
+	VIBE_THUMBNAIL string `required:"true"`
+	VIBE_VIDEO     string `required:"true"`
+	VIBE_SELFIE    string `required:"true"`
+
+	// resumable uploads, partial files live in UPLOAD_STORAGE (DESTINATION/.uploads when empty) until stored
+	UPLOAD_STORAGE        string
+	UPLOAD_MAX_SIZE       int           `default:"104857600" min:"1"`
+	UPLOAD_EXPIRY         time.Duration `default:"24h"`
+	UPLOAD_SWEEP_INTERVAL time.Duration `default:"1h"` // also sweeps expired drafts
+
+	// vibe drafts, uncommitted ones are swept with their files after DRAFT_EXPIRY
+	DRAFT_EXPIRY time.Duration `default:"24h"`
+
+	// media limits per role, a zero duration or dimension does not limit, dimensions are the longest side in pixels
+	VIDEO_MAX_SIZE             int           `default:"104857600" min:"1"`
+	VIDEO_MAX_DURATION         time.Duration `default:"3m"`
+	VIDEO_MAX_DIMENSION        int           `default:"3840" min:"0"`
+	THUMBNAIL_MAX_SIZE         int           `default:"5242880" min:"1"`
+	THUMBNAIL_MAX_DIMENSION    int           `default:"4096" min:"0"`
+	SELFIE_MAX_SIZE            int           `default:"20971520" min:"1"`
+	SELFIE_MAX_DIMENSION       int           `default:"8192" min:"0"`
+	USER_PICTURE_MAX_SIZE      int           `default:"20971520" min:"1"`
+	USER_PICTURE_MAX_DIMENSION int           `default:"4096" min:"0"`
+
+	// stores the renditions of pictures stored before they had any in the background after startup
+	RENDITIONS_ON_STARTUP bool `default:"true"`
+
+	// transcoding, ffmpeg turns each video into an HLS ladder, poster and thumbnail, the video is
+	// served once that is done; disabled, videos are served as uploaded
+	TRANSCODE_ENABLED       bool          `default:"true"`
+	TRANSCODE_WORKERS       int           `default:"2" min:"0"` // 0 leaves the queue to other instances
+	FFMPEG_PATH             string        `default:"ffmpeg"`
+	TRANSCODE_DIR           string        // DESTINATION/.transcode when empty
+	TRANSCODE_POLL_INTERVAL time.Duration `default:"5s"`
+	TRANSCODE_TIMEOUT       time.Duration `default:"15m"`
+	TRANSCODE_MAX_ATTEMPTS  int           `default:"3" min:"1"`
+	TRANSCODE_RETRY_BACKOFF time.Duration `default:"1m"`
+}
+
+var CONFIGURATION Configuration
+
+// Defaults give an upload chunk 5 minutes to arrive, about 37 MB on a 1 Mbit/s uplink
+func (Configuration) Defaults() map[string]string {
+	return map[string]string{"SERVER_READ_TIMEOUT": "5m", "SERVER_WRITE_TIMEOUT": "5m"}
+}
+
+// Loads and validates the configuration from defaults, config file, env and *_FILE secrets
+func InitConfig() error {
+	return vcconfig.Load(&CONFIGURATION)
+}
+
+// Logs the configuration in use with secret values redacted
+func PrintConfig() {
+	vcconfig.Print(CONFIGURATION)
+}
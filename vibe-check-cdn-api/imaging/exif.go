@@ -0,0 +1,90 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Orientation values of the EXIF Orientation tag, how the stored pixels must be turned to be
+// upright. 1 is upright, 6 and 8 are the quarter turns phones record portrait photos with.
+const (
+	Upright          = 1
+	MirrorHorizontal = 2
+	Rotate180        = 3
+	MirrorVertical   = 4
+	Transpose        = 5
+	Rotate90         = 6 // clockwise
+	Transverse       = 7
+	Rotate270        = 8 // clockwise
+)
+
+const orientationTag = 0x0112
+
+// jpegOrientation finds the EXIF orientation of a JPEG among the segments before its image
+// data, Upright when it has none or it cannot be read
+func jpegOrientation(r io.Reader) int {
+	var marker [4]byte
+	if _, err := io.ReadFull(r, marker[:2]); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return Upright
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return Upright
+		}
+		// SOS starts the image data, the metadata segments all come before it
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return Upright
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return Upright
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return Upright
+		}
+		if marker[1] == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+	}
+}
+
+// tiffOrientation reads the Orientation tag of the first IFD of the TIFF structure EXIF is
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return Upright
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return Upright
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return Upright
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return Upright
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return Upright
+		}
+		if order.Uint16(tiff[entry:]) != orientationTag {
+			continue
+		}
+		// a SHORT, stored in the first two bytes of the value field
+		orientation := int(order.Uint16(tiff[entry+8:]))
+		if orientation < Upright || orientation > Rotate270 {
+			return Upright
+		}
+		return orientation
+	}
+	return Upright
+}
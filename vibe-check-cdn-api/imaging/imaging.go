@@ -0,0 +1,229 @@
+package imaging
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+)
+
+// Quality of the JPEG files Encode writes
+const JPEGQuality = 85
+
+// ErrUnreadable is what Decode failures wrap when the file is not a JPEG or PNG it can decode
+var ErrUnreadable = errors.New("imaging: not a readable JPEG or PNG picture")
+
+// ErrTooLarge is what Decode failures wrap when the picture is bigger than it may decode
+var ErrTooLarge = errors.New("imaging: picture too large")
+
+// Picture is a decoded picture, upright, with none of the metadata of the file it came from
+type Picture struct {
+	Image  *image.RGBA
+	Format string // "jpeg" or "png", what the file was
+}
+
+// Decode reads the JPEG or PNG picture at path and turns it upright by its EXIF orientation.
+// A longer side than maxSide pixels is refused from the header, before any pixel is decoded;
+// zero does not limit.
+func Decode(path string, maxSide int) (Picture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Picture{}, err
+	}
+	defer f.Close()
+
+	config, format, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return Picture{}, fmt.Errorf("%w: %v", ErrUnreadable, err)
+	}
+	if format != "jpeg" && format != "png" {
+		return Picture{}, fmt.Errorf("%w: a %s picture", ErrUnreadable, format)
+	}
+	if maxSide > 0 && (config.Width > maxSide || config.Height > maxSide) {
+		return Picture{}, fmt.Errorf("%w: picture is %dx%d, its longest side may be at most %d", ErrTooLarge, config.Width, config.Height, maxSide)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Picture{}, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(f))
+	if err != nil {
+		return Picture{}, fmt.Errorf("%w: %v", ErrUnreadable, err)
+	}
+
+	orientation := Upright
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return Picture{}, err
+		}
+		orientation = jpegOrientation(bufio.NewReader(f))
+	}
+	return Picture{Image: orient(toRGBA(img), orientation), Format: format}, nil
+}
+
+// toRGBA copies img into premultiplied RGBA with its origin at 0,0, what the other functions work on
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// orient turns the stored pixels of src the way an EXIF orientation says
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation == Upright {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= Transpose {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch orientation {
+			case MirrorHorizontal:
+				sx, sy = w-1-dx, dy
+			case Rotate180:
+				sx, sy = w-1-dx, h-1-dy
+			case MirrorVertical:
+				sx, sy = dx, h-1-dy
+			case Transpose:
+				sx, sy = dy, dx
+			case Rotate90:
+				sx, sy = dy, h-1-dx
+			case Transverse:
+				sx, sy = w-1-dy, h-1-dx
+			case Rotate270:
+				sx, sy = w-1-dy, dx
+			}
+			s := src.PixOffset(sx, sy)
+			d := dst.PixOffset(dx, dy)
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+// Fit scales src down so its longer side has at most size pixels, keeping the aspect ratio.
+// A smaller picture is returned as it is, pictures are never scaled up.
+func Fit(src *image.RGBA, size int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	dw, dh := size, h*size/w
+	if h > w {
+		dw, dh = w*size/h, size
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	return resize(src, dw, dh)
+}
+
+// resize averages every pixel of src into the dw×dh pixels covering it, a box filter that keeps
+// detail when shrinking a lot. Output rows are made one at a time, so only one row of sums is
+// held whatever the size of src. src is premultiplied, so transparent pixels do not darken their
+// neighbours.
+func resize(src *image.RGBA, dw int, dh int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	columns := make([][]span, dw)
+	for x := range columns {
+		columns[x] = coverage(x, w, dw)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	acc := make([]float64, dw*4)
+	for y := 0; y < dh; y++ {
+		for i := range acc {
+			acc[i] = 0
+		}
+		for _, row := range coverage(y, h, dh) {
+			for x, spans := range columns {
+				for _, s := range spans {
+					p := src.Pix[src.PixOffset(s.at, row.at):]
+					weight := s.weight * row.weight
+					for c := 0; c < 4; c++ {
+						acc[x*4+c] += float64(p[c]) * weight
+					}
+				}
+			}
+		}
+		d := dst.PixOffset(0, y)
+		for i, v := range acc {
+			dst.Pix[d+i] = clamp(v)
+		}
+	}
+	return dst
+}
+
+// span is how much of source pixel at goes into a destination pixel
+type span struct {
+	at     int
+	weight float64
+}
+
+// coverage lists the source pixels destination pixel i of n covers out of size, with weights
+// adding up to one
+func coverage(i int, size int, n int) []span {
+	scale := float64(size) / float64(n)
+	start, end := float64(i)*scale, float64(i+1)*scale
+	var spans []span
+	for at := int(start); at < size && float64(at) < end; at++ {
+		left, right := float64(at), float64(at+1)
+		if left < start {
+			left = start
+		}
+		if right > end {
+			right = end
+		}
+		spans = append(spans, span{at: at, weight: (right - left) / scale})
+	}
+	return spans
+}
+
+func clamp(v float64) uint8 {
+	v += 0.5
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// flatten lays img over white, JPEG has no transparency
+func flatten(img *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// EncodeJPEG writes img as a JPEG of JPEGQuality, without any metadata
+func EncodeJPEG(w io.Writer, img *image.RGBA) error {
+	if !img.Opaque() {
+		img = flatten(img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+}
+
+// Encode writes the picture back in the format it came in, without any metadata
+func (p Picture) Encode(w io.Writer) error {
+	if p.Format == "png" {
+		return png.Encode(w, p.Image)
+	}
+	return EncodeJPEG(w, p.Image)
+}
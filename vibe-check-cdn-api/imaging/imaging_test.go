@@ -0,0 +1,199 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// labelled makes a picture whose pixels are the letters of rows, one row per string
+func labelled(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x := range row {
+			img.Set(x, y, color.RGBA{R: row[x], A: 255})
+		}
+	}
+	return img
+}
+
+func rows(img *image.RGBA) []string {
+	var rows []string
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		row := make([]byte, img.Bounds().Dx())
+		for x := range row {
+			row[x] = img.RGBAAt(x, y).R
+		}
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	// the stored pixels, a portrait picture two wide and three high
+	stored := []string{"ab", "cd", "ef"}
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{Upright, []string{"ab", "cd", "ef"}},
+		{MirrorHorizontal, []string{"ba", "dc", "fe"}},
+		{Rotate180, []string{"fe", "dc", "ba"}},
+		{MirrorVertical, []string{"ef", "cd", "ab"}},
+		{Transpose, []string{"ace", "bdf"}},
+		{Rotate90, []string{"eca", "fdb"}},
+		{Transverse, []string{"fdb", "eca"}},
+		{Rotate270, []string{"bdf", "ace"}},
+	}
+	for _, tt := range tests {
+		got := rows(orient(labelled(stored...), tt.orientation))
+		if len(got) != len(tt.want) {
+			t.Errorf("orient(%d) = %q, want %q", tt.orientation, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("orient(%d) = %q, want %q", tt.orientation, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// exif builds the APP1 segment of an EXIF block holding only an orientation
+func exif(order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 26)
+	copy(tiff, "II")
+	if order == binary.BigEndian {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], orientationTag)
+	order.PutUint16(tiff[12:], 3) // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+	return append(header, segment...)
+}
+
+// withSegment puts a segment right after the start of image marker of a JPEG
+func withSegment(file []byte, segment []byte) []byte {
+	return append(append(append([]byte{}, file[:2]...), segment...), file[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, labelled("ab", "cd", "ef"), nil); err != nil {
+		t.Fatal(err)
+	}
+	comment := []byte{0xFF, 0xFE, 0, 4, 'h', 'i'}
+	tests := []struct {
+		name string
+		file []byte
+		want int
+	}{
+		{"no exif", plain.Bytes(), Upright},
+		{"intel order", withSegment(plain.Bytes(), exif(binary.LittleEndian, Rotate90)), Rotate90},
+		{"motorola order", withSegment(plain.Bytes(), exif(binary.BigEndian, Rotate270)), Rotate270},
+		{"after a comment", withSegment(plain.Bytes(), append(comment, exif(binary.BigEndian, Rotate180)...)), Rotate180},
+		{"out of range", withSegment(plain.Bytes(), exif(binary.LittleEndian, 9)), Upright},
+		{"truncated", withSegment(plain.Bytes(), exif(binary.LittleEndian, Rotate90))[:30], Upright},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), Upright},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(bytes.NewReader(tt.file)); got != tt.want {
+			t.Errorf("jpegOrientation(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "portrait.jpg")
+	if err := ioutil.WriteFile(path, withSegment(plain.Bytes(), exif(binary.LittleEndian, Rotate90)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Decode(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := p.Image.Bounds(); p.Format != "jpeg" || b.Dx() != 3 || b.Dy() != 2 {
+		t.Errorf("Decode = a %dx%d %s, want the upright 3x2 jpeg", b.Dx(), b.Dy(), p.Format)
+	}
+	if _, err := Decode(path, 2); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Decode(maxSide 2) = %v, want ErrTooLarge", err)
+	}
+
+	gif := filepath.Join(dir, "picture.gif")
+	if err := ioutil.WriteFile(gif, []byte("GIF89a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(gif, 0); !errors.Is(err, ErrUnreadable) {
+		t.Errorf("Decode(gif) = %v, want ErrUnreadable", err)
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		w, h, size   int
+		wantW, wantH int
+		unchanged    bool
+	}{
+		{4000, 3000, 1000, 1000, 750, false},
+		{3000, 4000, 1000, 750, 1000, false},
+		{1000, 1000, 1000, 1000, 1000, true},
+		{500, 400, 1000, 500, 400, true},
+		{5000, 2, 1000, 1000, 1, false},
+	}
+	for _, tt := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, tt.w, tt.h))
+		got := Fit(src, tt.size)
+		if b := got.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("Fit(%dx%d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.size, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+		if (got == src) != tt.unchanged {
+			t.Errorf("Fit(%dx%d, %d) returned the source %v, want %v", tt.w, tt.h, tt.size, got == src, tt.unchanged)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	got := Fit(src, 2)
+	for x := 0; x < 2; x++ {
+		if c := got.RGBAAt(x, 0); c != (color.RGBA{128, 128, 128, 255}) {
+			t.Errorf("pixel %d = %v, want the grey average", x, c)
+		}
+	}
+}
+
+func TestEncodeJPEGFlattensOnWhite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(4, 4).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("transparent pixel encoded as %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	_ "encoding/json"
 	_ "io/ioutil"
 	"net/http"
@@ -8,9 +9,23 @@ import (
 	_ "time"
 
 	"vibe/api/video"
+	"vibe/config"
+	mAPI "vibe/model/api"
 	"vibe/store"
+	"vibe/transcode"
+
+	"vibe-common/api"
+	"vibe-common/health"
+	"vibe-common/logging"
+	"vibe-common/metrics"
+	"vibe-common/middleware"
+	"vibe-common/migrate"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/server"
+	"vibe-common/session"
+	"vibe-common/tracing"
 
-	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -19,87 +34,211 @@ import (
 	_ "github.com/thedevsaddam/gojsonq"
 )
 
-// Requests
-func handleAuthRequests(r *mux.Router) {
-	r.HandleFunc("/chunk-upload", video.ChunkUploadHandler).Methods("POST")
-	r.HandleFunc("/user-pic-upload", video.UserPicUploadHandler).Methods("POST")
-	r.HandleFunc("/getLocationLatestData", video.GetLocationLatestData).Methods("POST")
-	r.HandleFunc("/data/user", video.GetDataByUser).Methods("POST")
-	r.HandleFunc("/videos/location", video.GetVideosByLocation).Methods("POST")
-	r.HandleFunc("/setFavoriteStatus/{locationName}/{lat}/{lon}/{user_name}/{liked_status}", video.SetFavoriteStatus).Methods("POST")
-	r.HandleFunc("/getUserFavoriteLocationData/{user_name}", video.GetUserFavoriteLocationData).Methods("GET")
-	r.HandleFunc("/chat-message-upload", video.ChatMessageUpload).Methods("POST")
-	r.HandleFunc("/getLocationChat", video.GetLocationChat).Methods("POST")
-	r.HandleFunc("/setVideoLikedStatus", video.SetVideoLikedStatus).Methods("POST")
-	r.HandleFunc("/setIsVideoDeletedStatus", video.SetIsVideoDeletedStatus).Methods("POST")
-	r.HandleFunc("/get-user-latest-data", video.GetUserLatestData).Methods("POST")
-
+// Resource routes of the v1 API, each documented in the OpenAPI spec and validated against its request type
+func handleV1Requests(r *mux.Router, spec *openapi.Spec) {
+	me := session.Require(store.CacheConn)      // the session's user is the subject
+	viewer := session.Optional(store.CacheConn) // the session's user, if any, marks likes and follows
+
+	// Locations
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/locations", Summary: "Record a location and read its ID",
+		Request: mAPI.LocationRequest{}, Response: video.LocationResponse{}, Session: session.Cookie}, viewer(http.HandlerFunc(video.CreateLocation)))
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/locations/{location_id}/vibes", Summary: "Read the vibes at a location, newest first",
+		Request: mAPI.LocationVibesQuery{}, Response: video.VideosResponse{}, Session: session.Cookie}, viewer(http.HandlerFunc(video.GetLocationVibes)))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/locations/{location_id}/vibes/{vibe_id}", Summary: "Delete a vibe of the session's user",
+		Request: mAPI.VibeParam{}, Session: session.Cookie}, me(http.HandlerFunc(video.DeleteVibe)))
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/locations/{location_id}/vibes/{vibe_id}/status", Summary: "Read how far a vibe of the session's user got through transcoding",
+		Request: mAPI.VibeParam{}, Response: video.VibeStatusResponse{}, Session: session.Cookie}, me(http.HandlerFunc(video.GetVibeStatus)))
+	spec.Handle(r, openapi.Route{Method: "PUT", Path: "/v1/locations/{location_id}/vibes/{vibe_id}/like", Summary: "Like a vibe",
+		Request: mAPI.VibeParam{}, Session: session.Cookie}, me(http.HandlerFunc(video.LikeVibe)))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/locations/{location_id}/vibes/{vibe_id}/like", Summary: "Unlike a vibe",
+		Request: mAPI.VibeParam{}, Session: session.Cookie}, me(http.HandlerFunc(video.UnlikeVibe)))
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/v1/locations/{location_id}/messages", Summary: "Read the messages of a location thread",
+		Request: mAPI.LocationMessagesQuery{}, Response: video.ChatResponse{}}, video.GetLocationMessages)
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/locations/{location_id}/messages", Summary: "Post a message to a location thread",
+		Request: mAPI.LocationMessageRequest{}, Status: http.StatusCreated, Session: session.Cookie}, me(http.HandlerFunc(video.PostLocationMessage)))
+
+	// Resumable uploads, tus 1.0.0
+	spec.HandleFunc(r, openapi.Route{Method: "OPTIONS", Path: "/v1/uploads", Summary: "Read the tus version, extensions and size limit",
+		Status: http.StatusNoContent}, video.UploadOptions)
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/uploads", Summary: "Start a resumable upload of a vibe's video, thumbnail or selfie",
+		Request: mAPI.UploadCreateRequest{}, Status: http.StatusCreated, Session: session.Cookie}, me(video.Tus(http.HandlerFunc(video.CreateUpload))))
+	spec.Handle(r, openapi.Route{Method: "HEAD", Path: "/v1/uploads/{upload_id:[0-9a-f]+}", Summary: "Read the offset an upload resumes from",
+		Request: mAPI.UploadParam{}, Session: session.Cookie}, me(video.Tus(http.HandlerFunc(video.HeadUpload))))
+	spec.Handle(r, openapi.Route{Method: "PATCH", Path: "/v1/uploads/{upload_id:[0-9a-f]+}", Summary: "Write a chunk at the upload's offset",
+		Request: mAPI.UploadPatchRequest{}, Status: http.StatusNoContent, Session: session.Cookie}, me(video.Tus(http.HandlerFunc(video.PatchUpload))))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/uploads/{upload_id:[0-9a-f]+}", Summary: "Abandon an upload",
+		Request: mAPI.UploadParam{}, Status: http.StatusNoContent, Session: session.Cookie}, me(video.Tus(http.HandlerFunc(video.DeleteUpload))))
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/uploads/{upload_id:[0-9a-f]+}/finalize", Summary: "Store a complete upload with its vibe, finalizing the video records the vibe",
+		Request: mAPI.UploadParam{}, Response: video.VibeResponse{}, Status: http.StatusCreated, Session: session.Cookie}, me(http.HandlerFunc(video.FinalizeUpload)))
+
+	// Vibe drafts, parts upload with their draft_id and the commit records the vibe
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/drafts", Summary: "Start a vibe draft with the parts it is made of",
+		Request: mAPI.DraftRequest{}, Response: video.DraftResponse{}, Status: http.StatusCreated, Session: session.Cookie}, me(http.HandlerFunc(video.CreateDraft)))
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/drafts/{draft_id:[0-9a-f]+}", Summary: "Read which parts of a draft are uploaded",
+		Request: mAPI.DraftParam{}, Response: video.DraftResponse{}, Session: session.Cookie}, me(http.HandlerFunc(video.GetDraft)))
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/v1/drafts/{draft_id:[0-9a-f]+}/commit", Summary: "Record a draft's vibe once every part is uploaded",
+		Request: mAPI.DraftParam{}, Response: video.VibeResponse{}, Status: http.StatusCreated, Session: session.Cookie}, me(http.HandlerFunc(video.CommitDraft)))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/drafts/{draft_id:[0-9a-f]+}", Summary: "Abandon a draft and its uploaded parts",
+		Request: mAPI.DraftParam{}, Status: http.StatusNoContent, Session: session.Cookie}, me(http.HandlerFunc(video.DeleteDraft)))
+
+	// Users
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/users/{user_id}/vibes", Summary: "Read a user's profile and vibes, newest first",
+		Request: mAPI.UserVibesQuery{}, Response: video.UserDataResponse{}, Session: session.Cookie}, viewer(http.HandlerFunc(video.GetUserVibes)))
+
+	// Favorites of the session's user
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/me/favorites", Summary: "Read the latest video of each favorite location",
+		Response: video.VideosResponse{}, Session: session.Cookie}, me(http.HandlerFunc(video.GetFavorites)))
+	spec.Handle(r, openapi.Route{Method: "PUT", Path: "/v1/me/favorites/{location_id}", Summary: "Add a favorite location",
+		Request: mAPI.LocationParam{}, Session: session.Cookie}, me(http.HandlerFunc(video.AddFavorite)))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/me/favorites/{location_id}", Summary: "Remove a favorite location",
+		Request: mAPI.LocationParam{}, Session: session.Cookie}, me(http.HandlerFunc(video.RemoveFavorite)))
+
+	// Profile picture of the session's user
+	spec.Handle(r, openapi.Route{Method: "PUT", Path: "/v1/me/picture", Summary: "Store the request body as the profile picture",
+		Response: video.PictureResponse{}, Session: session.Cookie}, me(http.HandlerFunc(video.PutPicture)))
 }
 
-func main() {
+// Routes from before v1, kept as deprecated aliases until the clients have moved over
+func handleAuthRequests(r *mux.Router, spec *openapi.Spec) {
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/chunk-upload", Summary: "Upload one chunk of a video, 201 until the last one",
+		Request: mAPI.ChunkUploadRequest{}, Deprecated: true, Successor: "/v1/uploads"}, video.ChunkUploadHandler)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/user-pic-upload", Summary: "Upload one chunk of a profile picture",
+		Request: mAPI.UserPicUploadRequest{}, Deprecated: true, Successor: "/v1/me/picture"}, video.UserPicUploadHandler)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/getLocationLatestData", Summary: "Read the latest video at a location",
+		Request: mAPI.LocationDataQuery{}, Response: video.VibecheckLocationData{}, Deprecated: true, Successor: "/v1/locations/{location_id}/vibes"}, video.GetLocationLatestData)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/data/user", Summary: "Read a user's profile and videos",
+		Request: mAPI.UserDataRequest{}, Response: video.UserDataResponse{}, Deprecated: true, Successor: "/v1/users/{user_id}/vibes"}, video.GetDataByUser)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/videos/location", Summary: "Read the videos at a location after the latest",
+		Request: mAPI.LocationDataQuery{}, Response: video.VideosResponse{}, Deprecated: true, Successor: "/v1/locations/{location_id}/vibes"}, video.GetVideosByLocation)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/setFavoriteStatus/{locationName}/{lat}/{lon}/{user_name}/{liked_status}", Summary: "Add or remove a favorite location",
+		Request: mAPI.FavoriteStatusRequest{}, Deprecated: true, Successor: "/v1/me/favorites/{location_id}"}, video.SetFavoriteStatus)
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/getUserFavoriteLocationData/{user_name}", Summary: "Read the latest video of each of a user's favorite locations",
+		Request: mAPI.FavoriteLocationsRequest{}, Response: video.VideosResponse{}, Deprecated: true, Successor: "/v1/me/favorites"}, video.GetUserFavoriteLocationData)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/chat-message-upload", Summary: "Post a chat message to a location thread",
+		Request: mAPI.ChatMessageRequest{}, Deprecated: true, Successor: "/v1/locations/{location_id}/messages"}, video.ChatMessageUpload)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/getLocationChat", Summary: "Read the messages of a location thread",
+		Request: mAPI.LocationChatRequest{}, Response: video.ChatResponse{}, Deprecated: true, Successor: "/v1/locations/{location_id}/messages"}, video.GetLocationChat)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/setVideoLikedStatus", Summary: "Like or unlike a video",
+		Request: mAPI.VideoLikeRequest{}, Deprecated: true, Successor: "/v1/locations/{location_id}/vibes/{vibe_id}/like"}, video.SetVideoLikedStatus)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/setIsVideoDeletedStatus", Summary: "Delete a user's video",
+		Request: mAPI.VideoDeletedRequest{}, Deprecated: true, Successor: "/v1/locations/{location_id}/vibes/{vibe_id}"}, video.SetIsVideoDeletedStatus)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/get-user-latest-data", Summary: "Read a user's profile and latest video",
+		Request: mAPI.UserDataRequest{}, Response: video.UserDataResponse{}, Deprecated: true, Successor: "/v1/users/{user_id}/vibes"}, video.GetUserLatestData)
+}
 
-	// Load environemnt variables
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("error loading .env file, program will terminate.")
-	}
+// Health
+func handleHealthRequests(r *mux.Router) {
+	checks := health.New(config.CONFIGURATION.READINESS_TIMEOUT)
+	checks.Add("mariadb", health.DB(store.DB))
+	checks.Add("redis", health.Redis(store.Cache))
+	checks.Add("storage", health.Storage(store.Media))
+	checks.Add("upload_storage", health.Writable(video.UPLOAD_STORAGE))
+	r.HandleFunc("/healthz", checks.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", checks.Readiness).Methods("GET")
+}
 
-	// Get environment type, prod, dev, or local
-	APP_ENV := os.Getenv("APP_ENV")
+// Metrics
+func handleMetricsRequests(r *mux.Router) {
+	metrics.RegisterDB("vibe_db", store.DB)
+	metrics.RegisterCache("cache", store.Cache)
+	r.Use(metrics.Middleware)
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+}
 
-	// If we get here and APP_ENV is still an empty string, kill program.
-	if APP_ENV == "" {
-		log.Fatal("APP_ENV not found, program will terminate.")
-	}
+func main() {
 
-	// Build log output file
-	os.Remove("log.txt") // remove old log
-	file, err := os.OpenFile("log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	// Load and validate configuration, every missing or invalid key is reported at once
+	err := config.InitConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.SetOutput(file)
 
-	// Trace, Debug, Info, Warn, Error, Fatal, and Panic (oridnal 6 - 0)
-	logLevel, ok := os.LookupEnv("LOG_LEVEL")
+	// Build log output file and set log level
+	logging.Setup(config.CONFIGURATION.LoggingConfig())
+	config.PrintConfig()
+
+	// "migrate up|down [steps]|status" manages the vibe_db schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		// only the migrations asked for run, MIGRATE_ON_STARTUP must not apply every pending one first
+		config.CONFIGURATION.MIGRATE_ON_STARTUP = false
+		store.InitDB()
+		err = migrate.Run(context.Background(), store.DB, os.Args[2:])
+		store.Cleanup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// LOG_LEVEL not set, let's default to info
-	if !ok {
-		logLevel = "info"
+	// "renditions" stores the renditions of pictures uploaded before they had any and exits
+	if len(os.Args) > 1 && os.Args[1] == "renditions" {
+		store.InitDB()
+		store.InitStorage()
+		video.Setup(repository.NewMariaDB(store.DB, config.CONFIGURATION.MARIA_DB_QUERY_TIMEOUT), store.Media)
+		transcode.FFMPEG_PATH = config.CONFIGURATION.FFMPEG_PATH // HEIC pictures are decoded with it
+		err = video.BackfillRenditions(context.Background())
+		video.Cleanup()
+		store.Cleanup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Parse string to log level
-	parsedLevel, err := log.ParseLevel(logLevel)
+	// Spans for handlers, queries and outbound calls, flushed last on shutdown
+	stopTracing, err := tracing.Setup(config.CONFIGURATION.TracingConfig("cdn-api"))
 	if err != nil {
-		parsedLevel = log.DebugLevel
+		log.Fatal(err)
 	}
-	// set global log level
-	log.SetLevel(parsedLevel)
-
-	log.Info("STARTING LOG...")
-	log.Info("APP_ENV: " + APP_ENV)
-	log.Info("LOG_LEVEL: " + logLevel)
 
 	// Requests
 	r := mux.NewRouter()
-	handleAuthRequests(r)
+	r.Use(tracing.Middleware("cdn-api"), middleware.RequestID, middleware.AccessLog, middleware.Recover)
+	r.NotFoundHandler = http.HandlerFunc(api.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(api.MethodNotAllowed)
+	spec := openapi.New("cdn-api", "1.0.0")
+	handleV1Requests(r, spec)
+	handleAuthRequests(r, spec)
+	r.Handle("/openapi.json", spec).Methods("GET")
 
 	// Initialize DB Connection
 	store.InitDB()
 
+	// Media files go to local storage or an S3 bucket
+	store.InitStorage()
+
+	// Derive storage locations and hand the handlers their repositories and storage
+	repos := repository.NewMariaDB(store.DB, config.CONFIGURATION.MARIA_DB_QUERY_TIMEOUT)
+	video.Setup(repos, store.Media)
+
+	// Workers turning uploaded videos into HLS
+	transcode.Setup(repos, store.Media)
+
+	// Renditions of the pictures stored before they had any, HEIC ones need FFMPEG_PATH from transcode.Setup
+	if config.CONFIGURATION.RENDITIONS_ON_STARTUP {
+		video.StartBackfill()
+	}
+
 	// Initialize Cache Connection
 	store.InitCache()
 
-	APP_PORT := os.Getenv("APP_PORT")
-	log.Info("listening and serving on HTTP port" + APP_PORT)
+	// Liveness and readiness
+	handleHealthRequests(r)
+
+	// Prometheus metrics
+	handleMetricsRequests(r)
 
 	// Listen and serve
-	// need to implement certs for security
-	if APP_ENV == "prod" {
-		log.Fatal(http.ListenAndServe(APP_PORT, r))
-		// log.Fatal(http.ListenAndServeTLS(":8082", "/certs/fullchain.pem", "/certs/key.pem", r))
-	} else {
-		log.Fatal(http.ListenAndServe(APP_PORT, r))
+	err = server.Run(config.CONFIGURATION.ServerConfig(config.CONFIGURATION.APP_PORT), r, Cleanup, stopTracing)
+	if err != nil {
+		log.Fatal("Server exited with error: ", err)
 	}
 
 }
+
+// Performs cleanup of service to make sure no leaks of resources
+func Cleanup() {
+	transcode.Cleanup() // The workers and the upload sweep use the DB, stop them first
+	video.Cleanup()
+	store.Cleanup()
+}
@@ -0,0 +1,201 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Type is the content type a file was sniffed as
+type Type string
+
+const (
+	MP4  Type = "video/mp4"
+	MOV  Type = "video/quicktime"
+	JPEG Type = "image/jpeg"
+	PNG  Type = "image/png"
+	HEIC Type = "image/heic"
+)
+
+// SniffLen is how much of the start of a file Sniff needs to tell every type apart
+const SniffLen = 64
+
+// Info is what probing a file found out about it
+type Info struct {
+	Type     Type
+	Width    int // of the stored frames, before Rotation
+	Height   int
+	Size     int64         // bytes
+	Duration time.Duration // zero for images
+	Audio    bool          // a video has a sound track
+	Codec    string        // of a video's picture, e.g. "h264" or "hevc"
+	Rotation int           // degrees clockwise a player turns a video's frames, 0, 90, 180 or 270
+	Bitrate  int64         // bits per second of a video over its whole file
+}
+
+// Display is the size a video is shown at, its frames turned by Rotation
+func (i Info) Display() (int, int) {
+	if i.Rotation == 90 || i.Rotation == 270 {
+		return i.Height, i.Width
+	}
+	return i.Width, i.Height
+}
+
+// Limits are what a file of one role may be, zero MaxDuration or MaxDimension do not limit
+type Limits struct {
+	Types        []Type
+	MaxSize      int64
+	MaxDuration  time.Duration
+	MaxDimension int // longest side in pixels, so portrait and landscape share the limit
+}
+
+// Allows reports whether files of type t may be kept
+func (l Limits) Allows(t Type) bool {
+	for _, allowed := range l.Types {
+		if t == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Reason says which rule a rejected file broke
+type Reason int
+
+const (
+	BadType   Reason = iota // not a type the role allows, or not readable as the type it claims
+	TooLarge                // more bytes than MaxSize
+	OverLimit               // longer than MaxDuration or bigger than MaxDimension
+)
+
+// Rejection is the error Validate returns for files that must not be kept
+type Rejection struct {
+	Reason  Reason
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+func rejectf(reason Reason, format string, args ...interface{}) *Rejection {
+	return &Rejection{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// ISO base media brands, the major brand or the first known compatible one decides the type
+var brands = map[string]Type{
+	"isom": MP4, "iso2": MP4, "iso4": MP4, "iso5": MP4, "iso6": MP4,
+	"mp41": MP4, "mp42": MP4, "avc1": MP4, "M4V ": MP4, "MSNV": MP4,
+	"qt  ": MOV,
+	"heic": HEIC, "heix": HEIC, "heim": HEIC, "heis": HEIC,
+}
+
+// Sniff tells the type of a file from its first bytes, at most SniffLen are looked at, "" when unknown
+func Sniff(head []byte) Type {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		if t, ok := brands[string(head[8:12])]; ok {
+			return t
+		}
+		// mif1 and similar generic majors name the real format among the compatible brands
+		end := int(uint32(head[0])<<24 | uint32(head[1])<<16 | uint32(head[2])<<8 | uint32(head[3]))
+		if end > len(head) {
+			end = len(head)
+		}
+		for i := 16; i+4 <= end; i += 4 {
+			if t, ok := brands[string(head[i:i+4])]; ok {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// Validate sniffs and probes the file at path, returning a *Rejection when it breaks the limits
+func Validate(path string, l Limits) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+	if stat.Size() > l.MaxSize {
+		return Info{}, rejectf(TooLarge, "file has %d bytes, at most %d are allowed", stat.Size(), l.MaxSize)
+	}
+
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Info{}, err
+	}
+	t := Sniff(head[:n])
+	if !l.Allows(t) {
+		return Info{}, rejectf(BadType, "file must be %s, got %s", typeList(l.Types), describe(t))
+	}
+
+	info, err := probe(f, stat.Size(), t)
+	if err != nil {
+		return Info{}, rejectf(BadType, "file is not a readable %s: %v", t, err)
+	}
+	if l.MaxDuration > 0 && info.Duration > l.MaxDuration {
+		return info, rejectf(OverLimit, "file runs %s, at most %s is allowed", info.Duration.Round(time.Millisecond), l.MaxDuration)
+	}
+	if side := longest(info); l.MaxDimension > 0 && side > l.MaxDimension {
+		return info, rejectf(OverLimit, "file is %dx%d, its longest side may be at most %d", info.Width, info.Height, l.MaxDimension)
+	}
+	return info, nil
+}
+
+// Probe says what the file at path is without checking it against any limits
+func Probe(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Info{}, err
+	}
+	return probe(f, stat.Size(), Sniff(head[:n]))
+}
+
+func longest(info Info) int {
+	if info.Width > info.Height {
+		return info.Width
+	}
+	return info.Height
+}
+
+func typeList(types []Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func describe(t Type) string {
+	if t == "" {
+		return "an unrecognized format"
+	}
+	return string(t)
+}
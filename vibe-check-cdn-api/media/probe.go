@@ -0,0 +1,297 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // registers the decoder DecodeConfig uses
+	_ "image/png"
+	"io"
+	"strings"
+	"time"
+)
+
+// probe reads the dimensions, and for videos the duration and encoding, of a file sniffed as t
+func probe(r io.ReaderAt, size int64, t Type) (Info, error) {
+	var info Info
+	var err error
+	switch t {
+	case JPEG, PNG:
+		var config image.Config
+		config, _, err = image.DecodeConfig(io.NewSectionReader(r, 0, size))
+		info = Info{Type: t, Width: config.Width, Height: config.Height}
+	case MP4, MOV:
+		info, err = probeMovie(r, size, t)
+	case HEIC:
+		info, err = probeHEIF(r, size, t)
+	default:
+		err = fmt.Errorf("cannot probe %q", t)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	info.Size = size
+	return info, nil
+}
+
+// codecs names the sample entries of common video codecs, others keep their four letters
+var codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264",
+	"hvc1": "hevc", "hev1": "hevc",
+	"av01": "av1", "vp09": "vp9", "mp4v": "mpeg4",
+	"apch": "prores", "apcn": "prores", "apcs": "prores", "apco": "prores", "ap4h": "prores",
+}
+
+// box is an ISO base media (MP4, QuickTime, HEIF) box, start and end bound its payload
+type box struct {
+	typ   string
+	start int64
+	end   int64
+}
+
+// walk calls fn for each box between start and end, stopping at the first error
+func walk(r io.ReaderAt, start, end int64, fn func(b box) error) error {
+	for off := start; off < end; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], off); err != nil {
+			return errors.New("truncated box header")
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		payload := off + 8
+		switch size {
+		case 0: // runs to the end of its parent
+			size = end - off
+		case 1: // a 64 bit size follows the type
+			if _, err := r.ReadAt(header[8:16], off+8); err != nil {
+				return errors.New("truncated box header")
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			payload += 8
+		}
+		if size < payload-off || off+size > end {
+			return fmt.Errorf("box %q runs past its parent", header[4:8])
+		}
+		if err := fn(box{typ: string(header[4:8]), start: payload, end: off + size}); err != nil {
+			return err
+		}
+		off += size
+	}
+	return nil
+}
+
+// children calls fn for each box inside b, skipping the version and flags of full boxes
+func children(r io.ReaderAt, b box, full bool, fn func(b box) error) error {
+	start := b.start
+	if full {
+		start += 4
+	}
+	return walk(r, start, b.end, fn)
+}
+
+// read returns n bytes of a box's payload from offset off
+func read(r io.ReaderAt, b box, off int64, n int) ([]byte, error) {
+	if b.start+off+int64(n) > b.end {
+		return nil, fmt.Errorf("box %q is too short", b.typ)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, b.start+off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// track is what probeMovie reads of one trak box
+type track struct {
+	width    int
+	height   int
+	rotation int
+	sound    bool
+	codec    string
+}
+
+// probeMovie reads the duration from moov/mvhd, the picture size, rotation and codec from the
+// largest track and whether a track's handler is sound
+func probeMovie(r io.ReaderAt, size int64, t Type) (Info, error) {
+	info := Info{Type: t}
+	foundMovie := false
+	err := walk(r, 0, size, func(top box) error {
+		if top.typ != "moov" {
+			return nil
+		}
+		foundMovie = true
+		return children(r, top, false, func(b box) error {
+			switch b.typ {
+			case "mvhd":
+				d, err := movieDuration(r, b)
+				info.Duration = d
+				return err
+			case "trak":
+				var tr track
+				err := children(r, b, false, func(tb box) error {
+					var err error
+					switch tb.typ {
+					case "tkhd":
+						tr.width, tr.height, tr.rotation, err = trackHeader(r, tb)
+					case "mdia":
+						tr.sound, tr.codec, err = trackMedia(r, tb)
+					}
+					return err
+				})
+				info.Audio = info.Audio || tr.sound
+				if tr.width*tr.height > info.Width*info.Height {
+					info.Width, info.Height, info.Rotation, info.Codec = tr.width, tr.height, tr.rotation, tr.codec
+				}
+				return err
+			}
+			return nil
+		})
+	})
+	switch {
+	case err != nil:
+		return Info{}, err
+	case !foundMovie:
+		return Info{}, errors.New("no moov box, the movie is incomplete")
+	case info.Width == 0 || info.Height == 0:
+		return Info{}, errors.New("no video track")
+	}
+	if info.Duration > 0 {
+		info.Bitrate = int64(float64(size*8) / info.Duration.Seconds())
+	}
+	return info, nil
+}
+
+// movieDuration reads an mvhd box, whose layout depends on its version
+func movieDuration(r io.ReaderAt, b box) (time.Duration, error) {
+	version, err := read(r, b, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	var timescale, duration uint64
+	if version[0] == 1 {
+		buf, err := read(r, b, 20, 12) // after version, flags, 64 bit creation and modification times
+		if err != nil {
+			return 0, err
+		}
+		timescale, duration = uint64(binary.BigEndian.Uint32(buf[:4])), binary.BigEndian.Uint64(buf[4:])
+	} else {
+		buf, err := read(r, b, 12, 8)
+		if err != nil {
+			return 0, err
+		}
+		timescale, duration = uint64(binary.BigEndian.Uint32(buf[:4])), uint64(binary.BigEndian.Uint32(buf[4:]))
+	}
+	if timescale == 0 {
+		return 0, errors.New("mvhd has no timescale")
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+}
+
+// trackHeader reads the 16.16 fixed point width and height ending a tkhd box, zero for sound
+// tracks, and the quarter turn of the transformation matrix before them
+func trackHeader(r io.ReaderAt, b box) (int, int, int, error) {
+	version, err := read(r, b, 0, 1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	off := int64(40)
+	if version[0] == 1 {
+		off = 52 // creation, modification and duration are 64 bit
+	}
+	// the matrix is 9 values, the width and height follow it
+	buf, err := read(r, b, off, 44)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// the first two values are the cosine and sine of the rotation
+	cos, sin := int32(binary.BigEndian.Uint32(buf[:4])), int32(binary.BigEndian.Uint32(buf[4:8]))
+	rotation := 0
+	switch {
+	case cos < 0 && sin == 0:
+		rotation = 180
+	case cos == 0 && sin > 0:
+		rotation = 90
+	case cos == 0 && sin < 0:
+		rotation = 270
+	}
+	return int(binary.BigEndian.Uint32(buf[36:40]) >> 16), int(binary.BigEndian.Uint32(buf[40:]) >> 16), rotation, nil
+}
+
+// trackMedia reads whether the handler type of a track's mdia/hdlr is sound, after version,
+// flags and pre_defined, and the codec of its first sample entry in minf/stbl/stsd
+func trackMedia(r io.ReaderAt, mdia box) (bool, string, error) {
+	sound, codec := false, ""
+	err := children(r, mdia, false, func(b box) error {
+		switch b.typ {
+		case "hdlr":
+			handler, err := read(r, b, 8, 4)
+			sound = err == nil && string(handler) == "soun"
+			return err
+		case "minf":
+			return children(r, b, false, func(b box) error {
+				if b.typ != "stbl" {
+					return nil
+				}
+				return children(r, b, false, func(b box) error {
+					if b.typ != "stsd" || codec != "" {
+						return nil
+					}
+					// entries follow version, flags and the entry count
+					return walk(r, b.start+8, b.end, func(entry box) error {
+						if codec == "" {
+							codec = entry.typ
+							if name, ok := codecs[codec]; ok {
+								codec = name
+							}
+						}
+						return nil
+					})
+				})
+			})
+		}
+		return nil
+	})
+	return sound, strings.TrimSpace(codec), err
+}
+
+// probeHEIF reads the picture size from the largest ispe property in meta/iprp/ipco, the
+// smaller ones describe thumbnails and grid tiles
+func probeHEIF(r io.ReaderAt, size int64, t Type) (Info, error) {
+	info := Info{Type: t}
+	err := walk(r, 0, size, func(top box) error {
+		if top.typ != "meta" {
+			return nil
+		}
+		return children(r, top, true, func(b box) error {
+			if b.typ != "iprp" {
+				return nil
+			}
+			return children(r, b, false, func(b box) error {
+				if b.typ != "ipco" {
+					return nil
+				}
+				return children(r, b, false, func(b box) error {
+					if b.typ != "ispe" {
+						return nil
+					}
+					buf, err := read(r, b, 4, 8)
+					if err != nil {
+						return err
+					}
+					w, h := int(binary.BigEndian.Uint32(buf[:4])), int(binary.BigEndian.Uint32(buf[4:]))
+					if w*h > info.Width*info.Height {
+						info.Width, info.Height = w, h
+					}
+					return nil
+				})
+			})
+		})
+	})
+	if err != nil {
+		return Info{}, err
+	}
+	if info.Width == 0 || info.Height == 0 {
+		return Info{}, errors.New("no image size property")
+	}
+	return info, nil
+}
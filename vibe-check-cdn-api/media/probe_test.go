@@ -0,0 +1,219 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// mkbox builds an ISO base media box around the concatenated parts
+func mkbox(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	return append(append(u32(uint32(8+len(payload))), typ...), payload...)
+}
+
+// fullbox builds a box whose payload starts with version 0 and no flags
+func fullbox(typ string, parts ...[]byte) []byte {
+	return mkbox(typ, append([][]byte{make([]byte, 4)}, parts...)...)
+}
+
+const (
+	one      = 0x00010000 // 1.0 in 16.16 fixed point
+	minusOne = 0xFFFF0000
+)
+
+// trak builds a track of the given size, matrix cosine and sine, handler and sample entry
+func trak(width, height int, cos, sin uint32, handler, entry string) []byte {
+	tkhd := make([]byte, 84)
+	copy(tkhd[40:], u32(cos))
+	copy(tkhd[44:], u32(sin))
+	copy(tkhd[76:], u32(uint32(width)<<16))
+	copy(tkhd[80:], u32(uint32(height)<<16))
+	stsd := fullbox("stsd", u32(1), mkbox(entry, make([]byte, 78)))
+	return mkbox("trak",
+		mkbox("tkhd", tkhd),
+		mkbox("mdia",
+			fullbox("hdlr", make([]byte, 4), []byte(handler), make([]byte, 12)),
+			mkbox("minf", mkbox("stbl", stsd))))
+}
+
+// moov builds a movie box whose header says it lasts seconds
+func moov(seconds float64, tracks ...[]byte) []byte {
+	mvhd := fullbox("mvhd", u32(0), u32(0), u32(1000), u32(uint32(seconds*1000)), make([]byte, 80))
+	return mkbox("moov", append([][]byte{mvhd}, tracks...)...)
+}
+
+// movie builds an MP4 lasting seconds with the tracks
+func movie(seconds float64, tracks ...[]byte) []byte {
+	return bytes.Join([][]byte{
+		mkbox("ftyp", []byte("isom"), u32(0x200), []byte("isomavc1")),
+		moov(seconds, tracks...),
+		mkbox("mdat", make([]byte, 1000)),
+	}, nil)
+}
+
+// heif builds a HEIF picture with an ispe property per size
+func heif(sizes ...[2]uint32) []byte {
+	var ispe [][]byte
+	for _, s := range sizes {
+		ispe = append(ispe, fullbox("ispe", u32(s[0]), u32(s[1])))
+	}
+	return bytes.Join([][]byte{
+		mkbox("ftyp", []byte("mif1"), u32(0), []byte("mif1heic")),
+		fullbox("meta", mkbox("iprp", mkbox("ipco", ispe...))),
+	}, nil)
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want Type
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1}, JPEG},
+		{"png", []byte("\x89PNG\r\n\x1a\n...."), PNG},
+		{"mp4", movie(1)[:SniffLen], MP4},
+		{"quicktime", mkbox("ftyp", []byte("qt  "), u32(0)), MOV},
+		{"heic by compatible brand", heif()[:24], HEIC},
+		{"unknown brand", mkbox("ftyp", []byte("mif1"), u32(0), []byte("mif1avif")), ""},
+		{"gif", []byte("GIF89a"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := Sniff(tt.head); got != tt.want {
+			t.Errorf("Sniff(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProbeMovie(t *testing.T) {
+	sound := trak(0, 0, one, 0, "soun", "mp4a")
+	tests := []struct {
+		name     string
+		file     []byte
+		want     Info
+		displayW int
+	}{
+		{"landscape with sound", movie(12.5, trak(1920, 1080, one, 0, "vide", "avc1"), sound),
+			Info{Width: 1920, Height: 1080, Duration: 12500 * time.Millisecond, Audio: true, Codec: "h264"}, 1920},
+		{"turned a quarter", movie(2, trak(1920, 1080, 0, one, "vide", "hvc1")),
+			Info{Width: 1920, Height: 1080, Duration: 2 * time.Second, Codec: "hevc", Rotation: 90}, 1080},
+		{"upside down", movie(2, trak(640, 480, minusOne, 0, "vide", "av01")),
+			Info{Width: 640, Height: 480, Duration: 2 * time.Second, Codec: "av1", Rotation: 180}, 640},
+		{"turned back a quarter", movie(2, trak(640, 480, 0, minusOne, "vide", "xyz ")),
+			Info{Width: 640, Height: 480, Duration: 2 * time.Second, Codec: "xyz", Rotation: 270}, 480},
+		{"largest track wins", movie(2, trak(320, 180, one, 0, "vide", "mp4v"), trak(1280, 720, one, 0, "vide", "avc1")),
+			Info{Width: 1280, Height: 720, Duration: 2 * time.Second, Codec: "h264"}, 1280},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probe(bytes.NewReader(tt.file), int64(len(tt.file)), MP4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Type, tt.want.Size = MP4, int64(len(tt.file))
+			tt.want.Bitrate = int64(float64(len(tt.file)*8) / tt.want.Duration.Seconds())
+			if got != tt.want {
+				t.Errorf("probe = %+v, want %+v", got, tt.want)
+			}
+			if w, _ := got.Display(); w != tt.displayW {
+				t.Errorf("displayed width = %d, want %d", w, tt.displayW)
+			}
+		})
+	}
+}
+
+func TestProbeRejects(t *testing.T) {
+	ftyp := mkbox("ftyp", []byte("isom"), u32(0))
+	overrun := append(ftyp, u32(64)...)
+	overrun = append(overrun, "moov"...)
+	tests := []struct {
+		name string
+		file []byte
+		t    Type
+	}{
+		{"movie without moov", append(ftyp, mkbox("mdat", make([]byte, 16))...), MP4},
+		{"movie with only sound", movie(1, trak(0, 0, one, 0, "soun", "mp4a")), MP4},
+		{"box past the end", overrun, MP4},
+		{"short mvhd", append(ftyp, mkbox("moov", fullbox("mvhd", u32(0)))...), MP4},
+		{"heif without ispe", heif(), HEIC},
+		{"unknown type", []byte("GIF89a"), ""},
+		{"corrupt png", []byte("\x89PNG\r\n\x1a\n"), PNG},
+	}
+	for _, tt := range tests {
+		if info, err := probe(bytes.NewReader(tt.file), int64(len(tt.file)), tt.t); err == nil {
+			t.Errorf("probe(%s) = %+v, want an error", tt.name, info)
+		}
+	}
+}
+
+func TestProbeLargeBox(t *testing.T) {
+	// a size of 1 puts a 64 bit size after the type
+	file := bytes.Join([][]byte{u32(1), []byte("mdat"), u32(0), u32(16 + 32), make([]byte, 32),
+		moov(3, trak(720, 1280, one, 0, "vide", "avc1"))}, nil)
+	info, err := probe(bytes.NewReader(file), int64(len(file)), MP4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 720 || info.Height != 1280 || info.Duration != 3*time.Second {
+		t.Errorf("probe = %+v", info)
+	}
+}
+
+func TestProbeHEIF(t *testing.T) {
+	file := heif([2]uint32{512, 512}, [2]uint32{4032, 3024}, [2]uint32{320, 240})
+	info, err := probe(bytes.NewReader(file), int64(len(file)), HEIC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Info{Type: HEIC, Width: 4032, Height: 3024, Size: int64(len(file))}); info != want {
+		t.Errorf("probe = %+v, want %+v", info, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "video")
+	if err := ioutil.WriteFile(video, movie(10, trak(1080, 1920, one, 0, "vide", "avc1")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	limits := Limits{Types: []Type{MP4, MOV}, MaxSize: 1 << 20, MaxDuration: time.Minute, MaxDimension: 1920}
+	tests := []struct {
+		name   string
+		limits func(l *Limits)
+		reason Reason
+		ok     bool
+	}{
+		{"within the limits", func(l *Limits) {}, 0, true},
+		{"type not allowed", func(l *Limits) { l.Types = []Type{JPEG, PNG} }, BadType, false},
+		{"too many bytes", func(l *Limits) { l.MaxSize = 100 }, TooLarge, false},
+		{"too long", func(l *Limits) { l.MaxDuration = 5 * time.Second }, OverLimit, false},
+		{"too big", func(l *Limits) { l.MaxDimension = 1280 }, OverLimit, false},
+		{"no duration or dimension limit", func(l *Limits) { l.MaxDuration, l.MaxDimension = 0, 0 }, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := limits
+			tt.limits(&l)
+			_, err := Validate(video, l)
+			if tt.ok {
+				if err != nil {
+					t.Errorf("Validate = %v", err)
+				}
+				return
+			}
+			if r, ok := err.(*Rejection); !ok || r.Reason != tt.reason {
+				t.Errorf("Validate = %v, want a rejection for reason %d", err, tt.reason)
+			}
+		})
+	}
+}
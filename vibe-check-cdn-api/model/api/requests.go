@@ -0,0 +1,147 @@
+package model
+
+import (
+	"mime/multipart"
+	"time"
+)
+
+// Request bodies and parameters, validated by the openapi middleware before the handler runs
+
+type ChunkUploadRequest struct {
+	File         *multipart.FileHeader `form:"file" required:"true" doc:"the chunk"`
+	ContentRange string                `header:"Content-Range" required:"true" doc:"bytes start-end/size of the chunk"`
+	FileName     string                `header:"x-file-name" required:"true" doc:"role of the file, video, thumbnail or selfie, or the name that role is stored under"`
+	LocationName string                `form:"locationName"`
+	Lat          float64               `form:"lat" required:"true" min:"-90" max:"90"`
+	Lon          float64               `form:"lon" required:"true" min:"-180" max:"180"`
+	TimeStamp    time.Time             `form:"time_stamp" required:"true" doc:"when the video was recorded"`
+	UserId       string                `form:"user_id" required:"true" doc:"user posting the video"`
+}
+
+type UserPicUploadRequest struct {
+	File         *multipart.FileHeader `form:"file" required:"true" doc:"the chunk"`
+	ContentRange string                `header:"Content-Range" required:"true" doc:"bytes start-end/size of the chunk"`
+	UserId       string                `form:"user_id" required:"true"`
+}
+
+type ChatMessageRequest struct {
+	LocationName string    `form:"location_name"`
+	Lat          float64   `form:"lat" required:"true" min:"-90" max:"90"`
+	Lon          float64   `form:"lon" required:"true" min:"-180" max:"180"`
+	ThreadName   string    `form:"thread_name"`
+	Text         string    `form:"text" required:"true"`
+	UserId       string    `form:"user_id" required:"true"`
+	CreatedAt    time.Time `form:"createdAt" required:"true"`
+	Id           string    `form:"_id" required:"true" doc:"message ID chosen by the client"`
+}
+
+type LocationChatRequest struct {
+	LocationName string  `form:"location_name"`
+	Lat          float64 `form:"lat" required:"true" min:"-90" max:"90"`
+	Lon          float64 `form:"lon" required:"true" min:"-180" max:"180"`
+	ThreadName   string  `form:"thread_name"`
+}
+
+type LocationDataQuery struct {
+	Location string `json:"location"`
+	Lat      string `json:"lat" required:"true" doc:"latitude as a decimal string"`
+	Lon      string `json:"lon" required:"true" doc:"longitude as a decimal string"`
+	UserId   string `json:"userID" doc:"requesting user, for like status"`
+}
+
+type VideoLikeRequest struct {
+	VideoFolder  string `json:"videoFolder" required:"true"`
+	LocationHash string `json:"locationHash" required:"true"`
+	UserId       string `json:"userID" required:"true"`
+	LikedStatus  bool   `json:"likedStatus" doc:"true likes the video, false removes the like"`
+}
+
+type UserDataRequest struct {
+	UserId          string `json:"user_id" required:"true" doc:"requesting user"`
+	UserIdFollowing string `json:"user_id_following" doc:"user whose data is read, the requesting user when empty"`
+}
+
+type FavoriteStatusRequest struct {
+	LocationName string  `path:"locationName"`
+	Lat          float64 `path:"lat" min:"-90" max:"90"`
+	Lon          float64 `path:"lon" min:"-180" max:"180"`
+	UserId       string  `path:"user_name" doc:"ID of the user, the path keeps its old name"`
+	LikedStatus  bool    `path:"liked_status" doc:"true adds the favorite, false removes it"`
+}
+
+type FavoriteLocationsRequest struct {
+	UserId string `path:"user_name" doc:"ID of the user, the path keeps its old name"`
+}
+
+type VideoDeletedRequest struct {
+	UserId        string `form:"user_id" required:"true"`
+	TimeStamp     string `form:"time_stamp" required:"true" doc:"time_stamp of the video as the feeds return it"`
+	DeletedStatus bool   `form:"deleted_status"`
+}
+
+// Parameters of the v1 resource routes, the session names the requesting user
+
+type UploadCreateRequest struct {
+	Length   int64  `header:"Upload-Length" required:"true" min:"0" doc:"size of the whole file in bytes"`
+	Metadata string `header:"Upload-Metadata" required:"true" doc:"tus metadata, comma-separated keys with base64 values: role (video, thumbnail or selfie), lat, lon, time_stamp (RFC 3339) and optionally location_name and sha256, the hex digest of the whole file. With draft_id the file is a part of that draft, which supplies lat, lon, time_stamp and location_name"`
+}
+
+type UploadParam struct {
+	UploadId string `path:"upload_id"`
+}
+
+type UploadPatchRequest struct {
+	UploadId string `path:"upload_id"`
+	Offset   int64  `header:"Upload-Offset" required:"true" min:"0" doc:"where the chunk starts, the Upload-Offset HEAD answers"`
+	Checksum string `header:"Upload-Checksum" doc:"algorithm and base64 digest of the chunk, sha1 or sha256"`
+}
+
+type DraftRequest struct {
+	LocationName string    `json:"location_name" doc:"place name the location is shown with"`
+	Lat          float64   `json:"lat" required:"true" min:"-90" max:"90"`
+	Lon          float64   `json:"lon" required:"true" min:"-180" max:"180"`
+	TimeStamp    time.Time `json:"time_stamp" required:"true" doc:"when the vibe was recorded"`
+	Parts        []string  `json:"parts" required:"true" doc:"roles the vibe is made of, video and optionally thumbnail and selfie"`
+}
+
+type DraftParam struct {
+	DraftId string `path:"draft_id"`
+}
+
+type LocationRequest struct {
+	Name string  `json:"name" doc:"place name the location is shown with"`
+	Lat  float64 `json:"lat" required:"true" min:"-90" max:"90"`
+	Lon  float64 `json:"lon" required:"true" min:"-180" max:"180"`
+}
+
+type LocationParam struct {
+	LocationId string `path:"location_id"`
+}
+
+type LocationVibesQuery struct {
+	LocationId string `path:"location_id"`
+	Limit      int    `query:"limit" min:"0" doc:"newest vibes to return, all of them when 0"`
+}
+
+type VibeParam struct {
+	LocationId string `path:"location_id"`
+	VibeId     string `path:"vibe_id" doc:"folder the vibe is stored in"`
+}
+
+type UserVibesQuery struct {
+	UserId string `path:"user_id"`
+	Limit  int    `query:"limit" min:"0" doc:"newest vibes to return, all of them when 0"`
+}
+
+type LocationMessagesQuery struct {
+	LocationId string `path:"location_id"`
+	Thread     string `query:"thread"`
+}
+
+type LocationMessageRequest struct {
+	LocationId string    `path:"location_id"`
+	Thread     string    `json:"thread"`
+	Text       string    `json:"text" required:"true"`
+	CreatedAt  time.Time `json:"created_at" required:"true"`
+	Id         string    `json:"id" required:"true" doc:"message ID chosen by the client"`
+}
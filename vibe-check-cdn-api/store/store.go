@@ -1,57 +1,73 @@
 package store
 
 import (
+	"context"
 	"database/sql"
-	_ "encoding/json"
-	"os"
-	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"vibe/config"
+
+	"vibe-common/migrate"
+	"vibe-common/storage"
+	vcstore "vibe-common/store"
+
 	"github.com/gomodule/redigo/redis"
+	log "github.com/sirupsen/logrus"
 )
 
 var DB *sql.DB
 var Cache *redis.Pool
+var Media storage.Storage
 
 // Initialize DB
 func InitDB() {
-	APP_ENV := os.Getenv("APP_ENV")
-	DB_HOST := "127.0.0.1"
-	DB_USER := "root"
-	DB_PASS := "build"
-	if APP_ENV == "prod" {
-		DB_HOST = os.Getenv("MARIA_DB_HOST")
-		DB_PASS = os.Getenv("MARIA_DB_PASSWORD")
-	}
-	db, err := sql.Open("mysql", DB_USER+":"+DB_PASS+"@tcp("+DB_HOST+":3306)/vibe_db?parseTime=true")
+	db, err := vcstore.InitDB(config.CONFIGURATION.DBConfig())
 	if err != nil {
-		panic(err.Error())
+		log.Fatal("Unable to create connection to DB:", err)
 	}
 	DB = db
+
+	// Fail fast on bad credentials or an unreachable server, retrying while it starts up
+	err = vcstore.WaitForDB(DB, config.CONFIGURATION.STARTUP_RETRIES, config.CONFIGURATION.STARTUP_RETRY_BACKOFF)
+	if err != nil {
+		log.Fatal("Unable to reach DB: ", err)
+	}
+
+	// Bring the schema up to date, services starting together take turns on a DB lock
+	if config.CONFIGURATION.MIGRATE_ON_STARTUP {
+		if err := migrate.Up(context.Background(), DB); err != nil {
+			log.Fatal("Unable to migrate DB: ", err)
+		}
+	}
 }
 
 // Initialize Cache
 func InitCache() {
-	APP_ENV := os.Getenv("APP_ENV")
-	REDIS_HOST := "127.0.0.1"
-	if APP_ENV == "prod" {
-		REDIS_HOST = os.Getenv("REDIS_HOST")
+	Cache = vcstore.InitCache(config.CONFIGURATION.CacheConfig())
+	err := vcstore.WaitForCache(Cache, config.CONFIGURATION.STARTUP_RETRIES, config.CONFIGURATION.STARTUP_RETRY_BACKOFF)
+	if err != nil {
+		log.Fatal("Unable to reach Cache: ", err)
 	}
-	Cache = &redis.Pool{
-		MaxIdle:     100,
-		IdleTimeout: 240 * time.Second,
-		//MaxActive:   200,
-		//Wait:        true,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", REDIS_HOST+":6379")
-			if err != nil {
-				return nil, err
-			}
-			return c, err
-		},
+}
+
+// Initialize the media storage, local below DESTINATION or an S3 bucket
+func InitStorage() {
+	s, err := storage.New(config.CONFIGURATION.StorageConfig(config.CONFIGURATION.DESTINATION))
+	if err != nil {
+		log.Fatal("Unable to set up storage: ", err)
 	}
+	Media = s
+}
+
+// Closes the DB and Cache pools, call once the server has drained
+func Cleanup() {
+	vcstore.Close(DB, Cache)
 }
 
 func ToString(reply interface{}, err error) (string, error) {
-	return redis.String(reply, err)
+	return vcstore.ToString(reply, err)
+}
+
+// CacheConn returns a traced Redis connection bounded by ctx and REDIS_COMMAND_TIMEOUT, close it when done
+func CacheConn(ctx context.Context) redis.Conn {
+	return vcstore.CacheConn(ctx, Cache, config.CONFIGURATION.REDIS_COMMAND_TIMEOUT)
 }
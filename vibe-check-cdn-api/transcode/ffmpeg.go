@@ -0,0 +1,163 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"vibe/media"
+)
+
+// rendition is one rung of the HLS ladder, sized by the shorter side so portrait and
+// landscape videos get the same quality
+type rendition struct {
+	name  string
+	short int // pixels of the shorter side
+	kbps  int // video bitrate
+}
+
+// ladder is every rendition from the top, a video gets those no larger than itself
+var ladder = []rendition{
+	{"1080p", 1080, 5000},
+	{"720p", 720, 2800},
+	{"480p", 480, 1400},
+	{"360p", 360, 800},
+}
+
+// Segments are cut on keyframes forced every segmentSeconds, whatever the frame rate
+const segmentSeconds = 4
+
+// Kilobits per second of the AAC audio of every rendition
+const audioKbps = 128
+
+// Pixels of the shorter side of the generated thumbnail
+const thumbnailShort = 320
+
+// renditionsFor picks the rungs no larger than the video, one too small for any keeps its own size
+func renditionsFor(info media.Info) []rendition {
+	short := info.Width
+	if info.Height < short {
+		short = info.Height
+	}
+	var picked []rendition
+	for _, r := range ladder {
+		if r.short <= short {
+			picked = append(picked, r)
+		}
+	}
+	if len(picked) == 0 {
+		smallest := ladder[len(ladder)-1]
+		picked = append(picked, rendition{name: fmt.Sprintf("%dp", short&^1), short: short &^ 1, kbps: smallest.kbps})
+	}
+	return picked
+}
+
+// scale fits the shorter side to short pixels after ffmpeg applied the rotation, the longer
+// side keeps the aspect ratio at an even size
+func scale(short int) string {
+	return fmt.Sprintf("scale=w='if(gt(iw,ih),-2,%d)':h='if(gt(iw,ih),%d,-2)'", short, short)
+}
+
+// hlsArgs encodes every rendition in one pass into out/<name>/index.m3u8 and its segments,
+// with out/master.m3u8 listing them
+func hlsArgs(source string, out string, rs []rendition, audio bool) []string {
+	args := []string{"-hide_banner", "-nostdin", "-loglevel", "error", "-y", "-i", source}
+
+	filter := fmt.Sprintf("[0:v]split=%d", len(rs))
+	for i := range rs {
+		filter += fmt.Sprintf("[s%d]", i)
+	}
+	for i, r := range rs {
+		filter += fmt.Sprintf(";[s%d]%s[v%d]", i, scale(r.short), i)
+	}
+	args = append(args, "-filter_complex", filter)
+
+	var streams []string
+	for i, r := range rs {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i),
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.kbps),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.kbps*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.kbps*3/2))
+		stream := fmt.Sprintf("v:%d", i)
+		if audio {
+			args = append(args, "-map", "0:a:0")
+			stream += fmt.Sprintf(",a:%d", i)
+		}
+		streams = append(streams, stream+",name:"+r.name)
+	}
+
+	args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-pix_fmt", "yuv420p",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds), "-sc_threshold", "0")
+	if audio {
+		args = append(args, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioKbps), "-ac", "2")
+	}
+	return append(args, "-f", "hls", "-hls_time", fmt.Sprint(segmentSeconds), "-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(out, "%v", "segment_%05d.ts"),
+		"-master_pl_name", "master.m3u8",
+		"-var_stream_map", strings.Join(streams, " "),
+		filepath.Join(out, "%v", "index.m3u8"))
+}
+
+// stillArgs grabs one frame as a full size poster and a small thumbnail, a second in or from
+// the middle of shorter videos
+func stillArgs(source string, out string, duration time.Duration) []string {
+	at := time.Second
+	if duration > 0 && duration/2 < at {
+		at = duration / 2
+	}
+	return []string{"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+		"-ss", fmt.Sprintf("%.3f", at.Seconds()), "-i", source,
+		"-filter_complex", "[0:v]split=2[p][t];[t]" + scale(thumbnailShort) + "[tv]",
+		"-map", "[p]", "-frames:v", "1", "-q:v", "2", filepath.Join(out, "poster.jpg"),
+		"-map", "[tv]", "-frames:v", "1", "-q:v", "4", filepath.Join(out, "thumbnail.jpg")}
+}
+
+// pictureArgs converts the first picture of source into the PNG out, turned upright
+func pictureArgs(source string, out string) []string {
+	return []string{"-hide_banner", "-nostdin", "-loglevel", "error", "-y", "-i", source,
+		"-frames:v", "1", "-update", "1", "-c:v", "png", out}
+}
+
+// ToPNG converts a picture Go does not decode, such as a HEIC photo, into the PNG out. When
+// ffmpeg ran but could not read the picture the error wraps its *exec.ExitError.
+func ToPNG(ctx context.Context, source string, out string) error {
+	return ffmpeg(ctx, pictureArgs(source, out))
+}
+
+// ffmpeg runs FFMPEG_PATH with args, a failure carries the end of what it printed
+func ffmpeg(ctx context.Context, args []string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, FFMPEG_PATH, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = "..." + msg[len(msg)-500:]
+		}
+		return fmt.Errorf("ffmpeg: %w: %s", err, msg)
+	}
+	return nil
+}
+
+// encode writes the HLS ladder, poster and thumbnail of the video at source below out
+func encode(ctx context.Context, source string, out string, info media.Info) error {
+	rs := renditionsFor(info)
+	for _, r := range rs {
+		if err := os.MkdirAll(filepath.Join(out, r.name), 0700); err != nil {
+			return err
+		}
+	}
+	if err := ffmpeg(ctx, hlsArgs(source, out, rs, info.Audio)); err != nil {
+		return err
+	}
+	return ffmpeg(ctx, stillArgs(source, out, info.Duration))
+}
@@ -0,0 +1,126 @@
+package transcode
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"vibe/media"
+)
+
+// after returns the argument following the first flag in args, "" when there is none
+func after(args []string, flag string) string {
+	for i, a := range args {
+		if a == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func count(args []string, arg string) int {
+	n := 0
+	for _, a := range args {
+		if a == arg {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRenditionsFor(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          string
+	}{
+		{"4k landscape", 3840, 2160, "1080p 720p 480p 360p"},
+		{"1080p portrait", 1080, 1920, "1080p 720p 480p 360p"},
+		{"between rungs", 1280, 800, "720p 480p 360p"},
+		{"just the smallest", 640, 360, "360p"},
+		{"smaller than any", 320, 241, "240p"},
+	}
+	for _, tt := range tests {
+		var names []string
+		for _, r := range renditionsFor(media.Info{Width: tt.width, Height: tt.height}) {
+			names = append(names, r.name)
+		}
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("renditionsFor(%s) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if r := renditionsFor(media.Info{Width: 320, Height: 241})[0]; r.short != 240 || r.kbps != ladder[len(ladder)-1].kbps {
+		t.Errorf("small rendition = %+v, want 240 pixels at the smallest bitrate", r)
+	}
+}
+
+func TestHLSArgs(t *testing.T) {
+	rs := []rendition{{"720p", 720, 2800}, {"360p", 360, 800}}
+	tests := []struct {
+		name    string
+		audio   bool
+		streams string
+	}{
+		{"with sound", true, "v:0,a:0,name:720p v:1,a:1,name:360p"},
+		{"silent", false, "v:0,name:720p v:1,name:360p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := hlsArgs("in.mp4", "out", rs, tt.audio)
+			if got := after(args, "-i"); got != "in.mp4" {
+				t.Errorf("-i %s, want in.mp4", got)
+			}
+			if got := after(args, "-var_stream_map"); got != tt.streams {
+				t.Errorf("-var_stream_map %q, want %q", got, tt.streams)
+			}
+			filter := after(args, "-filter_complex")
+			if !strings.HasPrefix(filter, "[0:v]split=2[s0][s1];[s0]scale=w='if(gt(iw,ih),-2,720)'") || !strings.HasSuffix(filter, "[v1]") {
+				t.Errorf("-filter_complex %q", filter)
+			}
+			for flag, want := range map[string]string{"-b:v:0": "2800k", "-maxrate:v:1": "856k", "-bufsize:v:1": "1200k", "-hls_time": "4"} {
+				if got := after(args, flag); got != want {
+					t.Errorf("%s %s, want %s", flag, got, want)
+				}
+			}
+			audioMaps, wantMaps := count(args, "0:a:0"), 0
+			if tt.audio {
+				wantMaps = len(rs)
+			}
+			if audioMaps != wantMaps || (after(args, "-c:a") == "aac") != tt.audio {
+				t.Errorf("audio mapped %d times, encoded %q", audioMaps, after(args, "-c:a"))
+			}
+			if last := args[len(args)-1]; last != filepath.Join("out", "%v", "index.m3u8") {
+				t.Errorf("writes %s", last)
+			}
+		})
+	}
+}
+
+func TestStillArgs(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		at       string
+	}{
+		{10 * time.Second, "1.000"},
+		{time.Second, "0.500"},
+		{0, "1.000"}, // unknown duration
+	}
+	for _, tt := range tests {
+		args := stillArgs("in.mp4", "out", tt.duration)
+		if got := after(args, "-ss"); got != tt.at {
+			t.Errorf("stillArgs(%s) seeks to %s, want %s", tt.duration, got, tt.at)
+		}
+	}
+	args := stillArgs("in.mp4", "out", 0)
+	if args[len(args)-1] != filepath.Join("out", "thumbnail.jpg") || count(args, filepath.Join("out", "poster.jpg")) != 1 {
+		t.Errorf("stillArgs writes %q", args)
+	}
+}
+
+func TestPictureArgs(t *testing.T) {
+	args := pictureArgs("in.heic", "out.png")
+	if after(args, "-i") != "in.heic" || after(args, "-frames:v") != "1" || after(args, "-c:v") != "png" || args[len(args)-1] != "out.png" {
+		t.Errorf("pictureArgs = %q", args)
+	}
+}
@@ -0,0 +1,28 @@
+package transcode
+
+import (
+	"vibe-common/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// transcode job metrics, result is "done", "retry", "failed" or "interrupted"
+var jobsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "transcode_jobs_finished_total",
+	Help:      "Transcode job attempts, by how they ended.",
+}, []string{"result"})
+
+var jobsRunning = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "transcode_jobs_running",
+	Help:      "Transcode jobs being encoded by this instance.",
+})
+
+var jobDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: metrics.Namespace,
+	Name:      "transcode_job_duration_seconds",
+	Help:      "Time spent fetching, encoding and storing a video.",
+	Buckets:   []float64{5, 10, 30, 60, 120, 300, 600, 1200},
+})
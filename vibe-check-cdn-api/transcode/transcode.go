@@ -0,0 +1,339 @@
+package transcode
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"vibe-common/logging"
+	"vibe-common/repository"
+	"vibe-common/storage"
+	"vibe-common/tracing"
+	"vibe/config"
+	"vibe/media"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// transcoding settings, set by Setup, FFMPEG_PATH also by commands converting pictures without workers
+var FFMPEG_PATH string
+var TRANSCODE_DIR string
+var TRANSCODE_TIMEOUT time.Duration
+var TRANSCODE_MAX_ATTEMPTS int
+var TRANSCODE_RETRY_BACKOFF time.Duration
+var STORAGE_PART_SIZE int64
+
+// How long Cleanup lets workers finish their current job before cancelling them
+var WORKER_DRAIN_TIMEOUT = time.Second * 30
+
+// HLSFolder holds everything a job writes, next to the uploaded video in its vibe folder
+const HLSFolder = "hls"
+
+// Files a job writes into HLSFolder besides the renditions
+const (
+	MasterPlaylist = "master.m3u8"
+	Poster         = "poster.jpg"
+	Thumbnail      = "thumbnail.jpg"
+)
+
+// contentTypes of the files a job writes, by extension
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".jpg":  "image/jpeg",
+}
+
+// vibe_db access and media storage, set by Setup
+var repos *repository.Repos
+var objects storage.Storage
+
+var ctx context.Context
+var cancel context.CancelFunc
+var stop chan struct{}
+var workers sync.WaitGroup
+
+// wake lets an idle worker look for a job before its next poll
+var wake = make(chan struct{}, 1)
+
+// permanent marks a failure retrying cannot fix, such as a source that is gone or unreadable
+type permanent struct{ error }
+
+// Runs on startup, starts TRANSCODE_WORKERS workers polling the queue every TRANSCODE_POLL_INTERVAL
+func Setup(r *repository.Repos, s storage.Storage) {
+	repos = r
+	objects = s
+	c := config.CONFIGURATION
+	FFMPEG_PATH = c.FFMPEG_PATH
+	TRANSCODE_DIR = c.TRANSCODE_DIR
+	if TRANSCODE_DIR == "" {
+		TRANSCODE_DIR = c.DESTINATION + "/.transcode" // hidden, so never a storage key
+	}
+	TRANSCODE_TIMEOUT = c.TRANSCODE_TIMEOUT
+	TRANSCODE_MAX_ATTEMPTS = c.TRANSCODE_MAX_ATTEMPTS
+	TRANSCODE_RETRY_BACKOFF = c.TRANSCODE_RETRY_BACKOFF
+	STORAGE_PART_SIZE = int64(c.STORAGE_PART_SIZE)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	stop = make(chan struct{})
+	if !c.TRANSCODE_ENABLED || c.TRANSCODE_WORKERS == 0 {
+		log.Info("Transcoding workers disabled")
+		return
+	}
+	if c.TRANSCODE_POLL_INTERVAL <= 0 {
+		log.Fatal("TRANSCODE_POLL_INTERVAL must be positive")
+	}
+	if _, err := exec.LookPath(FFMPEG_PATH); err != nil {
+		log.Fatal("ffmpeg not found, install it or set TRANSCODE_WORKERS=0: ", err)
+	}
+	if err := os.MkdirAll(TRANSCODE_DIR, 0700); err != nil {
+		log.Fatal("Unable to create transcoding directory "+TRANSCODE_DIR+": ", err)
+	}
+
+	workers.Add(c.TRANSCODE_WORKERS)
+	for i := 0; i < c.TRANSCODE_WORKERS; i++ {
+		go worker(i, c.TRANSCODE_POLL_INTERVAL)
+	}
+	log.Info("Started ", c.TRANSCODE_WORKERS, " transcoding workers")
+}
+
+// Notify wakes an idle worker, call it after queueing a job
+func Notify() {
+	select {
+	case wake <- struct{}{}:
+	default: // a wake up is already pending
+	}
+}
+
+// Stops the workers, letting them finish their current job for WORKER_DRAIN_TIMEOUT before
+// cancelling it, a cancelled job is queued again
+func Cleanup() {
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(WORKER_DRAIN_TIMEOUT):
+		log.Warning("Transcoding workers did not stop in time, cancelling")
+		cancel()
+		<-done
+	}
+	cancel()
+	log.Info("Transcoding workers stopped")
+}
+
+// worker claims and runs jobs until Cleanup, waiting for a poll or a wake up when none is due
+func worker(id int, interval time.Duration) {
+	defer workers.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		job, err := claim()
+		if err == nil {
+			Notify() // another worker may find a job too
+			run(id, job)
+			continue
+		}
+		if err != repository.ErrNotFound {
+			log.Error("W", id, ": claiming a transcode job failed: ", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func claim() (repository.TranscodeJob, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return repository.TranscodeJob{}, err
+	}
+	now := time.Now()
+	// the lease outlasts the job's timeout, so only a worker that died loses its job
+	return repos.Transcodes.Claim(ctx, hex.EncodeToString(b), now, now.Add(TRANSCODE_TIMEOUT+time.Minute))
+}
+
+// run transcodes a claimed job and records how it went
+func run(id int, job repository.TranscodeJob) {
+	jobCtx, span := tracing.Tracer("transcode").Start(ctx, "transcode.job")
+	defer span.End()
+	jobLog := logging.FromContext(jobCtx).WithField("location_id", job.LocationHash).WithField("vibe_id", job.Folder).WithField("attempt", job.Attempts)
+
+	if job.Attempts > TRANSCODE_MAX_ATTEMPTS {
+		// the last attempt's worker died holding it
+		finish(jobLog, job, permanent{errors.New("gave up after the last attempt was lost")})
+		return
+	}
+
+	jobLog.Info("W", id, ": Transcoding ", job.SourceKey)
+	jobsRunning.Inc()
+	started := time.Now()
+	timeoutCtx, cancelTimeout := context.WithTimeout(jobCtx, TRANSCODE_TIMEOUT)
+	err := transcode(timeoutCtx, job)
+	cancelTimeout()
+	jobsRunning.Dec()
+	jobDuration.Observe(time.Since(started).Seconds())
+	finish(jobLog, job, err)
+}
+
+// finish completes, retries or fails a job by how its attempt went
+func finish(jobLog *log.Entry, job repository.TranscodeJob, err error) {
+	now := time.Now()
+	// the attempt's context may be cancelled, the outcome is recorded regardless
+	recordCtx := context.Background()
+
+	var failed permanent
+	switch {
+	case err == nil:
+		err = repos.Transcodes.Complete(recordCtx, job, now)
+		if err == nil {
+			jobsFinished.WithLabelValues("done").Inc()
+			jobLog.Info("Transcoded ", job.SourceKey)
+			return
+		}
+		if err == repository.ErrNotFound {
+			discardOutput(jobLog, job)
+			return
+		}
+		jobLog.Error("recording a finished transcode failed: ", err)
+		return // the lease runs out and the job is done again
+	case ctx.Err() != nil:
+		// shutting down, another instance or the next start picks it up without the attempt counting
+		err = repos.Transcodes.Release(recordCtx, job, "interrupted by shutdown", now)
+		jobsFinished.WithLabelValues("interrupted").Inc()
+	case errors.As(err, &failed) || job.Attempts >= TRANSCODE_MAX_ATTEMPTS:
+		jobLog.Error("Transcoding failed for good: ", err)
+		err = repos.Transcodes.Fail(recordCtx, job, err.Error(), now)
+		jobsFinished.WithLabelValues("failed").Inc()
+	default:
+		backoff := TRANSCODE_RETRY_BACKOFF << uint(job.Attempts-1)
+		jobLog.Warning("Transcoding failed, retrying in ", backoff, ": ", err)
+		err = repos.Transcodes.Retry(recordCtx, job, err.Error(), now.Add(backoff), now)
+		jobsFinished.WithLabelValues("retry").Inc()
+	}
+	if err != nil && err != repository.ErrNotFound {
+		jobLog.Error("recording a failed transcode failed: ", err)
+	}
+}
+
+// discardOutput removes what a job wrote for a video deleted while it ran, a job whose claim
+// was taken over leaves it for the new claim to overwrite
+func discardOutput(jobLog *log.Entry, job repository.TranscodeJob) {
+	if _, err := repos.Transcodes.Status(context.Background(), job.LocationHash, job.Folder); err != repository.ErrNotFound {
+		jobLog.Warning("Transcode finished after its claim was taken over")
+		return
+	}
+	jobLog.Info("Video was deleted while transcoding, removing the output")
+	if err := storage.DeletePrefix(context.Background(), objects, outputPrefix(job)); err != nil {
+		jobLog.Error("removing the output of a deleted video failed: ", err)
+	}
+}
+
+// outputPrefix is where the output of a job is stored, next to its source
+func outputPrefix(job repository.TranscodeJob) string {
+	return path.Join(path.Dir(job.SourceKey), HLSFolder) + "/"
+}
+
+// transcode fetches the source of a job, encodes it and stores the output
+func transcode(ctx context.Context, job repository.TranscodeJob) error {
+	dir, err := ioutil.TempDir(TRANSCODE_DIR, "job-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	if err := download(ctx, job.SourceKey, source); err == storage.ErrNotExist {
+		return permanent{errors.New("the uploaded video is gone")}
+	} else if err != nil {
+		return err
+	}
+	info, err := media.Probe(source)
+	if err != nil {
+		return permanent{err}
+	}
+
+	out := filepath.Join(dir, HLSFolder)
+	if err := encode(ctx, source, out, info); err != nil {
+		return err
+	}
+	return upload(ctx, out, outputPrefix(job))
+}
+
+func download(ctx context.Context, key string, target string) error {
+	body, err := objects.Get(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// upload stores every file below out under prefix, the master playlist last so it never
+// names a rendition that is not there yet
+func upload(ctx context.Context, out string, prefix string) error {
+	var files []string
+	err := filepath.Walk(out, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(out, p)
+		if err != nil {
+			return err
+		}
+		if rel != MasterPlaylist {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range append(files, MasterPlaylist) {
+		if err := uploadFile(ctx, filepath.Join(out, rel), prefix+filepath.ToSlash(rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadFile(ctx context.Context, p string, key string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return storage.Upload(ctx, objects, key, f, info.Size(), contentTypes[filepath.Ext(p)], STORAGE_PART_SIZE)
+}
@@ -1,22 +1,28 @@
 package subscriber
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
 	_ "io/ioutil"
-	"log"
 	"net/http"
 	"regexp"
-	"vibe/api"
-	mDB "vibe/model/db"
-	"vibe/store"
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	mAPI "vibe/model/api"
 
 	_ "github.com/thedevsaddam/gojsonq"
 )
 
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// vibe_db access, set by Setup
+var repos *repository.Repos
+
+// Setup hands the handlers their repositories
+func Setup(r *repository.Repos) {
+	repos = r
+}
+
 func isEmailValid(e string) bool {
 	if len(e) < 3 && len(e) > 254 {
 		return false
@@ -25,55 +31,37 @@ func isEmailValid(e string) bool {
 }
 
 func Subscribe(w http.ResponseWriter, r *http.Request) {
-	// decode subscriber
-	sub := &mDB.Subscriber{}
-	err := json.NewDecoder(r.Body).Decode(sub)
-
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	reqLog := logging.FromContext(r.Context())
+	// decoded by the openapi middleware
+	sub := openapi.Request(r).(*mAPI.SubscribeRequest)
 
 	// Check for email validity
 	if !isEmailValid(sub.Email) {
-		log.Println("Not a valid email")
-		fmt.Printf("Not a valid email")
-		api.Respond(w, true, http.StatusBadRequest)
+		reqLog.Info("Not a valid email")
+		api.RespondInvalid(w, api.FieldError{Field: "email", Message: "is not a valid email"})
 		return
 	}
 
 	// Query db for existing subscriber
-	result := store.DB.QueryRow("SELECT subscriber_id FROM subscribers WHERE email=?", string(sub.Email))
-
-	if err != nil {
-		api.Respond(w, true, http.StatusInternalServerError)
+	if exists, err := repos.Subscribers.Exists(r.Context(), sub.Email); err == nil && exists {
+		reqLog.Info("Subscriber already exists")
+		api.RespondError(w, http.StatusConflict, api.CodeAlreadySubscribed, "email is already subscribed", api.FieldError{Field: "email", Message: "is already subscribed"})
 		return
-	}
-	storedSub := &mDB.Subscriber{}
-	if err := result.Scan(&storedSub.Email); err == nil {
-		log.Println("Subscriber already exists")
-		api.Respond(w, true, http.StatusConflict)
-		return
-	} else if err == sql.ErrNoRows {
+	} else if err == nil {
 		// insert subscriber into db
-		if _, err = store.DB.Query(`INSERT into subscribers (email) VALUES (?)`, string(sub.Email)); err != nil {
+		if err = repos.Subscribers.Create(r.Context(), sub.Email); err != nil {
 			// if issue with insert return error
-			log.Println("error store")
-			log.Println(err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			reqLog.Error("storing the subscriber failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to store the subscriber")
 			return
 		}
 
-		log.Printf("Successfully subscribed")
-		fmt.Printf("Subscriber is: %+v", sub) 
-		api.Respond(w, true, http.StatusCreated)
+		reqLog.Infof("Successfully subscribed")
+		api.RespondSuccess(w, http.StatusCreated, "subscriber", "Successfully subscribed")
 
 	} else {
-		s := err.Error()
-    fmt.Printf("type: %T; value: %q\n", s, s)
-		log.Println("Bad DB query")
-		log.Println(err)
-		api.Respond(w, true, http.StatusInternalServerError)
+		reqLog.Error("looking up the subscriber failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to look up the subscriber")
 		return
 	}
-}
\ No newline at end of file
+}
@@ -1,245 +1,137 @@
 package twilio
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"os"
 	"strings"
-	"vibe/api"
-	mDB "vibe/model/db"
-	"vibe/store"
+	"time"
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/middleware"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/tracing"
+	"vibe/config"
+	mAPI "vibe/model/api"
 )
 
-type SendData struct {
-	Phone string `json:"phone"`
-	Code  string `json:"code"`
-}
+// client forwards X-Request-ID and trace context to Twilio
+var client = &http.Client{Timeout: 10 * time.Second, Transport: middleware.PropagateRequestID(tracing.Transport(nil))}
+
+// vibe_db access, set by Setup
+var repos *repository.Repos
 
-type ErrorMessage struct {
-	Message string `json:"errorMessage"`
+// Setup hands the handlers their repositories
+func Setup(r *repository.Repos) {
+	repos = r
 }
 
 func PasswordRecoveryVerifyPhoneNumber(w http.ResponseWriter, r *http.Request) {
-
-	SERVICE_SID := os.Getenv("TWILIO_SERVICE_SID")
-	ACCOUNT_SID := os.Getenv("TWILIO_ACCOUNT_SID")
-	AUTH_TOKEN := os.Getenv("TWILIO_AUTH_TOKEN")
-
-	client := &http.Client{}
-	apiUrl := "https://verify.twilio.com"
-	resource := "/v2/Services/"
+	reqLog := logging.FromContext(r.Context())
 	channel := "sms"
-	creds := &SendData{}
-	err := json.NewDecoder(r.Body).Decode(creds)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
-
-	errorMessage := &ErrorMessage{}
-
-	// Check for empty values
-	if string(creds.Phone) == "" {
-		log.Println("Empty field(s)")
-		errorMessage.Message = "Empty field"
-		api.Respond(w, errorMessage, http.StatusBadRequest)
-		return
-	}
+	// decoded and checked for an empty phone by the openapi middleware
+	creds := openapi.Request(r).(*mAPI.PhoneRequest)
 
 	// Query db for existing user
-	result := store.DB.QueryRow("SELECT user_id FROM users WHERE phone=?", string(creds.Phone))
-	if err != nil {
-		api.Respond(w, nil, http.StatusInternalServerError)
-		return
-	}
-	storedCreds := &mDB.User{}
-	if err := result.Scan(&storedCreds.UserId); err == nil {
+	if _, err := repos.Users.IDByPhone(r.Context(), creds.Phone); err == nil {
 		data := url.Values{}
-		fmt.Println(creds.Phone)
 		data.Set("To", creds.Phone)
 		data.Set("Channel", channel)
 		data.Set("FriendlyName", "VibeCheck")
-		data.Set("serviceSid", SERVICE_SID)
-		u, _ := url.ParseRequestURI(apiUrl)
-		u.Path = resource + SERVICE_SID + "/Verifications"
-		urlStr := u.String()
-		// Define request
-		req, err := http.NewRequest("POST", urlStr, strings.NewReader(data.Encode()))
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		req.SetBasicAuth(ACCOUNT_SID, AUTH_TOKEN)
-		if err != nil {
-			fmt.Println(err.Error())
-			fmt.Println("Error making access token request")
-		}
-		requestDump, err := httputil.DumpRequest(req, true)
-		fmt.Println(string(requestDump))
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		// Make request
-		res, err := client.Do(req)
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		defer res.Body.Close()
-		// Read reponse
-		body, err := ioutil.ReadAll(res.Body)
-		fmt.Println(string(body))
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		api.RespondRaw(w, body, http.StatusOK)
-
-	} else if err == sql.ErrNoRows {
-		log.Println("Phone number not found")
-		errorMessage.Message = "Phone number not associated with any account"
-		api.Respond(w, errorMessage, http.StatusConflict)
+		respondVerify(w, r, "/Verifications", data)
+
+	} else if err == repository.ErrNotFound {
+		reqLog.Info("Phone number not found")
+		api.RespondError(w, http.StatusConflict, api.CodeUserNotFound, "Phone number not associated with any account", api.FieldError{Field: "phone", Message: "is not associated with any account"})
 		return
 
 	} else {
-		log.Println("Bad DB query")
-		log.Println(err)
-		api.Respond(w, nil, http.StatusInternalServerError)
+		reqLog.Error("looking up the phone number failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to look up the phone number")
 		return
 	}
 }
 
 func VerifyPhoneNumber(w http.ResponseWriter, r *http.Request) {
-
-	SERVICE_SID := os.Getenv("TWILIO_SERVICE_SID")
-	ACCOUNT_SID := os.Getenv("TWILIO_ACCOUNT_SID")
-	AUTH_TOKEN := os.Getenv("TWILIO_AUTH_TOKEN")
-
-	client := &http.Client{}
-	apiUrl := "https://verify.twilio.com"
-	resource := "/v2/Services/"
+	reqLog := logging.FromContext(r.Context())
 	channel := "sms"
-	creds := &SendData{}
-	err := json.NewDecoder(r.Body).Decode(creds)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
-
-	errorMessage := &ErrorMessage{}
-
-	// Check for empty values
-	if string(creds.Phone) == "" {
-		log.Println("Empty field(s)")
-		errorMessage.Message = "Empty field"
-		api.Respond(w, errorMessage, http.StatusBadRequest)
-		return
-	}
+	// decoded and checked for an empty phone by the openapi middleware
+	creds := openapi.Request(r).(*mAPI.PhoneRequest)
 
 	// Query db for existing user
-	result := store.DB.QueryRow("SELECT user_id FROM users WHERE phone=?", string(creds.Phone))
-	if err != nil {
-		api.Respond(w, nil, http.StatusInternalServerError)
-		return
-	}
-	storedCreds := &mDB.User{}
-	if err := result.Scan(&storedCreds.UserId); err == nil {
-		log.Println("Phone number already used")
-		errorMessage.Message = "This number is used."
-		api.Respond(w, errorMessage, http.StatusConflict)
+	if _, err := repos.Users.IDByPhone(r.Context(), creds.Phone); err == nil {
+		reqLog.Info("Phone number already used")
+		api.RespondError(w, http.StatusConflict, api.CodePhoneTaken, "This number is used.", api.FieldError{Field: "phone", Message: "is already used"})
 		return
-	} else if err == sql.ErrNoRows {
+	} else if err == repository.ErrNotFound {
 		data := url.Values{}
-		fmt.Println(creds.Phone)
 		data.Set("To", creds.Phone)
 		data.Set("Channel", channel)
 		data.Set("FriendlyName", "VibeCheck")
-		data.Set("serviceSid", SERVICE_SID)
-		u, _ := url.ParseRequestURI(apiUrl)
-		u.Path = resource + SERVICE_SID + "/Verifications"
-		urlStr := u.String()
-		// Define request
-		req, err := http.NewRequest("POST", urlStr, strings.NewReader(data.Encode()))
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		req.SetBasicAuth(ACCOUNT_SID, AUTH_TOKEN)
-		if err != nil {
-			fmt.Println(err.Error())
-			fmt.Println("Error making access token request")
-		}
-		requestDump, err := httputil.DumpRequest(req, true)
-		fmt.Println(string(requestDump))
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		// Make request
-		res, err := client.Do(req)
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		defer res.Body.Close()
-		// Read reponse
-		body, err := ioutil.ReadAll(res.Body)
-		fmt.Println(string(body))
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		api.RespondRaw(w, body, http.StatusOK)
+		respondVerify(w, r, "/Verifications", data)
 
 	} else {
-		log.Println("Bad DB query")
-		log.Println(err)
-		api.Respond(w, nil, http.StatusInternalServerError)
+		reqLog.Error("looking up the phone number failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to look up the phone number")
 		return
 	}
 }
 
 func VerifyCode(w http.ResponseWriter, r *http.Request) {
-	SERVICE_SID := os.Getenv("TWILIO_SERVICE_SID")
-	ACCOUNT_SID := os.Getenv("TWILIO_ACCOUNT_SID")
-	AUTH_TOKEN := os.Getenv("TWILIO_AUTH_TOKEN")
-	client := &http.Client{}
-	apiUrl := "https://verify.twilio.com"
-	resource := "/v2/Services/"
-	creds := &SendData{}
-	err := json.NewDecoder(r.Body).Decode(creds)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
+	// decoded and checked for empty values by the openapi middleware
+	creds := openapi.Request(r).(*mAPI.VerifyCodeRequest)
+
 	data := url.Values{}
-	fmt.Println(creds.Phone)
 	data.Set("To", creds.Phone)
 	data.Set("Code", creds.Code)
 	data.Set("FriendlyName", "VibeCheck")
+	respondVerify(w, r, "/VerificationCheck", data)
+}
+
+// respondVerify posts data to a resource of the Twilio Verify service and passes
+// its answer through, Twilio rejecting the request becomes VERIFICATION_FAILED and
+// Twilio being unreachable UPSTREAM_FAILED
+func respondVerify(w http.ResponseWriter, r *http.Request, resource string, data url.Values) {
+	reqLog := logging.FromContext(r.Context())
+	SERVICE_SID := config.CONFIGURATION.TWILIO_SERVICE_SID
 	data.Set("serviceSid", SERVICE_SID)
-	u, _ := url.ParseRequestURI(apiUrl)
-	u.Path = resource + SERVICE_SID + "/VerificationCheck"
-	urlStr := u.String()
+	urlStr := "https://verify.twilio.com/v2/Services/" + SERVICE_SID + resource
+
 	// Define request
-	req, err := http.NewRequest("POST", urlStr, strings.NewReader(data.Encode()))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.SetBasicAuth(ACCOUNT_SID, AUTH_TOKEN)
+	req, err := http.NewRequestWithContext(r.Context(), "POST", urlStr, strings.NewReader(data.Encode()))
 	if err != nil {
-		fmt.Println(err.Error())
-		fmt.Println("Error making access token request")
-	}
-	requestDump, err := httputil.DumpRequest(req, true)
-	fmt.Println(string(requestDump))
-	if err != nil {
-		fmt.Println(err.Error())
+		reqLog.Error("Error making verify request: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to build the verification request")
+		return
 	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(config.CONFIGURATION.TWILIO_ACCOUNT_SID, config.CONFIGURATION.TWILIO_AUTH_TOKEN)
+
 	// Make request
 	res, err := client.Do(req)
 	if err != nil {
-		fmt.Println(err.Error())
+		reqLog.Error("Error calling Twilio: ", err)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "verification service is unavailable")
+		return
 	}
 	defer res.Body.Close()
 	// Read reponse
 	body, err := ioutil.ReadAll(res.Body)
-	fmt.Println(string(body))
 	if err != nil {
-		fmt.Println(err.Error())
+		reqLog.Error("Error reading Twilio response: ", err)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "verification service is unavailable")
+		return
+	}
+	if res.StatusCode >= 500 {
+		reqLog.Error("Twilio answered ", res.StatusCode)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "verification service is unavailable")
+		return
+	}
+	if res.StatusCode >= 400 {
+		reqLog.Info("Twilio rejected the verification: ", res.StatusCode)
+		api.RespondError(w, http.StatusBadRequest, api.CodeVerificationFailed, "verification was rejected")
+		return
 	}
 	api.RespondRaw(w, body, http.StatusOK)
 }
@@ -2,184 +2,169 @@ package user
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
-	"vibe/api"
-	mDB "vibe/model/db"
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/middleware"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/session"
+	"vibe-common/tracing"
+	"vibe/auth"
+	"vibe/config"
+	mAPI "vibe/model/api"
 	model "vibe/model/db"
-	"vibe/store"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Response struct {
 	IsAvail bool `json:"isAvail"`
 }
 
+// vibe_db access, set by Setup
+var repos *repository.Repos
+
+// base URL of cdn-api, set by Setup
+var CDN_API_URL string
+
+// Setup hands the handlers their repositories
+func Setup(r *repository.Repos) {
+	repos = r
+	CDN_API_URL = strings.TrimSuffix(config.CONFIGURATION.CDN_API_URL, "/")
+}
+
+// GetUserInfo answers /user-info as it did before v1, the user of the session in email and a
+// null body with a 401 without a live session, where RequireAuth answers the error envelope
 func GetUserInfo(w http.ResponseWriter, r *http.Request) {
-	customer := &model.Customer{}
-	fmt.Println("Getting user info...")
-	c, err := r.Cookie("session_token")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			api.Respond(w, nil, http.StatusUnauthorized)
-			return
-		}
-		api.Respond(w, nil, http.StatusBadRequest)
+	userID, err := auth.LookupSession(r)
+	switch {
+	case err == session.ErrNoSession:
+		api.Respond(w, nil, http.StatusUnauthorized)
 		return
-	}
-	sessionToken := c.Value
-
-	conn := store.Cache.Get()
-	res, err := store.ToString(conn.Do("GET", sessionToken))
-	if err != nil {
+	case err != nil:
+		logging.FromContext(r.Context()).Error("Unable to read session: ", err)
 		api.Respond(w, nil, http.StatusInternalServerError)
 		return
 	}
-	if res == "" {
-		api.Respond(w, nil, http.StatusUnauthorized)
+	customer := &model.Customer{}
+	customer.Email = userID
+	api.Respond(w, customer, http.StatusAccepted)
+}
+
+// GetMe answers with the account of the session, behind RequireAuth
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	user, err := repos.Users.ByID(r.Context(), session.UserID(r))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			api.RespondError(w, http.StatusNotFound, api.CodeUserNotFound, "the account of the session no longer exists")
+			return
+		}
+		log.Error("Bad DB query: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to look up the user")
 		return
 	}
-	defer conn.Close()
-	fmt.Println(res)
-	customer.Email = res
-	api.Respond(w, customer, http.StatusAccepted)
+	api.RespondOK(w, mAPI.User{UserId: user.UserID, UserName: user.UserName, Phone: user.Phone, Photo: user.Photo})
 }
 
 func UsernameAvailablityCheck(w http.ResponseWriter, r *http.Request) {
+	respondUsernameAvailability(w, r, openapi.Request(r).(*mAPI.UsernameRequest).UserName)
+}
+
+func GetUsername(w http.ResponseWriter, r *http.Request) {
+	respondUsernameAvailability(w, r, openapi.Request(r).(*mAPI.UsernameParam).UserName)
+}
+
+// respondUsernameAvailability answers 200 for a free username and 409 for a taken one
+func respondUsernameAvailability(w http.ResponseWriter, r *http.Request, userName string) {
 	res := &Response{}
 	res.IsAvail = false
-	creds := &mDB.User{}
-	err := json.NewDecoder(r.Body).Decode(creds)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
 	// Query db for user
-	result := store.DB.QueryRow("SELECT user_name FROM users WHERE user_name=?", string(creds.UserName))
+	_, err := repos.Users.ByUserName(r.Context(), userName)
 	if err != nil {
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
-	storedCreds := &mDB.User{}
-	err = result.Scan(&storedCreds.UserName)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Println("Username Available")
+		if err == repository.ErrNotFound {
+			log.Info("Username Available")
 			res.IsAvail = true
 			api.Respond(w, res, http.StatusOK)
 			return
 		}
-		log.Println("Bad DB query")
-		log.Println(err)
-		api.Respond(w, res, http.StatusInternalServerError)
+		log.Error("Bad DB query")
+		log.Error(err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to check the username")
 		return
 	}
-	log.Println("Username Taken")
-	api.Respond(w, res, http.StatusConflict)
+	log.Info("Username Taken")
+	api.RespondError(w, http.StatusConflict, api.CodeUsernameTaken, "username is already taken", api.FieldError{Field: "user_name", Message: "is already taken"})
 }
 
 func SetDeleteStatus(w http.ResponseWriter, r *http.Request) {
-	res := &Response{}
-	res.IsAvail = true
-	creds := &mDB.User{}
-	err := json.NewDecoder(r.Body).Decode(creds)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
-	// Delete user's liked videos in the database
-	_, err = store.DB.Exec("DELETE FROM videos_liked WHERE user_id = ?;", string(creds.UserId))
-	if err != nil {
-		log.Println("Error when removing user data from videos_liked:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
-
-	// Delete user's chat messages in the database
-	_, err = store.DB.Exec("DELETE FROM all_chats WHERE user_id = ?;", string(creds.UserId))
-	if err != nil {
-		log.Println("Error when removing user data from all_chats:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
-
-	// Delete user's posted videos in the latest_videos table in database
-	_, err = store.DB.Exec("DELETE FROM latest_videos WHERE user_id = ?;", string(creds.UserId))
-	if err != nil {
-		log.Println("Error when removing user data from latest_videos:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
-
-	// Delete user's posted videos in the all_videos table in database
-	_, err = store.DB.Exec("DELETE FROM all_videos WHERE user_id = ?;", string(creds.UserId))
-	if err != nil {
-		log.Println("Error when removing user data from all_videos:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
+	creds := openapi.Request(r).(*mAPI.DeleteUserRequest)
+	logging.SetUserID(r.Context(), string(creds.UserId))
+	deleteUser(w, r, creds.UserId)
+	log.Info("User ", string(creds.UserId), " has been deleted ", creds.IsDeleted)
+}
 
-	// Delete user's favorited locations in the database
-	_, err = store.DB.Exec("DELETE FROM favorites WHERE user_id = ?;", string(creds.UserId))
-	if err != nil {
-		log.Println("Error when removing user data from favorites:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
+// DeleteMe deletes the account of the session, behind RequireAuth
+func DeleteMe(w http.ResponseWriter, r *http.Request) {
+	deleteUser(w, r, session.UserID(r))
+}
 
-	// Delete user's account in the database
-	_, err = store.DB.Exec("DELETE FROM users WHERE user_id = ?;", string(creds.UserId))
-	if err != nil {
-		log.Println("Error when removing user data from users:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
+// deleteUser removes the user and everything they posted
+func deleteUser(w http.ResponseWriter, r *http.Request, userID string) {
+	res := &Response{}
+	res.IsAvail = true
+	// Delete user's liked videos, chat messages, posted videos, favorited locations and account in the database
+	deletes := []struct {
+		table string
+		fn    func(ctx context.Context, userID string) error
+	}{
+		{"videos_liked", repos.Likes.DeleteByUser},
+		{"all_chats", repos.Chats.DeleteByUser},
+		{"latest_videos and all_videos", repos.Videos.DeleteByUser},
+		{"favorites", repos.Favorites.DeleteByUser},
+		{"users", repos.Users.Delete},
+	}
+	for _, d := range deletes {
+		if err := d.fn(r.Context(), userID); err != nil {
+			log.Error("Error when removing user data from "+d.table+": ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to delete the user")
+			return
+		}
 	}
 
-	// log.Println("User", string(creds.UserName), "updated with is_delete status", creds.IsDeleted)
-	log.Println("User", string(creds.UserId), "has been deleted", creds.IsDeleted)
-
 	res.IsAvail = false
 	api.Respond(w, res, http.StatusOK)
 }
 
 func SetUserFollowing(w http.ResponseWriter, r *http.Request) {
-	res := &Response{}
-	res.IsAvail = false
-	userFollow := &mDB.UserFollower{}
-	err := json.NewDecoder(r.Body).Decode(userFollow)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
+	userFollow := openapi.Request(r).(*mAPI.UserFollowRequest)
+	logging.SetUserID(r.Context(), string(userFollow.UserId))
+	follow(w, r, userFollow.UserId, userFollow.UserIdFollowing)
+}
 
-	// insert user following user_name_following in the database
-	_, err = store.DB.Exec("INSERT INTO user_follower (`user_id`, `user_id_following`) VALUES(?, ?);", string(userFollow.UserId), string(userFollow.UserIdFollowing))
-	if err != nil {
-		log.Println("Error when adding new user follow:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
+// Follow makes the user of the session follow the user in the path, behind RequireAuth
+func Follow(w http.ResponseWriter, r *http.Request) {
+	follow(w, r, session.UserID(r), openapi.Request(r).(*mAPI.UserParam).UserId)
+}
 
-	// update user following count in users table in the database
-	_, err = store.DB.Exec("UPDATE users SET following_count = following_count + 1 WHERE user_id = ?", string(userFollow.UserId))
-	if err != nil {
-		log.Println("Error when incrementing user following_count:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
+func follow(w http.ResponseWriter, r *http.Request, userID string, followingID string) {
+	res := &Response{}
+	res.IsAvail = false
 
-	// update user_following follower count in users table in the database
-	_, err = store.DB.Exec("UPDATE users SET follower_count = follower_count + 1 WHERE user_id = ?", string(userFollow.UserIdFollowing))
-	if err != nil {
-		log.Println("Error when incrementing user follower_count:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
+	// insert user following user_id_following and update both users' counts in the database
+	if err := repos.Follows.Follow(r.Context(), userID, followingID); err != nil {
+		log.Error("Error when adding new user follow: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to follow the user")
 		return
 	}
 
-	log.Println("User", string(userFollow.UserId), "is now following", userFollow.UserIdFollowing)
+	log.Info("User ", userID, " is now following ", followingID)
 
 	res.IsAvail = true
 	api.Respond(w, res, http.StatusOK)
@@ -187,261 +172,152 @@ func SetUserFollowing(w http.ResponseWriter, r *http.Request) {
 }
 
 func SetUserUnfollowing(w http.ResponseWriter, r *http.Request) {
-	res := &Response{}
-	res.IsAvail = false
-	userFollow := &mDB.UserFollower{}
-	err := json.NewDecoder(r.Body).Decode(userFollow)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
-
-	// delete user following user_following in the database
-	_, err = store.DB.Exec("DELETE FROM user_follower WHERE user_id = ? and user_id_following = ?;", string(userFollow.UserId), string(userFollow.UserIdFollowing))
+	userFollow := openapi.Request(r).(*mAPI.UserFollowRequest)
+	logging.SetUserID(r.Context(), string(userFollow.UserId))
+	unfollow(w, r, userFollow.UserId, userFollow.UserIdFollowing)
+}
 
-	if err != nil {
-		log.Println("Error when removing user follow:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
+// Unfollow makes the user of the session stop following the user in the path, behind RequireAuth
+func Unfollow(w http.ResponseWriter, r *http.Request) {
+	unfollow(w, r, session.UserID(r), openapi.Request(r).(*mAPI.UserParam).UserId)
+}
 
-	// update user following count in users table in the database
-	_, err = store.DB.Exec("UPDATE users SET following_count = following_count - 1 WHERE user_id = ?", string(userFollow.UserId))
-	if err != nil {
-		log.Println("Error when decrementing user following_count:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
-	}
+func unfollow(w http.ResponseWriter, r *http.Request, userID string, followingID string) {
+	res := &Response{}
+	res.IsAvail = false
 
-	// update user_following follower count in users table in the database
-	_, err = store.DB.Exec("UPDATE users SET follower_count = follower_count - 1 WHERE user_id = ?", string(userFollow.UserIdFollowing))
-	if err != nil {
-		log.Println("Error when decrementing user follower_count:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
+	// delete user following user_id_following and update both users' counts in the database
+	if err := repos.Follows.Unfollow(r.Context(), userID, followingID); err != nil {
+		log.Error("Error when removing user follow: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to unfollow the user")
 		return
 	}
 
-	log.Println("User", string(userFollow.UserId), "is now unfollowing", userFollow.UserIdFollowing)
+	log.Info("User ", userID, " is now unfollowing ", followingID)
 
 	res.IsAvail = true
 	api.Respond(w, res, http.StatusOK)
 
 }
 
-type FollowerDataRequest struct {
-	UserId          string `json:"user_id" db:"user_id"`
-	UserIdFollowing string `json:"user_id_following" db:"user_id_following"`
-}
-
 // in general this should be used for the users following stories page
 func GetFollowingData(w http.ResponseWriter, r *http.Request) {
-	res := &Response{}
-	res.IsAvail = false
-	userFollow := &mDB.UserFollower{}
-	err := json.NewDecoder(r.Body).Decode(userFollow)
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
+	userFollow := openapi.Request(r).(*mAPI.UserRequest)
+	logging.SetUserID(r.Context(), string(userFollow.UserId))
+	respondFollowData(w, r, repos.Follows.Following, userFollow.UserId)
+}
 
-	var payload = []byte(`{"user_follow_data": [`)
+func GetFollowing(w http.ResponseWriter, r *http.Request) {
+	respondFollowData(w, r, repos.Follows.Following, openapi.Request(r).(*mAPI.UserParam).UserId)
+}
 
-	// select rows of user_id that the given user is following
-	rows, err := store.DB.Query("SELECT user_id_following FROM user_follower WHERE user_id = ?;", string(userFollow.UserId))
+func GetFollowerData(w http.ResponseWriter, r *http.Request) {
+	userFollow := openapi.Request(r).(*mAPI.UserRequest)
+	logging.SetUserID(r.Context(), string(userFollow.UserId))
+	respondFollowData(w, r, repos.Follows.Followers, userFollow.UserId)
+}
+
+func GetFollowers(w http.ResponseWriter, r *http.Request) {
+	respondFollowData(w, r, repos.Follows.Followers, openapi.Request(r).(*mAPI.UserParam).UserId)
+}
+
+// respondFollowData answers with the latest video and profile of each user list returns for userID
+func respondFollowData(w http.ResponseWriter, r *http.Request, list func(ctx context.Context, userID string) ([]string, error), userID string) {
+	var payload = []byte(`{"user_follow_data": [`)
 
+	// select rows of user_id that the given user is following, or that follow them
+	ids, err := list(r.Context(), userID)
 	if err != nil {
-		log.Println("Error when fetching list of user followings:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
+		log.Error("Error when fetching list of user followings: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to list the users")
 		return
 	}
 
-	// if we get here that means we have videos to send to client
-	defer rows.Close()
-
 	var count = 0
-	for rows.Next() {
-		log.Println("in rows.next")
-
-		var user_id_following sql.NullString
-		err := rows.Scan(&user_id_following)
-
+	for _, user_id_following := range ids {
+		log.Info("Getting latest video and associated metadata for user: ", user_id_following)
+		data, err := fetchUserLatestData(r.Context(), user_id_following)
 		if err != nil {
-			panic(err)
-		} else {
-
-			log.Println("Getting latest video and associated metadata for user: ", user_id_following)
-
-			// marshall request data body first
-			body, err := json.Marshal(&FollowerDataRequest{UserId: user_id_following.String})
-			if err != nil {
-				log.Println("Error marshalling request data: ", err)
-				panic(err)
-			}
-
-			url := "https://cdn-api.vibecheck.tech/get-user-latest-data"    // url for GetUserLatestData
-			req, err := http.NewRequest("POST", url, bytes.NewBuffer(body)) // request
-			if err != nil {
-				log.Println("Error building request: ", err)
-				panic(err)
-			}
-			req.Header.Add("Content-Type", "application/json")
-
-			client := &http.Client{Timeout: 10 * time.Second} // create an http client
-			response, err := client.Do(req)                   // send the request
-			if err != nil {
-				log.Println("Error while sending the response bytes: ", err)
-				panic(err)
-			}
-			log.Println("Sent request for GetUserLatestData")
-			defer response.Body.Close()
-
-			if response.StatusCode != http.StatusOK { // check if response is not 200 OK
-				log.Println("Response not received correctly.......")
-				log.Println(response.StatusCode)
-			}
-
-			responseBody := new(bytes.Buffer) // read response
-			_, err = responseBody.ReadFrom(response.Body)
-			if err != nil {
-				log.Println("Error while reading the response bytes: ", err)
-				panic(err)
-			}
-
-			payload = append(payload, []byte(responseBody.String())...)
-			payload = append(payload, []byte(`,`)...)
-			count = count + 1
-
+			log.Error("Error fetching latest data from cdn-api: ", err)
+			api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "unable to fetch the latest videos")
+			return
 		}
+
+		payload = append(payload, data...)
+		payload = append(payload, []byte(`,`)...)
+		count = count + 1
 	}
 
 	if count > 0 {
 		payload = payload[:len(payload)-1]
 	}
 	payload = append(payload, []byte(`]}`)...)
-	log.Println("payload in string format = ", string(payload))
+	log.Info("payload in string format = ", string(payload))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(payload)
 }
 
-func GetFollowerData(w http.ResponseWriter, r *http.Request) {
-	res := &Response{}
-	res.IsAvail = false
-	userFollow := &mDB.UserFollower{}
-	err := json.NewDecoder(r.Body).Decode(userFollow)
+// fetchUserLatestData asks cdn-api for a user's latest video and profile, any
+// failure including a non-200 answer is returned rather than passed on to the client
+func fetchUserLatestData(ctx context.Context, userID string) ([]byte, error) {
+	endpoint := CDN_API_URL + "/v1/users/" + url.PathEscape(userID) + "/vibes?limit=1" // url for GetUserVibes
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)                  // request carrying the request ID
 	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
+		return nil, err
 	}
 
-	var payload = []byte(`{"user_follow_data": [`)
-
-	// select rows of user_id that the given user is following
-	rows, err := store.DB.Query("SELECT user_id FROM user_follower WHERE user_id_following = ?;", string(userFollow.UserId))
-
+	client := &http.Client{Timeout: 10 * time.Second, Transport: middleware.PropagateRequestID(tracing.Transport(nil))} // forwards X-Request-ID and trace context
+	response, err := client.Do(req)                                                                                     // send the request
 	if err != nil {
-		log.Println("Error when fetching list of user followings:", err)
-		api.Respond(w, res, http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	defer response.Body.Close()
+	log.Info("Sent request for GetUserVibes")
 
-	// if we get here that means we have videos to send to client
-	defer rows.Close()
-
-	var count = 0
-	for rows.Next() {
-		log.Println("in rows.next")
-
-		var user_id_following sql.NullString
-		err := rows.Scan(&user_id_following)
-
-		if err != nil {
-			panic(err)
-		} else {
-
-			log.Println("Getting latest video and associated metadata for user: ", user_id_following)
-
-			// marshall request data body first
-			body, err := json.Marshal(&FollowerDataRequest{UserId: user_id_following.String})
-			if err != nil {
-				log.Println("Error marshalling request data: ", err)
-				panic(err)
-			}
-
-			url := "https://cdn-api.vibecheck.tech/get-user-latest-data"    // url for GetUserLatestData
-			req, err := http.NewRequest("POST", url, bytes.NewBuffer(body)) // request
-			if err != nil {
-				log.Println("Error building request: ", err)
-				panic(err)
-			}
-			req.Header.Add("Content-Type", "application/json")
-
-			client := &http.Client{Timeout: 10 * time.Second} // create an http client
-			response, err := client.Do(req)                   // send the request
-			if err != nil {
-				log.Println("Error while sending the response bytes: ", err)
-				panic(err)
-			}
-			log.Println("Sent request for GetUserLatestData")
-			defer response.Body.Close()
-
-			if response.StatusCode != http.StatusOK { // check if response is not 200 OK
-				log.Println("Response not received correctly.......")
-				log.Println(response.StatusCode)
-			}
-
-			responseBody := new(bytes.Buffer) // read response
-			_, err = responseBody.ReadFrom(response.Body)
-			if err != nil {
-				log.Println("Error while reading the response bytes: ", err)
-				panic(err)
-			}
-
-			payload = append(payload, []byte(responseBody.String())...)
-			payload = append(payload, []byte(`,`)...)
-			count = count + 1
-
-		}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vibes of %s returned %d", userID, response.StatusCode)
 	}
 
-	if count > 0 {
-		payload = payload[:len(payload)-1]
+	responseBody := new(bytes.Buffer) // read response
+	if _, err = responseBody.ReadFrom(response.Body); err != nil {
+		return nil, err
 	}
-	payload = append(payload, []byte(`]}`)...)
-	log.Println("payload in string format = ", string(payload))
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(payload)
+	return responseBody.Bytes(), nil
 }
 
 func GetFollowingAndFollowerCount(w http.ResponseWriter, r *http.Request) {
-	request := &model.UserRequest{}
-	err := json.NewDecoder(r.Body).Decode(request) // decode request first
-	if err != nil {
-		api.Respond(w, nil, http.StatusBadRequest)
-		return
-	}
+	request := openapi.Request(r).(*mAPI.UserRequest)
+	logging.SetUserID(r.Context(), string(request.UserId))
+	respondFollowCounts(w, r, request.UserId)
+}
 
-	var counts model.FFCounts
+func GetFollowCounts(w http.ResponseWriter, r *http.Request) {
+	respondFollowCounts(w, r, openapi.Request(r).(*mAPI.UserParam).UserId)
+}
 
-	// sql query for getting follower_count and following_count columns
-	q_err := store.DB.QueryRow("select follower_count, following_count from users where user_id = ?;", string(request.UserId)).Scan(&counts.FollowerCount, &counts.FollowingCount)
-	if q_err != nil {
-		log.Println("Error when fetching follower and following counts:", q_err)
-		api.Respond(w, nil, http.StatusInternalServerError)
+func respondFollowCounts(w http.ResponseWriter, r *http.Request, userID string) {
+	// follower_count and following_count columns
+	stored, q_err := repos.Users.Counts(r.Context(), userID)
+	if q_err == repository.ErrNotFound {
+		api.RespondError(w, http.StatusNotFound, api.CodeUserNotFound, "no such user")
 		return
 	}
-	response := struct {
-		Counts model.FFCounts `json:"counts"`
-	}{
-		Counts: counts,
+	if q_err != nil {
+		log.Error("Error when fetching follower and following counts: ", q_err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to count the follows")
+		return
 	}
+	counts := model.FFCounts{FollowerCount: stored.FollowerCount, FollowingCount: stored.FollowingCount}
+	response := mAPI.FollowCountsResponse{Counts: counts}
 
 	jsonData, n_err := json.Marshal(response) // convert to JSON
 	if n_err != nil {
-		log.Println("Error Encountered when marshalling... ", n_err)
-		panic(n_err)
+		log.Error("Error Encountered when marshalling... ", n_err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to encode the counts")
+		return
 	}
 
-	log.Println("response in string format = ", string(jsonData))
+	log.Info("response in string format = ", string(jsonData))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonData)
 }
@@ -0,0 +1,27 @@
+package video
+
+import (
+	"vibe-common/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// upload metrics
+var chunksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "upload_chunks_received_total",
+	Help:      "Upload chunks written to storage.",
+}, []string{"kind"})
+
+var bytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "upload_bytes_received_total",
+	Help:      "Upload bytes written to storage.",
+}, []string{"kind"})
+
+var uploadsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "uploads_completed_total",
+	Help:      "Uploads whose final chunk was received.",
+}, []string{"kind"})
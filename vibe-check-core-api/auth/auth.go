@@ -1,140 +1,115 @@
 package auth
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
 	_ "io/ioutil"
-	"log"
 	"net/http"
-	"vibe/api"
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/openapi"
+	"vibe-common/repository"
 	mAPI "vibe/model/api"
 	model "vibe/model/auth"
 	mDB "vibe/model/db"
-	"vibe/store"
 
 	_ "github.com/thedevsaddam/gojsonq"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// vibe_db access, set by Setup
+var repos *repository.Repos
+
+// Setup hands the handlers their repositories
+func Setup(r *repository.Repos) {
+	repos = r
+}
+
 func Signup(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
 	authStatus := &model.Auth{}
 	authStatus.IsAuth = false
-	// decode creds
-	creds := &mDB.User{}
-	err := json.NewDecoder(r.Body).Decode(creds)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	// Check for empty values
-	if string(creds.UserName) == "" || string(creds.Password) == "" || string(creds.Phone) == "" {
-		log.Println("Empty field(s)")
-		api.Respond(w, authStatus, http.StatusBadRequest)
-		return
-	}
+	// creds are decoded and checked for empty values by the openapi middleware
+	creds := openapi.Request(r).(*mAPI.SignupRequest)
 
 	// Query db for existing user
-	result := store.DB.QueryRow("SELECT user_id FROM users WHERE user_name=? OR phone=?", string(creds.UserName), string(creds.Phone))
-	if err != nil {
-		log.Println("error checking if user exists")
-		api.Respond(w, authStatus, http.StatusInternalServerError)
+	if _, err := repos.Users.IDByUserNameOrPhone(r.Context(), creds.UserName, creds.Phone); err == nil {
+		reqLog.Info("User already exists")
+		api.RespondError(w, http.StatusConflict, api.CodeUsernameTaken, "username or phone is already registered")
 		return
-	}
-	storedCreds := &mDB.User{}
-	if err := result.Scan(&storedCreds.UserId); err == nil {
-		log.Println("User already exists")
-		api.Respond(w, authStatus, http.StatusConflict)
-		return
-	} else if err == sql.ErrNoRows {
-		log.Println("Username available")
+	} else if err == repository.ErrNotFound {
+		reqLog.Info("Username available")
 		//salt and hash password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), 8)
 		if err != nil {
-			log.Println("error hash")
-			w.WriteHeader(http.StatusInternalServerError)
+			reqLog.Error("hashing the password failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to hash the password")
 			return
 		}
-		log.Println(hashedPassword)
 		// insert creds into db
-		if _, err = store.DB.Query(`INSERT into users (user_id, user_name, password, phone, photo) VALUES (?, ?, ?, ?, ?)`, string(GenerateUUID()), string(creds.UserName), string(hashedPassword), string(creds.Phone), false); err != nil {
+		user := repository.User{UserID: GenerateUUID(), UserName: creds.UserName, Password: string(hashedPassword), Phone: creds.Phone}
+		if err = repos.Users.Create(r.Context(), user); err != nil {
 			// if issue with insert return error
-			log.Println("error store")
-			log.Println(err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			reqLog.Error("storing the user failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to create the user")
 			return
 		}
 		// set session
-		SetSession(w, creds.UserName)
+		if err = SetSession(w, r, user.UserID); err != nil {
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to start a session")
+			return
+		}
 		// if we reach this point, user password is set and default 200 status is sent
 
-		log.Printf("Successfully signed up")
-		fmt.Printf("User creds are: %+v", creds) // authStatus.IsAuth = true
+		reqLog.Infof("Successfully signed up")
 		authStatus = &model.Auth{
 			IsAuth: true,
 			User: mAPI.User{
-				UserId:   creds.UserId,
+				UserId:   user.UserID,
 				UserName: creds.UserName,
 				Phone:    creds.Phone,
 			},
 		}
 		api.Respond(w, authStatus, http.StatusCreated)
 	} else {
-		log.Println("Bad DB query")
-		log.Println(err)
-		api.Respond(w, authStatus, http.StatusInternalServerError)
+		reqLog.Error("looking up the user failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to look up the user")
 		return
 	}
 }
 
 func UpdatePassword(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
 	authStatus := &model.Auth{}
 	authStatus.IsAuth = false
-	// decode creds
-	creds := &mDB.User{}
-	err := json.NewDecoder(r.Body).Decode(creds)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	// Check for empty values
-	if string(creds.Password) == "" || string(creds.Phone) == "" {
-		log.Println("Empty field(s)")
-		api.Respond(w, authStatus, http.StatusBadRequest)
-		return
-	}
+	// creds are decoded and checked for empty values by the openapi middleware
+	creds := openapi.Request(r).(*mAPI.UpdatePasswordRequest)
 
 	// Query db for existing user
-	result := store.DB.QueryRow("SELECT user_id FROM users WHERE phone=?", string(creds.Phone))
-	if err != nil {
-		api.Respond(w, authStatus, http.StatusInternalServerError)
-		return
-	}
+	var err error
 	storedCreds := &mDB.User{}
-	if err := result.Scan(&storedCreds.UserId); err == nil {
-		log.Println("User exists")
+	if storedCreds.UserId, err = repos.Users.IDByPhone(r.Context(), creds.Phone); err == nil {
+		reqLog.Info("User exists")
 		//salt and hash password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), 8)
 		if err != nil {
-			log.Println("error hash")
-			w.WriteHeader(http.StatusInternalServerError)
+			reqLog.Error("hashing the password failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to hash the password")
 			return
 		}
-		log.Println(hashedPassword)
 		// insert creds into db
-		if _, err = store.DB.Query(`UPDATE users SET password = ? WHERE user_id = ?`, string(hashedPassword), string(storedCreds.UserId)); err != nil {
+		if err = repos.Users.UpdatePassword(r.Context(), storedCreds.UserId, string(hashedPassword)); err != nil {
 			// if issue with insert return error
-			log.Println("error store")
-			log.Println(err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			reqLog.Error("storing the password failed: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to update the password")
 			return
 		}
 		// set session
-		SetSession(w, storedCreds.UserName)
+		if err = SetSession(w, r, storedCreds.UserId); err != nil {
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to start a session")
+			return
+		}
 		// if we reach this point, user password is set and default 200 status is sent
 
-		log.Printf("Successfully reset password")
-		// fmt.Printf("User creds are: %+v", creds) // authStatus.IsAuth = true
+		reqLog.Infof("Successfully reset password")
 		authStatus = &model.Auth{
 			IsAuth: true,
 			User: mAPI.User{
@@ -145,72 +120,58 @@ func UpdatePassword(w http.ResponseWriter, r *http.Request) {
 		}
 		api.Respond(w, authStatus, http.StatusCreated)
 
-	} else if err == sql.ErrNoRows {
-		log.Println("User does not exist")
-		api.Respond(w, authStatus, http.StatusConflict)
+	} else if err == repository.ErrNotFound {
+		reqLog.Info("User does not exist")
+		api.RespondError(w, http.StatusConflict, api.CodeUserNotFound, "Phone number not associated with any account", api.FieldError{Field: "phone", Message: "is not associated with any account"})
 		return
 
 	} else {
-		log.Println("Bad DB query")
-		log.Println(err)
-		api.Respond(w, authStatus, http.StatusInternalServerError)
+		reqLog.Error("looking up the user failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to look up the user")
 		return
 	}
 }
 
 func Signin(w http.ResponseWriter, r *http.Request) {
+	reqLog := logging.FromContext(r.Context())
 	authStatus := &model.Auth{}
 	authStatus.IsAuth = false
-	creds := &mDB.User{}
-	err := json.NewDecoder(r.Body).Decode(creds)
+	// creds are decoded and checked for empty values by the openapi middleware
+	creds := openapi.Request(r).(*mAPI.LoginRequest)
+	// Query db for user and obtain stored password
+	storedCreds, err := repos.Users.ByUserName(r.Context(), creds.UserName)
 	if err != nil {
-		api.Respond(w, authStatus, http.StatusBadRequest)
-		return
-	}
-	// Check for empty values
-	if string(creds.UserName) == "" || string(creds.Password) == "" {
-		log.Println("Empty field(s)")
-		api.Respond(w, authStatus, http.StatusBadRequest)
-		return
-	}
-	// Query db for user
-	result := store.DB.QueryRow("SELECT user_id, user_name, password FROM users WHERE user_name=?", string(creds.UserName))
-	if err != nil {
-		api.Respond(w, authStatus, http.StatusInternalServerError)
-	}
-	// Obtain stored password
-	storedCreds := &mDB.User{}
-	err = result.Scan(&storedCreds.UserId, &storedCreds.UserName, &storedCreds.Password)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			println("Username not found")
-			api.Respond(w, authStatus, http.StatusUnauthorized)
+		if err == repository.ErrNotFound {
+			reqLog.Info("Username not found")
+			api.RespondError(w, http.StatusUnauthorized, api.CodeInvalidCredentials, "username or password is incorrect")
 			return
 		}
-		log.Println("Bad DB query")
-		log.Println(err)
-		api.Respond(w, authStatus, http.StatusInternalServerError)
+		reqLog.Error("looking up the user failed: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to look up the user")
 		return
 	}
 	// Compare stored hashed with hashed version of received password
 	if err = bcrypt.CompareHashAndPassword([]byte(storedCreds.Password), []byte(creds.Password)); err != nil {
 		// If passwords don't match return 401
-		log.Println("Incorrect password")
-		api.Respond(w, authStatus, http.StatusUnauthorized)
+		reqLog.Info("Incorrect password")
+		api.RespondError(w, http.StatusUnauthorized, api.CodeInvalidCredentials, "username or password is incorrect")
 		return
 	}
 	authStatus = &model.Auth{
 		IsAuth: true,
 		User: mAPI.User{
-			UserId:   storedCreds.UserId,
+			UserId:   storedCreds.UserID,
 			UserName: storedCreds.UserName,
 			Phone:    storedCreds.Phone,
 		},
 	}
 	// set session
-	SetSession(w, authStatus.User.UserId)
+	if err = SetSession(w, r, authStatus.User.UserId); err != nil {
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to start a session")
+		return
+	}
 	// if we reach this point, user password is correct and default 200 status is sent
-	log.Println("Successfully signed in")
+	reqLog.Info("Successfully signed in")
 	api.Respond(w, authStatus, http.StatusOK)
 }
 
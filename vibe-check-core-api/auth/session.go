@@ -1,57 +1,54 @@
 package auth
 
 import (
-	"log"
 	"net/http"
 	"time"
-	"vibe/api"
+	"vibe-common/api"
+	"vibe-common/session"
 	model "vibe/model/auth"
 	"vibe/store"
+
+	log "github.com/sirupsen/logrus"
 )
 
-func SetSession(w http.ResponseWriter, username string) {
+// SetSession stores a new session for the user and sets its cookie, the caller answers the request
+func SetSession(w http.ResponseWriter, r *http.Request, userID string) error {
 	sessionToken := GenerateUUID()
-	conn := store.Cache.Get()
-	_, err := conn.Do("SETEX", sessionToken, "1800", username)
+	conn := store.CacheConn(r.Context())
 	defer conn.Close()
+	_, err := conn.Do("SETEX", sessionToken, "1800", userID)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		log.Error(err)
+		return err
 	}
 
 	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
+		Name:     session.Cookie,
 		Value:    sessionToken,
 		Path:     "/",
 		SameSite: http.SameSiteStrictMode,
 		HttpOnly: true,
 		Expires:  time.Now().Add(1800 * time.Second),
 	})
+	return nil
 }
 
+// RemoveSession ends the session, behind RequireAuth
 func RemoveSession(w http.ResponseWriter, r *http.Request) {
 	authStatus := &model.Auth{}
 	authStatus.IsAuth = false
-	c, err := r.Cookie("session_token")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			api.Respond(w, authStatus, http.StatusUnauthorized)
-			return
-		}
-	}
 	// remove session from cache
-	conn := store.Cache.Get()
-	_, err = conn.Do("DEL", string(c.Value))
+	conn := store.CacheConn(r.Context())
 	defer conn.Close()
+	_, err := conn.Do("DEL", session.Token(r))
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Error(err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to remove the session")
 		return
 	}
 	// remove session from browser
 	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
+		Name:     session.Cookie,
 		Value:    "",
 		Path:     "/",
 		SameSite: http.SameSiteDefaultMode,
@@ -1,9 +1,10 @@
 package auth
 
 import (
-	"fmt"
 	"net/http"
-	"vibe/api"
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/session"
 	model "vibe/model/auth"
 	"vibe/store"
 
@@ -14,66 +15,40 @@ func GenerateUUID() string {
 	return uuid.NewString()
 }
 
-// Middleware for validating authentication for API access
+// Middleware for validating authentication for API access, the handler reads the
+// session's user with session.UserID
 func RequireAuth(next http.HandlerFunc) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authStatus := &model.Auth{}
-		authStatus.IsAuth = false
-		c, err := r.Cookie("session_token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				api.Respond(w, authStatus, http.StatusUnauthorized)
-				return
-			}
-			api.Respond(w, authStatus, http.StatusBadRequest)
-			return
-		}
-		sessionToken := c.Value
-
-		conn := store.Cache.Get()
-		res, err := conn.Do("GET", sessionToken)
-		defer conn.Close()
-		if err != nil {
-			api.Respond(w, authStatus, http.StatusInternalServerError)
-			return
-		}
-		if res == nil {
-			api.Respond(w, authStatus, http.StatusUnauthorized)
-			return
-		}
-		//authStatus.IsAuth = false
-		//ctx := context.WithValue(r.Context(), "is_auth", authStatus.IsAuth)
-		//next.ServeHTTP(w, r.WithContext(ctx))
-		next.ServeHTTP(w, r)
+	return session.Require(store.CacheConn)(next)
+}
 
-	})
+// LookupSession returns the user of the request's live session, session.ErrNoSession without
+// one. The routes from before v1 check the session with it and answer in their old shape.
+func LookupSession(r *http.Request) (string, error) {
+	conn := store.CacheConn(r.Context())
+	defer conn.Close()
+	return session.Lookup(conn, session.Token(r))
 }
 
+// IsAuthenticated answers 200 for a live session, behind RequireAuth
 func IsAuthenticated(w http.ResponseWriter, r *http.Request) {
 	authStatus := &model.Auth{}
-	authStatus.IsAuth = false
-	c, err := r.Cookie("session_token")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			api.Respond(w, authStatus, http.StatusUnauthorized)
-			return
-		}
-		api.Respond(w, authStatus, http.StatusBadRequest)
-		return
-	}
-	sessionToken := c.Value
-	fmt.Println(sessionToken)
-	conn := store.Cache.Get()
-	res, err := conn.Do("GET", sessionToken)
-	defer conn.Close()
-	if err != nil {
-		api.Respond(w, authStatus, http.StatusInternalServerError)
-		return
-	}
-	if res == nil {
-		api.Respond(w, authStatus, http.StatusUnauthorized)
-		return
-	}
 	authStatus.IsAuth = true
 	api.Respond(w, authStatus, http.StatusOK)
 }
+
+// IsAuthenticatedLegacy answers /isauth as it did before v1: {"isAuth": false} with a 401
+// without a live session, where RequireAuth answers the error envelope
+func IsAuthenticatedLegacy(w http.ResponseWriter, r *http.Request) {
+	authStatus := &model.Auth{}
+	_, err := LookupSession(r)
+	switch {
+	case err == session.ErrNoSession:
+		api.Respond(w, authStatus, http.StatusUnauthorized)
+	case err != nil:
+		logging.FromContext(r.Context()).Error("Unable to read session: ", err)
+		api.Respond(w, authStatus, http.StatusInternalServerError)
+	default:
+		authStatus.IsAuth = true
+		api.Respond(w, authStatus, http.StatusOK)
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vibe/store"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// sessions is a Redis connection that knows the session "live" of alice and fails on "broken"
+type sessions struct{}
+
+func (sessions) Close() error { return nil }
+func (sessions) Err() error   { return nil }
+func (sessions) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "GET" {
+		return nil, nil
+	}
+	switch args[0] {
+	case "live":
+		return []byte("alice"), nil
+	case "broken":
+		return nil, errors.New("connection refused")
+	}
+	return nil, nil
+}
+func (sessions) Send(cmd string, args ...interface{}) error { return nil }
+func (sessions) Flush() error                               { return nil }
+func (sessions) Receive() (interface{}, error)              { return nil, nil }
+
+func TestIsAuthenticatedLegacy(t *testing.T) {
+	store.Cache = &redis.Pool{Dial: func() (redis.Conn, error) { return sessions{}, nil }}
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		isAuth bool
+	}{
+		{"no cookie", "", http.StatusUnauthorized, false},
+		{"expired session", "gone", http.StatusUnauthorized, false},
+		{"session store down", "broken", http.StatusInternalServerError, false},
+		{"live session", "live", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/isauth", nil)
+		if tt.token != "" {
+			r.AddCookie(&http.Cookie{Name: "session_token", Value: tt.token})
+		}
+		w := httptest.NewRecorder()
+		IsAuthenticatedLegacy(w, r)
+		// the body is the Auth of the pre-v1 route, never the error envelope
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.status || body["is_auth"] != tt.isAuth || body["error"] != nil {
+			t.Errorf("%s: /isauth = %d %s, want %d with is_auth %t", tt.name, w.Code, w.Body, tt.status, tt.isAuth)
+		}
+	}
+}
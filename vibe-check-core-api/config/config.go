@@ -1,43 +1,44 @@
 package config
 
-import (
-	"fmt"
-
-	"github.com/tkanos/gonfig"
-)
+import vcconfig "vibe-common/config"
 
 type Configuration struct {
-	APP_ENV           string
-	VIBE_PORT         string
-	REDIS_HOST        string
-	REDIS_PORT        string
-	MARIA_DB_USERNAME string
-	MARIA_DB_PASSWORD string
-	MARIA_DB_PORT     string
-	MARIA_DB_HOST     string
-	MONGO_USER        string
-	MONGO_PASS        string
-	MONGO_ARGS        string
-	MONGO_HOST        string
-	MONGO_PORT        string
-	UPLOADS_LOCATION  string
+	vcconfig.Base
+	vcconfig.Server
+	vcconfig.Health
+	vcconfig.Tracing
+	vcconfig.MariaDB
+	vcconfig.Redis
+	VIBE_PORT        string `required:"true"`
+	MONGO_USER       string
+	MONGO_PASS       string `secret:"true"`
+	MONGO_ARGS       string
+	MONGO_HOST       string
+	MONGO_PORT       string
+	UPLOADS_LOCATION string `required:"true"`
+
+	// base URL of cdn-api, asked for the latest vibe of followed users
+	CDN_API_URL string `default:"https://cdn-api.vibecheck.tech"`
+
+	// Twilio phone verification
+	TWILIO_SERVICE_SID string
+	TWILIO_ACCOUNT_SID string
+	TWILIO_AUTH_TOKEN  string `secret:"true"`
 }
 
-var ENV string
 var CONFIGURATION Configuration
 
-func InitConfig(params ...string) Configuration {
-	configuration := Configuration{}
-	ENV = "dev"
-	if len(params) > 0 {
-		ENV = params[0]
-	}
-	fileName := fmt.Sprintf("./config/%s_config.json", ENV) // filepath
-	gonfig.GetConf(fileName, &configuration)
-	CONFIGURATION = configuration
-	return configuration
+// Defaults give an upload chunk 5 minutes to arrive, about 37 MB on a 1 Mbit/s uplink
+func (Configuration) Defaults() map[string]string {
+	return map[string]string{"SERVER_READ_TIMEOUT": "5m", "SERVER_WRITE_TIMEOUT": "5m"}
+}
+
+// Loads and validates the configuration from defaults, ./config/<APP_ENV>_config.json, env and *_FILE secrets
+func InitConfig() error {
+	return vcconfig.Load(&CONFIGURATION)
 }
 
+// Logs the configuration in use with secret values redacted
 func PrintConfig() {
-	fmt.Println("Using " + ENV + " config... using " + "./config/" + ENV + "_config.json")
+	vcconfig.Print(CONFIGURATION)
 }
@@ -3,15 +3,20 @@ module vibe
 go 1.15
 
 require (
-	github.com/ghodss/yaml v1.0.0 // indirect
 	github.com/gin-gonic/gin v1.8.2
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gomodule/redigo v1.8.5
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
-	github.com/joho/godotenv v1.4.0
 	github.com/thedevsaddam/gojsonq v2.3.0+incompatible
-	github.com/tkanos/gonfig v0.0.0-20210106201359-53e13348de2f // indirect
-	go.mongodb.org/mongo-driver v1.11.1 // indirect
+	go.mongodb.org/mongo-driver v1.11.1
 	golang.org/x/crypto v0.4.0
 )
+
+require (
+	github.com/prometheus/client_golang v1.11.1
+	github.com/sirupsen/logrus v1.9.0
+	vibe-common v0.0.0
+)
+
+replace vibe-common => ../vibe-common
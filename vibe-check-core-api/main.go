@@ -1,28 +1,39 @@
 package main
 
 import (
+	"context"
 	_ "encoding/json"
-	"errors"
-	"fmt"
 	_ "io/ioutil"
 	"net/http"
 	"os"
 	_ "time"
 
-	"log"
-	"vibe/api"
+	"vibe-common/api"
+	"vibe-common/health"
+	"vibe-common/logging"
+	"vibe-common/metrics"
+	"vibe-common/middleware"
+	"vibe-common/migrate"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/server"
+	"vibe-common/session"
+	"vibe-common/tracing"
 	"vibe/api/subscriber"
 	"vibe/api/twilio"
 	"vibe/api/user"
 	"vibe/api/video"
 	"vibe/auth"
 	"vibe/config"
+	mAPI "vibe/model/api"
+	mAuth "vibe/model/auth"
+	mDB "vibe/model/db"
 	"vibe/store"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	log "github.com/sirupsen/logrus"
 	_ "github.com/thedevsaddam/gojsonq"
 )
 
@@ -47,107 +58,183 @@ func Test(w http.ResponseWriter, r *http.Request) {
 	api.Respond(w, res, http.StatusOK)
 }
 
-// Requests
-func handleAuthRequests(r *mux.Router) {
-	r.HandleFunc("/isauth", auth.IsAuthenticated).Methods("GET")
-	r.HandleFunc("/signup", auth.Signup).Methods("POST")
-	r.HandleFunc("/update-password", auth.UpdatePassword).Methods("POST")
-	r.HandleFunc("/login", auth.Signin).Methods("POST")
-	r.HandleFunc("/verify-phone-num", twilio.VerifyPhoneNumber).Methods("POST")
-	r.HandleFunc("/pass-rec-verify-phone-num", twilio.PasswordRecoveryVerifyPhoneNumber).Methods("POST")
-	r.HandleFunc("/verify-phone-code", twilio.VerifyCode).Methods("POST")
-	r.HandleFunc("/username-check", user.UsernameAvailablityCheck).Methods("POST")
-	r.Handle("/signout", auth.RequireAuth(auth.RemoveSession)).Methods("POST")
-	r.HandleFunc("/test-no-auth", Test).Methods("GET")
-	r.Handle("/test-auth", auth.RequireAuth(Test)).Methods("GET")
-	// possibly should go through RequireAuth route
-	r.HandleFunc("/user-info", user.GetUserInfo).Methods("GET")
-	r.HandleFunc("/chunk-upload", video.ChunkUploadHandler).Methods("POST")
-	r.HandleFunc("/videos/{latitude}/{longitude}", video.GetLatestVideo).Methods("GET")
-	r.HandleFunc("/set-delete-status", user.SetDeleteStatus).Methods("POST")
-	r.HandleFunc("/set-user-following", user.SetUserFollowing).Methods("POST")
-	r.HandleFunc("/set-user-unfollowing", user.SetUserUnfollowing).Methods("POST")
-	r.HandleFunc("/get-following-data", user.GetFollowingData).Methods("POST")
-	r.HandleFunc("/get-follower-data", user.GetFollowerData).Methods("POST")
-	r.HandleFunc("/get-follower-following-count", user.GetFollowingAndFollowerCount).Methods("POST")
-	r.HandleFunc("/subscribe", subscriber.Subscribe).Methods("POST")
+// Resource routes of the v1 API, each documented in the OpenAPI spec and validated against its request type
+func handleV1Requests(r *mux.Router, spec *openapi.Spec) {
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/v1/users", Summary: "Create an account and start a session",
+		Request: mAPI.SignupRequest{}, Response: mAuth.Auth{}, Status: http.StatusCreated}, auth.Signup)
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/v1/usernames/{user_name}", Summary: "Report whether a username is free, 409 when taken",
+		Request: mAPI.UsernameParam{}, Response: user.Response{}}, user.GetUsername)
+	spec.HandleFunc(r, openapi.Route{Method: "PUT", Path: "/v1/password", Summary: "Reset the password of the account with a verified phone",
+		Request: mAPI.UpdatePasswordRequest{}, Response: mAuth.Auth{}, Status: http.StatusCreated}, auth.UpdatePassword)
+
+	// Sessions
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/v1/session", Summary: "Start a session",
+		Request: mAPI.LoginRequest{}, Response: mAuth.Auth{}}, auth.Signin)
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/session", Summary: "Report whether the session is valid",
+		Response: mAuth.Auth{}, Session: session.Cookie}, auth.RequireAuth(auth.IsAuthenticated))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/session", Summary: "End the session",
+		Response: mAuth.Auth{}, Session: session.Cookie}, auth.RequireAuth(auth.RemoveSession))
+
+	// Phone verification
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/v1/verifications", Summary: "Send a verification code to a phone not yet registered",
+		Request: mAPI.PhoneRequest{}, Response: map[string]interface{}{}}, twilio.VerifyPhoneNumber)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/v1/password-recoveries", Summary: "Send a password recovery code to a registered phone",
+		Request: mAPI.PhoneRequest{}, Response: map[string]interface{}{}}, twilio.PasswordRecoveryVerifyPhoneNumber)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/v1/verification-checks", Summary: "Check a verification code",
+		Request: mAPI.VerifyCodeRequest{}, Response: map[string]interface{}{}}, twilio.VerifyCode)
+
+	// The account of the session
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/v1/me", Summary: "Read the account of the session",
+		Response: mAPI.User{}, Session: session.Cookie}, auth.RequireAuth(user.GetMe))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/me", Summary: "Delete the account of the session and everything it posted",
+		Response: user.Response{}, Session: session.Cookie}, auth.RequireAuth(user.DeleteMe))
+	spec.Handle(r, openapi.Route{Method: "PUT", Path: "/v1/me/following/{user_id}", Summary: "Follow a user",
+		Request: mAPI.UserParam{}, Response: user.Response{}, Session: session.Cookie}, auth.RequireAuth(user.Follow))
+	spec.Handle(r, openapi.Route{Method: "DELETE", Path: "/v1/me/following/{user_id}", Summary: "Unfollow a user",
+		Request: mAPI.UserParam{}, Response: user.Response{}, Session: session.Cookie}, auth.RequireAuth(user.Unfollow))
+
+	// Users
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/v1/users/{user_id}/following", Summary: "Read the latest videos of the users a user follows",
+		Request: mAPI.UserParam{}, Response: mAPI.FollowDataResponse{}}, user.GetFollowing)
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/v1/users/{user_id}/followers", Summary: "Read the latest videos of a user's followers",
+		Request: mAPI.UserParam{}, Response: mAPI.FollowDataResponse{}}, user.GetFollowers)
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/v1/users/{user_id}/follow-counts", Summary: "Count a user's followers and followings",
+		Request: mAPI.UserParam{}, Response: mAPI.FollowCountsResponse{}}, user.GetFollowCounts)
+
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/v1/subscribers", Summary: "Subscribe an email to the newsletter",
+		Request: mAPI.SubscribeRequest{}, Status: http.StatusCreated}, subscriber.Subscribe)
+}
+
+// Routes from before v1, kept as deprecated aliases until the clients have moved over
+func handleAuthRequests(r *mux.Router, spec *openapi.Spec) {
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/isauth", Summary: "Report whether the session is valid",
+		Response: mAuth.Auth{}, Session: session.Cookie, Deprecated: true, Successor: "/v1/session"}, auth.IsAuthenticatedLegacy)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/signup", Summary: "Create an account and start a session",
+		Request: mAPI.SignupRequest{}, Response: mAuth.Auth{}, Status: http.StatusCreated, Deprecated: true, Successor: "/v1/users"}, auth.Signup)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/update-password", Summary: "Reset the password of the account with a verified phone",
+		Request: mAPI.UpdatePasswordRequest{}, Response: mAuth.Auth{}, Status: http.StatusCreated, Deprecated: true, Successor: "/v1/password"}, auth.UpdatePassword)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/login", Summary: "Start a session",
+		Request: mAPI.LoginRequest{}, Response: mAuth.Auth{}, Deprecated: true, Successor: "/v1/session"}, auth.Signin)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/verify-phone-num", Summary: "Send a verification code to a phone not yet registered",
+		Request: mAPI.PhoneRequest{}, Response: map[string]interface{}{}, Deprecated: true, Successor: "/v1/verifications"}, twilio.VerifyPhoneNumber)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/pass-rec-verify-phone-num", Summary: "Send a password recovery code to a registered phone",
+		Request: mAPI.PhoneRequest{}, Response: map[string]interface{}{}, Deprecated: true, Successor: "/v1/password-recoveries"}, twilio.PasswordRecoveryVerifyPhoneNumber)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/verify-phone-code", Summary: "Check a verification code",
+		Request: mAPI.VerifyCodeRequest{}, Response: map[string]interface{}{}, Deprecated: true, Successor: "/v1/verification-checks"}, twilio.VerifyCode)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/username-check", Summary: "Report whether a username is free",
+		Request: mAPI.UsernameRequest{}, Response: user.Response{}, Deprecated: true, Successor: "/v1/usernames/{user_name}"}, user.UsernameAvailablityCheck)
+	spec.Handle(r, openapi.Route{Method: "POST", Path: "/signout", Summary: "End the session",
+		Response: mAuth.Auth{}, Session: session.Cookie, Deprecated: true, Successor: "/v1/session"}, auth.RequireAuth(auth.RemoveSession))
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/test-no-auth", Summary: "Test route",
+		Response: Res{}}, Test)
+	spec.Handle(r, openapi.Route{Method: "GET", Path: "/test-auth", Summary: "Test route behind a session",
+		Response: Res{}, Session: session.Cookie}, auth.RequireAuth(Test))
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/user-info", Summary: "Read the user of the session",
+		Response: mDB.Customer{}, Status: http.StatusAccepted, Session: session.Cookie, Deprecated: true, Successor: "/v1/me"}, user.GetUserInfo)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/chunk-upload", Summary: "Upload one chunk of a file",
+		Request: mAPI.ChunkUploadRequest{}}, video.ChunkUploadHandler)
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/videos/{latitude}/{longitude}", Summary: "Read the latest video at a location from the old video table, cdn-api serves the feeds",
+		Request: mAPI.LatestVideoRequest{}, Response: mAPI.Video{}, Deprecated: true}, video.GetLatestVideo)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/set-delete-status", Summary: "Delete a user and everything they posted",
+		Request: mAPI.DeleteUserRequest{}, Response: user.Response{}, Deprecated: true, Successor: "/v1/me"}, user.SetDeleteStatus)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/set-user-following", Summary: "Follow a user",
+		Request: mAPI.UserFollowRequest{}, Response: user.Response{}, Deprecated: true, Successor: "/v1/me/following/{user_id}"}, user.SetUserFollowing)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/set-user-unfollowing", Summary: "Unfollow a user",
+		Request: mAPI.UserFollowRequest{}, Response: user.Response{}, Deprecated: true, Successor: "/v1/me/following/{user_id}"}, user.SetUserUnfollowing)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/get-following-data", Summary: "Read the latest videos of the users a user follows",
+		Request: mAPI.UserRequest{}, Response: mAPI.FollowDataResponse{}, Deprecated: true, Successor: "/v1/users/{user_id}/following"}, user.GetFollowingData)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/get-follower-data", Summary: "Read the latest videos of a user's followers",
+		Request: mAPI.UserRequest{}, Response: mAPI.FollowDataResponse{}, Deprecated: true, Successor: "/v1/users/{user_id}/followers"}, user.GetFollowerData)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/get-follower-following-count", Summary: "Count a user's followers and followings",
+		Request: mAPI.UserRequest{}, Response: mAPI.FollowCountsResponse{}, Deprecated: true, Successor: "/v1/users/{user_id}/follow-counts"}, user.GetFollowingAndFollowerCount)
+	spec.HandleFunc(r, openapi.Route{Method: "POST", Path: "/subscribe", Summary: "Subscribe an email to the newsletter",
+		Request: mAPI.SubscribeRequest{}, Status: http.StatusCreated, Deprecated: true, Successor: "/v1/subscribers"}, subscriber.Subscribe)
+}
+
+// Health
+func handleHealthRequests(r *mux.Router) {
+	checks := health.New(config.CONFIGURATION.READINESS_TIMEOUT)
+	checks.Add("mariadb", health.DB(store.DB))
+	checks.Add("redis", health.Redis(store.Cache))
+	checks.Add("storage", health.Writable(config.CONFIGURATION.UPLOADS_LOCATION))
+	r.HandleFunc("/healthz", checks.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", checks.Readiness).Methods("GET")
+}
+
+// Metrics
+func handleMetricsRequests(r *mux.Router) {
+	metrics.RegisterDB("vibe_db", store.DB)
+	metrics.RegisterCache("cache", store.Cache)
+	r.Use(metrics.Middleware)
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
 }
 
 func main() {
 
-	// Load Environemnt Variables
-	err := godotenv.Load()
+	// Load and validate configuration, every missing or invalid key is reported at once
+	err := config.InitConfig()
 	if err != nil {
-		log.Fatal("error loading .env file")
+		log.Fatal(err)
 	}
 
-	// overwrite APP_ENV with environment variable if it exists, otherwise it should defualt to "test"
-	APP_ENV := os.Getenv("APP_ENV")
-	config.InitConfig(APP_ENV)
+	// Build log file and set log level
+	logging.Setup(config.CONFIGURATION.LoggingConfig())
 	config.PrintConfig()
 
-	log.Printf("CONFIGURATION: %+v\n", config.CONFIGURATION)
-
-	// if we get here and APP_ENV is still an empty string, kill program.
-	if APP_ENV == "" {
-		log.Fatal("APP_ENV not found!")
+	// "migrate up|down [steps]|status" manages the vibe_db schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		// only the migrations asked for run, MIGRATE_ON_STARTUP must not apply every pending one first
+		config.CONFIGURATION.MIGRATE_ON_STARTUP = false
+		store.InitDB()
+		err = migrate.Run(context.Background(), store.DB, os.Args[2:])
+		store.Cleanup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Build log file
-	file, err := os.OpenFile("log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	// Spans for handlers, queries and outbound calls, flushed last on shutdown
+	stopTracing, err := tracing.Setup(config.CONFIGURATION.TracingConfig("core-api"))
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Fatal(err)
 	}
-	log.SetOutput(file)
-	log.Println("STARTING LOG")
-	log.Println("APP_ENV: " + APP_ENV)
 
 	// Requests
 	r := mux.NewRouter()
-	handleAuthRequests(r)
-	log.Println("past handleAuthRequests")
+	r.Use(tracing.Middleware("core-api"), middleware.RequestID, middleware.AccessLog, middleware.Recover)
+	r.NotFoundHandler = http.HandlerFunc(api.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(api.MethodNotAllowed)
+	spec := openapi.New("core-api", "1.0.0")
+	handleV1Requests(r, spec)
+	handleAuthRequests(r, spec)
+	r.Handle("/openapi.json", spec).Methods("GET")
+	log.Debug("past handleAuthRequests")
 
 	// Initialize DB Connection
 	store.InitDB()
-	log.Println("past InitDB")
+	log.Debug("past InitDB")
+
+	// Hand the handlers their repositories
+	repos := repository.NewMariaDB(store.DB, config.CONFIGURATION.MARIA_DB_QUERY_TIMEOUT)
+	auth.Setup(repos)
+	user.Setup(repos)
+	twilio.Setup(repos)
+	subscriber.Setup(repos)
 
 	// Initialize Cache Connection
 	store.InitCache()
-	log.Println("past InitCache")
-
-	var VIBE_PORT = config.CONFIGURATION.VIBE_PORT
-	// fmt.Printf("Starting server on %v\n", VIBE_PORT)
-	// var VIBE_PORT = config.CONFIGURATION.VIBE_PORT
-	log.Printf("VIBE_PORT: %s\n", VIBE_PORT)
-	if VIBE_PORT == "" {
-		log.Fatal("VIBE_PORT is not set!")
-	}
+	log.Debug("past InitCache")
 
+	// Liveness and readiness
+	handleHealthRequests(r)
 
-	// Serve
-	if APP_ENV == "prod" {
-		err = http.ListenAndServeTLS(VIBE_PORT, "/certs/fullchain.pem", "/certs/key.pem", r) // Blocking function
-	} else {
-		log.Println("in listen and serve")
-		err = http.ListenAndServe(VIBE_PORT, r) // Blocking function
-	}
-
-	if errors.Is(err, http.ErrServerClosed) {
-		fmt.Printf("server closed\n")
-	} else if err != nil {
-		fmt.Printf("error starting server: %s\n", err)
-		os.Exit(1)
-	}
-}
+	// Prometheus metrics
+	handleMetricsRequests(r)
 
-func GetConfig() {
-	panic("unimplemented")
-}
-
-// addHeaders will act as middleware to give us CORS support
-func addHeaders(h http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		h.ServeHTTP(w, r)
+	// Serve
+	err = server.Run(config.CONFIGURATION.ServerConfig(config.CONFIGURATION.VIBE_PORT), r, store.Cleanup, stopTracing)
+	if err != nil {
+		log.Fatal("Server exited with error: ", err)
 	}
 }
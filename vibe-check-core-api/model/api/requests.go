@@ -0,0 +1,84 @@
+package model
+
+import (
+	"mime/multipart"
+
+	mDB "vibe/model/db"
+)
+
+// Request bodies and parameters, validated by the openapi middleware before the handler runs
+
+type SignupRequest struct {
+	UserName string `json:"user_name" required:"true"`
+	Password string `json:"password" required:"true"`
+	Phone    string `json:"phone" required:"true"`
+}
+
+type UpdatePasswordRequest struct {
+	Password string `json:"password" required:"true" doc:"new password"`
+	Phone    string `json:"phone" required:"true" doc:"phone of the account, verified beforehand"`
+}
+
+type LoginRequest struct {
+	UserName string `json:"user_name" required:"true"`
+	Password string `json:"password" required:"true"`
+}
+
+type PhoneRequest struct {
+	Phone string `json:"phone" required:"true" doc:"E.164 number the code is sent to"`
+}
+
+type VerifyCodeRequest struct {
+	Phone string `json:"phone" required:"true"`
+	Code  string `json:"code" required:"true" doc:"code received by SMS"`
+}
+
+type UsernameRequest struct {
+	UserName string `json:"user_name" required:"true"`
+}
+
+type UsernameParam struct {
+	UserName string `path:"user_name"`
+}
+
+type UserRequest struct {
+	UserId string `json:"user_id" required:"true"`
+}
+
+type UserParam struct {
+	UserId string `path:"user_id"`
+}
+
+type DeleteUserRequest struct {
+	UserId    string `json:"user_id" required:"true"`
+	IsDeleted bool   `json:"is_deleted"`
+}
+
+type UserFollowRequest struct {
+	UserId          string `json:"user_id" required:"true"`
+	UserIdFollowing string `json:"user_id_following" required:"true" doc:"user being followed or unfollowed"`
+}
+
+type LatestVideoRequest struct {
+	Latitude  string `path:"latitude"`
+	Longitude string `path:"longitude"`
+}
+
+type ChunkUploadRequest struct {
+	File         *multipart.FileHeader `form:"file" required:"true" doc:"the chunk"`
+	ContentRange string                `header:"Content-Range" required:"true" doc:"bytes start-end/size of the chunk"`
+}
+
+type SubscribeRequest struct {
+	Email string `json:"email" required:"true"`
+}
+
+// Response bodies that are not stored models
+
+type FollowDataResponse struct {
+	UserFollowData []interface{} `json:"user_follow_data" doc:"the cdn-api get-user-latest-data answer of each user"`
+}
+
+type FollowCountsResponse struct {
+	Counts mDB.FFCounts `json:"counts"`
+}
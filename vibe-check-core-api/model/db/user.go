@@ -31,20 +31,7 @@ type FFCounts struct {
 	FollowingCount int `json:"following_count" db:"following_count"`
 }
 
-// current Users schema
-// CREATE TABLE `users` (
-// 	`user_id` varchar(36) NOT NULL,
-// 	`user_name` varchar(20) NOT NULL,
-// 	`password` char(60) NOT NULL,
-// 	`email` varchar(100) DEFAULT NULL,
-// 	`date_created` datetime(3) DEFAULT current_timestamp(3),
-// 	`date_updated` datetime(3) DEFAULT current_timestamp(3),
-// 	`phone` varchar(15) NOT NULL,
-// 	`photo` BOOLEAN NOT NULL,
-// 	`first_name` VARCHAR(255) NOT NULL,
-// 	`last_name` VARCHAR(255),
-// 	PRIMARY KEY (`user_id`)
-//   ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+// The users table is defined by the migrations in vibe-common/migrate
 
 // type Customer struct {
 // 	Id          string    `json:"id" db:"id"`
@@ -1,15 +1,17 @@
 package store
 
 import (
+	"context"
 	"vibe/config"
 
+	"vibe-common/migrate"
+	vcstore "vibe-common/store"
+
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"database/sql"
 	_ "encoding/json"
-	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/gomodule/redigo/redis"
 )
 
@@ -22,13 +24,6 @@ var UPLOADS_LOCATION string
 // Initialize DB
 func InitDB() {
 
-	config := config.CONFIGURATION
-
-	MARIA_DB_HOST := config.MARIA_DB_HOST
-	MARIA_DB_PORT := config.MARIA_DB_PORT
-	MARIA_DB_USER := config.MARIA_DB_USERNAME
-	MARIA_DB_PASS := config.MARIA_DB_PASSWORD
-
 	// temp local testing on ruky's machine
 	// MARIA_DB_HOST := "127.0.0.1"
 	// MARIA_DB_PORT := "3306"
@@ -56,13 +51,26 @@ func InitDB() {
 	// }
 
 	// Maria DB
-	db, err := sql.Open("mysql", MARIA_DB_USER+":"+MARIA_DB_PASS+"@tcp("+MARIA_DB_HOST+":"+MARIA_DB_PORT+")/vibe_db?parseTime=true")
+	db, err := vcstore.InitDB(config.CONFIGURATION.DBConfig())
 	DB = db
 
 	if err != nil {
 		panic(err)
 	}
 
+	// Fail fast on bad credentials or an unreachable server, retrying while it starts up
+	err = vcstore.WaitForDB(DB, config.CONFIGURATION.STARTUP_RETRIES, config.CONFIGURATION.STARTUP_RETRY_BACKOFF)
+	if err != nil {
+		panic(err)
+	}
+
+	// Bring the schema up to date, services starting together take turns on a DB lock
+	if config.CONFIGURATION.MIGRATE_ON_STARTUP {
+		if err := migrate.Up(context.Background(), DB); err != nil {
+			panic(err)
+		}
+	}
+
 	// // Mongo DB
 	// // Create a new client and connect to the server
 	// client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(MONGO_URI))
@@ -90,24 +98,28 @@ func InitDB() {
 // Initialize Cache
 func InitCache() {
 
-	REDIS_HOST := config.CONFIGURATION.REDIS_HOST
-	REDIS_PORT := config.CONFIGURATION.REDIS_PORT
-
-	Cache = &redis.Pool{
-		MaxIdle:     100,
-		IdleTimeout: 240 * time.Second,
-		//MaxActive:   200,
-		//Wait:        true,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", REDIS_HOST+":"+REDIS_PORT)
-			if err != nil {
-				return nil, err
-			}
-			return c, err
-		},
+	Cache = vcstore.InitCache(config.CONFIGURATION.CacheConfig())
+	err := vcstore.WaitForCache(Cache, config.CONFIGURATION.STARTUP_RETRIES, config.CONFIGURATION.STARTUP_RETRY_BACKOFF)
+	if err != nil {
+		panic(err)
 	}
 }
 
+// Closes the DB and Cache pools, call once the server has drained
+func Cleanup() {
+	vcstore.Close(DB, Cache)
+}
+
 func ToString(reply interface{}, err error) (string, error) {
-	return redis.String(reply, err)
+	return vcstore.ToString(reply, err)
+}
+
+// CacheConn borrows a cache connection whose commands are traced and bounded by ctx
+func CacheConn(ctx context.Context) redis.Conn {
+	return vcstore.CacheConn(ctx, Cache, config.CONFIGURATION.REDIS_COMMAND_TIMEOUT)
+}
+
+// QueryContext bounds a query made outside the repositories by MARIA_DB_QUERY_TIMEOUT
+func QueryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return vcstore.WithTimeout(ctx, config.CONFIGURATION.MARIA_DB_QUERY_TIMEOUT)
 }
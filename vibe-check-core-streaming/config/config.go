@@ -0,0 +1,31 @@
+package config
+
+import vcconfig "vibe-common/config"
+
+type Configuration struct {
+	vcconfig.Base
+	vcconfig.Server
+	vcconfig.Health
+	vcconfig.Tracing
+	vcconfig.Storage
+	APP_PORT         string `required:"true"`
+	UPLOADS_LOCATION string `required:"true"` // root of local storage, shared with cdn-api's DESTINATION
+}
+
+var CONFIGURATION Configuration
+
+// Defaults lets a video stream run as long as the client reads it, the header read and the
+// idle timeout still bound slow or silent connections
+func (Configuration) Defaults() map[string]string {
+	return map[string]string{"SERVER_READ_TIMEOUT": "0s", "SERVER_WRITE_TIMEOUT": "0s"}
+}
+
+// Loads and validates the configuration from defaults, config file, env and *_FILE secrets
+func InitConfig() error {
+	return vcconfig.Load(&CONFIGURATION)
+}
+
+// Logs the configuration in use with secret values redacted
+func PrintConfig() {
+	vcconfig.Print(CONFIGURATION)
+}
@@ -5,8 +5,11 @@ go 1.15
 require (
 	github.com/gin-gonic/gin v1.8.1
 	github.com/go-sql-driver/mysql v1.6.0
-	github.com/joho/godotenv v1.4.0
-	github.com/sirupsen/logrus v1.9.0 // indirect
+	github.com/sirupsen/logrus v1.9.0
 	github.com/thedevsaddam/gojsonq v2.3.0+incompatible
 	golang.org/x/crypto v0.0.0-20211108221036-ceb1ce70b4fa // indirect
 )
+
+require vibe-common v0.0.0
+
+replace vibe-common => ../vibe-common
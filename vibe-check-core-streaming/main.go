@@ -4,14 +4,23 @@ import (
 	_ "encoding/json"
 	_ "io/ioutil"
 	"net/http"
-	"os"
 	_ "time"
 
+	"vibe/config"
+
+	"vibe-common/api"
+	"vibe-common/health"
+	"vibe-common/logging"
+	"vibe-common/metrics"
+	"vibe-common/middleware"
+	"vibe-common/server"
+	"vibe-common/storage"
+	"vibe-common/tracing"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
 
 	_ "github.com/thedevsaddam/gojsonq"
 )
@@ -32,57 +41,48 @@ type Res struct {
 
 func main() {
 
-	// Load Environemnt Variables
-	err := godotenv.Load()
+	// Load and validate configuration, every missing or invalid key is reported at once
+	err := config.InitConfig()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
 	}
+	APP_ENV = config.CONFIGURATION.APP_ENV
+
+	// Build log output file and set log level
+	logging.Setup(config.CONFIGURATION.LoggingConfig())
+	config.PrintConfig()
 
-	// Build log output file
-	os.Remove("log.txt") // remove old log
-	file, err := os.OpenFile("log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	// Spans for handlers, queries and outbound calls, flushed last on shutdown
+	stopTracing, err := tracing.Setup(config.CONFIGURATION.TracingConfig("core-streaming"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.SetOutput(file)
 
-	// Trace, Debug, Info, Warn, Error, Fatal, and Panic (oridnal 6 - 0)
-	logLevel, ok := os.LookupEnv("LOG_LEVEL")
-
-	// LOG_LEVEL not set, let's default to info
-	if !ok {
-		logLevel = "info"
-	}
+	// --- HLS STREAMING SERVER SETUP ---
+	UPLOADS_LOCATION := config.CONFIGURATION.UPLOADS_LOCATION
 
-	// Parse string to log level
-	parsedLevel, err := log.ParseLevel(logLevel)
+	APP_PORT := config.CONFIGURATION.APP_PORT
+	media, err := storage.New(config.CONFIGURATION.StorageConfig(UPLOADS_LOCATION))
 	if err != nil {
-		parsedLevel = log.DebugLevel
+		log.Fatal("Unable to set up storage: ", err)
 	}
-	// set global log level
-	log.SetLevel(parsedLevel)
+	log.Info("Serving ", config.CONFIGURATION.STORAGE_BACKEND, " storage")
 
-	log.Info("STARTING LOG...")
-	log.Info("APP_ENV: " + APP_ENV)
-	log.Info("LOG_LEVEL: " + logLevel)
+	http.Handle("/", metrics.Instrument("/", storage.Handler(media)))
+	// cdn-api stages the parts of vibe drafts there until they are committed
+	http.Handle("/drafts/", http.HandlerFunc(api.NotFound))
+	http.Handle("/metrics", metrics.Handler())
 
-	// --- HLS STREAMING SERVER SETUP ---
-	UPLOADS_LOCATION := os.Getenv("UPLOADS_LOCATION")
-
-	APP_PORT := os.Getenv("APP_PORT")
-	log.Info("Serving ", UPLOADS_LOCATION, " on HTTP port ", APP_PORT)
+	// Liveness and readiness
+	checks := health.New(config.CONFIGURATION.READINESS_TIMEOUT)
+	checks.Add("storage", health.Storage(media))
+	http.HandleFunc("/healthz", checks.Liveness)
+	http.HandleFunc("/readyz", checks.Readiness)
 
-	http.Handle("/", addHeaders(http.FileServer(http.Dir(UPLOADS_LOCATION))))
-	log.Fatal(http.ListenAndServe(APP_PORT, nil))
+	err = server.Run(config.CONFIGURATION.ServerConfig(APP_PORT), tracing.Handler(middleware.RequestID(middleware.AccessLog(middleware.Recover(http.DefaultServeMux))), "core-streaming"), stopTracing)
+	if err != nil {
+		log.Fatal("Server exited with error: ", err)
+	}
 	// --- HLS STREAMING SERVER SETUP ---
 
 }
-
-// --- HLS STREAMING SERVER SETUP ---
-// addHeaders will act as middleware to give us CORS support
-func addHeaders(h http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		h.ServeHTTP(w, r)
-	}
-}
@@ -4,7 +4,7 @@ import (
 	"net/http"
 	"time"
 
-	"vibe/api"
+	"vibe-common/api"
 	mAPI "vibe/model/api"
 
 	log "github.com/sirupsen/logrus"
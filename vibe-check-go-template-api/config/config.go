@@ -0,0 +1,24 @@
+package config
+
+import vcconfig "vibe-common/config"
+
+type Configuration struct {
+	vcconfig.Base
+	vcconfig.Server
+	vcconfig.Health
+	vcconfig.Tracing
+	vcconfig.MariaDB
+	APP_PORT string `required:"true"`
+}
+
+var CONFIGURATION Configuration
+
+// Loads and validates the configuration from defaults, config file, env and *_FILE secrets
+func InitConfig() error {
+	return vcconfig.Load(&CONFIGURATION)
+}
+
+// Logs the configuration in use with secret values redacted
+func PrintConfig() {
+	vcconfig.Print(CONFIGURATION)
+}
@@ -6,8 +6,11 @@ require (
 	github.com/gin-gonic/gin v1.8.2
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gorilla/mux v1.8.0
-	github.com/joho/godotenv v1.4.0
 	github.com/sirupsen/logrus v1.9.0
 	github.com/thedevsaddam/gojsonq v2.3.0+incompatible
 	golang.org/x/crypto v0.4.0 // indirect
 )
+
+require vibe-common v0.0.0
+
+replace vibe-common => ../vibe-common
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	_ "encoding/json"
 	"fmt"
 	_ "io/ioutil"
@@ -9,14 +10,25 @@ import (
 	_ "time"
 
 	test "vibe/api/test"
+	"vibe/config"
+	mAPI "vibe/model/api"
 	"vibe/store"
 
+	"vibe-common/api"
+	"vibe-common/health"
+	"vibe-common/logging"
+	"vibe-common/metrics"
+	"vibe-common/middleware"
+	"vibe-common/migrate"
+	"vibe-common/openapi"
+	"vibe-common/server"
+	"vibe-common/tracing"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	_ "github.com/thedevsaddam/gojsonq"
 )
 
@@ -25,97 +37,82 @@ var APP_ENV string
 var METHOD_LOGGING string
 var ENV_PROD = "prod"
 
-// Requests
-func handleAuthRequests(r *mux.Router) {
-	r.HandleFunc("/test-no-auth", test.GetTest).Methods("GET")
+// Requests, each documented in the OpenAPI spec and validated against its request type
+func handleAuthRequests(r *mux.Router, spec *openapi.Spec) {
+	spec.HandleFunc(r, openapi.Route{Method: "GET", Path: "/test-no-auth", Summary: "Test route",
+		Response: mAPI.Test{}}, test.GetTest)
+	r.Handle("/openapi.json", spec).Methods("GET")
+}
+
+// Health
+func handleHealthRequests(r *mux.Router) {
+	checks := health.New(config.CONFIGURATION.READINESS_TIMEOUT)
+	checks.Add("mariadb", health.DB(store.DB))
+	r.HandleFunc("/healthz", checks.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", checks.Readiness).Methods("GET")
+}
+
+// Metrics
+func handleMetricsRequests(r *mux.Router) {
+	metrics.RegisterDB("vibe_db", store.DB)
+	r.Use(metrics.Middleware)
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
 }
 
 func main() {
 	fmt.Println("Starting template-api microservice...")
-	fmt.Println("No logs will be generated here. Please see log.txt file for logging")
-
-	// Build log output file
-	os.Remove("log.txt") // remove old log
-	file, err := os.OpenFile("log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		fmt.Println("Cannot create log file: ", err)
-	}
-	log.SetOutput(file)
+	fmt.Println("Logs are written as JSON to LOG_OUTPUT (stdout by default)")
 
-	// Load environment variables
-	err = godotenv.Load()
+	// Load and validate configuration, every missing or invalid key is reported at once
+	err := config.InitConfig()
 	if err != nil {
-		log.Fatal("Error loading .env file, program will terminate: ", err)
-	}
-
-	// Get environment type, prod, dev, or local
-	APP_ENV := os.Getenv("APP_ENV")
-	if APP_ENV == "" {
-		log.Fatal("APP_ENV not found, program will terminate.")
-	}
-
-	// Check if we should be logging methods along log messages
-	methodLogging, ok := os.LookupEnv("METHOD_LOGGING")
-	if !ok {
-
-		log.Warning("METHOD_LOGGING not specified in .env, defaulting to false")
-		methodLogging = "false"
-	}
-
-	if methodLogging == "true" {
-		log.SetReportCaller(true)
+		log.Fatal(err)
 	}
-
-	// Trace, Debug, Info, Warn, Error, Fatal, and Panic (oridnal 6 - 0)
-	logLevel, ok := os.LookupEnv("LOG_LEVEL")
-
-	// LOG_LEVEL not set, let's default to info
-	if !ok {
-		logLevel = "info"
-		log.Warning("LOG_LEVEL not specified in .env, defaulting to info")
+	APP_ENV = config.CONFIGURATION.APP_ENV
+
+	// Build log output file and set log level
+	logging.Setup(config.CONFIGURATION.LoggingConfig())
+	config.PrintConfig()
+
+	// "migrate up|down [steps]|status" manages the vibe_db schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		// only the migrations asked for run, MIGRATE_ON_STARTUP must not apply every pending one first
+		config.CONFIGURATION.MIGRATE_ON_STARTUP = false
+		store.InitDB()
+		err = migrate.Run(context.Background(), store.DB, os.Args[2:])
+		store.Cleanup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Parse string to log level
-	parsedLevel, err := log.ParseLevel(logLevel)
+	// Spans for handlers, queries and outbound calls, flushed last on shutdown
+	stopTracing, err := tracing.Setup(config.CONFIGURATION.TracingConfig("go-template-api"))
 	if err != nil {
-		log.Error("Invalid log level, defaulting to debug: ", err)
-		parsedLevel = log.DebugLevel
+		log.Fatal(err)
 	}
 
-	// Set global log level
-	log.SetLevel(parsedLevel)
-
-	log.Info("STARTING LOG...")
-	log.Info("APP_ENV: " + APP_ENV)
-	log.Info("LOG_LEVEL: " + logLevel)
-	log.Info("METHOD_LOGGING: " + methodLogging)
-
 	// Requests
 	r := mux.NewRouter()
-	handleAuthRequests(r)
+	r.Use(tracing.Middleware("go-template-api"), middleware.RequestID, middleware.AccessLog, middleware.Recover)
+	r.NotFoundHandler = http.HandlerFunc(api.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(api.MethodNotAllowed)
+	handleAuthRequests(r, openapi.New("template-api", "1.0.0"))
 
 	// Initialize DB Connection
 	store.InitDB()
 
-	APP_PORT := os.Getenv("APP_PORT")
-	log.Info("Listening and serving on HTTP port", APP_PORT)
+	// Liveness and readiness
+	handleHealthRequests(r)
 
-	// Listen and serve
-	// need to implement certs for security
-	if APP_ENV == ENV_PROD {
-		fmt.Println("Prod initialization complete")
-		log.Fatal(http.ListenAndServe(APP_PORT, r))
-		//log.Fatal(http.ListenAndServeTLS(APP_PORT, "/certs/fullchain.pem", "/certs/key.pem", r))
-	} else {
-		fmt.Println("Dev initialization complete")
-		log.Fatal(http.ListenAndServe(APP_PORT, r))
-	}
-}
+	// Prometheus metrics
+	handleMetricsRequests(r)
 
-// addHeaders will act as middleware to give us CORS support
-func addHeaders(h http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		h.ServeHTTP(w, r)
+	// Listen and serve
+	fmt.Println(APP_ENV, "initialization complete")
+	err = server.Run(config.CONFIGURATION.ServerConfig(config.CONFIGURATION.APP_PORT), r, store.Cleanup, stopTracing)
+	if err != nil {
+		log.Fatal("Server exited with error: ", err)
 	}
 }
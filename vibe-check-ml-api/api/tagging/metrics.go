@@ -0,0 +1,34 @@
+package tagging
+
+import (
+	"vibe-common/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// tagging job metrics
+var jobsQueued = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "tagging_jobs_queued_total",
+	Help:      "Tagging jobs added to the queue.",
+})
+
+var jobsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "tagging_jobs_failed_total",
+	Help:      "Tagging jobs that failed, by the stage that failed (predict or store).",
+}, []string{"stage"})
+
+var queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "tagging_queue_depth",
+	Help:      "Tagging jobs queued or being processed.",
+})
+
+// openai usage, type is "prompt" or "completion"
+var openAITokens = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "openai_tokens_used_total",
+	Help:      "Tokens billed by OpenAI chat completions.",
+}, []string{"type"})
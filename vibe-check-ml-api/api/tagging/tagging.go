@@ -8,19 +8,23 @@ import (
 	"hash/fnv"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"vibe/api"
+	"vibe-common/api"
+	"vibe-common/logging"
+	"vibe-common/middleware"
+	"vibe-common/openapi"
+	"vibe-common/repository"
+	"vibe-common/tracing"
+	"vibe/config"
 	mAPI "vibe/model/api"
-	"vibe/store"
 
-	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var APP_ENV string
@@ -38,13 +42,10 @@ var jobs chan Job
 var jobIDs map[string]struct{}
 var ctx context.Context
 var cancel context.CancelFunc
+var workers sync.WaitGroup
 
-// Response -> response for the util scope
-type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Name    string `json:"name"`
-}
+// How long Cleanup lets workers finish their current job before cancelling them
+var WORKER_DRAIN_TIMEOUT = time.Second * 30
 
 // Job details
 type Job struct {
@@ -52,65 +53,37 @@ type Job struct {
 	Lat           string
 	Lon           string
 	Location_name string
+	RequestID     string            // Request that queued the job, forwarded on the job's outbound calls
+	Trace         trace.SpanContext // Span that queued the job, parent of the job's span
 }
 
 var client = &http.Client{
 	Timeout: time.Second * 10,
-	Transport: &http.Transport{
+	Transport: middleware.PropagateRequestID(tracing.Transport(&http.Transport{
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 10,
 		IdleConnTimeout:     time.Second * 30,
-	},
+	})),
 }
 
+// vibe_db access, set by Setup
+var repos *repository.Repos
+
 // Runs on startup
-func Setup() {
+func Setup(r *repository.Repos) {
+	repos = r
 	LoadEnvValues()
 	SetupJobChannel()
 }
 
-// Loads in the needed enviroment variables
+// Loads in the needed values from the validated configuration
 func LoadEnvValues() {
-	// Load environment variables
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env: ", err)
-	}
-
-	APP_ENV = os.Getenv("APP_ENV") // This is error checking in main.go
-
-	OPEN_API_KEY = os.Getenv("OPEN_API_KEY")
-	if OPEN_API_KEY == "" {
-		log.Fatal("OPEN_API_KEY is not set")
-	}
-
-	GOOGLE_API_KEY = os.Getenv("GOOGLE_API_KEY")
-	if GOOGLE_API_KEY == "" {
-		log.Fatal("GOOGLE_API_KEY is not set")
-	}
-
-	LOCATIONS_API_PORT = os.Getenv("LOCATIONS_API_PORT")
-	if LOCATIONS_API_PORT == "" {
-		log.Fatal("LOCATIONS_API_PORT is not set")
-	}
-
-	LOCATIONS_API_URL = os.Getenv("LOCATIONS_API_URL")
-	if LOCATIONS_API_URL == "" {
-		log.Fatal("LOCATIONS_API_URL is not set")
-	}
-
-	num_workers, ok := os.LookupEnv("NUM_WORKERS")
-	if !ok {
-		log.Warning("No NUM_WORKERS specified, defaulting to 1")
-		NUM_WORKERS = 1
-	} else {
-		i, err := strconv.Atoi(num_workers)
-		if err != nil {
-			log.Warning("Couldn't convert NUM_WORKERS to int, defaulting to 1")
-			NUM_WORKERS = 1
-		}
-		NUM_WORKERS = i
-	}
+	APP_ENV = config.CONFIGURATION.APP_ENV
+	OPEN_API_KEY = config.CONFIGURATION.OPEN_API_KEY
+	GOOGLE_API_KEY = config.CONFIGURATION.GOOGLE_API_KEY
+	LOCATIONS_API_PORT = config.CONFIGURATION.LOCATIONS_API_PORT
+	LOCATIONS_API_URL = config.CONFIGURATION.LOCATIONS_API_URL
+	NUM_WORKERS = config.CONFIGURATION.NUM_WORKERS
 }
 
 // Setups up the job channel to process predictions
@@ -124,11 +97,10 @@ func SetupJobChannel() {
 	log.Info("Setup job channel")
 
 	// Setup wait group and start go routines (workers)
-	var wg sync.WaitGroup
-	wg.Add(NUM_WORKERS)
+	workers.Add(NUM_WORKERS)
 	log.Trace("Setting up the workers for job channel")
 	for i := 0; i < NUM_WORKERS; i++ {
-		go worker(i, &wg)
+		go worker(i, &workers)
 		log.Trace("    Worker ", i, " setup")
 	}
 	log.Info("All workers setup for job channel")
@@ -147,25 +119,32 @@ func worker(worker_id int, wg *sync.WaitGroup) {
 				log.Trace("W", worker_id, ": Channel closed")
 				return
 			}
-			log.Trace("W", worker_id, ": Processing job ", job.ID, " | ", job.Location_name)
+			jobCtx := logging.WithRequestID(trace.ContextWithRemoteSpanContext(ctx, job.Trace), job.RequestID)
+			jobCtx, span := tracing.Tracer("tagging").Start(jobCtx, "tagging.job")
+			jobLog := logging.FromContext(jobCtx)
+			jobLog.Trace("W", worker_id, ": Processing job ", job.ID, " | ", job.Location_name)
 
-			log.Info("W", worker_id, ": Getting predictions")
-			predictions, err := GetPreditionsFromAPI(worker_id, prompt_raw, tags_raw, job.Lat, job.Lon, job.Location_name)
+			jobLog.Info("W", worker_id, ": Getting predictions")
+			predictions, err := GetPreditionsFromAPI(jobCtx, worker_id, prompt_raw, tags_raw, job.Lat, job.Lon, job.Location_name)
 			if err != nil {
-				log.Error("W", worker_id, ": There was an error getting tags from API: ", err)
+				jobLog.Error("W", worker_id, ": There was an error getting tags from API: ", err)
+				jobsFailed.WithLabelValues("predict").Inc()
 				delete(jobIDs, job.ID)
 				log.Trace("W", worker_id, ": Removed job ", job.ID, " due to error")
 			}
 
 			// Store tags into DB
-			err = StoreTagsInDB(worker_id, predictions, job.Lat, job.Lon, job.Location_name, job.ID)
+			err = StoreTagsInDB(jobCtx, worker_id, predictions, job.Lat, job.Lon, job.Location_name, job.ID)
 			if err != nil {
-				log.Error("W", worker_id, ": There was an error storing tags into DB: ", err)
+				jobLog.Error("W", worker_id, ": There was an error storing tags into DB: ", err)
+				jobsFailed.WithLabelValues("store").Inc()
 				delete(jobIDs, job.ID)
 				log.Trace("W", worker_id, ": Removed job ", job.ID, " due to error")
 			}
 
 			delete(jobIDs, job.ID) // Remove job from current job list
+			queueDepth.Dec()
+			span.End()
 			log.Trace("W", worker_id, ": Removed job ", job.ID)
 		}
 	}
@@ -176,6 +155,8 @@ func AddPredictionJob(job Job) {
 	// Check if job isnt already in queue
 	if _, ok := jobIDs[job.ID]; !ok {
 		jobIDs[job.ID] = struct{}{}
+		jobsQueued.Inc()
+		queueDepth.Inc()
 		jobs <- job
 		log.Info("Added job to queue: ", job.ID, " | ", job.Location_name)
 	}
@@ -213,61 +194,15 @@ func LimitString(input string, maxLength int) string {
 func GetTags(w http.ResponseWriter, r *http.Request) {
 	log.Info("Starting get tag logic")
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// Read in form values
-	location_name := r.FormValue("location_name")
-	lat_raw := r.FormValue("lat")
-	lon_raw := r.FormValue("lon")
+	// Form values, required and parsed by the openapi middleware
+	req := openapi.Request(r).(*mAPI.TagsRequest)
+	location_name := req.LocationName
+	lat_float, lon_float := req.Lat, req.Lon
 	log.WithFields(log.Fields{
 		"location_name": location_name,
-		"lat":           lat_raw,
-		"long":          lon_raw,
-	}).Trace("Raw form values")
-	log.Info("Processed form values")
-
-	// Error check each form value to make sure they exist
-	if location_name == "" {
-		response.Message = "Missing required query parameter: location_name"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	if lat_raw == "" {
-		response.Message = "Missing required query parameter: lat"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	if lon_raw == "" {
-		response.Message = "Missing required query parameter: lon"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	// Convert lat and lon to floats
-	lat_float, err := strconv.ParseFloat(lat_raw, 64)
-	if err != nil {
-		response.Message = "Invalid lat query parameter: " + err.Error()
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	lon_float, err := strconv.ParseFloat(lon_raw, 64)
-	if err != nil {
-		response.Message = "Invalid lon query parameter: " + err.Error()
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
+		"lat":           lat_float,
+		"long":          lon_float,
+	}).Trace("Form values")
 
 	// Expand float to have 9 decimal points as is the the db table criteria(may change)
 	lat_formatted := fmt.Sprintf("%.9f", lat_float)
@@ -275,18 +210,19 @@ func GetTags(w http.ResponseWriter, r *http.Request) {
 	location_hash := GenerateLocationHashString(location_name, lat_formatted, lon_formatted)
 
 	// Get tags for location hash
-	predictions, err := GetTagsFromDB(location_hash)
+	predictions, err := GetTagsFromDB(r.Context(), location_hash)
 
 	if err != nil {
-		response.Message = "There was an error getting tags from DB"
-		log.Error(response.Message, ": ", err)
-		api.Respond(w, response, http.StatusInternalServerError)
+		log.Error("There was an error getting tags from DB: ", err)
+		api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "There was an error getting tags from DB")
 		return
 	}
 
 	// Get predicitons if DB was empty
 	if predictions == "" {
 		AddPredictionJob(Job{
+			RequestID:     logging.RequestID(r.Context()),
+			Trace:         trace.SpanContextFromContext(r.Context()),
 			ID:            location_hash,
 			Lat:           lat_formatted,
 			Lon:           lon_formatted,
@@ -310,65 +246,25 @@ func GetTags(w http.ResponseWriter, r *http.Request) {
 func GetTagsCenterPos(w http.ResponseWriter, r *http.Request) {
 	log.Info("Starting get tag logic")
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// Read in form values
-	lat_raw := r.FormValue("lat")
-	lon_raw := r.FormValue("lon")
-	filter := r.FormValue("filter") // Optional so can be blank
+	// Form values, required and parsed by the openapi middleware
+	req := openapi.Request(r).(*mAPI.TagsCenterRequest)
+	lat_float, lon_float := req.Lat, req.Lon
+	filter := req.Filter // Optional so can be blank
 	log.WithFields(log.Fields{
-		"lat":    lat_raw,
-		"long":   lon_raw,
+		"lat":    lat_float,
+		"long":   lon_float,
 		"filter": filter,
-	}).Trace("Raw form values")
-	log.Info("Processed form values")
-
-	// Error check each form value to make sure they exist
-	if lat_raw == "" {
-		response.Message = "Missing required query parameter: lat"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	if lon_raw == "" {
-		response.Message = "Missing required query parameter: lon"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	// Convert lat and lon to floats
-	lat_float, err := strconv.ParseFloat(lat_raw, 64)
-	if err != nil {
-		response.Message = "Invalid lat query parameter: " + err.Error()
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	lon_float, err := strconv.ParseFloat(lon_raw, 64)
-	if err != nil {
-		response.Message = "Invalid lon query parameter: " + err.Error()
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
+	}).Trace("Form values")
 
 	// Expand float to have 9 decimal points as is the the db table criteria(may change)
 	lat_formatted := fmt.Sprintf("%.9f", lat_float)
 	lon_formatted := fmt.Sprintf("%.9f", lon_float)
 
 	// Get locations from Locations API
-	locations, err := GetLocations(lat_formatted, lon_formatted)
+	locations, err := GetLocations(r.Context(), lat_formatted, lon_formatted)
 	if err != nil {
-		response.Message = "Error while getting location from Locations API"
-		log.Error(response.Message, ": ", err)
-		api.Respond(w, response, http.StatusInternalServerError)
+		log.Error("Error while getting location from Locations API: ", err)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "Error while getting location from Locations API")
 		return
 	}
 
@@ -393,9 +289,8 @@ func GetTagsCenterPos(w http.ResponseWriter, r *http.Request) {
 
 		// Convert lat and lon to floats
 		if location.Name == "" {
-			response.Message = "Invalid location name"
-			log.Warning(response.Message)
-			api.Respond(w, response, http.StatusBadRequest)
+			log.Warning("Locations API returned a location without a name")
+			api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "Invalid location name from Locations API")
 			return
 		}
 
@@ -405,17 +300,18 @@ func GetTagsCenterPos(w http.ResponseWriter, r *http.Request) {
 		location_hash := GenerateLocationHashString(location.Name, lat_formatted, lon_formatted)
 
 		// Get tags for location hash
-		predictions, err := GetTagsFromDB(location_hash)
+		predictions, err := GetTagsFromDB(r.Context(), location_hash)
 		if err != nil {
-			response.Message = "There was an error getting tags from DB"
-			log.Error(response.Message, ": ", err)
-			api.Respond(w, response, http.StatusInternalServerError)
+			log.Error("There was an error getting tags from DB: ", err)
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "There was an error getting tags from DB")
 			return
 		}
 
 		// Get predicitons if DB was empty
 		if predictions == "" {
 			AddPredictionJob(Job{
+				RequestID:     logging.RequestID(r.Context()),
+				Trace:         trace.SpanContextFromContext(r.Context()),
 				ID:            location_hash,
 				Lat:           lat_formatted,
 				Lon:           lon_formatted,
@@ -463,7 +359,7 @@ func GetTagsCenterPos(w http.ResponseWriter, r *http.Request) {
 }
 
 // Gets locations around a point via our locations service
-func GetLocations(lat string, lon string) (mAPI.LocationsAPIGetLocationsResponse, error) {
+func GetLocations(ctx context.Context, lat string, lon string) (mAPI.LocationsAPIGetLocationsResponse, error) {
 	log.Info("Getting locations from Locations API")
 
 	// Setup Locations API POST body
@@ -473,7 +369,7 @@ func GetLocations(lat string, lon string) (mAPI.LocationsAPIGetLocationsResponse
 	}
 	body, err := json.Marshal(data)
 	if err != nil {
-		log.Panic("Unable to marshal into JSON: ", err)
+		log.Error("Unable to marshal into JSON: ", err)
 		return nil, err
 	}
 	postBody := bytes.NewBuffer(body)
@@ -482,9 +378,9 @@ func GetLocations(lat string, lon string) (mAPI.LocationsAPIGetLocationsResponse
 	request := &http.Request{}
 	if APP_ENV == ENV_PROD {
 		// request, err = http.NewRequest(http.MethodPost, "http://127.0.0.1:"+LOCATIONS_API_PORT, postBody)
-		request, err = http.NewRequest(http.MethodPost, LOCATIONS_API_URL, postBody)
+		request, err = http.NewRequestWithContext(ctx, http.MethodPost, LOCATIONS_API_URL, postBody)
 	} else {
-		request, err = http.NewRequest(http.MethodPost, LOCATIONS_API_URL, postBody)
+		request, err = http.NewRequestWithContext(ctx, http.MethodPost, LOCATIONS_API_URL, postBody)
 	}
 	if err != nil {
 		log.Error("Unable to create request: ", err)
@@ -532,7 +428,7 @@ func GetLocations(lat string, lon string) (mAPI.LocationsAPIGetLocationsResponse
 }
 
 // Gets tags from the db
-func GetTagsFromDB(location_hash string) (string, error) {
+func GetTagsFromDB(ctx context.Context, location_hash string) (string, error) {
 	log.Info("Getting tags from DB")
 
 	// Dont attempt to get tags from DB when in debug
@@ -541,51 +437,25 @@ func GetTagsFromDB(location_hash string) (string, error) {
 		return "", nil
 	}
 
-	// Prepare sql for select
-	stmt, err := store.DB.Prepare("SELECT tag FROM location_tags WHERE location_hash = ?")
-	if err != nil {
-		log.Error("Failed to prepare statement: ", err)
-		return "", err
-	}
-	defer stmt.Close()
-
-	// Execute sql
-	rows, err := stmt.Query(location_hash)
+	tags, err := repos.Tags.ForLocation(ctx, location_hash)
 	if err != nil {
 		log.Error("SELECT FROM location_tags failed: ", err)
 		return "", err
 	}
-	defer rows.Close()
 
-	// Loop through rows, using Scan to append tag to selected_tags string
+	// Join the tags into the selected_tags string
 	log.Trace("Grabbing tags from selected rows")
-	selected_tags := ""
-	for rows.Next() {
-		var tag string
-		err := rows.Scan(&tag)
-		if err != nil {
-			log.Error("Bad DB query: ", err)
-			return "", err
-		}
-
-		log.Trace("Tag: ", tag)
-
-		if selected_tags == "" {
-			selected_tags += (tag)
-		} else {
-			selected_tags += ("," + tag) // CSV format
-		}
-	}
+	selected_tags := strings.Join(tags, ",") // CSV format
 	log.Info("Tags for ", location_hash, ": ", selected_tags)
 	return selected_tags, nil
 }
 
 // Gets the place id from googles places api
-func GetPlaceID(worker_id int, lat string, lon string, loc_name string) (string, error) {
+func GetPlaceID(ctx context.Context, worker_id int, lat string, lon string, loc_name string) (string, error) {
 	log.Info("W", worker_id, ": Getting PlaceID for location: ", loc_name)
 
 	// Create findplace request for Google
-	req, err := http.NewRequest("GET", "https://maps.googleapis.com/maps/api/place/findplacefromtext/json?", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://maps.googleapis.com/maps/api/place/findplacefromtext/json?", nil)
 	if err != nil {
 		log.Error("W", worker_id, ": Unable to create request: ", err)
 		return "", err
@@ -643,11 +513,11 @@ func GetPlaceID(worker_id int, lat string, lon string, loc_name string) (string,
 }
 
 // Gets the reviews based on the places api
-func GetReviewsByPlacesID(worker_id int, place_id string) (string, error) {
+func GetReviewsByPlacesID(ctx context.Context, worker_id int, place_id string) (string, error) {
 	log.Info("W", worker_id, ": Getting reviews for PlaceID: ", place_id)
 
 	// Create place details request for Google
-	req, err := http.NewRequest("GET", "https://maps.googleapis.com/maps/api/place/details/json?", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://maps.googleapis.com/maps/api/place/details/json?", nil)
 	if err != nil {
 		log.Error("W", worker_id, ": Unable to create request: ", err)
 		return "", err
@@ -716,16 +586,16 @@ func GetReviewsByPlacesID(worker_id int, place_id string) (string, error) {
 }
 
 // Gets the review data
-func GetReviewData(worker_id int, lat string, lon string, loc_name string) (string, error) {
+func GetReviewData(ctx context.Context, worker_id int, lat string, lon string, loc_name string) (string, error) {
 	// Get place id for reviews via Google API
-	place_id, err := GetPlaceID(worker_id, lat, lon, loc_name)
+	place_id, err := GetPlaceID(ctx, worker_id, lat, lon, loc_name)
 	if err != nil {
 		log.Error("W", worker_id, ": Unable to get place id: ", err)
 		return "", err
 	}
 
 	// Get reviews via the place id via Google API
-	text_data, err := GetReviewsByPlacesID(worker_id, place_id)
+	text_data, err := GetReviewsByPlacesID(ctx, worker_id, place_id)
 	if err != nil {
 		log.Error("W", worker_id, ": Unable to get reviews by place id: ", err)
 		return "", err
@@ -737,14 +607,14 @@ func GetReviewData(worker_id int, lat string, lon string, loc_name string) (stri
 }
 
 // Gets the tag predictions from OpenAI api
-func GetPreditionsFromAPI(worker_id int, prompt string, tags string, lat string, lon string, loc_name string) (string, error) {
+func GetPreditionsFromAPI(ctx context.Context, worker_id int, prompt string, tags string, lat string, lon string, loc_name string) (string, error) {
 	// Only do outgoing requests if in prod
 	if APP_ENV != ENV_PROD {
 		log.Warning("W ", worker_id, ": Returning fake values, not in prod")
 		return "wild", nil
 	}
 
-	text, err := GetReviewData(worker_id, lat, lon, loc_name) // Gets the review data for predictions
+	text, err := GetReviewData(ctx, worker_id, lat, lon, loc_name) // Gets the review data for predictions
 	if err != nil {
 		log.Error("W", worker_id, ": Couldnt get review data ", err)
 		return "", err
@@ -774,13 +644,13 @@ func GetPreditionsFromAPI(worker_id int, prompt string, tags string, lat string,
 
 	body, err := json.Marshal(data)
 	if err != nil {
-		log.Panic("W", worker_id, ": Unable to marshal into JSON: ", err)
+		log.Error("W", worker_id, ": Unable to marshal into JSON: ", err)
 		return "", err
 	}
 	postBody := bytes.NewBuffer(body)
 
 	// Create chat completions request for OpenAI
-	request, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", postBody)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/chat/completions", postBody)
 	if err != nil {
 		log.Error("W", worker_id, ": Unable to create request: ", err)
 		return "", err
@@ -824,6 +694,9 @@ func GetPreditionsFromAPI(worker_id int, prompt string, tags string, lat string,
 		return "", err
 	}
 
+	openAITokens.WithLabelValues("prompt").Add(float64(result.Usage.PromptTokens))
+	openAITokens.WithLabelValues("completion").Add(float64(result.Usage.CompletionTokens))
+
 	// Only return if results were completed
 	result_tags := ""
 	if result.Choices[0].FinishReason == "stop" {
@@ -843,7 +716,7 @@ func GetPreditionsFromAPI(worker_id int, prompt string, tags string, lat string,
 }
 
 // Store tags in the db
-func StoreTagsInDB(worker_id int, tags string, lat string, lon string, location_name string, location_hash string) error {
+func StoreTagsInDB(ctx context.Context, worker_id int, tags string, lat string, lon string, location_name string, location_hash string) error {
 	// Only store tags in DB if in prod
 	if APP_ENV != ENV_PROD {
 		log.Warning("W", worker_id, ": Skipping store, not in prod")
@@ -852,60 +725,28 @@ func StoreTagsInDB(worker_id int, tags string, lat string, lon string, location_
 
 	log.Info("W", worker_id, ": Storing tags in DB: ", tags)
 
+	// Insert into locations handling (if not in table already)
+	lat_float, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return err
+	}
+	lon_float, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return err
+	}
+	location := repository.Location{Hash: location_hash, Name: location_name, Lat: lat_float, Lon: lon_float}
+	if err := repos.Locations.Ensure(ctx, location); err != nil {
+		log.Error("W", worker_id, ": INSERT INTO locations failed: ", err)
+		return err
+	}
+
 	// Insert each tag as a row with location_hash
 	for _, tag := range strings.Split(tags, ",") {
-
-		// REFACTOR THIS
-		// Insert into locations handling (if not in table already)
-		stmt, err := store.DB.Prepare("INSERT INTO locations (location_hash, location_name, lat, lon) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT * FROM locations WHERE location_hash = ?)")
-		if err != nil {
-			log.Error("W", worker_id, ": Failed to prepare statement: ", err)
-			return err
-		}
-		defer stmt.Close()
-
-		// Insert into DB
-		result, err := stmt.Exec(location_hash, location_name, lat, lon, location_hash)
-		if err != nil {
-			log.Error("W", worker_id, ": INSERT INTO locations failed: ", err)
-			return err
-		}
-
-		rows, err := result.RowsAffected() // Grab rows effected for logging
-		if err != nil {
-			log.Error("W", worker_id, ": Error getting rows effected for INSERT: ", err)
-			return err
-		}
-
-		if rows != 0 {
-			log.Info("W", worker_id, ": Row added to locations")
-		}
-
-		// Insert into locations_tags handling
-		// Prepare sql insertion
-		stmt, err = store.DB.Prepare("INSERT INTO location_tags(location_hash, tag) value(?,?)")
-		if err != nil {
-			log.Error("W", worker_id, ": Failed to prepare statement: ", err)
-			return err
-		}
-		defer stmt.Close()
-
-		// Insert into DB
-		result, err = stmt.Exec(location_hash, tag)
-		if err != nil {
+		if err := repos.Tags.Add(ctx, location_hash, tag); err != nil {
 			log.Error("W", worker_id, ": INSERT INTO location_tags failed: ", err)
 			return err
 		}
-
-		rows, err = result.RowsAffected() // Grab rows effected for logging
-		if err != nil {
-			log.Error("W", worker_id, ": Error getting rows effected for INSERT: ", err)
-			return err
-		}
-
-		if rows != 0 {
-			log.Info("W", worker_id, ": Row added to location_tags")
-		}
+		log.Info("W", worker_id, ": Row added to location_tags")
 	}
 	return nil
 }
@@ -913,7 +754,22 @@ func StoreTagsInDB(worker_id int, tags string, lat string, lon string, location_
 // Cleans up any captured resources and frees them
 func Cleanup() {
 	log.Info("Cleaning up tagging")
-	cancel()    // Stop all workers
-	close(jobs) // Close channel
+	close(jobs) // Workers finish their current job and exit
+
+	// Wait for the workers, cancelling them if they take too long
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("All tagging workers stopped")
+	case <-time.After(WORKER_DRAIN_TIMEOUT):
+		log.Warning("Tagging workers did not stop in time, cancelling")
+		cancel()
+		<-done
+	}
+	cancel()
 	client.CloseIdleConnections()
 }
@@ -0,0 +1,31 @@
+package config
+
+import vcconfig "vibe-common/config"
+
+type Configuration struct {
+	vcconfig.Base
+	vcconfig.Server
+	vcconfig.Health
+	vcconfig.Tracing
+	vcconfig.MariaDB
+	APP_PORT string `required:"true"`
+
+	// Tagging
+	OPEN_API_KEY       string `required:"true" secret:"true"`
+	GOOGLE_API_KEY     string `required:"true" secret:"true"`
+	LOCATIONS_API_PORT string `required:"true"`
+	LOCATIONS_API_URL  string `required:"true"`
+	NUM_WORKERS        int    `default:"1" min:"1"`
+}
+
+var CONFIGURATION Configuration
+
+// Loads and validates the configuration from defaults, config file, env and *_FILE secrets
+func InitConfig() error {
+	return vcconfig.Load(&CONFIGURATION)
+}
+
+// Logs the configuration in use with secret values redacted
+func PrintConfig() {
+	vcconfig.Print(CONFIGURATION)
+}
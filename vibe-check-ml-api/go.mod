@@ -5,10 +5,16 @@ go 1.15
 require (
 	github.com/gin-gonic/gin v1.8.2
 	github.com/go-sql-driver/mysql v1.6.0
-	github.com/gomodule/redigo v1.8.5
 	github.com/gorilla/mux v1.8.0
-	github.com/joho/godotenv v1.4.0
 	github.com/sirupsen/logrus v1.9.0
 	github.com/thedevsaddam/gojsonq v2.3.0+incompatible
 	golang.org/x/crypto v0.4.0 // indirect
 )
+
+require (
+	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	vibe-common v0.0.0
+)
+
+replace vibe-common => ../vibe-common
@@ -0,0 +1,15 @@
+package model
+
+// Request parameters, validated by the openapi middleware before the handler runs
+
+type TagsRequest struct {
+	LocationName string  `form:"location_name" required:"true"`
+	Lat          float64 `form:"lat" required:"true" min:"-90" max:"90"`
+	Lon          float64 `form:"lon" required:"true" min:"-180" max:"180"`
+}
+
+type TagsCenterRequest struct {
+	Lat    float64 `form:"lat" required:"true" min:"-90" max:"90"`
+	Lon    float64 `form:"lon" required:"true" min:"-180" max:"180"`
+	Filter string  `form:"filter" doc:"only return locations whose tags equal it"`
+}
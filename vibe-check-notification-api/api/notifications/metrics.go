@@ -0,0 +1,21 @@
+package notifications
+
+import (
+	"vibe-common/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// notification metrics, target is "device" or "topic"
+var notificationsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "notifications_sent_total",
+	Help:      "Notifications accepted by FCM.",
+}, []string{"target"})
+
+var notificationsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "notifications_failed_total",
+	Help:      "Notifications FCM failed to accept.",
+}, []string{"target"})
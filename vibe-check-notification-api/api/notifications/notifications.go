@@ -2,37 +2,49 @@ package notifications
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
-	"vibe/api"
+	"vibe-common/api"
+	"vibe-common/middleware"
+	"vibe-common/openapi"
+	"vibe-common/tracing"
+	"vibe/config"
+	mAPI "vibe/model/api"
 
 	log "github.com/sirupsen/logrus"
 
 	firebase "firebase.google.com/go"
 	"firebase.google.com/go/messaging"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
-// Response -> response for the util scope
-type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Name    string `json:"name"`
-}
-
 var ctx context.Context
 var cancel context.CancelFunc
 var app *firebase.App
 
 var client *messaging.Client
 
+// OAuth2 scopes needed to send messages through FCM
+var fcmScopes = []string{
+	"https://www.googleapis.com/auth/cloud-platform",
+	"https://www.googleapis.com/auth/firebase.messaging",
+}
+
 func Setup() {
-	opt := option.WithCredentialsFile("serviceAccountKey.json")
+	opt := option.WithCredentialsFile(config.CONFIGURATION.FIREBASE_CREDENTIALS_FILE)
 	ctx, cancel = context.WithCancel(context.Background())
 
+	// Authorized http client whose requests forward X-Request-ID and trace context to FCM
+	httpClient, _, err := htransport.NewClient(ctx, opt, option.WithScopes(fcmScopes...))
+	if err != nil {
+		log.Fatal("Error creating FCM http client: ", err)
+	}
+	httpClient.Transport = middleware.PropagateRequestID(tracing.Transport(httpClient.Transport))
+
 	// Initialize a new FCM app object
-	var err error // So it wont complain about undeclared err var
-	app, err = firebase.NewApp(ctx, nil, opt)
+	app, err = firebase.NewApp(ctx, nil, opt, option.WithHTTPClient(httpClient))
 	if err != nil {
 		log.Fatal("Error initializing firebase app: ", err)
 	}
@@ -49,36 +61,16 @@ func Setup() {
 func UnsubscribeDevicesToChannel(w http.ResponseWriter, r *http.Request) {
 	log.Info("In unsubscribe devices to topic handler -------------------------")
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// Read in form values
-	device_tokens := r.FormValue("device_tokens")
-	topic := r.FormValue("topic")
-
-	// These params are required
-	if device_tokens == "" {
-		response.Message = "Missing required query parameter: device_tokens"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	if topic == "" {
-		response.Message = "Missing required query parameter: topic"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
+	// Form values, required by the openapi middleware
+	req := openapi.Request(r).(*mAPI.TopicDevicesRequest)
+	device_tokens := req.DeviceTokens
+	topic := req.Topic
 
 	device_tokens_split := strings.Split(device_tokens, ",")
 	if len(device_tokens_split) > 1000 {
-		response.Message = "Too many device tokens, please limit to < 1000 tokens per call"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+		log.Warning("Too many device tokens, please limit to < 1000 tokens per call")
+		api.RespondInvalid(w, api.FieldError{Field: "device_tokens", Message: "must hold fewer than 1000 tokens"})
+		return
 	}
 
 	log.Info("Processed form values")
@@ -87,51 +79,30 @@ func UnsubscribeDevicesToChannel(w http.ResponseWriter, r *http.Request) {
 	firebase_response, err := client.UnsubscribeFromTopic(r.Context(), device_tokens_split, topic)
 	log.Trace("Raw firebase response: ", firebase_response)
 	if err != nil {
-		response.Message = "There was an error sending unsubscribe message to FCM: " + err.Error()
-		log.Error(response.Message)
-		api.Respond(w, response, http.StatusBadGateway)
+		log.Error("There was an error sending unsubscribe message to FCM: ", err)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "There was an error sending unsubscribe message to FCM")
 		return
 	}
 	log.Trace("Devices Unsubscribed: ", device_tokens_split)
 	log.Info("Unsubscribed devices to ", topic)
 
-	api.RespondOK(w, Response{Success: true, Message: "Devices unsubscribed from topic: " + topic})
+	api.RespondSuccess(w, http.StatusOK, topic, "Devices unsubscribed from topic: "+topic)
 }
 
 // Handler for subscribing device(s) to a specified topic (Identical to unsubcribe)
 func SubscribeDevicesToTopic(w http.ResponseWriter, r *http.Request) {
 	log.Info("In subscribe devices to topic handler -------------------------")
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// Read in form values
-	device_tokens := r.FormValue("device_tokens")
-	topic := r.FormValue("topic")
-
-	// These params are required
-	if device_tokens == "" {
-		response.Message = "Missing required query parameter: device_tokens"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
-
-	if topic == "" {
-		response.Message = "Missing required query parameter: topic"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
+	// Form values, required by the openapi middleware
+	req := openapi.Request(r).(*mAPI.TopicDevicesRequest)
+	device_tokens := req.DeviceTokens
+	topic := req.Topic
 
 	device_tokens_split := strings.Split(device_tokens, ",")
 	if len(device_tokens_split) > 1000 {
-		response.Message = "Too many device tokens, please limit to < 1000 tokens per call"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
+		log.Warning("Too many device tokens, please limit to < 1000 tokens per call")
+		api.RespondInvalid(w, api.FieldError{Field: "device_tokens", Message: "must hold fewer than 1000 tokens"})
+		return
 	}
 
 	log.Info("Processed form values")
@@ -140,39 +111,25 @@ func SubscribeDevicesToTopic(w http.ResponseWriter, r *http.Request) {
 	firebase_response, err := client.SubscribeToTopic(r.Context(), device_tokens_split, topic)
 	log.Trace("Raw firebase response: ", firebase_response)
 	if err != nil {
-		response.Message = "There was an error sending subscribe message to FCM: " + err.Error()
-		log.Error(response.Message)
-		api.Respond(w, response, http.StatusBadGateway)
+		log.Error("There was an error sending subscribe message to FCM: ", err)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "There was an error sending subscribe message to FCM")
 		return
 	}
 	log.Trace("Devices subcribed: ", device_tokens_split)
 	log.Info("Subscribed devices to ", topic)
 
-	api.RespondOK(w, Response{Success: true, Message: "Devices subscribed to topic: " + topic})
+	api.RespondSuccess(w, http.StatusOK, topic, "Devices subscribed to topic: "+topic)
 }
 
 // Sends a notification to the specified device
 func SendNotificationToDevice(w http.ResponseWriter, r *http.Request) {
 	log.Info("In send notification handler -------------------------")
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// Read in form values
-	device_token := r.FormValue("device_token")
-	title := r.FormValue("title")
-	body := r.FormValue("body")
-
-	// These params are required
-	if device_token == "" {
-		response.Message = "Missing required query parameter: device_token"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
+	// Form values, device_token is required by the openapi middleware
+	req := openapi.Request(r).(*mAPI.DeviceNotificationRequest)
+	device_token := req.DeviceToken
+	title := req.Title
+	body := req.Body
 
 	// if title == "" {
 	// 	response.Message = "Missing required query parameter: title"
@@ -198,37 +155,25 @@ func SendNotificationToDevice(w http.ResponseWriter, r *http.Request) {
 	firebase_response, err := client.Send(r.Context(), notification_message)
 	log.Trace("Raw firebase response: ", firebase_response)
 	if err != nil {
-		response.Message = "There was an error sending message to FCM: " + err.Error()
-		log.Error(response.Message)
-		api.Respond(w, response, http.StatusBadGateway)
+		notificationsFailed.WithLabelValues("device").Inc()
+		log.Error("There was an error sending message to FCM: ", err)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "There was an error sending message to FCM")
 		return
 	}
+	notificationsSent.WithLabelValues("device").Inc()
 
-	api.RespondOK(w, Response{Success: true, Message: "Push notification sent to device"})
+	api.RespondSuccess(w, http.StatusOK, "", "Push notification sent to device")
 }
 
 // Sends a notification to the specified topic
 func SendNotificationToTopic(w http.ResponseWriter, r *http.Request) {
 	log.Info("In send notification handler -------------------------")
 
-	response := &Response{
-		Success: false,
-		Message: "none",
-		Name:    "",
-	}
-
-	// Read in form values
-	topic := r.FormValue("topic")
-	title := r.FormValue("title")
-	body := r.FormValue("body")
-
-	// These params are required
-	if topic == "" {
-		response.Message = "Missing required query parameter: topic"
-		log.Warning(response.Message)
-		api.Respond(w, response, http.StatusBadRequest)
-		return
-	}
+	// Form values, topic is required by the openapi middleware
+	req := openapi.Request(r).(*mAPI.TopicNotificationRequest)
+	topic := req.Topic
+	title := req.Title
+	body := req.Body
 
 	// if title == "" {
 	// 	response.Message = "Missing required query parameter: title"
@@ -254,13 +199,23 @@ func SendNotificationToTopic(w http.ResponseWriter, r *http.Request) {
 	firebase_response, err := client.Send(r.Context(), notification_message)
 	log.Trace("Raw firebase response: ", firebase_response)
 	if err != nil {
-		response.Message = "There was an error sending message to FCM: " + err.Error()
-		log.Error(response.Message)
-		api.Respond(w, response, http.StatusBadGateway)
+		notificationsFailed.WithLabelValues("topic").Inc()
+		log.Error("There was an error sending message to FCM: ", err)
+		api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "There was an error sending message to FCM")
 		return
 	}
+	notificationsSent.WithLabelValues("topic").Inc()
+
+	api.RespondSuccess(w, http.StatusOK, "", "Push notification sent to topic")
+}
 
-	api.RespondOK(w, Response{Success: true, Message: "Push notification sent to topic"})
+// Reports whether FCM accepts our credentials by validating a dry run message
+func HealthCheck(ctx context.Context) error {
+	if client == nil {
+		return errors.New("messaging client not initialized")
+	}
+	_, err := client.SendDryRun(ctx, &messaging.Message{Topic: "readyz"})
+	return err
 }
 
 // Cleans up any captured resources and frees them
@@ -0,0 +1,27 @@
+package config
+
+import vcconfig "vibe-common/config"
+
+type Configuration struct {
+	vcconfig.Base
+	vcconfig.Server
+	vcconfig.Health
+	vcconfig.Tracing
+	vcconfig.MariaDB
+	APP_PORT string `required:"true"`
+
+	// Service account keys used to talk to FCM
+	FIREBASE_CREDENTIALS_FILE string `default:"serviceAccountKey.json"`
+}
+
+var CONFIGURATION Configuration
+
+// Loads and validates the configuration from defaults, config file, env and *_FILE secrets
+func InitConfig() error {
+	return vcconfig.Load(&CONFIGURATION)
+}
+
+// Logs the configuration in use with secret values redacted
+func PrintConfig() {
+	vcconfig.Print(CONFIGURATION)
+}
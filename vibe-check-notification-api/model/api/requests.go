@@ -0,0 +1,20 @@
+package model
+
+// Request parameters, validated by the openapi middleware before the handler runs
+
+type TopicDevicesRequest struct {
+	DeviceTokens string `form:"device_tokens" required:"true" doc:"comma separated FCM registration tokens, fewer than 1000"`
+	Topic        string `form:"topic" required:"true"`
+}
+
+type DeviceNotificationRequest struct {
+	DeviceToken string `form:"device_token" required:"true"`
+	Title       string `form:"title"`
+	Body        string `form:"body"`
+}
+
+type TopicNotificationRequest struct {
+	Topic string `form:"topic" required:"true"`
+	Title string `form:"title"`
+	Body  string `form:"body"`
+}
@@ -1,11 +1,14 @@
 package store
 
 import (
+	"context"
 	"database/sql"
-	_ "encoding/json"
-	"os"
 
-	_ "github.com/go-sql-driver/mysql"
+	"vibe/config"
+
+	"vibe-common/migrate"
+	vcstore "vibe-common/store"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -13,17 +16,27 @@ var DB *sql.DB
 
 // Initialize DB
 func InitDB() {
-	APP_ENV := os.Getenv("APP_ENV")
-	DB_HOST := "127.0.0.1"
-	DB_USER := "root"
-	DB_PASS := "build"
-	if APP_ENV == "prod" {
-		DB_HOST = os.Getenv("MARIA_DB_HOST")
-		DB_PASS = os.Getenv("MARIA_DB_PASSWORD")
-	}
-	db, err := sql.Open("mysql", DB_USER+":"+DB_PASS+"@tcp("+DB_HOST+":3306)/vibe_db?parseTime=true")
+	db, err := vcstore.InitDB(config.CONFIGURATION.DBConfig())
 	if err != nil {
 		log.Fatal("Unable to create connection to DB:", err)
 	}
 	DB = db
+
+	// Fail fast on bad credentials or an unreachable server, retrying while it starts up
+	err = vcstore.WaitForDB(DB, config.CONFIGURATION.STARTUP_RETRIES, config.CONFIGURATION.STARTUP_RETRY_BACKOFF)
+	if err != nil {
+		log.Fatal("Unable to reach DB: ", err)
+	}
+
+	// Bring the schema up to date, services starting together take turns on a DB lock
+	if config.CONFIGURATION.MIGRATE_ON_STARTUP {
+		if err := migrate.Up(context.Background(), DB); err != nil {
+			log.Fatal("Unable to migrate DB: ", err)
+		}
+	}
+}
+
+// Closes the DB pool, call once the server has drained
+func Cleanup() {
+	vcstore.Close(DB, nil)
 }
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+
+	"vibe-common/logging"
+)
+
+// Error codes are part of the API contract, clients switch on them, so existing codes never change meaning
+const (
+	CodeBadRequest             = "BAD_REQUEST"              // body, form or query could not be parsed
+	CodeValidationFailed       = "VALIDATION_FAILED"        // one or more fields are missing or invalid, see details
+	CodeUnauthorized           = "UNAUTHORIZED"             // no valid session
+	CodeInvalidCredentials     = "INVALID_CREDENTIALS"      // username or password is wrong
+	CodeUsernameTaken          = "USERNAME_TAKEN"           // username is already registered, at signup also the phone
+	CodePhoneTaken             = "PHONE_TAKEN"              // phone is already registered
+	CodeUserNotFound           = "USER_NOT_FOUND"           // no account matches
+	CodeNotFound               = "NOT_FOUND"                // the requested resource or route does not exist
+	CodeMethodNotAllowed       = "METHOD_NOT_ALLOWED"       // the route exists but not for this method
+	CodeOriginNotAllowed       = "ORIGIN_NOT_ALLOWED"       // CORS preflight from an origin that is not allowed
+	CodeAlreadySubscribed      = "ALREADY_SUBSCRIBED"       // email is already on the newsletter
+	CodeUploadRangeInvalid     = "UPLOAD_RANGE_INVALID"     // Content-Range header is missing or malformed
+	CodeUploadTooLarge         = "UPLOAD_TOO_LARGE"         // file exceeds the size limit
+	CodeUploadFailed           = "UPLOAD_FAILED"            // the upload could not be stored
+	CodeUploadNotFound         = "UPLOAD_NOT_FOUND"         // no resumable upload with that ID, or it expired
+	CodeUploadOffsetMismatch   = "UPLOAD_OFFSET_MISMATCH"   // the chunk does not start where the upload stands, HEAD it and resume
+	CodeUploadChecksumMismatch = "UPLOAD_CHECKSUM_MISMATCH" // the chunk does not match its Upload-Checksum, send it again
+	CodeUploadIncomplete       = "UPLOAD_INCOMPLETE"        // finalized before every byte arrived
+	CodeUploadLocked           = "UPLOAD_LOCKED"            // another chunk of the same upload is being written
+	CodeUnsupportedMediaType   = "UNSUPPORTED_MEDIA_TYPE"   // the body's Content-Type is not accepted here
+	CodeTusVersionUnsupported  = "TUS_VERSION_UNSUPPORTED"  // Tus-Resumable names a tus version this server does not speak
+	CodeMediaTypeNotAllowed    = "MEDIA_TYPE_NOT_ALLOWED"   // the file's content is not a type its role accepts, it was discarded
+	CodeMediaLimitExceeded     = "MEDIA_LIMIT_EXCEEDED"     // the file runs longer or is bigger in pixels than its role allows, it was discarded
+	CodeDraftNotFound          = "DRAFT_NOT_FOUND"          // no vibe draft with that ID, or it expired
+	CodeDraftIncomplete        = "DRAFT_INCOMPLETE"         // committed before every part of the draft's manifest was stored, see details
+	CodeVibeExists             = "VIBE_EXISTS"              // a vibe was already recorded by the same user at the same time and location
+	CodeVerificationFailed     = "VERIFICATION_FAILED"      // phone verification code was rejected
+	CodeUpstreamFailed         = "UPSTREAM_FAILED"          // a service this one depends on failed
+	CodeInternal               = "INTERNAL"                 // unexpected server error, report the request_id
+)
+
+// FieldError explains why one field of a request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrorBody is the machine-readable part of a failed response
+type ErrorBody struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// Envelope is the body of every error and of every success that reports an outcome
+// rather than data. Success, Message and Name keep the shape older clients read.
+type Envelope struct {
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	Name      string     `json:"name,omitempty"`
+	Error     *ErrorBody `json:"error,omitempty"`
+	RequestID string     `json:"request_id,omitempty"`
+}
+
+// RespondSuccess writes a success envelope, name identifies what was acted on
+func RespondSuccess(w http.ResponseWriter, statusCode int, name string, message string) {
+	Respond(w, &Envelope{Success: true, Message: message, Name: name, RequestID: requestID(w)}, statusCode)
+}
+
+// RespondError writes an error envelope with a stable code and optional field details
+func RespondError(w http.ResponseWriter, statusCode int, code string, message string, details ...FieldError) {
+	Respond(w, newErrorEnvelope(w, code, message, details), statusCode)
+}
+
+// RespondInvalid writes a 400 VALIDATION_FAILED envelope listing the rejected fields
+func RespondInvalid(w http.ResponseWriter, details ...FieldError) {
+	RespondError(w, http.StatusBadRequest, CodeValidationFailed, "request failed validation", details...)
+}
+
+// NotFound answers requests no route matches, set it as the router's NotFoundHandler
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	RespondError(w, http.StatusNotFound, CodeNotFound, "no route matches "+r.URL.Path)
+}
+
+// MethodNotAllowed answers requests whose route exists for other methods only
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	RespondError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
+
+func newErrorEnvelope(w http.ResponseWriter, code string, message string, details []FieldError) *Envelope {
+	return &Envelope{
+		Message:   message,
+		Error:     &ErrorBody{Code: code, Message: message, Details: details},
+		RequestID: requestID(w),
+	}
+}
+
+// requestID reads the ID middleware.RequestID echoes on the response
+func requestID(w http.ResponseWriter) string {
+	return w.Header().Get(logging.RequestIDHeader)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Respond writes data as JSON with the given status, a body that cannot be encoded becomes a 500 envelope
+func Respond(w http.ResponseWriter, data interface{}, statusCode int) {
+	log.Trace("entered Response")
+	res, err := json.Marshal(data)
+	if err != nil {
+		log.Error("unable to encode data to JSON: ", err)
+		res, _ = json.Marshal(newErrorEnvelope(w, CodeInternal, "response could not be encoded", nil))
+		statusCode = http.StatusInternalServerError
+	}
+	log.Trace(string(res))
+	RespondRaw(w, res, statusCode)
+}
+
+// Used for http.StatusOK write responses
+func RespondOK(w http.ResponseWriter, data interface{}) {
+	Respond(w, data, http.StatusOK)
+}
+
+func RespondRaw(w http.ResponseWriter, data []byte, statusCode int) {
+	log.Trace("entered ResponseRaw")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibe-common/logging"
+
+	"github.com/joho/godotenv"
+	log "github.com/sirupsen/logrus"
+)
+
+// Configuration structs are plain structs of string, bool, int, float64,
+// time.Duration and []string fields. The key of a field is its name unless a
+// `config:"KEY"` tag overrides it, and embedded structs are flattened so the
+// shared blocks below can be reused by every service.
+//
+// Supported tags:
+//   default:"value"   value used when no source sets the key
+//   required:"true"   the key must end up non-empty
+//   secret:"true"     the value is redacted by Print
+//   oneof:"a,b,c"     the value must be one of the listed values
+//   min:"1"           lower bound for int fields
+//
+// Sources are layered, each one overriding the previous:
+//   1. defaults from the struct tags, then those of the config's Defaults method
+//   2. the JSON file named by CONFIG_FILE, or ./config/<APP_ENV>_config.json when it exists
+//   3. the environment (a local .env file is loaded into it first)
+//   4. <KEY>_FILE environment variables, whose file contents become the value (docker/k8s secrets)
+
+// Base holds the keys every service needs
+type Base struct {
+	APP_ENV        string `required:"true" oneof:"local,dev,prod"`
+	LOG_LEVEL      string `default:"info" oneof:"trace,debug,info,warn,warning,error,fatal,panic"`
+	METHOD_LOGGING bool   `default:"false"`
+	LOG_FORMAT     string `default:"json" oneof:"json,text"`
+	LOG_OUTPUT     string `default:"stdout"` // stdout, stderr or a file path
+}
+
+// LoggingConfig converts the keys into what logging.Setup expects
+func (c Base) LoggingConfig() logging.Config {
+	return logging.Config{
+		Level:         c.LOG_LEVEL,
+		MethodLogging: c.METHOD_LOGGING,
+		Format:        c.LOG_FORMAT,
+		Output:        c.LOG_OUTPUT,
+	}
+}
+
+// Defaulter is implemented by a service's configuration to override the defaults of the shared
+// blocks, e.g. a streaming server without a write timeout. Keys it names that the
+// configuration lacks are reported as problems.
+type Defaulter interface {
+	Defaults() map[string]string
+}
+
+// ValidationError reports every missing or invalid key found while loading
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// field is a single leaf key of a configuration struct
+type field struct {
+	key   string
+	value reflect.Value
+	tag   reflect.StructTag
+}
+
+// Load fills cfg, which must be a pointer to a struct, from every source and
+// validates the result. All problems are returned together in a *ValidationError.
+func Load(cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: Load expects a pointer to a struct, got %T", cfg)
+	}
+	fields := collect(v.Elem())
+	var problems []string
+
+	set := func(f *field, raw string, source string) {
+		if err := assign(f.value, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (from %s): %v", f.key, source, err))
+		}
+	}
+
+	// a local .env only feeds the environment, real environment variables win
+	err := godotenv.Load()
+	if err != nil && !os.IsNotExist(err) {
+		problems = append(problems, "unable to read .env: "+err.Error())
+	}
+
+	// 1. defaults
+	for _, f := range fields {
+		if def, ok := f.tag.Lookup("default"); ok {
+			set(f, def, "default")
+		}
+	}
+	if d, ok := cfg.(Defaulter); ok {
+		overrides := d.Defaults()
+		for _, f := range fields {
+			if def, ok := overrides[f.key]; ok {
+				set(f, def, "service default")
+				delete(overrides, f.key)
+			}
+		}
+		for key := range overrides {
+			problems = append(problems, "default of unknown key "+key)
+		}
+	}
+
+	// 2. file
+	fileName := os.Getenv("CONFIG_FILE")
+	if fileName == "" && os.Getenv("APP_ENV") != "" {
+		fileName = fmt.Sprintf("./config/%s_config.json", os.Getenv("APP_ENV"))
+		if _, err := os.Stat(fileName); err != nil {
+			fileName = ""
+		}
+	}
+	if fileName != "" {
+		values, err := readFile(fileName)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, f := range fields {
+			if raw, ok := values[f.key]; ok {
+				set(f, raw, fileName)
+			}
+		}
+	}
+
+	// 3. environment
+	for _, f := range fields {
+		if raw, ok := os.LookupEnv(f.key); ok {
+			set(f, raw, "env")
+		}
+	}
+
+	// 4. *_FILE secrets
+	for _, f := range fields {
+		path, ok := os.LookupEnv(f.key + "_FILE")
+		if !ok || path == "" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s_FILE: %v", f.key, err))
+			continue
+		}
+		set(f, strings.TrimRight(string(contents), "\r\n"), f.key+"_FILE")
+	}
+
+	// Validate
+	for _, f := range fields {
+		if f.tag.Get("required") == "true" && f.value.IsZero() {
+			problems = append(problems, f.key+" is required but not set")
+			continue
+		}
+		if oneof, ok := f.tag.Lookup("oneof"); ok && f.value.Kind() == reflect.String && f.value.String() != "" {
+			allowed := strings.Split(oneof, ",")
+			if !contains(allowed, f.value.String()) {
+				problems = append(problems, fmt.Sprintf("%s must be one of [%s], got %q", f.key, oneof, f.value.String()))
+			}
+		}
+		if min, ok := f.tag.Lookup("min"); ok && f.value.Kind() == reflect.Int {
+			bound, _ := strconv.Atoi(min)
+			if f.value.Int() < int64(bound) {
+				problems = append(problems, fmt.Sprintf("%s must be at least %d, got %d", f.key, bound, f.value.Int()))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Print logs every key of cfg with secret values redacted
+func Print(cfg interface{}) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	for _, f := range collect(v) {
+		value := fmt.Sprint(f.value.Interface())
+		if f.tag.Get("secret") == "true" && value != "" {
+			value = "********"
+		}
+		log.Info(f.key, ": ", value)
+	}
+}
+
+// collect flattens a struct value into its leaf keys
+func collect(v reflect.Value) []*field {
+	var fields []*field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue // unexported
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, collect(v.Field(i))...)
+			continue
+		}
+		key := sf.Name
+		if k, ok := sf.Tag.Lookup("config"); ok {
+			key = k
+		}
+		fields = append(fields, &field{key: key, value: v.Field(i), tag: sf.Tag})
+	}
+	return fields
+}
+
+// assign parses raw into the field according to its kind
+func assign(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid int %q", raw)
+		}
+		v.SetInt(i)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// readFile reads a flat JSON config file into raw string values
+func readFile(fileName string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file %s: %v", fileName, err)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(contents, &parsed); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %v", fileName, err)
+	}
+	values := make(map[string]string, len(parsed))
+	for k, v := range parsed {
+		switch t := v.(type) {
+		case []interface{}:
+			parts := make([]string, len(t))
+			for i, p := range t {
+				parts[i] = fmt.Sprint(p)
+			}
+			values[k] = strings.Join(parts, ",")
+		default:
+			values[k] = fmt.Sprint(t)
+		}
+	}
+	return values, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
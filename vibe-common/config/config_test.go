@@ -0,0 +1,146 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setenv sets an environment variable for the rest of the test
+func setenv(t *testing.T, key string, value string) {
+	t.Helper()
+	old, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+type testBlock struct {
+	TEST_PORT    string        `required:"true"`
+	TEST_TIMEOUT time.Duration `default:"5s"`
+}
+
+type testConfig struct {
+	testBlock
+	TEST_MODE    string   `default:"fast" oneof:"fast,slow"`
+	TEST_WORKERS int      `default:"2" min:"1"`
+	TEST_TOKEN   string   `secret:"true"`
+	TEST_HOSTS   []string `default:"a, b"`
+	TEST_RATIO   float64  `config:"TEST_SHARE" default:"0.5"`
+	TEST_ON      bool
+}
+
+type testDefaults struct {
+	testBlock
+}
+
+func (testDefaults) Defaults() map[string]string {
+	return map[string]string{"TEST_TIMEOUT": "0s"}
+}
+
+type testUnknownDefault struct {
+	testBlock
+}
+
+func (testUnknownDefault) Defaults() map[string]string {
+	return map[string]string{"TEST_NOPE": "1"}
+}
+
+func TestLoadLayersSources(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(`{"TEST_PORT": ":80", "TEST_WORKERS": 4, "TEST_HOSTS": ["x", "y"], "TEST_MODE": "slow"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "CONFIG_FILE", file)
+	setenv(t, "TEST_MODE", "fast")
+	setenv(t, "TEST_SHARE", "0.25")
+	setenv(t, "TEST_ON", "true")
+	setenv(t, "TEST_TOKEN_FILE", secret)
+
+	var c testConfig
+	if err := Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{
+		testBlock:    testBlock{TEST_PORT: ":80", TEST_TIMEOUT: 5 * time.Second},
+		TEST_MODE:    "fast", // the environment wins over the file
+		TEST_WORKERS: 4,
+		TEST_TOKEN:   "s3cret",
+		TEST_HOSTS:   []string{"x", "y"},
+		TEST_RATIO:   0.25,
+		TEST_ON:      true,
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Load = %+v, want %+v", c, want)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	setenv(t, "CONFIG_FILE", "")
+	setenv(t, "TEST_MODE", "medium")
+	setenv(t, "TEST_WORKERS", "0")
+	setenv(t, "TEST_TIMEOUT", "soon")
+
+	var c testConfig
+	err := Load(&c)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Load = %v, want a *ValidationError", err)
+	}
+	for _, key := range []string{"TEST_PORT is required", "TEST_MODE must be one of", "TEST_WORKERS must be at least 1", "TEST_TIMEOUT (from env)"} {
+		found := false
+		for _, p := range verr.Problems {
+			found = found || strings.Contains(p, key)
+		}
+		if !found {
+			t.Errorf("problems %q miss %q", verr.Problems, key)
+		}
+	}
+}
+
+func TestLoadServiceDefaults(t *testing.T) {
+	setenv(t, "CONFIG_FILE", "")
+	setenv(t, "TEST_PORT", ":80")
+
+	var c testDefaults
+	if err := Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.TEST_TIMEOUT != 0 {
+		t.Errorf("TEST_TIMEOUT = %v, want the service default 0", c.TEST_TIMEOUT)
+	}
+
+	setenv(t, "TEST_TIMEOUT", "1m")
+	if err := Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.TEST_TIMEOUT != time.Minute {
+		t.Errorf("TEST_TIMEOUT = %v, want the environment's 1m", c.TEST_TIMEOUT)
+	}
+
+	var u testUnknownDefault
+	if err := Load(&u); err == nil || !strings.Contains(err.Error(), "TEST_NOPE") {
+		t.Errorf("Load = %v, want the unknown default reported", err)
+	}
+}
+
+func TestLoadRejectsNonStruct(t *testing.T) {
+	var s string
+	if err := Load(&s); err == nil {
+		t.Error("Load of a *string succeeded")
+	}
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"time"
+
+	"vibe-common/middleware"
+	"vibe-common/server"
+	"vibe-common/storage"
+	"vibe-common/store"
+	"vibe-common/tracing"
+)
+
+// MariaDB holds the keys for reaching vibe_db
+type MariaDB struct {
+	MARIA_DB_HOST     string `default:"127.0.0.1"`
+	MARIA_DB_PORT     string `default:"3306"`
+	MARIA_DB_USERNAME string `default:"root"`
+	MARIA_DB_PASSWORD string `required:"true" secret:"true"`
+	MARIA_DB_NAME     string `default:"vibe_db"`
+	// Apply pending schema migrations before serving
+	MIGRATE_ON_STARTUP bool `default:"false"`
+	// Pool limits, 0 open conns is unlimited
+	MARIA_DB_MAX_OPEN_CONNS     int           `default:"25" min:"0"`
+	MARIA_DB_MAX_IDLE_CONNS     int           `default:"25" min:"0"`
+	MARIA_DB_CONN_MAX_LIFETIME  time.Duration `default:"5m"`
+	MARIA_DB_CONN_MAX_IDLE_TIME time.Duration `default:"1m"`
+	MARIA_DB_DIAL_TIMEOUT       time.Duration `default:"5s"`
+	MARIA_DB_READ_TIMEOUT       time.Duration `default:"30s"`
+	MARIA_DB_WRITE_TIMEOUT      time.Duration `default:"30s"`
+	// Deadline for the queries of one repository call, on top of the request's own
+	MARIA_DB_QUERY_TIMEOUT time.Duration `default:"5s"`
+}
+
+// DBConfig converts the keys into what store.InitDB expects
+func (c MariaDB) DBConfig() store.DBConfig {
+	return store.DBConfig{
+		Host:            c.MARIA_DB_HOST,
+		Port:            c.MARIA_DB_PORT,
+		User:            c.MARIA_DB_USERNAME,
+		Password:        c.MARIA_DB_PASSWORD,
+		Name:            c.MARIA_DB_NAME,
+		MaxOpenConns:    c.MARIA_DB_MAX_OPEN_CONNS,
+		MaxIdleConns:    c.MARIA_DB_MAX_IDLE_CONNS,
+		ConnMaxLifetime: c.MARIA_DB_CONN_MAX_LIFETIME,
+		ConnMaxIdleTime: c.MARIA_DB_CONN_MAX_IDLE_TIME,
+		DialTimeout:     c.MARIA_DB_DIAL_TIMEOUT,
+		ReadTimeout:     c.MARIA_DB_READ_TIMEOUT,
+		WriteTimeout:    c.MARIA_DB_WRITE_TIMEOUT,
+	}
+}
+
+// Redis holds the keys for reaching the session cache
+type Redis struct {
+	REDIS_HOST string `default:"127.0.0.1"`
+	REDIS_PORT string `default:"6379"`
+	// Pool limits, 0 active is unlimited, with REDIS_WAIT callers queue for a free connection
+	REDIS_MAX_IDLE      int           `default:"100" min:"0"`
+	REDIS_MAX_ACTIVE    int           `default:"200" min:"0"`
+	REDIS_WAIT          bool          `default:"true"`
+	REDIS_IDLE_TIMEOUT  time.Duration `default:"240s"`
+	REDIS_DIAL_TIMEOUT  time.Duration `default:"5s"`
+	REDIS_READ_TIMEOUT  time.Duration `default:"5s"`
+	REDIS_WRITE_TIMEOUT time.Duration `default:"5s"`
+	// Deadline for borrowing a connection and its commands, on top of the request's own
+	REDIS_COMMAND_TIMEOUT time.Duration `default:"2s"`
+}
+
+// CacheConfig converts the keys into what store.InitCache expects
+func (c Redis) CacheConfig() store.CacheConfig {
+	return store.CacheConfig{
+		Host:         c.REDIS_HOST,
+		Port:         c.REDIS_PORT,
+		MaxIdle:      c.REDIS_MAX_IDLE,
+		MaxActive:    c.REDIS_MAX_ACTIVE,
+		Wait:         c.REDIS_WAIT,
+		IdleTimeout:  c.REDIS_IDLE_TIMEOUT,
+		DialTimeout:  c.REDIS_DIAL_TIMEOUT,
+		ReadTimeout:  c.REDIS_READ_TIMEOUT,
+		WriteTimeout: c.REDIS_WRITE_TIMEOUT,
+	}
+}
+
+// Server holds the http.Server timeouts, uploads need the read/write timeouts to cover a whole
+// chunk and streams a write timeout of 0, services taking either override these defaults
+type Server struct {
+	SERVER_READ_HEADER_TIMEOUT time.Duration `default:"10s"`
+	SERVER_READ_TIMEOUT        time.Duration `default:"60s"`
+	SERVER_WRITE_TIMEOUT       time.Duration `default:"60s"`
+	SERVER_IDLE_TIMEOUT        time.Duration `default:"120s"`
+	SERVER_SHUTDOWN_TIMEOUT    time.Duration `default:"30s"`
+
+	// Browser access, "*" lets any origin in and an empty list turns CORS off
+	CORS_ALLOWED_ORIGINS   []string      `default:"*"`
+	CORS_ALLOW_CREDENTIALS bool          `default:"false"`
+	CORS_ALLOWED_METHODS   []string      `default:"GET,HEAD,POST,PUT,PATCH,DELETE"`
+	CORS_ALLOWED_HEADERS   []string      `default:"Authorization,Content-Type,Content-Range,Range,X-Request-ID,X-File-Name,Tus-Resumable,Upload-Length,Upload-Metadata,Upload-Offset,Upload-Checksum"`
+	CORS_EXPOSED_HEADERS   []string      `default:"X-Request-ID,Deprecation,Link,Content-Range,Accept-Ranges,Location,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Max-Size,Tus-Checksum-Algorithm,Upload-Length,Upload-Metadata,Upload-Offset,Upload-Expires"`
+	CORS_MAX_AGE           time.Duration `default:"10m"`
+
+	// Security headers, HSTS is only sent over TLS and 0 leaves it out
+	HSTS_MAX_AGE            time.Duration `default:"4320h"`
+	HSTS_INCLUDE_SUBDOMAINS bool          `default:"false"`
+	TRUST_FORWARDED_PROTO   bool          `default:"false"`
+	FRAME_OPTIONS           string        `default:"DENY" oneof:"DENY,SAMEORIGIN"`
+	REFERRER_POLICY         string        `default:"no-referrer"`
+
+	// TLS, served when both files are set; the files are watched so a renewal needs no restart
+	TLS_CERT_FILE       string
+	TLS_KEY_FILE        string
+	TLS_MIN_VERSION     string        `default:"1.2" oneof:"1.2,1.3"`
+	TLS_CIPHER_POLICY   string        `default:"intermediate" oneof:"intermediate,compatible"`
+	TLS_RELOAD_INTERVAL time.Duration `default:"1m"`
+}
+
+// ServerConfig converts the keys into what server.Run expects
+func (c Server) ServerConfig(addr string) server.Config {
+	return server.Config{
+		Addr:              addr,
+		ReadHeaderTimeout: c.SERVER_READ_HEADER_TIMEOUT,
+		ReadTimeout:       c.SERVER_READ_TIMEOUT,
+		WriteTimeout:      c.SERVER_WRITE_TIMEOUT,
+		IdleTimeout:       c.SERVER_IDLE_TIMEOUT,
+		ShutdownTimeout:   c.SERVER_SHUTDOWN_TIMEOUT,
+		TLS: server.TLSConfig{
+			CertFile:       c.TLS_CERT_FILE,
+			KeyFile:        c.TLS_KEY_FILE,
+			MinVersion:     server.TLSVersions[c.TLS_MIN_VERSION],
+			CipherSuites:   server.CipherPolicies[c.TLS_CIPHER_POLICY],
+			ReloadInterval: c.TLS_RELOAD_INTERVAL,
+		},
+		CORS: middleware.CORSConfig{
+			AllowedOrigins:   c.CORS_ALLOWED_ORIGINS,
+			AllowCredentials: c.CORS_ALLOW_CREDENTIALS,
+			AllowedMethods:   c.CORS_ALLOWED_METHODS,
+			AllowedHeaders:   c.CORS_ALLOWED_HEADERS,
+			ExposedHeaders:   c.CORS_EXPOSED_HEADERS,
+			MaxAge:           c.CORS_MAX_AGE,
+		},
+		Security: middleware.SecurityConfig{
+			HSTSMaxAge:            c.HSTS_MAX_AGE,
+			HSTSIncludeSubdomains: c.HSTS_INCLUDE_SUBDOMAINS,
+			TrustForwardedProto:   c.TRUST_FORWARDED_PROTO,
+			FrameOptions:          c.FRAME_OPTIONS,
+			ReferrerPolicy:        c.REFERRER_POLICY,
+		},
+	}
+}
+
+// Health holds the readiness check and startup retry settings
+type Health struct {
+	READINESS_TIMEOUT     time.Duration `default:"2s"`
+	STARTUP_RETRIES       int           `default:"5" min:"1"`
+	STARTUP_RETRY_BACKOFF time.Duration `default:"1s"`
+}
+
+// Tracing selects the span exporter, stdout and file are meant for local use
+type Tracing struct {
+	TRACING_EXPORTER     string  `default:"none" oneof:"none,stdout,file,otlp"`
+	TRACING_FILE         string  `default:"traces.json"`
+	TRACING_ENDPOINT     string  `default:"localhost:4318"`
+	TRACING_INSECURE     bool    `default:"true"`
+	TRACING_SAMPLE_RATIO float64 `default:"1"`
+}
+
+// TracingConfig converts the keys into what tracing.Setup expects
+func (c Tracing) TracingConfig(serviceName string) tracing.Config {
+	return tracing.Config{
+		ServiceName: serviceName,
+		Exporter:    c.TRACING_EXPORTER,
+		File:        c.TRACING_FILE,
+		Endpoint:    c.TRACING_ENDPOINT,
+		Insecure:    c.TRACING_INSECURE,
+		SampleRatio: c.TRACING_SAMPLE_RATIO,
+	}
+}
+
+// Storage selects where media objects are kept, files below the service's media directory or
+// an S3 compatible bucket such as MinIO
+type Storage struct {
+	STORAGE_BACKEND string `default:"local" oneof:"local,s3"`
+	// Files bigger than a part are stored as multipart uploads, S3 wants parts of at least 5MB
+	STORAGE_PART_SIZE int    `default:"16777216" min:"5242880"`
+	S3_ENDPOINT       string `default:"http://127.0.0.1:9000"`
+	S3_REGION         string `default:"us-east-1"`
+	S3_BUCKET         string `default:"vibe-media"`
+	S3_ACCESS_KEY     string `secret:"true"`
+	S3_SECRET_KEY     string `secret:"true"`
+	S3_PATH_STYLE     bool   `default:"true"`
+}
+
+// StorageConfig converts the keys into what storage.New expects, root is the local directory
+func (c Storage) StorageConfig(root string) storage.Config {
+	return storage.Config{
+		Backend: c.STORAGE_BACKEND,
+		Root:    root,
+		S3: storage.S3Config{
+			Endpoint:  c.S3_ENDPOINT,
+			Region:    c.S3_REGION,
+			Bucket:    c.S3_BUCKET,
+			AccessKey: c.S3_ACCESS_KEY,
+			SecretKey: c.S3_SECRET_KEY,
+			PathStyle: c.S3_PATH_STYLE,
+		},
+	}
+}
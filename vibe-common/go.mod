@@ -0,0 +1,20 @@
+module vibe-common
+
+go 1.15
+
+require (
+	github.com/XSAM/otelsql v0.8.0
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/gomodule/redigo v1.8.5
+	github.com/gorilla/mux v1.8.0
+	github.com/joho/godotenv v1.5.1
+	github.com/prometheus/client_golang v1.11.1
+	github.com/sirupsen/logrus v1.9.0
+	go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux v0.25.0
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.25.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+)
@@ -0,0 +1,219 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"vibe-common/api"
+	"vibe-common/storage"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Check reports whether a dependency is usable, it must give up once ctx is done
+type Check func(ctx context.Context) error
+
+// Status is the JSON reported for a single dependency
+type Status struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is the JSON body of /readyz
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Status `json:"checks"`
+}
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks registered by a service
+type Checker struct {
+	timeout time.Duration
+	mu      sync.RWMutex
+	checks  []namedCheck
+}
+
+// New creates a Checker where every check gets at most timeout to answer
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a dependency check under name
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Run executes every check concurrently and collects the results
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Status, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			status := c.runOne(ctx, nc.check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = status
+			if status.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}(nc)
+	}
+	wg.Wait()
+	return report
+}
+
+// runOne runs a single check with the timeout, a check that never returns is reported as timed out
+func (c *Checker) runOne(ctx context.Context, check Check) Status {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() { result <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	status := Status{Status: StatusOK, Latency: time.Since(start).String()}
+	if err != nil {
+		status.Status = StatusUnavailable
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// Liveness answers /healthz, the process is up if it can answer at all
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	api.RespondOK(w, Report{Status: StatusOK, Checks: map[string]Status{}})
+}
+
+// Readiness answers /readyz with the status of every dependency, 503 if any is unavailable
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	if report.Status != StatusOK {
+		api.Respond(w, report, http.StatusServiceUnavailable)
+		return
+	}
+	api.RespondOK(w, report)
+}
+
+// DB pings the MariaDB pool
+func DB(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		if db == nil {
+			return errors.New("not initialized")
+		}
+		return db.PingContext(ctx)
+	}
+}
+
+// Redis borrows a connection from the pool and sends PING
+func Redis(pool *redis.Pool) Check {
+	return func(ctx context.Context) error {
+		if pool == nil {
+			return errors.New("not initialized")
+		}
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		timeout := time.Duration(0)
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		_, err = redis.DoWithTimeout(conn, timeout, "PING")
+		return err
+	}
+}
+
+// HTTP checks an upstream answers url, any non 5xx status counts as reachable
+func HTTP(client *http.Client, url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("upstream answered %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// Writable checks that a file can be created in dir
+func Writable(dir string) Check {
+	return func(ctx context.Context) error {
+		f, err := ioutil.TempFile(dir, ".readyz-")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		f.Close()
+		return os.Remove(name)
+	}
+}
+
+// Readable checks that dir exists and can be listed
+func Readable(dir string) Check {
+	return func(ctx context.Context) error {
+		f, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Readdirnames(1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	}
+}
+
+// Storage lists the media storage, which fails when a bucket or root directory is unreachable
+func Storage(s storage.Storage) Check {
+	return func(ctx context.Context) error {
+		if s == nil {
+			return errors.New("not initialized")
+		}
+		_, err := s.List(ctx, "readyz/") // nothing is stored there, so listing it is cheap
+		return err
+	}
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the request ID between services
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userIDKey
+)
+
+// userHolder lets handlers record the user after the request context was created
+type userHolder struct {
+	mu sync.Mutex
+	id string
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithUserSlot returns a copy of ctx that SetUserID can record the user on
+func WithUserSlot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, userIDKey, &userHolder{})
+}
+
+// SetUserID records the user making the request so the access log can report it
+func SetUserID(ctx context.Context, id string) {
+	if holder, ok := ctx.Value(userIDKey).(*userHolder); ok {
+		holder.mu.Lock()
+		holder.id = id
+		holder.mu.Unlock()
+	}
+}
+
+// UserID returns the user recorded with SetUserID, or an empty string
+func UserID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if holder, ok := ctx.Value(userIDKey).(*userHolder); ok {
+		holder.mu.Lock()
+		defer holder.mu.Unlock()
+		return holder.id
+	}
+	return ""
+}
+
+// FromContext returns a logger that tags every entry with the request ID and user of ctx
+func FromContext(ctx context.Context) *log.Entry {
+	return log.WithContext(ctx)
+}
+
+// contextHook copies the request ID and user of an entry's context into its fields
+type contextHook struct{}
+
+func (contextHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (contextHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if id := RequestID(entry.Context); id != "" {
+		entry.Data["request_id"] = id
+	}
+	if user := UserID(entry.Context); user != "" {
+		entry.Data["user_id"] = user
+	}
+	return nil
+}
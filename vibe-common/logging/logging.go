@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"io"
+	stdlog "log"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config selects the level, format and destination of the global logger
+type Config struct {
+	Level         string
+	MethodLogging bool
+	// json or text
+	Format string
+	// stdout, stderr or a file path
+	Output string
+}
+
+// Setup points the global logrus logger at the configured output and applies
+// the level and format. The standard library logger is routed through logrus
+// so packages still using it end up in the same stream.
+func Setup(cfg Config) {
+	log.SetOutput(openOutput(cfg.Output))
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.StandardLogger().WriterLevel(log.InfoLevel))
+
+	if cfg.Format == "text" {
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	} else {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	// Log the calling method along with log messages
+	log.SetReportCaller(cfg.MethodLogging)
+
+	// Request scoped fields for entries logged with FromContext or log.WithContext
+	log.AddHook(contextHook{})
+
+	// Trace, Debug, Info, Warn, Error, Fatal, and Panic (oridnal 6 - 0)
+	parsedLevel, err := log.ParseLevel(cfg.Level)
+	if err != nil {
+		log.Error("Invalid log level, defaulting to debug: ", err)
+		parsedLevel = log.DebugLevel
+	}
+
+	// Set global log level
+	log.SetLevel(parsedLevel)
+
+	log.Info("STARTING LOG...")
+	log.Info("LOG_LEVEL: " + cfg.Level)
+}
+
+// openOutput resolves the configured output, falling back to stdout if a file cannot be opened
+func openOutput(output string) io.Writer {
+	switch output {
+	case "", "stdout":
+		return os.Stdout
+	case "stderr":
+		return os.Stderr
+	}
+	os.Remove(output) // remove old log
+	file, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		log.Error("Cannot create log file, logging to stdout: ", err)
+		return os.Stdout
+	}
+	return file
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric exported by the vibe services
+const Namespace = "vibe"
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route template and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+)
+
+// Handler serves the registered metrics for /metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records request metrics labeled by the matched mux route template,
+// so /videos/{latitude}/{longitude} is one series instead of one per location
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		Instrument(route, next).ServeHTTP(w, r)
+	})
+}
+
+// Instrument records request metrics for handler under a fixed route label,
+// for handlers that are not served through a mux router
+func Instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter remembers the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the wrapper
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// RegisterDB exports sql.DB.Stats for the pool as go_sql_* metrics labeled db_name
+func RegisterDB(name string, db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterCache exports redis.Pool.Stats for the pool labeled pool
+func RegisterCache(name string, pool *redis.Pool) {
+	labels := prometheus.Labels{"pool": name}
+	prometheus.MustRegister(&poolCollector{
+		pool: pool,
+		active: prometheus.NewDesc(Namespace+"_redis_pool_active_connections",
+			"Connections in the Redis pool, idle and in use.", nil, labels),
+		idle: prometheus.NewDesc(Namespace+"_redis_pool_idle_connections",
+			"Idle connections in the Redis pool.", nil, labels),
+		waitCount: prometheus.NewDesc(Namespace+"_redis_pool_wait_count_total",
+			"Connections waited for.", nil, labels),
+		waitDuration: prometheus.NewDesc(Namespace+"_redis_pool_wait_duration_seconds_total",
+			"Time blocked waiting for a connection.", nil, labels),
+	})
+}
+
+// poolCollector reads redis.Pool.Stats on every scrape
+type poolCollector struct {
+	pool                                  *redis.Pool
+	active, idle, waitCount, waitDuration *prometheus.Desc
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+	ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(stats.ActiveCount))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.IdleCount))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
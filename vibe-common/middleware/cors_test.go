@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	listed := CORSConfig{
+		AllowedOrigins:   []string{"https://app.vibecheck.tech"},
+		AllowCredentials: true,
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"Upload-Offset"},
+		MaxAge:           time.Hour,
+	}
+	anyOrigin := CORSConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}
+
+	tests := []struct {
+		name    string
+		config  CORSConfig
+		method  string
+		origin  string
+		status  int
+		headers map[string]string
+	}{
+		{"preflight from a listed origin", listed, http.MethodOptions, "https://APP.vibecheck.tech", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":      "https://APP.vibecheck.tech",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, POST",
+			"Access-Control-Allow-Headers":     "Content-Type",
+			"Access-Control-Max-Age":           "3600",
+			"Access-Control-Expose-Headers":    "",
+		}},
+		{"preflight from another origin", listed, http.MethodOptions, "https://evil.example", http.StatusForbidden, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"request from a listed origin", listed, http.MethodGet, "https://app.vibecheck.tech", http.StatusTeapot, map[string]string{
+			"Access-Control-Allow-Origin":   "https://app.vibecheck.tech",
+			"Access-Control-Expose-Headers": "Upload-Offset",
+			"Access-Control-Allow-Methods":  "",
+			"Vary":                          "Origin",
+		}},
+		{"request from another origin", listed, http.MethodGet, "https://evil.example", http.StatusTeapot, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"request without an origin", listed, http.MethodOptions, "", http.StatusTeapot, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"preflight to any origin", anyOrigin, http.MethodOptions, "https://evil.example", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":      "*",
+			"Access-Control-Allow-Credentials": "",
+			"Access-Control-Max-Age":           "",
+			"Vary":                             "Access-Control-Request-Method",
+		}},
+		{"cors turned off", CORSConfig{}, http.MethodOptions, "https://app.vibecheck.tech", http.StatusTeapot, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/vibes", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			w := httptest.NewRecorder()
+			CORS(tt.config)(ok).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			for key, want := range tt.headers {
+				if got := w.Header().Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import "net/http"
+
+// Deprecated marks every response of a legacy route with a Deprecation header, and
+// with a Link to the route replacing it when successor is not empty
+func Deprecated(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if successor != "" {
+				w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
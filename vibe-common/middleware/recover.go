@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Recover turns a panicking handler into a logged stack trace and a JSON 500, so one
+// bad request never takes the process down. Place it after RequestID and AccessLog.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler { // net/http's own way of dropping a connection
+				panic(p)
+			}
+			logging.FromContext(r.Context()).WithFields(log.Fields{
+				"panic": p,
+				"stack": string(debug.Stack()),
+			}).Error("handler panicked")
+
+			if sw.wroteHeader { // too late for a clean response, the client sees a cut-off body
+				return
+			}
+			api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "internal server error")
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
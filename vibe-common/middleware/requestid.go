@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"vibe-common/logging"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestID reuses the caller's X-Request-ID or assigns a new one, echoes it on
+// the response and stores it in the request context for logging and outbound calls
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		ctx := logging.WithUserSlot(logging.WithRequestID(r.Context(), id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AccessLog writes one entry per request with the request ID, route, user, status and latency.
+// The user is the one recorded with logging.SetUserID, or the user_id form value.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		user := logging.UserID(r.Context())
+		if user == "" && r.Form != nil {
+			user = r.Form.Get("user_id")
+		}
+
+		logging.FromContext(r.Context()).WithFields(log.Fields{
+			"method":     r.Method,
+			"route":      route,
+			"status":     sw.status,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"user_id":    user,
+		}).Info("request handled")
+	})
+}
+
+// PropagateRequestID wraps an outbound transport so every request made with a
+// context carrying a request ID forwards it as X-Request-ID. A nil base uses
+// http.DefaultTransport.
+func PropagateRequestID(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		id := logging.RequestID(r.Context())
+		if id == "" || r.Header.Get(logging.RequestIDHeader) != "" {
+			return base.RoundTrip(r)
+		}
+		r = r.Clone(r.Context()) // a RoundTripper must not modify the caller's request
+		r.Header.Set(logging.RequestIDHeader, id)
+		return base.RoundTrip(r)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts caller IDs that are short and safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// statusWriter remembers the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the wrapper
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
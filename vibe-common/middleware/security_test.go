@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	direct := SecurityConfig{HSTSMaxAge: time.Hour, FrameOptions: "DENY", ReferrerPolicy: "no-referrer"}
+	proxied := SecurityConfig{HSTSMaxAge: time.Hour, HSTSIncludeSubdomains: true, TrustForwardedProto: true}
+
+	tests := []struct {
+		name   string
+		config SecurityConfig
+		tls    bool
+		proto  string
+		hsts   string
+	}{
+		{"plain HTTP", direct, false, "", ""},
+		{"TLS", direct, true, "", "max-age=3600"},
+		{"forwarded proto from a client", direct, false, "https", ""},
+		{"forwarded proto from a trusted proxy", proxied, false, "https", "max-age=3600; includeSubDomains"},
+		{"trusted proxy forwarding plain HTTP", proxied, false, "http", ""},
+		{"HSTS turned off", SecurityConfig{TrustForwardedProto: true}, true, "https", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		w := httptest.NewRecorder()
+		SecurityHeaders(tt.config)(ok).ServeHTTP(w, r)
+		h := w.Header()
+		if got := h.Get("Strict-Transport-Security"); got != tt.hsts {
+			t.Errorf("%s: Strict-Transport-Security %q, want %q", tt.name, got, tt.hsts)
+		}
+		if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("X-Frame-Options") != tt.config.FrameOptions || h.Get("Referrer-Policy") != tt.config.ReferrerPolicy {
+			t.Errorf("%s: headers %v", tt.name, h)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Migration is one versioned schema change. Statements run one Exec at a time
+// since the driver does not allow multi statements. MariaDB commits DDL
+// implicitly, so a migration that fails halfway is not rolled back and
+// every statement should be safe to run again.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// State reports whether a migration has been applied and when
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Name of the table applied versions are recorded in
+const Table = "schema_migrations"
+
+// Named lock so services starting together do not migrate concurrently
+const lockName = "vibe_db_migrate"
+const lockTimeout = 60
+
+var ErrLocked = errors.New("another migration holds the lock")
+
+// Up applies every pending migration in order
+func Up(ctx context.Context, db *sql.DB) error {
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for _, m := range All {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, m, m.Up); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "INSERT INTO "+Table+" (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+				return fmt.Errorf("recording migration %d: %v", m.Version, err)
+			}
+			log.Info("Applied migration ", m.Version, " ", m.Name)
+			pending++
+		}
+		if pending == 0 {
+			log.Info("Schema up to date at version ", latest())
+		}
+		return nil
+	})
+}
+
+// Down reverts the last steps applied migrations, newest first
+func Down(ctx context.Context, db *sql.DB, steps int) error {
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(All) - 1; i >= 0 && steps > 0; i-- {
+			m := All[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := apply(ctx, conn, m, m.Down); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM "+Table+" WHERE version = ?", m.Version); err != nil {
+				return fmt.Errorf("unrecording migration %d: %v", m.Version, err)
+			}
+			log.Info("Reverted migration ", m.Version, " ", m.Name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status lists every known migration and whether it has been applied
+func Status(ctx context.Context, db *sql.DB) ([]State, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]State, 0, len(All))
+	for _, m := range All {
+		at, ok := applied[m.Version]
+		states = append(states, State{Migration: m, Applied: ok, AppliedAt: at})
+	}
+	return states, nil
+}
+
+// Run executes the migrate subcommand: up, down [steps] or status
+func Run(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down [steps] | status")
+	}
+	switch args[0] {
+	case "up":
+		return Up(ctx, db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		return Down(ctx, db, steps)
+	case "status":
+		states, err := Status(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-24s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, want up, down or status", args[0])
+	}
+}
+
+// apply runs the statements of one direction of a migration
+func apply(ctx context.Context, conn *sql.Conn, m Migration, statements []string) error {
+	for _, stmt := range statements {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// appliedVersions creates the bookkeeping table if needed and reads it
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+Table+` (
+	version INT NOT NULL,
+	name VARCHAR(100) NOT NULL,
+	applied_at DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+	PRIMARY KEY (version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM "+Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// withLock holds a MariaDB named lock on one connection while fn runs
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, lockTimeout).Scan(&got); err != nil {
+		return err
+	}
+	if got.Int64 != 1 {
+		return ErrLocked
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+
+	return fn(conn)
+}
+
+// latest is the highest known version
+func latest() int {
+	if len(All) == 0 {
+		return 0
+	}
+	return All[len(All)-1].Version
+}
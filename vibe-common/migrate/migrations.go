@@ -0,0 +1,292 @@
+package migrate
+
+// All is the vibe_db schema in version order. The SQL lives in Go source
+// rather than embedded files since the services still build with go 1.15.
+// The create migrations use IF NOT EXISTS so databases created by hand
+// before migrations existed adopt the history without changes. Never edit a
+// released migration, add a new one instead.
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: []string{`CREATE TABLE IF NOT EXISTS users (
+	user_id VARCHAR(36) NOT NULL,
+	user_name VARCHAR(20) NOT NULL,
+	password CHAR(60) NOT NULL,
+	email VARCHAR(100) DEFAULT NULL,
+	date_created DATETIME(3) DEFAULT CURRENT_TIMESTAMP(3),
+	date_updated DATETIME(3) DEFAULT CURRENT_TIMESTAMP(3),
+	phone VARCHAR(15) NOT NULL,
+	photo BOOLEAN NOT NULL DEFAULT FALSE,
+	first_name VARCHAR(255) DEFAULT NULL,
+	last_name VARCHAR(255) DEFAULT NULL,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	follower_count INT NOT NULL DEFAULT 0,
+	following_count INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id),
+	UNIQUE KEY users_user_name (user_name),
+	KEY users_phone (phone)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS users`},
+	},
+	{
+		Version: 2,
+		Name:    "create_locations",
+		Up: []string{`CREATE TABLE IF NOT EXISTS locations (
+	location_hash VARCHAR(20) NOT NULL,
+	location_name VARCHAR(255) NOT NULL,
+	lat DOUBLE NOT NULL,
+	lon DOUBLE NOT NULL,
+	PRIMARY KEY (location_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS locations`},
+	},
+	{
+		Version: 3,
+		Name:    "create_all_videos",
+		Up: []string{`CREATE TABLE IF NOT EXISTS all_videos (
+	video_folder VARCHAR(100) NOT NULL,
+	location_hash VARCHAR(20) NOT NULL,
+	user_id VARCHAR(36) NOT NULL,
+	time_stamp DATETIME(3) NOT NULL,
+	like_count INT NOT NULL DEFAULT 0,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	PRIMARY KEY (video_folder, location_hash),
+	KEY all_videos_location (location_hash, time_stamp),
+	KEY all_videos_user (user_id, time_stamp)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS all_videos`},
+	},
+	{
+		Version: 4,
+		Name:    "create_latest_videos",
+		Up: []string{`CREATE TABLE IF NOT EXISTS latest_videos (
+	location_hash VARCHAR(20) NOT NULL,
+	video_folder VARCHAR(100) NOT NULL,
+	user_id VARCHAR(36) DEFAULT NULL,
+	time_stamp DATETIME(3) DEFAULT NULL,
+	like_count INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (location_hash),
+	KEY latest_videos_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS latest_videos`},
+	},
+	{
+		Version: 5,
+		Name:    "create_all_chats",
+		Up: []string{`CREATE TABLE IF NOT EXISTS all_chats (
+	_id VARCHAR(64) NOT NULL,
+	location_hash VARCHAR(20) NOT NULL,
+	thread_name VARCHAR(100) NOT NULL,
+	user_id VARCHAR(36) NOT NULL,
+	createdAt DATETIME(3) NOT NULL,
+	msg_text TEXT NOT NULL,
+	PRIMARY KEY (_id),
+	KEY all_chats_thread (location_hash, thread_name, createdAt),
+	KEY all_chats_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS all_chats`},
+	},
+	{
+		Version: 6,
+		Name:    "create_favorites",
+		Up: []string{`CREATE TABLE IF NOT EXISTS favorites (
+	user_id VARCHAR(36) NOT NULL,
+	location_hash VARCHAR(20) NOT NULL,
+	PRIMARY KEY (user_id, location_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS favorites`},
+	},
+	{
+		Version: 7,
+		Name:    "create_videos_liked",
+		Up: []string{`CREATE TABLE IF NOT EXISTS videos_liked (
+	video_folder VARCHAR(100) NOT NULL,
+	location_hash VARCHAR(20) NOT NULL,
+	user_id VARCHAR(36) NOT NULL,
+	PRIMARY KEY (video_folder, location_hash, user_id),
+	KEY videos_liked_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS videos_liked`},
+	},
+	{
+		Version: 8,
+		Name:    "create_user_follower",
+		Up: []string{`CREATE TABLE IF NOT EXISTS user_follower (
+	user_id VARCHAR(36) NOT NULL,
+	user_id_following VARCHAR(36) NOT NULL,
+	PRIMARY KEY (user_id, user_id_following),
+	KEY user_follower_following (user_id_following)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS user_follower`},
+	},
+	{
+		Version: 9,
+		Name:    "create_subscribers",
+		Up: []string{`CREATE TABLE IF NOT EXISTS subscribers (
+	subscriber_id INT NOT NULL AUTO_INCREMENT,
+	email VARCHAR(100) NOT NULL,
+	date_created DATETIME(3) DEFAULT CURRENT_TIMESTAMP(3),
+	PRIMARY KEY (subscriber_id),
+	UNIQUE KEY subscribers_email (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS subscribers`},
+	},
+	{
+		Version: 10,
+		Name:    "create_location_tags",
+		Up: []string{`CREATE TABLE IF NOT EXISTS location_tags (
+	id INT NOT NULL AUTO_INCREMENT,
+	location_hash VARCHAR(20) NOT NULL,
+	tag VARCHAR(100) NOT NULL,
+	PRIMARY KEY (id),
+	KEY location_tags_location (location_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS location_tags`},
+	},
+	{
+		// Read by core-api's GetLatestVideo
+		Version: 11,
+		Name:    "create_video",
+		Up: []string{`CREATE TABLE IF NOT EXISTS video (
+	id INT NOT NULL AUTO_INCREMENT,
+	latitude VARCHAR(20) NOT NULL,
+	longitude VARCHAR(20) NOT NULL,
+	date_created DATETIME(3) DEFAULT CURRENT_TIMESTAMP(3),
+	user_id VARCHAR(36) NOT NULL,
+	vibe_points VARCHAR(20) DEFAULT NULL,
+	PRIMARY KEY (id),
+	KEY video_location (latitude, longitude, date_created)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS video`},
+	},
+	{
+		// Resumable uploads of cdn-api, the bytes live on disk under upload_id
+		Version: 12,
+		Name:    "create_uploads",
+		Up: []string{`CREATE TABLE IF NOT EXISTS uploads (
+	upload_id VARCHAR(36) NOT NULL,
+	user_id VARCHAR(36) NOT NULL,
+	upload_length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	metadata TEXT NOT NULL,
+	created_at DATETIME(3) NOT NULL,
+	expires_at DATETIME(3) NOT NULL,
+	PRIMARY KEY (upload_id),
+	KEY uploads_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS uploads`},
+	},
+	{
+		Version: 13,
+		Name:    "add_all_videos_sha256",
+		Up:      []string{`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS sha256 CHAR(64) NOT NULL DEFAULT ''`},
+		Down:    []string{`ALTER TABLE all_videos DROP COLUMN IF EXISTS sha256`},
+	},
+	{
+		// Written ranges of an upload, finalize rewinds to the first one it cannot vouch for
+		Version: 14,
+		Name:    "create_upload_chunks",
+		Up: []string{`CREATE TABLE IF NOT EXISTS upload_chunks (
+	upload_id VARCHAR(36) NOT NULL,
+	chunk_offset BIGINT NOT NULL,
+	chunk_length BIGINT NOT NULL,
+	sha256 CHAR(64) NOT NULL,
+	verified BOOLEAN NOT NULL DEFAULT FALSE,
+	PRIMARY KEY (upload_id, chunk_offset)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS upload_chunks`},
+	},
+	{
+		// Videos uploaded before transcoding existed are ready as they are, without HLS
+		Version: 15,
+		Name:    "add_all_videos_status",
+		Up: []string{
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS status VARCHAR(12) NOT NULL DEFAULT 'ready'`,
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS hls BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+		Down: []string{
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS hls`,
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS status`,
+		},
+	},
+	{
+		// Transcoding queue of cdn-api, run_after is when a queued job is due or a running one's lease ends
+		Version: 16,
+		Name:    "create_transcode_jobs",
+		Up: []string{`CREATE TABLE IF NOT EXISTS transcode_jobs (
+	video_folder VARCHAR(100) NOT NULL,
+	location_hash VARCHAR(20) NOT NULL,
+	source_key VARCHAR(255) NOT NULL,
+	status VARCHAR(12) NOT NULL DEFAULT 'queued',
+	attempts INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(1000) NOT NULL DEFAULT '',
+	claim VARCHAR(36) NOT NULL DEFAULT '',
+	run_after DATETIME(3) NOT NULL,
+	created_at DATETIME(3) NOT NULL,
+	updated_at DATETIME(3) NOT NULL,
+	PRIMARY KEY (video_folder, location_hash),
+	KEY transcode_jobs_due (status, run_after)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS transcode_jobs`},
+	},
+	{
+		// Probed from the uploaded file, zero for videos uploaded before, width and height as displayed
+		Version: 17,
+		Name:    "add_all_videos_metadata",
+		Up: []string{
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS duration_ms INT NOT NULL DEFAULT 0`,
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS width INT NOT NULL DEFAULT 0`,
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS height INT NOT NULL DEFAULT 0`,
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS codec VARCHAR(16) NOT NULL DEFAULT ''`,
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS rotation SMALLINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS bitrate BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE all_videos ADD COLUMN IF NOT EXISTS file_size BIGINT NOT NULL DEFAULT 0`,
+		},
+		Down: []string{
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS file_size`,
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS bitrate`,
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS rotation`,
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS codec`,
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS height`,
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS width`,
+			`ALTER TABLE all_videos DROP COLUMN IF EXISTS duration_ms`,
+		},
+	},
+	{
+		// Vibes whose files are uploaded before the vibe is recorded, parts is the manifest's
+		// comma separated roles and each stored part has a row in vibe_draft_parts
+		Version: 18,
+		Name:    "create_vibe_drafts",
+		Up: []string{`CREATE TABLE IF NOT EXISTS vibe_drafts (
+	draft_id VARCHAR(36) NOT NULL,
+	user_id VARCHAR(36) NOT NULL,
+	video_folder VARCHAR(100) NOT NULL,
+	location_hash VARCHAR(20) NOT NULL,
+	location_name VARCHAR(255) NOT NULL,
+	lat DOUBLE NOT NULL,
+	lon DOUBLE NOT NULL,
+	time_stamp DATETIME(3) NOT NULL,
+	parts VARCHAR(100) NOT NULL,
+	created_at DATETIME(3) NOT NULL,
+	expires_at DATETIME(3) NOT NULL,
+	PRIMARY KEY (draft_id),
+	UNIQUE KEY vibe_drafts_vibe (video_folder, location_hash),
+	KEY vibe_drafts_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, `CREATE TABLE IF NOT EXISTS vibe_draft_parts (
+	draft_id VARCHAR(36) NOT NULL,
+	role VARCHAR(12) NOT NULL,
+	storage_key VARCHAR(255) NOT NULL,
+	sha256 CHAR(64) NOT NULL,
+	duration_ms INT NOT NULL DEFAULT 0,
+	width INT NOT NULL DEFAULT 0,
+	height INT NOT NULL DEFAULT 0,
+	codec VARCHAR(16) NOT NULL DEFAULT '',
+	rotation SMALLINT NOT NULL DEFAULT 0,
+	bitrate BIGINT NOT NULL DEFAULT 0,
+	file_size BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (draft_id, role)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		Down: []string{`DROP TABLE IF EXISTS vibe_draft_parts`, `DROP TABLE IF EXISTS vibe_drafts`},
+	},
+}
@@ -0,0 +1,223 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+type requestKey struct{}
+
+// Request returns the request the route's validation decoded, a pointer to a new
+// value of the route's Request type, or nil for routes without one
+func Request(r *http.Request) interface{} {
+	return r.Context().Value(requestKey{})
+}
+
+// validate decodes every request into a new value of model's type, rejecting it with
+// a VALIDATION_FAILED envelope that lists each missing or invalid field
+func validate(model interface{}, next http.Handler) http.Handler {
+	if model == nil {
+		return next
+	}
+	t := structType(model)
+	fields := requestFields(t)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := reflect.New(t)
+		invalid, err := bind(r, v.Elem(), fields)
+		if err != nil {
+			api.RespondError(w, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+			return
+		}
+		if invalid != nil {
+			logging.FromContext(r.Context()).WithField("invalid", invalid).Info("request failed validation")
+			api.RespondInvalid(w, invalid...)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestKey{}, v.Interface())))
+	})
+}
+
+// bind fills v from the request, an unreadable body is returned as the error
+func bind(r *http.Request, v reflect.Value, fields []field) ([]api.FieldError, error) {
+	var body map[string]json.RawMessage
+	for _, f := range fields {
+		if f.in == "body" {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+				log.Trace("undecodable request body: ", err)
+				return nil, errors.New("request body must be a JSON object")
+			}
+			break
+		}
+	}
+
+	var invalid []api.FieldError
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		present, err := f.read(r, body, fv)
+		if err != nil {
+			invalid = append(invalid, api.FieldError{Field: f.name, Message: err.Error()})
+			continue
+		}
+		if !present || (fv.Kind() == reflect.String && fv.Len() == 0) {
+			if f.required {
+				invalid = append(invalid, api.FieldError{Field: f.name, Message: "is required"})
+			}
+			continue
+		}
+		if message := f.check(fv); message != "" {
+			invalid = append(invalid, api.FieldError{Field: f.name, Message: message})
+		}
+	}
+	return invalid, nil
+}
+
+// read sets fv from where the field lives and reports whether the request carried it
+func (f field) read(r *http.Request, body map[string]json.RawMessage, fv reflect.Value) (bool, error) {
+	switch {
+	case f.in == "body":
+		raw, ok := body[f.name]
+		if !ok || string(raw) == "null" {
+			return false, nil
+		}
+		if err := json.Unmarshal(raw, fv.Addr().Interface()); err != nil {
+			return true, errors.New(expected(f.typ))
+		}
+		return true, nil
+	case isFile(f.typ):
+		file, header, err := r.FormFile(f.name)
+		if err != nil {
+			return false, nil
+		}
+		file.Close() // handlers open the header again
+		fv.Set(reflect.ValueOf(header))
+		return true, nil
+	}
+
+	var s string
+	switch f.in {
+	case "path":
+		s = mux.Vars(r)[f.name]
+	case "query":
+		s = r.URL.Query().Get(f.name)
+	case "header":
+		s = r.Header.Get(f.name)
+	case "form":
+		s = r.FormValue(f.name)
+	}
+	if s == "" {
+		return false, nil
+	}
+	return true, parse(s, fv)
+}
+
+// parse sets a scalar from its string form
+func parse(s string, fv reflect.Value) error {
+	fail := errors.New(expected(fv.Type()))
+	if fv.Type() == timeType {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return fail
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fail
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return fail
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return fail
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return fail
+		}
+		fv.SetFloat(n)
+	}
+	return nil
+}
+
+// check applies the min, max and oneof constraints, "" means the value passes
+func (f field) check(fv reflect.Value) string {
+	var n float64
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(fv.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = fv.Float()
+	case reflect.String:
+		if f.oneof == nil {
+			return ""
+		}
+		for _, allowed := range f.oneof {
+			if fv.String() == allowed {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(f.oneof, ", ")
+	default:
+		return ""
+	}
+	if f.min != nil && n < *f.min {
+		return "must be at least " + strconv.FormatFloat(*f.min, 'f', -1, 64)
+	}
+	if f.max != nil && n > *f.max {
+		return "must be at most " + strconv.FormatFloat(*f.max, 'f', -1, 64)
+	}
+	return ""
+}
+
+// expected says what a value of t must look like
+func expected(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "must be an RFC 3339 time, e.g. 2006-01-02T15:04:05.000Z"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "must be true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "must be an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "must be a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "must be a number"
+	case reflect.Slice, reflect.Array:
+		return "must be an array"
+	case reflect.Map, reflect.Struct:
+		return "must be an object"
+	}
+	return "must be a string"
+}
@@ -0,0 +1,284 @@
+package openapi
+
+import (
+	"fmt"
+	"mime/multipart"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema as OpenAPI 3.0 uses it
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	fileType = reflect.TypeOf(multipart.FileHeader{})
+)
+
+// registry holds the schemas of named struct types, each described once under
+// components/schemas and referenced everywhere it appears
+type registry struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newRegistry() *registry {
+	return &registry{schemas: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+// field is one bindable field of a request type. It is read from the place its
+// path, query, header, form or json tag names, json meaning the JSON body, and
+// checked against its required, min, max and oneof tags. doc describes it.
+type field struct {
+	index    int
+	name     string
+	in       string // path, query, header, form or body
+	typ      reflect.Type
+	required bool
+	min      *float64
+	max      *float64
+	oneof    []string
+	doc      string
+}
+
+// structType is the struct type behind a Request value
+func structType(model interface{}) reflect.Type {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("openapi: request type %s is not a struct", t))
+	}
+	return t
+}
+
+// requestFields reads the tags of a request type, a tag the binder cannot honour
+// panics so a bad route fails at startup rather than on its first request
+func requestFields(t reflect.Type) []field {
+	var fields []field
+	var inForm, inBody bool
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		f := field{index: i, typ: sf.Type, doc: sf.Tag.Get("doc"), required: sf.Tag.Get("required") == "true"}
+		for _, in := range []string{"path", "query", "header", "form", "json"} {
+			if name, ok := sf.Tag.Lookup(in); ok {
+				f.name, f.in = strings.Split(name, ",")[0], in
+				break
+			}
+		}
+		if f.name == "" || f.name == "-" {
+			continue
+		}
+		if sf.PkgPath != "" {
+			panic(fmt.Sprintf("openapi: %s.%s is tagged but unexported", t, sf.Name))
+		}
+		if f.in == "json" {
+			f.in, inBody = "body", true
+		} else if !scalar(f.typ) && !isFile(f.typ) {
+			panic(fmt.Sprintf("openapi: %s.%s cannot be read from a string", t, sf.Name))
+		} else if isFile(f.typ) && (f.in != "form" || f.typ.Kind() != reflect.Ptr) {
+			panic(fmt.Sprintf("openapi: %s.%s must be a *multipart.FileHeader with a form tag", t, sf.Name))
+		}
+		if f.in == "form" {
+			inForm = true
+		}
+		f.min, f.max = bound(t, sf, "min"), bound(t, sf, "max")
+		if oneof, ok := sf.Tag.Lookup("oneof"); ok {
+			f.oneof = strings.Fields(oneof)
+		}
+		fields = append(fields, f)
+	}
+	if inForm && inBody {
+		panic(fmt.Sprintf("openapi: %s mixes form and json fields", t))
+	}
+	return fields
+}
+
+func bound(t reflect.Type, sf reflect.StructField, tag string) *float64 {
+	raw, ok := sf.Tag.Lookup(tag)
+	if !ok {
+		return nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: %s.%s has a bad %s tag %q", t, sf.Name, tag, raw))
+	}
+	return &v
+}
+
+// scalar reports whether a value of t can be parsed from a path, query, header or form value
+func scalar(t reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isFile(t reflect.Type) bool {
+	return t == fileType || (t.Kind() == reflect.Ptr && t.Elem() == fileType)
+}
+
+// describeRequest turns the fields of a request type into parameters and a body
+func (reg *registry) describeRequest(model interface{}) ([]Parameter, *RequestBody) {
+	var params []Parameter
+	var body *Schema
+	mediaType := "application/json"
+	for _, f := range requestFields(structType(model)) {
+		s := reg.fieldSchema(f)
+		switch f.in {
+		case "path", "query", "header":
+			params = append(params, Parameter{
+				Name:        f.name,
+				In:          f.in,
+				Description: f.doc,
+				Required:    f.required || f.in == "path",
+				Schema:      s,
+			})
+			continue
+		case "form":
+			if mediaType == "application/json" {
+				mediaType = "application/x-www-form-urlencoded"
+			}
+			if isFile(f.typ) {
+				mediaType = "multipart/form-data"
+			}
+		}
+		if body == nil {
+			body = &Schema{Type: "object", Properties: map[string]*Schema{}}
+		}
+		s.Description = f.doc
+		body.Properties[f.name] = s
+		if f.required {
+			body.Required = append(body.Required, f.name)
+		}
+	}
+	if body == nil {
+		return params, nil
+	}
+	return params, &RequestBody{
+		Required: len(body.Required) > 0,
+		Content:  map[string]MediaType{mediaType: {Schema: body}},
+	}
+}
+
+// fieldSchema describes a request field's type with its constraints
+func (reg *registry) fieldSchema(f field) *Schema {
+	s := reg.schemaOf(f.typ)
+	s.Minimum, s.Maximum, s.Enum = f.min, f.max, f.oneof
+	return s
+}
+
+// schemaOf describes how a Go type encodes to JSON, following json tags
+func (reg *registry) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case fileType:
+		return &Schema{Type: "string", Format: "binary"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: reg.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: reg.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" { // anonymous struct, described in place
+			s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+			reg.addProperties(s, t)
+			return s
+		}
+		return &Schema{Ref: "#/components/schemas/" + reg.define(t)}
+	}
+	return &Schema{} // interface{}, any value
+}
+
+// define names a struct type's schema, describing it on first use. Names are the
+// type name, qualified by the package when two packages use the same one.
+func (reg *registry) define(t reflect.Type) string {
+	if name, ok := reg.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := reg.schemas[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	reg.names[t], reg.schemas[name] = name, s // before the fields, so recursive types end in a $ref
+	reg.addProperties(s, t)
+	return name
+}
+
+// addProperties adds the JSON-encoded fields of a struct, flattening embedded ones as encoding/json does
+func (reg *registry) addProperties(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if sf.Anonymous && name == "" {
+			embedded := sf.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				reg.addProperties(s, embedded)
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		prop := reg.schemaOf(sf.Type)
+		prop.Description = sf.Tag.Get("doc")
+		s.Properties[name] = prop
+		if sf.Tag.Get("required") == "true" {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
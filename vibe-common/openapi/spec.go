@@ -0,0 +1,180 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"vibe-common/api"
+	"vibe-common/middleware"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Document is the subset of OpenAPI 3.0 the services describe themselves with
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem holds the operations of one path, keyed by lower-case method
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// Route describes one operation, Request and Response are zero values of the typed
+// request and success body, a nil Response documents the api.Envelope
+type Route struct {
+	Method   string
+	Path     string
+	Summary  string
+	Request  interface{}
+	Response interface{}
+	Status   int    // success status, 200 when unset
+	Session  string // cookie that carries the session, "" for public routes
+
+	// Deprecated routes answer with a Deprecation header, and a Link to Successor when set
+	Deprecated bool
+	Successor  string
+}
+
+// Spec collects the routes of a service into its OpenAPI document, register every
+// route at startup before serving
+type Spec struct {
+	doc Document
+	reg *registry
+}
+
+// New starts an empty document for a service
+func New(title string, version string) *Spec {
+	reg := newRegistry()
+	return &Spec{reg: reg, doc: Document{
+		OpenAPI:    "3.0.3",
+		Info:       Info{Title: title, Version: version},
+		Paths:      map[string]PathItem{},
+		Components: Components{Schemas: reg.schemas},
+	}}
+}
+
+// Handle registers h on the router for the route, documents it and validates its
+// requests against the Request type first, deprecated routes say so on every answer
+func (s *Spec) Handle(r *mux.Router, route Route, h http.Handler) *mux.Route {
+	s.add(route)
+	h = validate(route.Request, h)
+	if route.Deprecated {
+		h = middleware.Deprecated(route.Successor)(h)
+	}
+	return r.Handle(route.Path, h).Methods(route.Method)
+}
+
+// HandleFunc is Handle for plain handler functions
+func (s *Spec) HandleFunc(r *mux.Router, route Route, h http.HandlerFunc) *mux.Route {
+	return s.Handle(r, route, h)
+}
+
+// ServeHTTP answers with the document, mount it at GET /openapi.json
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.RespondOK(w, &s.doc)
+}
+
+// pathPattern matches mux variables carrying a pattern, e.g. {id:[0-9]+}
+var pathPattern = regexp.MustCompile(`\{([^}:]+):[^}]*\}`)
+
+func (s *Spec) add(route Route) {
+	path := pathPattern.ReplaceAllString(route.Path, "{$1}")
+	method := strings.ToLower(route.Method)
+	if s.doc.Paths[path] == nil {
+		s.doc.Paths[path] = PathItem{}
+	}
+	if _, ok := s.doc.Paths[path][method]; ok {
+		log.Warn("openapi: ", route.Method, " ", path, " is registered twice")
+	}
+
+	op := &Operation{Summary: route.Summary, Responses: map[string]Response{}, Deprecated: route.Deprecated}
+	if route.Successor != "" {
+		op.Description = "Replaced by " + route.Successor
+	}
+	if route.Request != nil {
+		op.Parameters, op.RequestBody = s.reg.describeRequest(route.Request)
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := route.Response
+	if body == nil {
+		body = api.Envelope{}
+	}
+	op.Responses[strconv.Itoa(status)] = s.jsonResponse(http.StatusText(status), body)
+	op.Responses["default"] = s.jsonResponse("Error envelope, error.code says what went wrong", api.Envelope{})
+
+	if route.Session != "" {
+		if s.doc.Components.SecuritySchemes == nil {
+			s.doc.Components.SecuritySchemes = map[string]SecurityScheme{}
+		}
+		s.doc.Components.SecuritySchemes[route.Session] = SecurityScheme{Type: "apiKey", In: "cookie", Name: route.Session}
+		s.doc.Components.SecuritySchemes["bearer"] = SecurityScheme{Type: "http", Scheme: "bearer"}
+		op.Security = []map[string][]string{{route.Session: {}}, {"bearer": {}}}
+	}
+	s.doc.Paths[path][method] = op
+}
+
+func (s *Spec) jsonResponse(description string, body interface{}) Response {
+	return Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: s.reg.schemaOf(reflect.TypeOf(body))}},
+	}
+}
@@ -0,0 +1,1015 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// NewMariaDB builds every repository on top of vibe_db. Each call runs under
+// the caller's context bounded by timeout, a zero timeout only follows the context.
+func NewMariaDB(db *sql.DB, timeout time.Duration) *Repos {
+	c := conn{db, timeout}
+	return &Repos{
+		Users:       &mariaUsers{c},
+		Follows:     &mariaFollows{c},
+		Videos:      &mariaVideos{c},
+		Locations:   &mariaLocations{c},
+		Chats:       &mariaChats{c},
+		Favorites:   &mariaFavorites{c},
+		Likes:       &mariaLikes{c},
+		Tags:        &mariaTags{c},
+		Subscribers: &mariaSubscribers{c},
+		Uploads:     &mariaUploads{c},
+		Transcodes:  &mariaTranscodes{c},
+		Drafts:      &mariaDrafts{c},
+	}
+}
+
+// conn is shared by the MariaDB repositories
+type conn struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// deadline bounds one repository call, cancelling it releases the connection
+func (c conn) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// queryStrings runs a query selecting one string column
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// inTx runs fn in a transaction, rolling back when it fails
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type mariaUsers struct{ conn }
+
+func (r *mariaUsers) Create(ctx context.Context, u User) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO users (user_id, user_name, password, phone, photo) VALUES (?, ?, ?, ?, ?)",
+		u.UserID, u.UserName, u.Password, u.Phone, u.Photo)
+	return err
+}
+
+func (r *mariaUsers) ByID(ctx context.Context, userID string) (User, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	u := User{}
+	err := r.db.QueryRowContext(ctx, "SELECT user_id, user_name, password, phone, photo FROM users WHERE user_id = ?", userID).
+		Scan(&u.UserID, &u.UserName, &u.Password, &u.Phone, &u.Photo)
+	return u, notFound(err)
+}
+
+func (r *mariaUsers) ByUserName(ctx context.Context, userName string) (User, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	u := User{}
+	err := r.db.QueryRowContext(ctx, "SELECT user_id, user_name, password, phone, photo FROM users WHERE user_name = ?", userName).
+		Scan(&u.UserID, &u.UserName, &u.Password, &u.Phone, &u.Photo)
+	return u, notFound(err)
+}
+
+func (r *mariaUsers) IDByPhone(ctx context.Context, phone string) (string, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	var id string
+	err := r.db.QueryRowContext(ctx, "SELECT user_id FROM users WHERE phone = ?", phone).Scan(&id)
+	return id, notFound(err)
+}
+
+func (r *mariaUsers) IDByUserNameOrPhone(ctx context.Context, userName string, phone string) (string, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	var id string
+	err := r.db.QueryRowContext(ctx, "SELECT user_id FROM users WHERE user_name = ? OR phone = ? LIMIT 1", userName, phone).Scan(&id)
+	return id, notFound(err)
+}
+
+func (r *mariaUsers) UpdatePassword(ctx context.Context, userID string, hash string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET password = ? WHERE user_id = ?", hash, userID)
+	return err
+}
+
+func (r *mariaUsers) SetPhoto(ctx context.Context, userID string, photo bool) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET photo = ? WHERE user_id = ?", photo, userID)
+	return err
+}
+
+func (r *mariaUsers) Profile(ctx context.Context, viewerID string, userID string) (Profile, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	p := Profile{}
+	err := r.db.QueryRowContext(ctx, "SELECT users.user_name, users.photo, IF(ISNULL(user_follower.user_id), false, true) AS is_following FROM users LEFT JOIN user_follower ON user_follower.user_id_following = users.user_id AND user_follower.user_id = ? WHERE users.user_id = ?",
+		viewerID, userID).Scan(&p.UserName, &p.Photo, &p.IsFollowing)
+	return p, notFound(err)
+}
+
+func (r *mariaUsers) Counts(ctx context.Context, userID string) (Counts, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	c := Counts{}
+	err := r.db.QueryRowContext(ctx, "SELECT follower_count, following_count FROM users WHERE user_id = ?", userID).
+		Scan(&c.FollowerCount, &c.FollowingCount)
+	return c, notFound(err)
+}
+
+func (r *mariaUsers) Delete(ctx context.Context, userID string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "DELETE FROM users WHERE user_id = ?", userID)
+	return err
+}
+
+type mariaFollows struct{ conn }
+
+func (r *mariaFollows) Follow(ctx context.Context, userID string, followingID string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO user_follower (user_id, user_id_following) VALUES (?, ?)", userID, followingID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET following_count = following_count + 1 WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE users SET follower_count = follower_count + 1 WHERE user_id = ?", followingID)
+		return err
+	})
+}
+
+func (r *mariaFollows) Unfollow(ctx context.Context, userID string, followingID string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM user_follower WHERE user_id = ? AND user_id_following = ?", userID, followingID)
+		if err != nil {
+			return err
+		}
+		// Only touch the counts when there was a follow to remove
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET following_count = following_count - 1 WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users SET follower_count = follower_count - 1 WHERE user_id = ?", followingID)
+		return err
+	})
+}
+
+func (r *mariaFollows) Following(ctx context.Context, userID string) ([]string, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return queryStrings(ctx, r.db, "SELECT user_id_following FROM user_follower WHERE user_id = ?", userID)
+}
+
+func (r *mariaFollows) Followers(ctx context.Context, userID string) ([]string, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return queryStrings(ctx, r.db, "SELECT user_id FROM user_follower WHERE user_id_following = ?", userID)
+}
+
+type mariaVideos struct{ conn }
+
+func (r *mariaVideos) Create(ctx context.Context, v Video) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, insertVideoQuery, insertVideoValues(v, VideoReady)...)
+	return err
+}
+
+func (r *mariaVideos) SetLatest(ctx context.Context, v Video) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO latest_videos (location_hash, video_folder, user_id, time_stamp, like_count) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE video_folder = VALUES(video_folder), user_id = VALUES(user_id), time_stamp = VALUES(time_stamp), like_count = VALUES(like_count)",
+		v.LocationHash, v.Folder, v.UserID, v.TimeStamp, v.LikeCount)
+	return err
+}
+
+// videoExistsQuery counts the all_videos rows of a folder at a location, deleted ones too
+const videoExistsQuery = "SELECT COUNT(*) FROM all_videos WHERE video_folder = ? AND location_hash = ?"
+
+func (r *mariaVideos) Exists(ctx context.Context, folder string, locationHash string) (bool, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	var n int
+	err := r.db.QueryRowContext(ctx, videoExistsQuery, folder, locationHash).Scan(&n)
+	return n > 0, err
+}
+
+// Columns of all_videos and vibe_draft_parts holding a VideoMeta, in the order of videoMetaValues
+const videoMetaColumns = "duration_ms, width, height, codec, rotation, bitrate, file_size"
+
+func videoMetaValues(m VideoMeta) []interface{} {
+	return []interface{}{m.Duration.Milliseconds(), m.Width, m.Height, m.Codec, m.Rotation, m.Bitrate, m.Size}
+}
+
+// insertVideoQuery adds an all_videos row, with the values of insertVideoValues
+const insertVideoQuery = "INSERT INTO all_videos (video_folder, location_hash, user_id, time_stamp, like_count, sha256, status, " + videoMetaColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+func insertVideoValues(v Video, status string) []interface{} {
+	return append([]interface{}{v.Folder, v.LocationHash, v.UserID, v.TimeStamp, v.LikeCount, v.SHA256, status}, videoMetaValues(v.Meta)...)
+}
+
+// Columns scanned by scanVideoView, is_liked depends on the videos_liked join of each query
+const videoViewColumns = "all_videos.video_folder, all_videos.location_hash, IFNULL(all_videos.like_count, 0), IF(ISNULL(videos_liked.user_id), false, true) AS is_liked, all_videos.time_stamp, all_videos.user_id, all_videos.sha256, all_videos.status, all_videos.hls, " +
+	"all_videos.duration_ms, all_videos.width, all_videos.height, all_videos.codec, all_videos.rotation, all_videos.bitrate, all_videos.file_size, " +
+	"users.user_name, users.photo, locations.location_name, locations.lat, locations.lon"
+
+const videoViewJoins = " FROM all_videos JOIN users ON all_videos.user_id = users.user_id JOIN locations ON all_videos.location_hash = locations.location_hash"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanVideoView(row scanner) (VideoView, error) {
+	v := VideoView{}
+	var durationMs int64
+	err := row.Scan(&v.Folder, &v.LocationHash, &v.LikeCount, &v.IsLiked, &v.TimeStamp, &v.UserID, &v.SHA256, &v.Status, &v.HLS,
+		&durationMs, &v.Meta.Width, &v.Meta.Height, &v.Meta.Codec, &v.Meta.Rotation, &v.Meta.Bitrate, &v.Meta.Size,
+		&v.UserName, &v.Photo, &v.Location.Name, &v.Location.Lat, &v.Location.Lon)
+	v.Meta.Duration = time.Duration(durationMs) * time.Millisecond
+	v.Location.Hash = v.LocationHash
+	return v, err
+}
+
+func (r *mariaVideos) queryViews(ctx context.Context, query string, args ...interface{}) ([]VideoView, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []VideoView
+	for rows.Next() {
+		v, err := scanVideoView(rows)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, v)
+	}
+	return views, rows.Err()
+}
+
+const atLocationQuery = "SELECT " + videoViewColumns + videoViewJoins +
+	" LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash AND videos_liked.user_id = ?" +
+	" WHERE all_videos.location_hash = ? AND all_videos.is_deleted = 0 AND all_videos.status = 'ready' ORDER BY all_videos.time_stamp DESC"
+
+func (r *mariaVideos) LatestAtLocation(ctx context.Context, viewerID string, locationHash string) (VideoView, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	v, err := scanVideoView(r.db.QueryRowContext(ctx, atLocationQuery+" LIMIT 1", viewerID, locationHash))
+	return v, notFound(err)
+}
+
+func (r *mariaVideos) AtLocation(ctx context.Context, viewerID string, locationHash string) ([]VideoView, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return r.queryViews(ctx, atLocationQuery, viewerID, locationHash)
+}
+
+func (r *mariaVideos) ByUser(ctx context.Context, userID string, limit int) ([]VideoView, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	query := "SELECT " + videoViewColumns + videoViewJoins +
+		" LEFT JOIN videos_liked ON all_videos.video_folder = videos_liked.video_folder AND all_videos.location_hash = videos_liked.location_hash AND videos_liked.user_id = all_videos.user_id" +
+		" WHERE all_videos.user_id = ? AND all_videos.is_deleted = 0 AND all_videos.status = 'ready' ORDER BY all_videos.time_stamp DESC"
+	if limit > 0 {
+		return r.queryViews(ctx, query+" LIMIT ?", userID, limit)
+	}
+	return r.queryViews(ctx, query, userID)
+}
+
+func (r *mariaVideos) Delete(ctx context.Context, userID string, locationHash string, folder string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM all_videos WHERE user_id = ? AND location_hash = ? AND video_folder = ?", userID, locationHash, folder)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM transcode_jobs WHERE location_hash = ? AND video_folder = ?", locationHash, folder); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM latest_videos WHERE location_hash = ? AND video_folder = ?", locationHash, folder)
+		return err
+	})
+}
+
+func (r *mariaVideos) DeleteByTimeStamp(ctx context.Context, userID string, timeStamp string) ([]Video, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	var deleted []Video
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		// MariaDB compares the time stamp by value, whatever form it is written in
+		rows, err := tx.QueryContext(ctx, "SELECT video_folder, location_hash FROM all_videos WHERE user_id = ? AND time_stamp = ? FOR UPDATE", userID, timeStamp)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			v := Video{UserID: userID}
+			if err := rows.Scan(&v.Folder, &v.LocationHash); err != nil {
+				return err
+			}
+			deleted = append(deleted, v)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM latest_videos WHERE user_id = ? AND time_stamp = ?", userID, timeStamp); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE transcode_jobs FROM transcode_jobs"+transcodeJobVideo+" WHERE all_videos.user_id = ? AND all_videos.time_stamp = ?", userID, timeStamp); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM all_videos WHERE user_id = ? AND time_stamp = ?", userID, timeStamp)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (r *mariaVideos) DeleteByUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM latest_videos WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE transcode_jobs FROM transcode_jobs"+transcodeJobVideo+" WHERE all_videos.user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM all_videos WHERE user_id = ?", userID)
+		return err
+	})
+}
+
+type mariaLocations struct{ conn }
+
+// ensureLocationQuery inserts a location unless its hash is known, the hash goes first and last
+const ensureLocationQuery = "INSERT INTO locations (location_hash, location_name, lat, lon) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT location_hash FROM locations WHERE location_hash = ?)"
+
+func (r *mariaLocations) Ensure(ctx context.Context, l Location) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, ensureLocationQuery, l.Hash, l.Name, l.Lat, l.Lon, l.Hash)
+	return err
+}
+
+type mariaChats struct{ conn }
+
+func (r *mariaChats) Create(ctx context.Context, c Chat) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO all_chats (_id, location_hash, thread_name, user_id, createdAt, msg_text) VALUES (?, ?, ?, ?, ?, ?)",
+		c.ID, c.LocationHash, c.Thread, c.UserID, c.CreatedAt, c.Text)
+	return err
+}
+
+func (r *mariaChats) Thread(ctx context.Context, locationHash string, thread string) ([]Chat, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	rows, err := r.db.QueryContext(ctx, "SELECT _id, location_hash, thread_name, user_id, createdAt, msg_text FROM all_chats WHERE location_hash = ? AND thread_name = ? ORDER BY createdAt DESC", locationHash, thread)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []Chat
+	for rows.Next() {
+		c := Chat{}
+		if err := rows.Scan(&c.ID, &c.LocationHash, &c.Thread, &c.UserID, &c.CreatedAt, &c.Text); err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+	return chats, rows.Err()
+}
+
+func (r *mariaChats) DeleteByUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "DELETE FROM all_chats WHERE user_id = ?", userID)
+	return err
+}
+
+type mariaFavorites struct{ conn }
+
+func (r *mariaFavorites) Add(ctx context.Context, userID string, locationHash string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "INSERT IGNORE INTO favorites (user_id, location_hash) VALUES (?, ?)", userID, locationHash)
+	return err
+}
+
+func (r *mariaFavorites) Remove(ctx context.Context, userID string, locationHash string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "DELETE FROM favorites WHERE user_id = ? AND location_hash = ?", userID, locationHash)
+	return err
+}
+
+func (r *mariaFavorites) Exists(ctx context.Context, userID string, locationHash string) (bool, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	var hash string
+	err := r.db.QueryRowContext(ctx, "SELECT location_hash FROM favorites WHERE user_id = ? AND location_hash = ?", userID, locationHash).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *mariaFavorites) ForUser(ctx context.Context, userID string) ([]FavoriteLocation, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	rows, err := r.db.QueryContext(ctx, `SELECT latest_videos.video_folder, favorites.location_hash, all_videos.time_stamp, all_videos.user_id, locations.location_name, locations.lat, locations.lon
+		FROM favorites
+		LEFT JOIN latest_videos ON favorites.location_hash = latest_videos.location_hash
+		LEFT JOIN locations ON favorites.location_hash = locations.location_hash
+		LEFT JOIN all_videos ON locations.location_hash = all_videos.location_hash AND latest_videos.video_folder = all_videos.video_folder
+		WHERE favorites.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var favorites []FavoriteLocation
+	for rows.Next() {
+		var folder, hash, poster, name sql.NullString
+		var timeStamp sql.NullTime
+		var lat, lon sql.NullFloat64
+		if err := rows.Scan(&folder, &hash, &timeStamp, &poster, &name, &lat, &lon); err != nil {
+			return nil, err
+		}
+		favorites = append(favorites, FavoriteLocation{
+			VideoFolder: folder.String,
+			TimeStamp:   timeStamp.Time,
+			UserID:      poster.String,
+			Location:    Location{Hash: hash.String, Name: name.String, Lat: lat.Float64, Lon: lon.Float64},
+		})
+	}
+	return favorites, rows.Err()
+}
+
+func (r *mariaFavorites) DeleteByUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "DELETE FROM favorites WHERE user_id = ?", userID)
+	return err
+}
+
+type mariaLikes struct{ conn }
+
+func (r *mariaLikes) Like(ctx context.Context, folder string, locationHash string, userID string) (bool, error) {
+	return r.set(ctx, "INSERT IGNORE INTO videos_liked (video_folder, location_hash, user_id) VALUES (?, ?, ?)", 1, folder, locationHash, userID)
+}
+
+func (r *mariaLikes) Unlike(ctx context.Context, folder string, locationHash string, userID string) (bool, error) {
+	return r.set(ctx, "DELETE FROM videos_liked WHERE video_folder = ? AND location_hash = ? AND user_id = ?", -1, folder, locationHash, userID)
+}
+
+// set runs the videos_liked statement and moves the like counts by delta when it changed the
+// row, in one transaction so a repeated or concurrent request counts once
+func (r *mariaLikes) set(ctx context.Context, query string, delta int, folder string, locationHash string, userID string) (bool, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	changed := false
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, folder, locationHash, userID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+		changed = true
+		if _, err := tx.ExecContext(ctx, "UPDATE all_videos SET like_count = IFNULL(like_count, 0) + ? WHERE video_folder = ? AND location_hash = ?", delta, folder, locationHash); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE latest_videos SET like_count = IFNULL(like_count, 0) + ? WHERE video_folder = ? AND location_hash = ?", delta, folder, locationHash)
+		return err
+	})
+	return changed && err == nil, err
+}
+
+func (r *mariaLikes) DeleteByUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "DELETE FROM videos_liked WHERE user_id = ?", userID)
+	return err
+}
+
+type mariaTags struct{ conn }
+
+func (r *mariaTags) ForLocation(ctx context.Context, locationHash string) ([]string, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return queryStrings(ctx, r.db, "SELECT tag FROM location_tags WHERE location_hash = ?", locationHash)
+}
+
+func (r *mariaTags) Add(ctx context.Context, locationHash string, tag string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO location_tags (location_hash, tag) VALUES (?, ?)", locationHash, tag)
+	return err
+}
+
+type mariaSubscribers struct{ conn }
+
+func (r *mariaSubscribers) Exists(ctx context.Context, email string) (bool, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	var id int
+	err := r.db.QueryRowContext(ctx, "SELECT subscriber_id FROM subscribers WHERE email = ?", email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *mariaSubscribers) Create(ctx context.Context, email string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO subscribers (email) VALUES (?)", email)
+	return err
+}
+
+type mariaUploads struct{ conn }
+
+func (r *mariaUploads) Create(ctx context.Context, u Upload) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	metadata, err := json.Marshal(u.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT INTO uploads (upload_id, user_id, upload_length, upload_offset, metadata, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		u.ID, u.UserID, u.Length, u.Offset, string(metadata), u.CreatedAt, u.ExpiresAt)
+	return err
+}
+
+// Columns scanned by scanUpload
+const uploadColumns = "upload_id, user_id, upload_length, upload_offset, metadata, created_at, expires_at"
+
+func scanUpload(row scanner) (Upload, error) {
+	u := Upload{}
+	var metadata string
+	if err := row.Scan(&u.ID, &u.UserID, &u.Length, &u.Offset, &metadata, &u.CreatedAt, &u.ExpiresAt); err != nil {
+		return u, err
+	}
+	return u, json.Unmarshal([]byte(metadata), &u.Metadata)
+}
+
+func (r *mariaUploads) ByID(ctx context.Context, id string) (Upload, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	u, err := scanUpload(r.db.QueryRowContext(ctx, "SELECT "+uploadColumns+" FROM uploads WHERE upload_id = ?", id))
+	if err != nil {
+		return u, notFound(err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT chunk_offset, chunk_length, sha256, verified FROM upload_chunks WHERE upload_id = ? ORDER BY chunk_offset", id)
+	if err != nil {
+		return u, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := UploadChunk{}
+		if err := rows.Scan(&c.Offset, &c.Length, &c.SHA256, &c.Verified); err != nil {
+			return u, err
+		}
+		u.Chunks = append(u.Chunks, c)
+	}
+	return u, rows.Err()
+}
+
+func (r *mariaUploads) AddChunk(ctx context.Context, id string, c UploadChunk) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE uploads SET upload_offset = ? WHERE upload_id = ? AND upload_offset = ?", c.Offset+c.Length, id, c.Offset)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO upload_chunks (upload_id, chunk_offset, chunk_length, sha256, verified) VALUES (?, ?, ?, ?, ?)",
+			id, c.Offset, c.Length, c.SHA256, c.Verified)
+		return err
+	})
+}
+
+func (r *mariaUploads) Rewind(ctx context.Context, id string, offset int64) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM upload_chunks WHERE upload_id = ? AND chunk_offset >= ?", id, offset); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE uploads SET upload_offset = ? WHERE upload_id = ?", offset, id)
+		return err
+	})
+}
+
+func (r *mariaUploads) Delete(ctx context.Context, id string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM upload_chunks WHERE upload_id = ?", id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM uploads WHERE upload_id = ?", id)
+		return err
+	})
+}
+
+func (r *mariaUploads) Expired(ctx context.Context, t time.Time) ([]Upload, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	rows, err := r.db.QueryContext(ctx, "SELECT "+uploadColumns+" FROM uploads WHERE expires_at < ?", t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []Upload
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+type mariaTranscodes struct{ conn }
+
+// Joins the video of each job
+const transcodeJobVideo = " JOIN all_videos ON transcode_jobs.video_folder = all_videos.video_folder AND transcode_jobs.location_hash = all_videos.location_hash"
+
+// maxJobError is what last_error holds, longer reasons are cut
+const maxJobError = 1000
+
+func jobError(reason string) string {
+	if len(reason) > maxJobError {
+		return reason[:maxJobError]
+	}
+	return reason
+}
+
+// insertJobQuery queues the transcode job of a video
+const insertJobQuery = "INSERT INTO transcode_jobs (video_folder, location_hash, source_key, status, run_after, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+
+func (r *mariaTranscodes) Enqueue(ctx context.Context, v Video, sourceKey string, now time.Time) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, insertVideoQuery, insertVideoValues(v, VideoProcessing)...); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, insertJobQuery, v.Folder, v.LocationHash, sourceKey, JobQueued, now, now, now)
+		return err
+	})
+}
+
+// Columns scanned by scanTranscodeJob
+const transcodeJobColumns = "video_folder, location_hash, source_key, status, attempts, last_error, claim, run_after, created_at, updated_at"
+
+func scanTranscodeJob(row scanner) (TranscodeJob, error) {
+	j := TranscodeJob{}
+	err := row.Scan(&j.Folder, &j.LocationHash, &j.SourceKey, &j.Status, &j.Attempts, &j.Error, &j.Claim, &j.RunAfter, &j.CreatedAt, &j.UpdatedAt)
+	return j, err
+}
+
+func (r *mariaTranscodes) Claim(ctx context.Context, claim string, now time.Time, leaseEnd time.Time) (TranscodeJob, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	// one UPDATE picks and takes the job, so two workers never claim the same one
+	res, err := r.db.ExecContext(ctx, "UPDATE transcode_jobs SET status = ?, claim = ?, attempts = attempts + 1, run_after = ?, updated_at = ?"+
+		" WHERE (status = ? AND run_after <= ?) OR (status = ? AND run_after < ?) ORDER BY run_after LIMIT 1",
+		JobRunning, claim, leaseEnd, now, JobQueued, now, JobRunning, now)
+	if err != nil {
+		return TranscodeJob{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return TranscodeJob{}, err
+	} else if n == 0 {
+		return TranscodeJob{}, ErrNotFound
+	}
+	j, err := scanTranscodeJob(r.db.QueryRowContext(ctx, "SELECT "+transcodeJobColumns+" FROM transcode_jobs WHERE claim = ?", claim))
+	return j, notFound(err)
+}
+
+// finishJob moves a claimed job to status, ErrNotFound when the claim was lost
+func finishJob(ctx context.Context, tx *sql.Tx, j TranscodeJob, status string, reason string, runAfter time.Time, now time.Time) error {
+	res, err := tx.ExecContext(ctx, "UPDATE transcode_jobs SET status = ?, last_error = ?, run_after = ?, claim = '', updated_at = ? WHERE video_folder = ? AND location_hash = ? AND claim = ? AND status = ?",
+		status, jobError(reason), runAfter, now, j.Folder, j.LocationHash, j.Claim, JobRunning)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Keeps the newer of the video in latest_videos and the one inserted, time_stamp is assigned last
+// since each assignment sees the ones before it
+const newerVideo = "latest_videos.time_stamp IS NULL OR VALUES(time_stamp) >= latest_videos.time_stamp"
+
+// setLatestIfNewerQuery makes the video with the folder and location hash its location's latest
+// unless a newer one is
+const setLatestIfNewerQuery = "INSERT INTO latest_videos (location_hash, video_folder, user_id, time_stamp, like_count)" +
+	" SELECT location_hash, video_folder, user_id, time_stamp, IFNULL(like_count, 0) FROM all_videos WHERE video_folder = ? AND location_hash = ?" +
+	" ON DUPLICATE KEY UPDATE video_folder = IF(" + newerVideo + ", VALUES(video_folder), latest_videos.video_folder)," +
+	" user_id = IF(" + newerVideo + ", VALUES(user_id), latest_videos.user_id)," +
+	" like_count = IF(" + newerVideo + ", VALUES(like_count), latest_videos.like_count)," +
+	" time_stamp = IF(" + newerVideo + ", VALUES(time_stamp), latest_videos.time_stamp)"
+
+func (r *mariaTranscodes) Complete(ctx context.Context, j TranscodeJob, now time.Time) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if err := finishJob(ctx, tx, j, JobDone, "", now, now); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "UPDATE all_videos SET status = ?, hls = TRUE WHERE video_folder = ? AND location_hash = ?", VideoReady, j.Folder, j.LocationHash)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.ExecContext(ctx, setLatestIfNewerQuery, j.Folder, j.LocationHash)
+		return err
+	})
+}
+
+func (r *mariaTranscodes) Retry(ctx context.Context, j TranscodeJob, reason string, runAfter time.Time, now time.Time) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		return finishJob(ctx, tx, j, JobQueued, reason, runAfter, now)
+	})
+}
+
+func (r *mariaTranscodes) Release(ctx context.Context, j TranscodeJob, reason string, now time.Time) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if err := finishJob(ctx, tx, j, JobQueued, reason, now, now); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE transcode_jobs SET attempts = attempts - 1 WHERE video_folder = ? AND location_hash = ? AND attempts > 0", j.Folder, j.LocationHash)
+		return err
+	})
+}
+
+func (r *mariaTranscodes) Fail(ctx context.Context, j TranscodeJob, reason string, now time.Time) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if err := finishJob(ctx, tx, j, JobFailed, reason, now, now); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE all_videos SET status = ? WHERE video_folder = ? AND location_hash = ?", VideoFailed, j.Folder, j.LocationHash)
+		return err
+	})
+}
+
+func (r *mariaTranscodes) Status(ctx context.Context, locationHash string, folder string) (VideoStatus, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	s := VideoStatus{}
+	err := r.db.QueryRowContext(ctx, "SELECT all_videos.user_id, all_videos.status, all_videos.hls, IFNULL(transcode_jobs.attempts, 0), IFNULL(transcode_jobs.last_error, ''), IFNULL(transcode_jobs.updated_at, all_videos.time_stamp)"+
+		" FROM all_videos LEFT JOIN transcode_jobs ON transcode_jobs.video_folder = all_videos.video_folder AND transcode_jobs.location_hash = all_videos.location_hash"+
+		" WHERE all_videos.video_folder = ? AND all_videos.location_hash = ? AND all_videos.is_deleted = 0", folder, locationHash).
+		Scan(&s.UserID, &s.Status, &s.HLS, &s.Attempts, &s.Error, &s.UpdatedAt)
+	return s, notFound(err)
+}
+
+type mariaDrafts struct{ conn }
+
+// Columns scanned by scanDraft
+const draftColumns = "draft_id, user_id, video_folder, location_hash, location_name, lat, lon, time_stamp, parts, created_at, expires_at"
+
+func scanDraft(row scanner) (Draft, error) {
+	d := Draft{}
+	var parts string
+	err := row.Scan(&d.ID, &d.UserID, &d.Folder, &d.Location.Hash, &d.Location.Name, &d.Location.Lat, &d.Location.Lon, &d.TimeStamp, &parts, &d.CreatedAt, &d.ExpiresAt)
+	if parts != "" {
+		d.Parts = strings.Split(parts, ",")
+	}
+	return d, err
+}
+
+func (r *mariaDrafts) Create(ctx context.Context, d Draft) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	res, err := r.db.ExecContext(ctx, "INSERT INTO vibe_drafts ("+draftColumns+") SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"+
+		" WHERE NOT EXISTS (SELECT draft_id FROM vibe_drafts WHERE video_folder = ? AND location_hash = ?)"+
+		" AND NOT EXISTS (SELECT video_folder FROM all_videos WHERE video_folder = ? AND location_hash = ?)",
+		d.ID, d.UserID, d.Folder, d.Location.Hash, d.Location.Name, d.Location.Lat, d.Location.Lon, d.TimeStamp, strings.Join(d.Parts, ","), d.CreatedAt, d.ExpiresAt,
+		d.Folder, d.Location.Hash, d.Folder, d.Location.Hash)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (r *mariaDrafts) ByID(ctx context.Context, id string) (Draft, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	d, err := scanDraft(r.db.QueryRowContext(ctx, "SELECT "+draftColumns+" FROM vibe_drafts WHERE draft_id = ?", id))
+	if err != nil {
+		return d, notFound(err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT role, storage_key, sha256, "+videoMetaColumns+" FROM vibe_draft_parts WHERE draft_id = ? ORDER BY role", id)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := DraftPart{}
+		var durationMs int64
+		if err := rows.Scan(&p.Role, &p.Key, &p.SHA256, &durationMs, &p.Meta.Width, &p.Meta.Height, &p.Meta.Codec, &p.Meta.Rotation, &p.Meta.Bitrate, &p.Meta.Size); err != nil {
+			return d, err
+		}
+		p.Meta.Duration = time.Duration(durationMs) * time.Millisecond
+		d.Stored = append(d.Stored, p)
+	}
+	return d, rows.Err()
+}
+
+func (r *mariaDrafts) SetPart(ctx context.Context, id string, p DraftPart) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		// the draft's row is held until the part is in, so a commit sees all of it or none
+		var found string
+		if err := tx.QueryRowContext(ctx, "SELECT draft_id FROM vibe_drafts WHERE draft_id = ? FOR UPDATE", id).Scan(&found); err != nil {
+			return notFound(err)
+		}
+		_, err := tx.ExecContext(ctx, "REPLACE INTO vibe_draft_parts (draft_id, role, storage_key, sha256, "+videoMetaColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			append([]interface{}{id, p.Role, p.Key, p.SHA256}, videoMetaValues(p.Meta)...)...)
+		return err
+	})
+}
+
+// deleteDraft removes a draft and its parts, ErrNotFound when it is gone
+func deleteDraft(ctx context.Context, tx *sql.Tx, id string) error {
+	res, err := tx.ExecContext(ctx, "DELETE FROM vibe_drafts WHERE draft_id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM vibe_draft_parts WHERE draft_id = ?", id)
+	return err
+}
+
+func (r *mariaDrafts) Delete(ctx context.Context, id string) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if err := deleteDraft(ctx, tx, id); err != ErrNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *mariaDrafts) Expired(ctx context.Context, t time.Time) ([]Draft, error) {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	rows, err := r.db.QueryContext(ctx, "SELECT "+draftColumns+" FROM vibe_drafts WHERE expires_at < ?", t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drafts []Draft
+	for rows.Next() {
+		d, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+func (r *mariaDrafts) Commit(ctx context.Context, id string, v Video, sourceKey string, queue bool, now time.Time) error {
+	ctx, cancel := r.deadline(ctx)
+	defer cancel()
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		// the draft's row is held until the vibe is in, a second commit waits and then finds it gone
+		d, err := scanDraft(tx.QueryRowContext(ctx, "SELECT "+draftColumns+" FROM vibe_drafts WHERE draft_id = ? FOR UPDATE", id))
+		if err != nil {
+			return notFound(err)
+		}
+		if err := deleteDraft(ctx, tx, id); err != nil {
+			return err
+		}
+
+		var exists int
+		err = tx.QueryRowContext(ctx, videoExistsQuery, v.Folder, v.LocationHash).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrDuplicate
+		}
+
+		l := d.Location
+		if _, err := tx.ExecContext(ctx, ensureLocationQuery, l.Hash, l.Name, l.Lat, l.Lon, l.Hash); err != nil {
+			return err
+		}
+		if queue {
+			if _, err := tx.ExecContext(ctx, insertVideoQuery, insertVideoValues(v, VideoProcessing)...); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, insertJobQuery, v.Folder, v.LocationHash, sourceKey, JobQueued, now, now, now)
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, insertVideoQuery, insertVideoValues(v, VideoReady)...); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, setLatestIfNewerQuery, v.Folder, v.LocationHash)
+		return err
+	})
+}
@@ -0,0 +1,852 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewMemory builds every repository on one in-memory store, for tests and
+// running a service without MariaDB
+func NewMemory() *Repos {
+	m := &memory{
+		users:       map[string]User{},
+		follows:     map[pairKey]bool{},
+		videos:      map[videoKey]Video{},
+		latest:      map[string]Video{},
+		locations:   map[string]Location{},
+		favorites:   map[pairKey]bool{},
+		likes:       map[likeKey]bool{},
+		tags:        map[string][]string{},
+		subscribers: map[string]bool{},
+		uploads:     map[string]Upload{},
+		transcodes:  map[videoKey]TranscodeJob{},
+		drafts:      map[string]Draft{},
+	}
+	return &Repos{
+		Users:       memoryUsers{m},
+		Follows:     memoryFollows{m},
+		Videos:      memoryVideos{m},
+		Locations:   memoryLocations{m},
+		Chats:       memoryChats{m},
+		Favorites:   memoryFavorites{m},
+		Likes:       memoryLikes{m},
+		Tags:        memoryTags{m},
+		Subscribers: memorySubscribers{m},
+		Uploads:     memoryUploads{m},
+		Transcodes:  memoryTranscodes{m},
+		Drafts:      memoryDrafts{m},
+	}
+}
+
+// pairKey keys user_follower and favorites rows
+type pairKey struct{ a, b string }
+
+type videoKey struct{ folder, locationHash string }
+
+type likeKey struct {
+	videoKey
+	userID string
+}
+
+// memory holds every table, one lock covers them all like a transaction would
+type memory struct {
+	sync.Mutex
+	users       map[string]User // by user_id
+	follows     map[pairKey]bool
+	videos      map[videoKey]Video
+	latest      map[string]Video // by location_hash
+	locations   map[string]Location
+	chats       []Chat
+	favorites   map[pairKey]bool
+	likes       map[likeKey]bool
+	tags        map[string][]string
+	subscribers map[string]bool
+	uploads     map[string]Upload // by upload_id
+	transcodes  map[videoKey]TranscodeJob
+	drafts      map[string]Draft // by draft_id, with their stored parts
+}
+
+type memoryUsers struct{ *memory }
+
+func (r memoryUsers) Create(ctx context.Context, u User) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.users[u.UserID]; ok {
+		return ErrDuplicate
+	}
+	for _, existing := range r.users {
+		if existing.UserName == u.UserName {
+			return ErrDuplicate
+		}
+	}
+	r.users[u.UserID] = u
+	return nil
+}
+
+func (r memoryUsers) find(match func(User) bool) (User, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, u := range r.users {
+		if match(u) {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (r memoryUsers) ByID(ctx context.Context, userID string) (User, error) {
+	return r.find(func(u User) bool { return u.UserID == userID })
+}
+
+func (r memoryUsers) ByUserName(ctx context.Context, userName string) (User, error) {
+	return r.find(func(u User) bool { return u.UserName == userName })
+}
+
+func (r memoryUsers) IDByPhone(ctx context.Context, phone string) (string, error) {
+	u, err := r.find(func(u User) bool { return u.Phone == phone })
+	return u.UserID, err
+}
+
+func (r memoryUsers) IDByUserNameOrPhone(ctx context.Context, userName string, phone string) (string, error) {
+	u, err := r.find(func(u User) bool { return u.UserName == userName || u.Phone == phone })
+	return u.UserID, err
+}
+
+func (r memoryUsers) update(userID string, fn func(u *User)) error {
+	r.Lock()
+	defer r.Unlock()
+	if u, ok := r.users[userID]; ok {
+		fn(&u)
+		r.users[userID] = u
+	}
+	return nil
+}
+
+func (r memoryUsers) UpdatePassword(ctx context.Context, userID string, hash string) error {
+	return r.update(userID, func(u *User) { u.Password = hash })
+}
+
+func (r memoryUsers) SetPhoto(ctx context.Context, userID string, photo bool) error {
+	return r.update(userID, func(u *User) { u.Photo = photo })
+}
+
+func (r memoryUsers) Profile(ctx context.Context, viewerID string, userID string) (Profile, error) {
+	r.Lock()
+	defer r.Unlock()
+	u, ok := r.users[userID]
+	if !ok {
+		return Profile{}, ErrNotFound
+	}
+	return Profile{UserName: u.UserName, Photo: u.Photo, IsFollowing: r.follows[pairKey{viewerID, userID}]}, nil
+}
+
+func (r memoryUsers) Counts(ctx context.Context, userID string) (Counts, error) {
+	r.Lock()
+	defer r.Unlock()
+	u, ok := r.users[userID]
+	if !ok {
+		return Counts{}, ErrNotFound
+	}
+	return Counts{FollowerCount: u.FollowerCount, FollowingCount: u.FollowingCount}, nil
+}
+
+func (r memoryUsers) Delete(ctx context.Context, userID string) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.users, userID)
+	return nil
+}
+
+type memoryFollows struct{ *memory }
+
+// addCounts must be called with the lock held
+func (m *memory) addCounts(userID string, followingID string, delta int) {
+	if u, ok := m.users[userID]; ok {
+		u.FollowingCount += delta
+		m.users[userID] = u
+	}
+	if u, ok := m.users[followingID]; ok {
+		u.FollowerCount += delta
+		m.users[followingID] = u
+	}
+}
+
+func (r memoryFollows) Follow(ctx context.Context, userID string, followingID string) error {
+	r.Lock()
+	defer r.Unlock()
+	key := pairKey{userID, followingID}
+	if r.follows[key] {
+		return ErrDuplicate
+	}
+	r.follows[key] = true
+	r.addCounts(userID, followingID, 1)
+	return nil
+}
+
+func (r memoryFollows) Unfollow(ctx context.Context, userID string, followingID string) error {
+	r.Lock()
+	defer r.Unlock()
+	key := pairKey{userID, followingID}
+	if !r.follows[key] {
+		return nil
+	}
+	delete(r.follows, key)
+	r.addCounts(userID, followingID, -1)
+	return nil
+}
+
+func (r memoryFollows) Following(ctx context.Context, userID string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	var ids []string
+	for key := range r.follows {
+		if key.a == userID {
+			ids = append(ids, key.b)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (r memoryFollows) Followers(ctx context.Context, userID string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	var ids []string
+	for key := range r.follows {
+		if key.b == userID {
+			ids = append(ids, key.a)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+type memoryVideos struct{ *memory }
+
+func (r memoryVideos) Create(ctx context.Context, v Video) error {
+	r.Lock()
+	defer r.Unlock()
+	key := videoKey{v.Folder, v.LocationHash}
+	if _, ok := r.videos[key]; ok {
+		return ErrDuplicate
+	}
+	v.Status = VideoReady
+	r.videos[key] = v
+	return nil
+}
+
+func (r memoryVideos) Exists(ctx context.Context, folder string, locationHash string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.videos[videoKey{folder, locationHash}]
+	return ok, nil
+}
+
+func (r memoryVideos) SetLatest(ctx context.Context, v Video) error {
+	r.Lock()
+	defer r.Unlock()
+	r.latest[v.LocationHash] = v
+	return nil
+}
+
+// views joins the matching videos like the feed queries, the lock must be held
+func (m *memory) views(match func(Video) bool, likedBy func(Video) string) []VideoView {
+	var views []VideoView
+	for key, v := range m.videos {
+		if !match(v) {
+			continue
+		}
+		u, ok := m.users[v.UserID]
+		if !ok {
+			continue
+		}
+		l, ok := m.locations[v.LocationHash]
+		if !ok {
+			continue
+		}
+		views = append(views, VideoView{
+			Video:    v,
+			IsLiked:  m.likes[likeKey{key, likedBy(v)}],
+			UserName: u.UserName,
+			Photo:    u.Photo,
+			Location: l,
+		})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].TimeStamp.After(views[j].TimeStamp) })
+	return views
+}
+
+func (r memoryVideos) AtLocation(ctx context.Context, viewerID string, locationHash string) ([]VideoView, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.views(func(v Video) bool { return v.LocationHash == locationHash && v.Status == VideoReady },
+		func(Video) string { return viewerID }), nil
+}
+
+func (r memoryVideos) LatestAtLocation(ctx context.Context, viewerID string, locationHash string) (VideoView, error) {
+	views, _ := r.AtLocation(ctx, viewerID, locationHash)
+	if len(views) == 0 {
+		return VideoView{}, ErrNotFound
+	}
+	return views[0], nil
+}
+
+func (r memoryVideos) ByUser(ctx context.Context, userID string, limit int) ([]VideoView, error) {
+	r.Lock()
+	defer r.Unlock()
+	views := r.views(func(v Video) bool { return v.UserID == userID && v.Status == VideoReady },
+		func(v Video) string { return v.UserID })
+	if limit > 0 && len(views) > limit {
+		views = views[:limit]
+	}
+	return views, nil
+}
+
+// deleteVideos removes the matching videos from both tables, the lock must be held
+func (m *memory) deleteVideos(match func(Video) bool) {
+	for hash, v := range m.latest {
+		if match(v) {
+			delete(m.latest, hash)
+		}
+	}
+	for key, v := range m.videos {
+		if match(v) {
+			delete(m.videos, key)
+			delete(m.transcodes, key)
+		}
+	}
+}
+
+func (r memoryVideos) Delete(ctx context.Context, userID string, locationHash string, folder string) error {
+	r.Lock()
+	defer r.Unlock()
+	match := func(v Video) bool {
+		return v.UserID == userID && v.LocationHash == locationHash && v.Folder == folder
+	}
+	for _, v := range r.videos {
+		if match(v) {
+			r.deleteVideos(match)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r memoryVideos) DeleteByTimeStamp(ctx context.Context, userID string, timeStamp string) ([]Video, error) {
+	r.Lock()
+	defer r.Unlock()
+	// time_stamp is compared by value like MariaDB does, at the millisecond its DATETIME(3) keeps
+	t, ok := parseDateTime(timeStamp)
+	match := func(v Video) bool {
+		return ok && v.UserID == userID && v.TimeStamp.Truncate(time.Millisecond).Equal(t)
+	}
+	var deleted []Video
+	for _, v := range r.videos {
+		if match(v) {
+			deleted = append(deleted, v)
+		}
+	}
+	r.deleteVideos(match)
+	return deleted, nil
+}
+
+// parseDateTime reads a DATETIME literal the way MariaDB does, with a space or a T before the
+// time and any fraction of a second
+func parseDateTime(s string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (r memoryVideos) DeleteByUser(ctx context.Context, userID string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.deleteVideos(func(v Video) bool { return v.UserID == userID })
+	return nil
+}
+
+type memoryLocations struct{ *memory }
+
+func (r memoryLocations) Ensure(ctx context.Context, l Location) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.locations[l.Hash]; !ok {
+		r.locations[l.Hash] = l
+	}
+	return nil
+}
+
+type memoryChats struct{ *memory }
+
+func (r memoryChats) Create(ctx context.Context, c Chat) error {
+	r.Lock()
+	defer r.Unlock()
+	for _, existing := range r.chats {
+		if existing.ID == c.ID {
+			return ErrDuplicate
+		}
+	}
+	r.chats = append(r.chats, c)
+	return nil
+}
+
+func (r memoryChats) Thread(ctx context.Context, locationHash string, thread string) ([]Chat, error) {
+	r.Lock()
+	defer r.Unlock()
+	var chats []Chat
+	for _, c := range r.chats {
+		if c.LocationHash == locationHash && c.Thread == thread {
+			chats = append(chats, c)
+		}
+	}
+	sort.SliceStable(chats, func(i, j int) bool { return chats[i].CreatedAt.After(chats[j].CreatedAt) })
+	return chats, nil
+}
+
+func (r memoryChats) DeleteByUser(ctx context.Context, userID string) error {
+	r.Lock()
+	defer r.Unlock()
+	kept := r.chats[:0]
+	for _, c := range r.chats {
+		if c.UserID != userID {
+			kept = append(kept, c)
+		}
+	}
+	r.chats = kept
+	return nil
+}
+
+type memoryFavorites struct{ *memory }
+
+func (r memoryFavorites) Add(ctx context.Context, userID string, locationHash string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.favorites[pairKey{userID, locationHash}] = true
+	return nil
+}
+
+func (r memoryFavorites) Remove(ctx context.Context, userID string, locationHash string) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.favorites, pairKey{userID, locationHash})
+	return nil
+}
+
+func (r memoryFavorites) Exists(ctx context.Context, userID string, locationHash string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.favorites[pairKey{userID, locationHash}], nil
+}
+
+func (r memoryFavorites) ForUser(ctx context.Context, userID string) ([]FavoriteLocation, error) {
+	r.Lock()
+	defer r.Unlock()
+	var favorites []FavoriteLocation
+	for key := range r.favorites {
+		if key.a != userID {
+			continue
+		}
+		f := FavoriteLocation{Location: r.locations[key.b]}
+		f.Location.Hash = key.b
+		if v, ok := r.latest[key.b]; ok {
+			f.VideoFolder = v.Folder
+			if stored, ok := r.videos[videoKey{v.Folder, key.b}]; ok {
+				f.TimeStamp = stored.TimeStamp
+				f.UserID = stored.UserID
+			}
+		}
+		favorites = append(favorites, f)
+	}
+	sort.Slice(favorites, func(i, j int) bool { return favorites[i].Location.Hash < favorites[j].Location.Hash })
+	return favorites, nil
+}
+
+func (r memoryFavorites) DeleteByUser(ctx context.Context, userID string) error {
+	r.Lock()
+	defer r.Unlock()
+	for key := range r.favorites {
+		if key.a == userID {
+			delete(r.favorites, key)
+		}
+	}
+	return nil
+}
+
+type memoryLikes struct{ *memory }
+
+func (r memoryLikes) Like(ctx context.Context, folder string, locationHash string, userID string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	key := likeKey{videoKey{folder, locationHash}, userID}
+	if r.likes[key] {
+		return false, nil
+	}
+	r.likes[key] = true
+	r.addLikes(key.videoKey, 1)
+	return true, nil
+}
+
+func (r memoryLikes) Unlike(ctx context.Context, folder string, locationHash string, userID string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	key := likeKey{videoKey{folder, locationHash}, userID}
+	if !r.likes[key] {
+		return false, nil
+	}
+	delete(r.likes, key)
+	r.addLikes(key.videoKey, -1)
+	return true, nil
+}
+
+// addLikes moves the like count of a video and of its location's latest video, the caller holds the lock
+func (m *memory) addLikes(key videoKey, delta int) {
+	if v, ok := m.videos[key]; ok {
+		v.LikeCount += float64(delta)
+		m.videos[key] = v
+	}
+	if v, ok := m.latest[key.locationHash]; ok && v.Folder == key.folder {
+		v.LikeCount += float64(delta)
+		m.latest[key.locationHash] = v
+	}
+}
+
+func (r memoryLikes) DeleteByUser(ctx context.Context, userID string) error {
+	r.Lock()
+	defer r.Unlock()
+	for key := range r.likes {
+		if key.userID == userID {
+			delete(r.likes, key)
+		}
+	}
+	return nil
+}
+
+type memoryTags struct{ *memory }
+
+func (r memoryTags) ForLocation(ctx context.Context, locationHash string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.tags[locationHash]...), nil
+}
+
+func (r memoryTags) Add(ctx context.Context, locationHash string, tag string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.tags[locationHash] = append(r.tags[locationHash], tag)
+	return nil
+}
+
+type memorySubscribers struct{ *memory }
+
+func (r memorySubscribers) Exists(ctx context.Context, email string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.subscribers[email], nil
+}
+
+func (r memorySubscribers) Create(ctx context.Context, email string) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.subscribers[email] {
+		return ErrDuplicate
+	}
+	r.subscribers[email] = true
+	return nil
+}
+
+type memoryUploads struct{ *memory }
+
+func (r memoryUploads) Create(ctx context.Context, u Upload) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.uploads[u.ID]; ok {
+		return ErrDuplicate
+	}
+	r.uploads[u.ID] = u
+	return nil
+}
+
+func (r memoryUploads) ByID(ctx context.Context, id string) (Upload, error) {
+	r.Lock()
+	defer r.Unlock()
+	u, ok := r.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	u.Chunks = append([]UploadChunk(nil), u.Chunks...)
+	return u, nil
+}
+
+func (r memoryUploads) AddChunk(ctx context.Context, id string, c UploadChunk) error {
+	r.Lock()
+	defer r.Unlock()
+	u, ok := r.uploads[id]
+	if !ok || u.Offset != c.Offset {
+		return ErrNotFound
+	}
+	u.Offset += c.Length
+	u.Chunks = append(append([]UploadChunk(nil), u.Chunks...), c)
+	r.uploads[id] = u
+	return nil
+}
+
+func (r memoryUploads) Rewind(ctx context.Context, id string, offset int64) error {
+	r.Lock()
+	defer r.Unlock()
+	u, ok := r.uploads[id]
+	if !ok {
+		return nil
+	}
+	var kept []UploadChunk
+	for _, c := range u.Chunks {
+		if c.Offset < offset {
+			kept = append(kept, c)
+		}
+	}
+	u.Offset, u.Chunks = offset, kept
+	r.uploads[id] = u
+	return nil
+}
+
+func (r memoryUploads) Delete(ctx context.Context, id string) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.uploads, id)
+	return nil
+}
+
+func (r memoryUploads) Expired(ctx context.Context, t time.Time) ([]Upload, error) {
+	r.Lock()
+	defer r.Unlock()
+	var uploads []Upload
+	for _, u := range r.uploads {
+		if u.ExpiresAt.Before(t) {
+			u.Chunks = nil
+			uploads = append(uploads, u)
+		}
+	}
+	return uploads, nil
+}
+
+type memoryTranscodes struct{ *memory }
+
+func (r memoryTranscodes) Enqueue(ctx context.Context, v Video, sourceKey string, now time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	key := videoKey{v.Folder, v.LocationHash}
+	if _, ok := r.videos[key]; ok {
+		return ErrDuplicate
+	}
+	v.Status, v.HLS = VideoProcessing, false
+	r.videos[key] = v
+	r.transcodes[key] = TranscodeJob{Folder: v.Folder, LocationHash: v.LocationHash, SourceKey: sourceKey, Status: JobQueued,
+		RunAfter: now, CreatedAt: now, UpdatedAt: now}
+	return nil
+}
+
+func (r memoryTranscodes) Claim(ctx context.Context, claim string, now time.Time, leaseEnd time.Time) (TranscodeJob, error) {
+	r.Lock()
+	defer r.Unlock()
+	var due *TranscodeJob
+	for _, j := range r.transcodes {
+		j := j
+		if (j.Status == JobQueued && !j.RunAfter.After(now)) || (j.Status == JobRunning && j.RunAfter.Before(now)) {
+			if due == nil || j.RunAfter.Before(due.RunAfter) {
+				due = &j
+			}
+		}
+	}
+	if due == nil {
+		return TranscodeJob{}, ErrNotFound
+	}
+	due.Status, due.Claim, due.RunAfter, due.UpdatedAt = JobRunning, claim, leaseEnd, now
+	due.Attempts++
+	r.transcodes[videoKey{due.Folder, due.LocationHash}] = *due
+	return *due, nil
+}
+
+// finish moves a claimed job to status, the lock must be held
+func (r memoryTranscodes) finish(j TranscodeJob, status string, reason string, runAfter time.Time, now time.Time) error {
+	key := videoKey{j.Folder, j.LocationHash}
+	current, ok := r.transcodes[key]
+	if !ok || current.Status != JobRunning || current.Claim != j.Claim {
+		return ErrNotFound
+	}
+	current.Status, current.Error, current.RunAfter, current.Claim, current.UpdatedAt = status, reason, runAfter, "", now
+	r.transcodes[key] = current
+	return nil
+}
+
+func (r memoryTranscodes) Complete(ctx context.Context, j TranscodeJob, now time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	key := videoKey{j.Folder, j.LocationHash}
+	v, ok := r.videos[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := r.finish(j, JobDone, "", now, now); err != nil {
+		return err
+	}
+	v.Status, v.HLS = VideoReady, true
+	r.videos[key] = v
+	if latest, ok := r.latest[v.LocationHash]; !ok || !v.TimeStamp.Before(latest.TimeStamp) {
+		r.latest[v.LocationHash] = v
+	}
+	return nil
+}
+
+func (r memoryTranscodes) Retry(ctx context.Context, j TranscodeJob, reason string, runAfter time.Time, now time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.finish(j, JobQueued, reason, runAfter, now)
+}
+
+func (r memoryTranscodes) Release(ctx context.Context, j TranscodeJob, reason string, now time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.finish(j, JobQueued, reason, now, now); err != nil {
+		return err
+	}
+	key := videoKey{j.Folder, j.LocationHash}
+	if current := r.transcodes[key]; current.Attempts > 0 {
+		current.Attempts--
+		r.transcodes[key] = current
+	}
+	return nil
+}
+
+func (r memoryTranscodes) Fail(ctx context.Context, j TranscodeJob, reason string, now time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.finish(j, JobFailed, reason, now, now); err != nil {
+		return err
+	}
+	key := videoKey{j.Folder, j.LocationHash}
+	if v, ok := r.videos[key]; ok {
+		v.Status = VideoFailed
+		r.videos[key] = v
+	}
+	return nil
+}
+
+func (r memoryTranscodes) Status(ctx context.Context, locationHash string, folder string) (VideoStatus, error) {
+	r.Lock()
+	defer r.Unlock()
+	key := videoKey{folder, locationHash}
+	v, ok := r.videos[key]
+	if !ok {
+		return VideoStatus{}, ErrNotFound
+	}
+	s := VideoStatus{UserID: v.UserID, Status: v.Status, HLS: v.HLS, UpdatedAt: v.TimeStamp}
+	if j, ok := r.transcodes[key]; ok {
+		s.Attempts, s.Error, s.UpdatedAt = j.Attempts, j.Error, j.UpdatedAt
+	}
+	return s, nil
+}
+
+type memoryDrafts struct{ *memory }
+
+func (r memoryDrafts) Create(ctx context.Context, d Draft) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.videos[videoKey{d.Folder, d.Location.Hash}]; ok {
+		return ErrDuplicate
+	}
+	for _, existing := range r.drafts {
+		if existing.ID == d.ID || existing.Folder == d.Folder && existing.Location.Hash == d.Location.Hash {
+			return ErrDuplicate
+		}
+	}
+	d.Parts = append([]string(nil), d.Parts...)
+	d.Stored = nil
+	r.drafts[d.ID] = d
+	return nil
+}
+
+func (r memoryDrafts) ByID(ctx context.Context, id string) (Draft, error) {
+	r.Lock()
+	defer r.Unlock()
+	d, ok := r.drafts[id]
+	if !ok {
+		return Draft{}, ErrNotFound
+	}
+	d.Parts = append([]string(nil), d.Parts...)
+	d.Stored = append([]DraftPart(nil), d.Stored...)
+	sort.Slice(d.Stored, func(i, j int) bool { return d.Stored[i].Role < d.Stored[j].Role })
+	return d, nil
+}
+
+func (r memoryDrafts) SetPart(ctx context.Context, id string, p DraftPart) error {
+	r.Lock()
+	defer r.Unlock()
+	d, ok := r.drafts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	stored := []DraftPart{p}
+	for _, existing := range d.Stored {
+		if existing.Role != p.Role {
+			stored = append(stored, existing)
+		}
+	}
+	d.Stored = stored
+	r.drafts[id] = d
+	return nil
+}
+
+func (r memoryDrafts) Delete(ctx context.Context, id string) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.drafts, id)
+	return nil
+}
+
+func (r memoryDrafts) Expired(ctx context.Context, t time.Time) ([]Draft, error) {
+	r.Lock()
+	defer r.Unlock()
+	var drafts []Draft
+	for _, d := range r.drafts {
+		if d.ExpiresAt.Before(t) {
+			d.Stored = nil
+			drafts = append(drafts, d)
+		}
+	}
+	return drafts, nil
+}
+
+func (r memoryDrafts) Commit(ctx context.Context, id string, v Video, sourceKey string, queue bool, now time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	d, ok := r.drafts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	key := videoKey{v.Folder, v.LocationHash}
+	if _, ok := r.videos[key]; ok {
+		return ErrDuplicate
+	}
+	delete(r.drafts, id)
+
+	if _, ok := r.locations[d.Location.Hash]; !ok {
+		r.locations[d.Location.Hash] = d.Location
+	}
+	if queue {
+		v.Status, v.HLS = VideoProcessing, false
+		r.videos[key] = v
+		r.transcodes[key] = TranscodeJob{Folder: v.Folder, LocationHash: v.LocationHash, SourceKey: sourceKey, Status: JobQueued,
+			RunAfter: now, CreatedAt: now, UpdatedAt: now}
+		return nil
+	}
+	v.Status = VideoReady
+	r.videos[key] = v
+	if latest, ok := r.latest[v.LocationHash]; !ok || !v.TimeStamp.Before(latest.TimeStamp) {
+		r.latest[v.LocationHash] = v
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseDateTime(t *testing.T) {
+	want := time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"2021-03-04 05:06:07.89", want, true},
+		{"2021-03-04T05:06:07.890", want, true},
+		{"2021-03-04 05:06:07", want.Truncate(time.Second), true},
+		{"2021-03-04", time.Time{}, false},
+		{"2021-03-04T05:06:07Z", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseDateTime(tt.in)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseDateTime(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMemoryDeleteByTimeStamp(t *testing.T) {
+	recorded := time.Date(2021, 3, 4, 5, 6, 7, 890123456, time.UTC)
+	tests := []struct {
+		name      string
+		userID    string
+		timeStamp string
+		aliceLeft int
+		bobLeft   int
+	}{
+		{"space layout", "alice", "2021-03-04 05:06:07.890", 1, 1},
+		{"T layout", "alice", "2021-03-04T05:06:07.89", 1, 1},
+		{"next second", "alice", "2021-03-04 05:06:08.890", 2, 1},
+		{"finer than DATETIME(3)", "alice", "2021-03-04 05:06:07.890123", 3, 1},
+		{"other user", "bob", "2021-03-04 05:06:07.890", 3, 0},
+		{"not a time", "alice", "yesterday", 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := NewMemory()
+			for _, id := range []string{"alice", "bob"} {
+				if err := repos.Users.Create(ctx, User{UserID: id, UserName: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, hash := range []string{"a", "b"} {
+				if err := repos.Locations.Ensure(ctx, Location{Hash: hash}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			videos := repos.Videos
+			for _, v := range []Video{
+				{Folder: "1", LocationHash: "a", UserID: "alice", TimeStamp: recorded},
+				{Folder: "2", LocationHash: "b", UserID: "alice", TimeStamp: recorded},
+				{Folder: "3", LocationHash: "a", UserID: "alice", TimeStamp: recorded.Add(time.Second)},
+				{Folder: "4", LocationHash: "a", UserID: "bob", TimeStamp: recorded},
+			} {
+				if err := videos.Create(ctx, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			deleted, err := videos.DeleteByTimeStamp(ctx, tt.userID, tt.timeStamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range deleted {
+				if v.UserID != tt.userID {
+					t.Errorf("deleted %s's video", v.UserID)
+				}
+			}
+			if left, _ := videos.ByUser(ctx, "alice", 0); len(left) != tt.aliceLeft {
+				t.Errorf("alice has %d videos left, want %d", len(left), tt.aliceLeft)
+			}
+			if left, _ := videos.ByUser(ctx, "bob", 0); len(left) != tt.bobLeft {
+				t.Errorf("bob has %d videos left, want %d", len(left), tt.bobLeft)
+			}
+			if len(deleted) != 4-tt.aliceLeft-tt.bobLeft {
+				t.Errorf("returned %d deleted videos, want %d", len(deleted), 4-tt.aliceLeft-tt.bobLeft)
+			}
+		})
+	}
+}
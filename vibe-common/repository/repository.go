@@ -0,0 +1,337 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by lookups that match no row
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned by the in-memory repositories where MariaDB would
+// reject a row on its primary or unique key, and by Drafts where a vibe exists
+var ErrDuplicate = errors.New("duplicate entry")
+
+// User is a row of users
+type User struct {
+	UserID         string
+	UserName       string
+	Password       string // bcrypt hash
+	Email          string
+	Phone          string
+	Photo          bool
+	FollowerCount  int
+	FollowingCount int
+	DateCreated    time.Time
+}
+
+// Profile is what another user sees of a user
+type Profile struct {
+	UserName    string
+	Photo       bool
+	IsFollowing bool // whether the viewer follows the user
+}
+
+// Counts are a user's follower and following totals
+type Counts struct {
+	FollowerCount  int
+	FollowingCount int
+}
+
+// Location is a row of locations, Hash is derived from the name and coordinates
+type Location struct {
+	Hash string
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+// Video is a row of all_videos, the same shape is kept per location in latest_videos
+type Video struct {
+	Folder       string
+	LocationHash string
+	UserID       string
+	TimeStamp    time.Time
+	LikeCount    float64
+	SHA256       string // hex digest of the video file, "" for videos uploaded before it was recorded
+	Status       string // VideoReady, VideoProcessing or VideoFailed
+	HLS          bool   // transcoded, the vibe folder holds an HLS ladder, poster and thumbnail
+	Meta         VideoMeta
+}
+
+// VideoMeta is what probing the uploaded file of a video found, zero for videos uploaded before
+// it was recorded
+type VideoMeta struct {
+	Duration time.Duration
+	Width    int    // as displayed, after Rotation
+	Height   int    // as displayed, after Rotation
+	Codec    string // e.g. "h264" or "hevc"
+	Rotation int    // degrees clockwise players turn the stored frames
+	Bitrate  int64  // bits per second over the whole file
+	Size     int64  // bytes of the uploaded file
+}
+
+// Processing states of a video, the feeds only serve ready videos
+const (
+	VideoProcessing = "processing"
+	VideoReady      = "ready"
+	VideoFailed     = "failed"
+)
+
+// VideoView is a video joined with its poster and location for the feeds
+type VideoView struct {
+	Video
+	IsLiked  bool
+	UserName string
+	Photo    bool
+	Location Location
+}
+
+// Chat is a message of a location's chat thread
+type Chat struct {
+	ID           string
+	LocationHash string
+	Thread       string
+	UserID       string
+	CreatedAt    time.Time
+	Text         string
+}
+
+// FavoriteLocation is a favorited location with its latest video, the video
+// fields are empty when nothing has been posted there yet
+type FavoriteLocation struct {
+	VideoFolder string
+	TimeStamp   time.Time
+	UserID      string
+	Location    Location
+}
+
+// Upload is a row of uploads, a resumable upload whose bytes are kept apart until it is finalized
+type Upload struct {
+	ID        string
+	UserID    string
+	Length    int64             // total size the client announced
+	Offset    int64             // bytes received so far
+	Metadata  map[string]string // decoded tus Upload-Metadata
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Chunks    []UploadChunk // by offset, only filled by ByID
+}
+
+// UploadChunk is a row of upload_chunks, one written range of an upload
+type UploadChunk struct {
+	Offset   int64
+	Length   int64
+	SHA256   string // hex digest of the bytes as written
+	Verified bool   // the client sent a checksum the bytes matched
+}
+
+// TranscodeJob is a row of transcode_jobs, the queued transcoding of one video
+type TranscodeJob struct {
+	Folder       string
+	LocationHash string
+	SourceKey    string // storage key of the uploaded video
+	Status       string // JobQueued, JobRunning, JobDone or JobFailed
+	Attempts     int    // claims so far, including the current one
+	Error        string // why the last attempt failed
+	Claim        string // token of the worker running it
+	RunAfter     time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// States of a transcode job
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Draft is a row of vibe_drafts, a vibe whose files are uploaded before it is recorded. Its
+// parts are staged apart, committing copies them into the vibe's folder and records the vibe.
+type Draft struct {
+	ID        string
+	UserID    string
+	Folder    string // of the vibe it becomes
+	Location  Location
+	TimeStamp time.Time
+	Parts     []string    // roles the manifest expects, e.g. video, selfie and thumbnail
+	Stored    []DraftPart // parts stored so far, only filled by ByID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// DraftPart is a row of vibe_draft_parts, one stored file of a draft
+type DraftPart struct {
+	Role   string
+	Key    string // storage key of the file
+	SHA256 string
+	Meta   VideoMeta // of the video part, zero for others
+}
+
+// VideoStatus is how far a video got through processing
+type VideoStatus struct {
+	UserID    string // who posted the video
+	Status    string // of the video
+	HLS       bool
+	Attempts  int    // of its transcode job, 0 without one
+	Error     string // of the last failed attempt
+	UpdatedAt time.Time
+}
+
+// Users reads and writes accounts
+type Users interface {
+	Create(ctx context.Context, u User) error
+	ByID(ctx context.Context, userID string) (User, error)
+	ByUserName(ctx context.Context, userName string) (User, error)
+	IDByPhone(ctx context.Context, phone string) (string, error)
+	IDByUserNameOrPhone(ctx context.Context, userName string, phone string) (string, error)
+	UpdatePassword(ctx context.Context, userID string, hash string) error
+	SetPhoto(ctx context.Context, userID string, photo bool) error
+	Profile(ctx context.Context, viewerID string, userID string) (Profile, error)
+	Counts(ctx context.Context, userID string) (Counts, error)
+	Delete(ctx context.Context, userID string) error
+}
+
+// Follows keeps user_follower and the users' follow counts in step
+type Follows interface {
+	Follow(ctx context.Context, userID string, followingID string) error
+	Unfollow(ctx context.Context, userID string, followingID string) error
+	Following(ctx context.Context, userID string) ([]string, error)
+	Followers(ctx context.Context, userID string) ([]string, error)
+}
+
+// Videos covers all_videos and latest_videos, deleted videos and videos that are not ready
+// are never returned
+type Videos interface {
+	// Create adds a ready video, Transcodes.Enqueue adds one still processing
+	Create(ctx context.Context, v Video) error
+	SetLatest(ctx context.Context, v Video) error
+	// Exists reports whether a row has the folder at the location, deleted or not, the same
+	// check Drafts.Create and Drafts.Commit make
+	Exists(ctx context.Context, folder string, locationHash string) (bool, error)
+	// LatestAtLocation and AtLocation mark videos liked by viewerID, newest first
+	LatestAtLocation(ctx context.Context, viewerID string, locationHash string) (VideoView, error)
+	AtLocation(ctx context.Context, viewerID string, locationHash string) ([]VideoView, error)
+	// ByUser marks videos the poster liked, newest first, limit 0 returns all
+	ByUser(ctx context.Context, userID string, limit int) ([]VideoView, error)
+	// Delete removes one of userID's videos, ErrNotFound when userID did not post it
+	Delete(ctx context.Context, userID string, locationHash string, folder string) error
+	// DeleteByTimeStamp removes userID's videos recorded at timeStamp, a DATETIME literal compared
+	// by value, whatever their status, and returns the ones it removed
+	DeleteByTimeStamp(ctx context.Context, userID string, timeStamp string) ([]Video, error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// Locations records every location a video, chat or tag refers to
+type Locations interface {
+	// Ensure inserts the location unless its hash is already known
+	Ensure(ctx context.Context, l Location) error
+}
+
+// Chats stores location chat threads
+type Chats interface {
+	Create(ctx context.Context, c Chat) error
+	// Thread returns the messages newest first
+	Thread(ctx context.Context, locationHash string, thread string) ([]Chat, error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// Favorites are the locations a user has hearted
+type Favorites interface {
+	Add(ctx context.Context, userID string, locationHash string) error
+	Remove(ctx context.Context, userID string, locationHash string) error
+	Exists(ctx context.Context, userID string, locationHash string) (bool, error)
+	ForUser(ctx context.Context, userID string) ([]FavoriteLocation, error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// Likes are the videos a user has liked. Like and Unlike keep the like counts of all_videos and
+// latest_videos in step and report false when the like already was as asked, counting nothing.
+type Likes interface {
+	Like(ctx context.Context, folder string, locationHash string, userID string) (bool, error)
+	Unlike(ctx context.Context, folder string, locationHash string, userID string) (bool, error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// Tags are the predicted tags of a location
+type Tags interface {
+	ForLocation(ctx context.Context, locationHash string) ([]string, error)
+	Add(ctx context.Context, locationHash string, tag string) error
+}
+
+// Subscribers are the newsletter sign ups
+type Subscribers interface {
+	Exists(ctx context.Context, email string) (bool, error)
+	Create(ctx context.Context, email string) error
+}
+
+// Uploads tracks resumable uploads until they are finalized or expire
+type Uploads interface {
+	Create(ctx context.Context, u Upload) error
+	ByID(ctx context.Context, id string) (Upload, error)
+	// AddChunk records a chunk written at the upload's offset and moves the offset past it,
+	// ErrNotFound when the upload is gone or no longer at c.Offset
+	AddChunk(ctx context.Context, id string, c UploadChunk) error
+	// Rewind moves the upload back to offset, forgetting the chunks from there on
+	Rewind(ctx context.Context, id string, offset int64) error
+	Delete(ctx context.Context, id string) error
+	// Expired returns the uploads whose expiry passed before t
+	Expired(ctx context.Context, t time.Time) ([]Upload, error)
+}
+
+// Transcodes is the queue of videos waiting to be transcoded, a job is claimed by one worker
+// at a time until its lease runs out
+type Transcodes interface {
+	// Enqueue adds a processing video and its job in one transaction
+	Enqueue(ctx context.Context, v Video, sourceKey string, now time.Time) error
+	// Claim takes the oldest due job, or a running one whose lease ran out, for claim until
+	// leaseEnd and counts the attempt, ErrNotFound when none is due
+	Claim(ctx context.Context, claim string, now time.Time, leaseEnd time.Time) (TranscodeJob, error)
+	// Complete marks the job done and its video ready with HLS, the video becomes the latest
+	// at its location unless a newer one is, ErrNotFound when the claim or the video is gone
+	Complete(ctx context.Context, j TranscodeJob, now time.Time) error
+	// Retry queues the claimed job again from runAfter
+	Retry(ctx context.Context, j TranscodeJob, reason string, runAfter time.Time, now time.Time) error
+	// Release queues the claimed job again at once and gives back its attempt, for a job
+	// interrupted by shutdown rather than failed
+	Release(ctx context.Context, j TranscodeJob, reason string, now time.Time) error
+	// Fail gives up on the claimed job and marks its video failed
+	Fail(ctx context.Context, j TranscodeJob, reason string, now time.Time) error
+	// Status reads a video's processing status, ErrNotFound when there is no such video
+	Status(ctx context.Context, locationHash string, folder string) (VideoStatus, error)
+}
+
+// Drafts keeps vibe drafts until they are committed or expire
+type Drafts interface {
+	// Create adds a draft, ErrDuplicate when another draft or a vibe has its folder and location
+	Create(ctx context.Context, d Draft) error
+	ByID(ctx context.Context, id string) (Draft, error)
+	// SetPart records a stored part, replacing the one stored for its role, ErrNotFound when the draft is gone
+	SetPart(ctx context.Context, id string, p DraftPart) error
+	Delete(ctx context.Context, id string) error
+	// Expired returns the drafts whose expiry passed before t
+	Expired(ctx context.Context, t time.Time) ([]Draft, error)
+	// Commit records the draft's vibe v and its location and deletes the draft in one transaction.
+	// With queue v is added processing with a transcode job for sourceKey, otherwise ready and the
+	// latest at its location. ErrNotFound when the draft is gone, ErrDuplicate when the vibe exists.
+	Commit(ctx context.Context, id string, v Video, sourceKey string, queue bool, now time.Time) error
+}
+
+// Repos bundles every repository, handlers receive it through their package Setup
+type Repos struct {
+	Users       Users
+	Follows     Follows
+	Videos      Videos
+	Locations   Locations
+	Chats       Chats
+	Favorites   Favorites
+	Likes       Likes
+	Tags        Tags
+	Subscribers Subscribers
+	Uploads     Uploads
+	Transcodes  Transcodes
+	Drafts      Drafts
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"vibe-common/middleware"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config describes where and how a service listens
+type Config struct {
+	Addr string
+	TLS  TLSConfig
+
+	// http.Server timeouts, zero means no timeout
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// How long in-flight requests get to finish once shutdown starts, zero waits forever
+	ShutdownTimeout time.Duration
+
+	// Browser access and security headers, applied to every request ahead of the handler
+	CORS     middleware.CORSConfig
+	Security middleware.SecurityConfig
+}
+
+// Run serves handler until the server fails or the process receives SIGINT or
+// SIGTERM. On a signal the server stops accepting connections and drains
+// in-flight requests, then the cleanup functions run in order. The returned
+// error is nil after a clean shutdown.
+func Run(cfg Config, handler http.Handler, cleanup ...func()) error {
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           middleware.SecurityHeaders(cfg.Security)(middleware.CORS(cfg.CORS)(handler)),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	// Certificates are read through GetCertificate so renewals apply without a restart
+	if cfg.TLS.Enabled() {
+		certs, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:               cfg.TLS.MinVersion,
+			CipherSuites:             cfg.TLS.CipherSuites,
+			PreferServerCipherSuites: true,
+			GetCertificate:           certs.GetCertificate,
+		}
+		stopWatch := make(chan struct{})
+		defer close(stopWatch)
+		go certs.watch(cfg.TLS.ReloadInterval, stopWatch)
+	}
+
+	// Listen and serve as a go routine
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLS.Enabled() {
+			log.Info("Listening and serving on HTTPS port ", cfg.Addr)
+			serveErr <- srv.ListenAndServeTLS("", "")
+		} else {
+			log.Info("Listening and serving on HTTP port ", cfg.Addr)
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	// OS signal handler
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var err error
+	select {
+	case err = <-serveErr:
+		log.Error("Server stopped: ", err)
+	case s := <-sig:
+		log.Info("Received os signal ", s, ", draining in-flight requests")
+		err = shutdown(srv, cfg.ShutdownTimeout)
+	}
+
+	log.Info("Cleaning up!")
+	for _, c := range cleanup {
+		c()
+	}
+	log.Info("Shutdown complete")
+	return err
+}
+
+// shutdown drains the server, forcing remaining connections closed once the timeout passes
+func shutdown(srv *http.Server, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		log.Error("Server did not drain in time, closing remaining connections: ", err)
+		srv.Close()
+		return err
+	}
+	log.Info("Server drained")
+	return nil
+}
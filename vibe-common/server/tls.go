@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSConfig says where the certificate lives and what clients may negotiate
+type TLSConfig struct {
+	// When both CertFile and KeyFile are set the server speaks TLS
+	CertFile string
+	KeyFile  string
+
+	MinVersion   uint16
+	CipherSuites []uint16 // TLS 1.2 suites, nil leaves Go's defaults; TLS 1.3 suites are not configurable
+
+	// How often the files are checked for a renewed certificate, zero only reloads on SIGHUP
+	ReloadInterval time.Duration
+}
+
+// Enabled reports whether the server should speak TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// TLSVersions maps the names configuration uses to TLS versions
+var TLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// CipherPolicies maps the names configuration uses to TLS 1.2 cipher suites. intermediate
+// is Mozilla's intermediate profile, forward secret AEAD suites only, compatible keeps
+// Go's defaults which add CBC suites for old clients
+var CipherPolicies = map[string][]uint16{
+	"intermediate": {
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	},
+	"compatible": nil,
+}
+
+// certReloader hands out the certificate loaded from disk and swaps it when the files
+// change, handshakes after a swap get the new certificate and open connections keep theirs
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // newest modification time of the files when cert was loaded
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	return c, c.reload()
+}
+
+// reload loads the key pair, keeping the current certificate when that fails
+func (c *certReloader) reload() error {
+	// Read the times first, files changing while loading are picked up by the next check
+	modTime, err := c.newest()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// newest returns the latest modification time of the certificate and key files
+func (c *certReloader) newest() (time.Time, error) {
+	var newest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// changed reports whether either file was modified since the certificate was loaded
+func (c *certReloader) changed() bool {
+	modTime, err := c.newest()
+	if err != nil {
+		// Mid-renewal the files can be missing for a moment, the next check sees them
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !modTime.Equal(c.modTime)
+}
+
+// GetCertificate is the tls.Config hook serving the current certificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch reloads the certificate on SIGHUP and, every interval, when the files changed,
+// until stop is closed
+func (c *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			c.reloadLogged("received SIGHUP")
+		case <-tick:
+			if c.changed() {
+				c.reloadLogged("certificate files changed")
+			}
+		}
+	}
+}
+
+func (c *certReloader) reloadLogged(reason string) {
+	if err := c.reload(); err != nil {
+		log.Error("Unable to reload TLS certificate, keeping the current one: ", err)
+		return
+	}
+	log.Info("Reloaded TLS certificate ", c.certFile, ", ", reason)
+}
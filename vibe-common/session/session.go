@@ -0,0 +1,96 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"vibe-common/api"
+	"vibe-common/logging"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Cookie is the cookie core-api keeps the session token in
+const Cookie = "session_token"
+
+// ErrNoSession is returned for a missing, unknown or expired session token
+var ErrNoSession = errors.New("no session")
+
+// Conn returns a Redis connection bounded by the request's context, e.g. a service's store.CacheConn
+type Conn func(ctx context.Context) redis.Conn
+
+type userKey struct{}
+
+// Token reads the session token from the session cookie, or from an "Authorization: Bearer"
+// header for clients calling services on other hosts than core-api
+func Token(r *http.Request) string {
+	if c, err := r.Cookie(Cookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return ""
+}
+
+// Lookup returns the ID of the user a session token was started for
+func Lookup(conn redis.Conn, token string) (string, error) {
+	if token == "" {
+		return "", ErrNoSession
+	}
+	userID, err := redis.String(conn.Do("GET", token))
+	if err == redis.ErrNil || (err == nil && userID == "") {
+		return "", ErrNoSession
+	}
+	return userID, err
+}
+
+// Require answers 401 to requests without a live session and passes the others on
+// with the session's user, read it with UserID
+func Require(conn Conn) func(http.Handler) http.Handler {
+	return middleware(conn, true)
+}
+
+// Optional passes every request on, with the session's user when it carries a live one
+func Optional(conn Conn) func(http.Handler) http.Handler {
+	return middleware(conn, false)
+}
+
+func middleware(conn Conn, required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := Token(r)
+			userID, err := "", ErrNoSession
+			if token != "" {
+				c := conn(r.Context())
+				userID, err = Lookup(c, token)
+				c.Close() // give the connection back before the wrapped handler runs
+			}
+			switch {
+			case err == ErrNoSession && required && token == "":
+				api.RespondError(w, http.StatusUnauthorized, api.CodeUnauthorized, "no session")
+				return
+			case err == ErrNoSession && required:
+				api.RespondError(w, http.StatusUnauthorized, api.CodeUnauthorized, "session expired")
+				return
+			case err != nil && err != ErrNoSession:
+				logging.FromContext(r.Context()).Error("Unable to read session: ", err)
+				api.RespondError(w, http.StatusInternalServerError, api.CodeInternal, "unable to read the session")
+				return
+			}
+			if userID != "" {
+				logging.SetUserID(r.Context(), userID)
+				r = r.WithContext(context.WithValue(r.Context(), userKey{}, userID))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UserID returns the user of the request's session, "" when Require or Optional found none
+func UserID(r *http.Request) string {
+	id, _ := r.Context().Value(userKey{}).(string)
+	return id
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"vibe-common/api"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Handler serves GET and HEAD of objects, the URL path is the key. Ranges and conditional
+// requests are answered by http.ServeContent, reading only the bytes asked for.
+func Handler(s Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			api.MethodNotAllowed(w, r)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if ValidKey(key) != nil {
+			api.NotFound(w, r)
+			return
+		}
+		obj, err := s.Stat(r.Context(), key)
+		if err == ErrNotExist {
+			api.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Error("Error reading stored object ", key, ": ", err)
+			api.RespondError(w, http.StatusBadGateway, api.CodeUpstreamFailed, "unable to read from storage")
+			return
+		}
+
+		if obj.ContentType != "" {
+			w.Header().Set("Content-Type", obj.ContentType)
+		}
+		if obj.ETag != "" {
+			w.Header().Set("ETag", obj.ETag)
+		}
+		content := &objectReader{ctx: r.Context(), s: s, obj: obj}
+		defer content.Close()
+		http.ServeContent(w, r, key, obj.ModTime, content)
+	})
+}
+
+// objectReader lets http.ServeContent seek in an object, each read after a seek opens a
+// ranged Get from the new offset
+type objectReader struct {
+	ctx    context.Context
+	s      Storage
+	obj    Object
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.obj.Size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.s.Get(o.ctx, o.obj.Key, o.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.obj.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: seek before the start of the object")
+	}
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
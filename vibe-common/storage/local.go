@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Stored files are readable by the service's group, which core-streaming must share, and by
+// no one else
+const (
+	dirMode  os.FileMode = 0750
+	fileMode os.FileMode = 0640
+)
+
+// multipartDir holds the parts of unfinished multipart uploads, hidden from keys and listings
+const multipartDir = ".multipart"
+
+// The HLS types are missing from Go's table and often wrong in the system's, ".ts" is also Qt
+// translations
+func init() {
+	mime.AddExtensionType(".m3u8", "application/vnd.apple.mpegurl")
+	mime.AddExtensionType(".ts", "video/mp2t")
+}
+
+// Local keeps objects as files below a root directory, the key is the path below it
+type Local struct {
+	root string
+}
+
+// NewLocal stores objects below root
+func NewLocal(root string) *Local {
+	return &Local{root: filepath.Clean(root)}
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// writeFile fills a hidden temporary file next to path from r and renames it into place,
+// so readers never see half a file
+func writeFile(path string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	n, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("storage: got %d bytes, expected %d", n, size)
+	}
+	if err == nil {
+		err = tmp.Chmod(fileMode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Put writes the object's file, the content type is derived from the key's extension instead
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, r, size)
+}
+
+func (l *Local) Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (Object, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return Object{}, ErrNotExist
+	} else if err != nil {
+		return Object{}, err
+	}
+	return l.object(key, info), nil
+}
+
+func (l *Local) object(key string, info os.FileInfo) Object {
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime(), ContentType: mime.TypeByExtension(filepath.Ext(key))}
+}
+
+// Delete removes the object's file and the directories it leaves empty
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != l.root && strings.HasPrefix(dir, l.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // not empty
+		}
+	}
+	return nil
+}
+
+// List walks the directory the prefix names, an empty prefix lists everything
+func (l *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	if _, err := os.Stat(l.root); err != nil {
+		return nil, err
+	}
+	dir := l.root
+	if base := prefix[:strings.LastIndex(prefix, "/")+1]; base != "" {
+		if err := ValidKey(strings.TrimSuffix(base, "/")); err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(l.root, filepath.FromSlash(base))
+	}
+
+	var objects []Object
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir // nothing stored under the prefix
+			}
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			objects = append(objects, l.object(key, info))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// uploadDir is where the parts of a multipart upload wait, the ID is checked so it cannot climb out
+func (l *Local) uploadDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("storage: invalid upload ID %q", uploadID)
+	}
+	return filepath.Join(l.root, multipartDir, uploadID), nil
+}
+
+// CreateMultipart starts a multipart upload, its parts are kept below the root until completed
+func (l *Local) CreateMultipart(ctx context.Context, key string, contentType string) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(b)
+	dir, _ := l.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return "", err
+	}
+	return uploadID, ioutil.WriteFile(filepath.Join(dir, "key"), []byte(key), fileMode)
+}
+
+// openUpload returns the directory of an upload after checking it was started for key
+func (l *Local) openUpload(key string, uploadID string) (string, error) {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	started, err := ioutil.ReadFile(filepath.Join(dir, "key"))
+	if os.IsNotExist(err) {
+		return "", ErrNotExist
+	} else if err != nil {
+		return "", err
+	}
+	if string(started) != key {
+		return "", fmt.Errorf("storage: upload %s is for %q", uploadID, started)
+	}
+	return dir, nil
+}
+
+func partName(number int) string {
+	return fmt.Sprintf("part-%05d", number)
+}
+
+func (l *Local) PutPart(ctx context.Context, key string, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	dir, err := l.openUpload(key, uploadID)
+	if err != nil {
+		return Part{}, err
+	}
+	sum := md5.New()
+	if err := writeFile(filepath.Join(dir, partName(number)), io.TeeReader(r, sum), size); err != nil {
+		return Part{}, err
+	}
+	return Part{Number: number, ETag: hex.EncodeToString(sum.Sum(nil))}, nil
+}
+
+// CompleteMultipart joins the parts, in the order given, into the object
+func (l *Local) CompleteMultipart(ctx context.Context, key string, uploadID string, parts []Part) error {
+	dir, err := l.openUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	readers := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		f, err := os.Open(filepath.Join(dir, partName(p.Number)))
+		if err != nil {
+			return fmt.Errorf("storage: part %d of upload %s: %v", p.Number, uploadID, err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if err := writeFile(path, io.MultiReader(readers...), -1); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (l *Local) AbortMultipart(ctx context.Context, key string, uploadID string) error {
+	dir, err := l.openUpload(key, uploadID)
+	if err == ErrNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func read(t *testing.T, s Storage, key string, offset int64, length int64) string {
+	t.Helper()
+	body, err := s.Get(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestLocalObjects(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocal(t.TempDir())
+	if err := s.Put(ctx, "videos/a/1/video.mp4", strings.NewReader("0123456789"), 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		offset, length int64
+		want           string
+	}{{0, -1, "0123456789"}, {2, 3, "234"}, {7, -1, "789"}, {4, 0, ""}} {
+		if got := read(t, s, "videos/a/1/video.mp4", tt.offset, tt.length); got != tt.want {
+			t.Errorf("Get(%d, %d) = %q, want %q", tt.offset, tt.length, got, tt.want)
+		}
+	}
+	o, err := s.Stat(ctx, "videos/a/1/video.mp4")
+	if err != nil || o.Size != 10 || o.ContentType != "video/mp4" {
+		t.Errorf("Stat = %+v, %v", o, err)
+	}
+	if _, err := s.Stat(ctx, "videos/a/1"); err != ErrNotExist {
+		t.Errorf("Stat of a folder = %v, want ErrNotExist", err)
+	}
+	if _, err := s.Get(ctx, "videos/a/2/video.mp4", 0, -1); err != ErrNotExist {
+		t.Errorf("Get of a missing key = %v, want ErrNotExist", err)
+	}
+	if err := s.Put(ctx, "../escape", strings.NewReader("x"), 1, ""); err != ErrInvalidKey {
+		t.Errorf("Put outside the root = %v, want ErrInvalidKey", err)
+	}
+
+	if err := s.Delete(ctx, "videos/a/1/video.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "videos/a/1/video.mp4"); err != nil {
+		t.Errorf("deleting twice = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.root, "videos")); !os.IsNotExist(err) {
+		t.Errorf("Delete left the empty folders behind: %v", err)
+	}
+}
+
+func TestLocalMultipart(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocal(t.TempDir())
+	key := "videos/a/1/video.mp4"
+	id, err := s.CreateMultipart(ctx, key, "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// parts may arrive in any order, completing joins them in the order given
+	second, err := s.PutPart(ctx, key, id, 2, strings.NewReader("world"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.PutPart(ctx, key, id, 1, strings.NewReader("hello "), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ETag == "" || first.ETag == second.ETag {
+		t.Errorf("parts have ETags %q and %q", first.ETag, second.ETag)
+	}
+	if _, err := s.PutPart(ctx, "videos/b/1/video.mp4", id, 3, strings.NewReader("x"), 1); err == nil {
+		t.Error("PutPart under another key succeeded")
+	}
+	if objects, _ := s.List(ctx, ""); len(objects) != 0 {
+		t.Errorf("an unfinished upload is listed: %+v", objects)
+	}
+	if err := s.CompleteMultipart(ctx, key, id, []Part{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, s, key, 0, -1); got != "hello world" {
+		t.Errorf("completed object holds %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(s.root, multipartDir, id)); !os.IsNotExist(err) {
+		t.Errorf("completing left the parts behind: %v", err)
+	}
+	if err := s.CompleteMultipart(ctx, key, id, []Part{first}); err != ErrNotExist {
+		t.Errorf("completing twice = %v, want ErrNotExist", err)
+	}
+
+	aborted, err := s.CreateMultipart(ctx, key, "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AbortMultipart(ctx, key, aborted); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AbortMultipart(ctx, key, aborted); err != nil {
+		t.Errorf("aborting twice = %v", err)
+	}
+	if _, err := s.PutPart(ctx, key, aborted, 1, strings.NewReader("x"), 1); err != ErrNotExist {
+		t.Errorf("PutPart of an aborted upload = %v, want ErrNotExist", err)
+	}
+	if _, err := s.PutPart(ctx, key, "../../etc", 1, strings.NewReader("x"), 1); err == nil {
+		t.Error("PutPart of a climbing upload ID succeeded")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocal(t.TempDir())
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	for _, partSize := range []int64{0, 30, 100, 1000} {
+		key := "videos/a/1/video.mp4"
+		if err := Upload(ctx, s, key, bytes.NewReader(data), int64(len(data)), "video/mp4", partSize); err != nil {
+			t.Fatalf("Upload in %d byte parts: %v", partSize, err)
+		}
+		if got := read(t, s, key, 0, -1); got != string(data) {
+			t.Errorf("Upload in %d byte parts stored %d bytes", partSize, len(got))
+		}
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Join(s.root, multipartDir)); len(entries) != 0 {
+		t.Errorf("Upload left %d multipart uploads behind", len(entries))
+	}
+}
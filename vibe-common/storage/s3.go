@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibe-common/tracing"
+)
+
+// S3 keeps objects in an S3 compatible bucket, requests are signed with AWS Signature Version 4
+type S3 struct {
+	endpoint *url.URL
+	config   S3Config
+	client   *http.Client
+}
+
+// Bodies are sent without hashing them first, which S3 and MinIO accept for signed requests
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// NewS3 checks the configuration, no request is sent until the first call
+func NewS3(c S3Config) (*S3, error) {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("S3 endpoint %q is not an http(s) URL", c.Endpoint)
+	}
+	if c.Bucket == "" || c.AccessKey == "" || c.SecretKey == "" {
+		return nil, errors.New("S3 storage needs a bucket, an access key and a secret key")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	// No client timeout, a large object takes as long as it takes; the request context bounds it
+	return &S3{endpoint: endpoint, config: c, client: &http.Client{Transport: tracing.Transport(nil)}}, nil
+}
+
+// s3Error is the error document S3 answers a failed request with
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// uriEncode escapes everything but the RFC 3986 unreserved characters, the way SigV4 wants
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery sorts and escapes the query as SigV4 signs it, which is also what is sent
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// objectURL addresses key in the bucket, an empty key addresses the bucket itself
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/") + "/"
+	if s.config.PathStyle {
+		path += s.config.Bucket + "/"
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+	}
+	path += key
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds the SigV4 headers, signing the host and the x-amz headers only
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+// do sends a signed request, answers of 300 and up come back as errors with the body closed.
+// A missing key or upload is ErrNotExist.
+func (s *S3) do(ctx context.Context, method string, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	if body == nil || size == 0 {
+		body, size = http.NoBody, 0
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, readError(resp)
+}
+
+// readError turns a failed answer into an error, HEAD answers carry no document to read
+func readError(resp *http.Response) error {
+	e := &s3Error{Status: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+	if data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil && len(data) > 0 {
+		xml.Unmarshal(data, e)
+	}
+	if e.Code == "NoSuchKey" || e.Code == "NoSuchUpload" || (resp.Request.Method == http.MethodHead && resp.StatusCode == http.StatusNotFound) {
+		return ErrNotExist
+	}
+	return e
+}
+
+func contentTypeHeader(contentType string) http.Header {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return header
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, contentTypeHeader(contentType), r, size, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header, nil, 0, unsignedPayload)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	if err := ValidKey(key); err != nil {
+		return Object{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0, unsignedPayload)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: resp.ContentLength, ModTime: modTime, ContentType: resp.Header.Get("Content-Type"), ETag: resp.Header.Get("ETag")}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0, unsignedPayload)
+	if err == ErrNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listResult is a page of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2, which returns keys in order
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0, unsignedPayload)
+		if err != nil {
+			return nil, err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, ModTime: c.LastModified, ETag: c.ETag})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *S3) CreateMultipart(ctx context.Context, key string, contentType string) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, contentTypeHeader(contentType), nil, 0, unsignedPayload)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3) PutPart(ctx context.Context, key string, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	if err := ValidKey(key); err != nil {
+		return Part{}, err
+	}
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	resp, err := s.do(ctx, http.MethodPut, key, query, nil, r, size, unsignedPayload)
+	if err != nil {
+		return Part{}, err
+	}
+	resp.Body.Close()
+	return Part{Number: number, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// completeUpload is the body of CompleteMultipartUpload
+type completeUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+// CompleteMultipart joins the parts, S3 can fail it with a 200 whose body is an error document
+func (s *S3) CompleteMultipart(ctx context.Context, key string, uploadID string, parts []Part) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	var doc completeUpload
+	for _, p := range parts {
+		doc.Parts = append(doc.Parts, struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		}{p.Number, p.ETag})
+	}
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, contentTypeHeader("application/xml"), bytes.NewReader(body), int64(len(body)), sha256Hex(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		XMLName xml.Name
+		s3Error
+	}
+	if xml.Unmarshal(data, &result) == nil && result.XMLName.Local == "Error" {
+		result.s3Error.Status = resp.StatusCode
+		return &result.s3Error
+	}
+	return nil
+}
+
+func (s *S3) AbortMultipart(ctx context.Context, key string, uploadID string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, 0, unsignedPayload)
+	if err == ErrNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Storage keeps media as objects named by slash separated keys, e.g. videos/123/folder/video.mp4
+type Storage interface {
+	// Put stores size bytes of r under key, replacing what was there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get reads length bytes of an object from offset, a negative length reads to the end
+	Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete removes an object, deleting one that does not exist is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose keys start with prefix, in key order
+	List(ctx context.Context, prefix string) ([]Object, error)
+
+	// Multipart uploads assemble an object from parts sent one at a time, it appears once completed
+	CreateMultipart(ctx context.Context, key string, contentType string) (string, error)
+	PutPart(ctx context.Context, key string, uploadID string, number int, r io.Reader, size int64) (Part, error)
+	CompleteMultipart(ctx context.Context, key string, uploadID string, parts []Part) error
+	AbortMultipart(ctx context.Context, key string, uploadID string) error
+}
+
+// Object describes a stored object
+type Object struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	ETag        string // "" when the backend has none
+}
+
+// Part is a stored part of a multipart upload, numbered from 1
+type Part struct {
+	Number int
+	ETag   string
+}
+
+var (
+	ErrNotExist   = errors.New("storage: object does not exist")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Config selects the backend, Root is only read by local and S3 only by s3
+type Config struct {
+	Backend string
+	Root    string
+	S3      S3Config
+}
+
+// S3Config says how to reach an S3 compatible bucket, such as MinIO
+type S3Config struct {
+	Endpoint  string // e.g. http://127.0.0.1:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // bucket in the path rather than the host name, what MinIO expects
+}
+
+// New creates the configured backend
+func New(c Config) (Storage, error) {
+	switch c.Backend {
+	case BackendLocal, "":
+		return NewLocal(c.Root), nil
+	case BackendS3:
+		return NewS3(c.S3)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", c.Backend)
+}
+
+// ValidKey rejects keys that are empty, absolute, or have empty, dot or hidden components
+func ValidKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "\\\x00") {
+		return ErrInvalidKey
+	}
+	for _, c := range strings.Split(key, "/") {
+		if c == "" || strings.HasPrefix(c, ".") {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// Upload stores size bytes of r under key, as a multipart upload of partSize parts when it is
+// bigger than one part, a partSize of 0 always sends it whole
+func Upload(ctx context.Context, s Storage, key string, r io.ReaderAt, size int64, contentType string, partSize int64) error {
+	if partSize <= 0 || size <= partSize {
+		return s.Put(ctx, key, io.NewSectionReader(r, 0, size), size, contentType)
+	}
+
+	uploadID, err := s.CreateMultipart(ctx, key, contentType)
+	if err != nil {
+		return err
+	}
+	var parts []Part
+	for off := int64(0); off < size; off += partSize {
+		length := partSize
+		if size-off < length {
+			length = size - off
+		}
+		part, err := s.PutPart(ctx, key, uploadID, len(parts)+1, io.NewSectionReader(r, off, length), length)
+		if err != nil {
+			s.AbortMultipart(context.Background(), key, uploadID)
+			return err
+		}
+		parts = append(parts, part)
+	}
+	if err := s.CompleteMultipart(ctx, key, uploadID, parts); err != nil {
+		s.AbortMultipart(context.Background(), key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// DeletePrefix removes every object whose key starts with prefix
+func DeletePrefix(ctx context.Context, s Storage, prefix string) error {
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if err := s.Delete(ctx, o.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyPrefix stores a copy of every object whose key starts with from under the same key
+// starting with to instead, returning the keys it wrote
+func CopyPrefix(ctx context.Context, s Storage, from string, to string) ([]string, error) {
+	objects, err := s.List(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	var copied []string
+	for _, o := range objects {
+		// listings of some backends leave the content type out
+		if o, err = s.Stat(ctx, o.Key); err != nil {
+			return copied, err
+		}
+		key := to + strings.TrimPrefix(o.Key, from)
+		body, err := s.Get(ctx, o.Key, 0, -1)
+		if err != nil {
+			return copied, err
+		}
+		err = s.Put(ctx, key, body, o.Size, o.ContentType)
+		body.Close()
+		if err != nil {
+			return copied, err
+		}
+		copied = append(copied, key)
+	}
+	return copied, nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"videos/abc/1/video.mp4", true},
+		{"drafts/7/video", true},
+		{"", false},
+		{"/videos/a", false},
+		{"videos//a", false},
+		{"videos/../a", false},
+		{"videos/.uploads/a", false},
+		{"videos\\a", false},
+	}
+	for _, tt := range tests {
+		if err := ValidKey(tt.key); (err == nil) != tt.ok {
+			t.Errorf("ValidKey(%q) = %v", tt.key, err)
+		}
+	}
+}
+
+func TestCopyAndDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocal(t.TempDir())
+	files := map[string]string{
+		"drafts/7/video":     "frames",
+		"drafts/7/thumbnail": "still",
+		"drafts/70/video":    "other draft",
+	}
+	for key, body := range files {
+		if err := s.Put(ctx, key, strings.NewReader(body), int64(len(body)), "video/mp4"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	copied, err := CopyPrefix(ctx, s, "drafts/7/", "videos/abc/1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(copied)
+	if want := []string{"videos/abc/1/thumbnail", "videos/abc/1/video"}; strings.Join(copied, ",") != strings.Join(want, ",") {
+		t.Errorf("CopyPrefix copied %q, want %q", copied, want)
+	}
+	for _, key := range copied {
+		if got, want := read(t, s, key, 0, -1), files["drafts/7/"+strings.TrimPrefix(key, "videos/abc/1/")]; got != want {
+			t.Errorf("%s holds %q, want %q", key, got, want)
+		}
+	}
+
+	if err := DeletePrefix(ctx, s, "drafts/7/"); err != nil {
+		t.Fatal(err)
+	}
+	for key := range files {
+		_, err := s.Stat(ctx, key)
+		if gone := err == ErrNotExist; gone != strings.HasPrefix(key, "drafts/7/") {
+			t.Errorf("Stat(%s) after DeletePrefix = %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gomodule/redigo/redis"
+	log "github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// DBConfig holds what is needed to reach the MariaDB instance backing vibe_db
+// and how the connection pool behaves, zero values keep the driver defaults
+type DBConfig struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	DialTimeout  time.Duration // establishing a connection
+	ReadTimeout  time.Duration // I/O read on a connection, bounds a stuck query
+	WriteTimeout time.Duration // I/O write on a connection
+}
+
+// DSN builds the go-sql-driver connection string for the config
+func (c DBConfig) DSN() string {
+	port := c.Port
+	if port == "" {
+		port = "3306"
+	}
+	name := c.Name
+	if name == "" {
+		name = "vibe_db"
+	}
+	dsn := c.User + ":" + c.Password + "@tcp(" + c.Host + ":" + port + ")/" + name + "?parseTime=true"
+	if c.DialTimeout > 0 {
+		dsn += "&timeout=" + c.DialTimeout.String()
+	}
+	if c.ReadTimeout > 0 {
+		dsn += "&readTimeout=" + c.ReadTimeout.String()
+	}
+	if c.WriteTimeout > 0 {
+		dsn += "&writeTimeout=" + c.WriteTimeout.String()
+	}
+	return dsn
+}
+
+// Initialize DB, every query gets a span when run with a traced context
+func InitDB(c DBConfig) (*sql.DB, error) {
+	traced := otelsql.WrapDriver(mysql.MySQLDriver{}, semconv.DBSystemMySQL.Value.AsString())
+	connector, err := traced.(driver.DriverContext).OpenConnector(c.DSN())
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	return db, nil
+}
+
+// CacheConfig holds what is needed to reach Redis and how the pool behaves
+type CacheConfig struct {
+	Host string
+	Port string
+
+	MaxIdle     int
+	MaxActive   int  // 0 allows any number of connections
+	Wait        bool // block Get until a connection frees up once MaxActive is reached
+	IdleTimeout time.Duration
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// Initialize Cache
+func InitCache(c CacheConfig) *redis.Pool {
+	port := c.Port
+	if port == "" {
+		port = "6379"
+	}
+	address := c.Host + ":" + port
+	return &redis.Pool{
+		MaxIdle:     c.MaxIdle,
+		MaxActive:   c.MaxActive,
+		Wait:        c.Wait,
+		IdleTimeout: c.IdleTimeout,
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", address,
+				redis.DialConnectTimeout(c.DialTimeout),
+				redis.DialReadTimeout(c.ReadTimeout),
+				redis.DialWriteTimeout(c.WriteTimeout))
+		},
+	}
+}
+
+// WithTimeout bounds ctx by d, a zero d only adds cancellation
+func WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// Retry calls fn until it succeeds or attempts run out, doubling the delay between tries
+func Retry(name string, attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 1; ; i++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if i >= attempts {
+			return fmt.Errorf("%s unreachable after %d attempts: %v", name, i, err)
+		}
+		log.Warning(name, " unreachable (attempt ", i, "/", attempts, "), retrying in ", delay, ": ", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// WaitForDB pings the DB with backoff so bad credentials or a down server show up at startup
+func WaitForDB(db *sql.DB, attempts int, delay time.Duration) error {
+	return Retry("DB", attempts, delay, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return db.PingContext(ctx)
+	})
+}
+
+// WaitForCache sends PING to the cache with backoff
+func WaitForCache(pool *redis.Pool, attempts int, delay time.Duration) error {
+	return Retry("Cache", attempts, delay, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = redis.DoWithTimeout(conn, 5*time.Second, "PING")
+		return err
+	})
+}
+
+// Close shuts the pools down in order, DB first then Cache, either may be nil
+func Close(db *sql.DB, cache *redis.Pool) {
+	if db != nil {
+		if err := db.Close(); err != nil {
+			log.Error("Error closing DB: ", err)
+		} else {
+			log.Info("DB closed")
+		}
+	}
+	if cache != nil {
+		if err := cache.Close(); err != nil {
+			log.Error("Error closing Cache: ", err)
+		} else {
+			log.Info("Cache closed")
+		}
+	}
+}
+
+func ToString(reply interface{}, err error) (string, error) {
+	return redis.String(reply, err)
+}
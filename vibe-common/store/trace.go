@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("vibe-common/store")
+
+// CacheConn borrows a connection from the pool whose commands are traced as
+// children of the span in ctx. Getting the connection and each command stop
+// once ctx is done or timeout has passed, a zero timeout only follows ctx.
+// Close it when done, like pool.Get.
+func CacheConn(ctx context.Context, pool *redis.Pool, timeout time.Duration) redis.Conn {
+	ctx, cancel := WithTimeout(ctx, timeout)
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		cancel()
+		return errorConn{err}
+	}
+	return &tracedConn{Conn: conn, ctx: ctx, cancel: cancel}
+}
+
+// tracedConn starts a client span for every command
+type tracedConn struct {
+	redis.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *tracedConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+func (c *tracedConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" { // flush only, nothing worth a span
+		return c.do(commandName, args...)
+	}
+	_, span := tracer.Start(c.ctx, "redis "+commandName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			attribute.String("db.operation", commandName),
+		))
+	defer span.End()
+
+	reply, err := c.do(commandName, args...)
+	if err != nil && err != redis.ErrNil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return reply, err
+}
+
+// do runs the command with the time left before the context's deadline
+func (c *tracedConn) do(commandName string, args ...interface{}) (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := c.ctx.Deadline()
+	if !ok {
+		return c.Conn.Do(commandName, args...)
+	}
+	return redis.DoWithTimeout(c.Conn, time.Until(deadline), commandName, args...)
+}
+
+// errorConn is returned when no connection could be borrowed, every call fails with err
+type errorConn struct{ err error }
+
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Close() error                                   { return nil }